@@ -0,0 +1,94 @@
+package shorty
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const redactedValue = "REDACTED"
+
+// newAccessLogger opens the access log destination configured by ACCESS_LOG:
+// "stdout", "stderr", "off", or a file path to append to
+func newAccessLogger() (*slog.Logger, error) {
+	var out io.Writer
+	switch cfg().AccessLog {
+	case "off", "false", "none":
+		return nil, nil
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg().AccessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening access log: %w", err)
+		}
+		out = f
+	}
+	return slog.New(slog.NewJSONHandler(out, nil)), nil
+}
+
+// accessLogMiddleware writes one structured JSON line per request
+func accessLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID(c)),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if query := redactQuery(c.Request.URL.RawQuery); query != "" {
+			attrs = append(attrs, slog.String("query", query))
+		}
+		if code := c.Param("code"); code != "" {
+			attrs = append(attrs, slog.String("code", code))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+
+		logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "request", attrs...)
+	}
+}
+
+// redactQuery replaces the values of sensitive query parameters
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redactedValue
+	}
+	for name := range values {
+		if isSensitiveParam(name) {
+			values[name] = []string{redactedValue}
+		}
+	}
+	return values.Encode()
+}
+
+// isSensitiveParam reports whether a query parameter name matches ACCESS_LOG_REDACT
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range cfg().AccessLogRedact {
+		if strings.Contains(name, strings.ToLower(sensitive)) {
+			return true
+		}
+	}
+	return false
+}
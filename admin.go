@@ -0,0 +1,238 @@
+package shorty
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startAdminServer serves the admin API, metrics, pprof and health on ADMIN_ADDR,
+// which defaults to localhost so none of it is exposed on the public port. It returns
+// the listener's server, or nil when ADMIN_ADDR is "off", and fails when the address
+// cannot be bound or is reachable from other hosts without authentication.
+func startAdminServer() (*http.Server, error) {
+	addr := cfg().AdminAddr
+	if addr == "" || addr == "off" {
+		return nil, nil
+	}
+	if err := checkAdminExposure(addr); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := adminTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin TLS settings: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("admin listener: %w", err)
+	}
+	srv := &http.Server{Handler: newAdminRouter(), TLSConfig: tlsConfig}
+	go func() {
+		var err error
+		if tlsConfig == nil {
+			log.Printf("🛠  Admin listener on http://%s", ln.Addr())
+			err = srv.Serve(ln)
+		} else {
+			log.Printf("🛠  Admin listener on https://%s", ln.Addr())
+			err = srv.ServeTLS(ln, "", "")
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Admin listener stopped: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// checkAdminExposure refuses an ADMIN_ADDR other hosts can reach unless ADMIN_TOKEN or
+// ADMIN_CLIENT_CA_FILE protects the admin and pprof routes
+func checkAdminExposure(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid ADMIN_ADDR %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	if cfg().AdminToken == "" && cfg().AdminClientCAFile == "" {
+		return fmt.Errorf("ADMIN_ADDR %s is not a loopback address; set ADMIN_TOKEN or ADMIN_CLIENT_CA_FILE, or ADMIN_ADDR=off", addr)
+	}
+	return nil
+}
+
+// adminTLSConfig returns the admin listener's TLS settings, or nil to serve plain HTTP.
+// With ADMIN_CLIENT_CA_FILE, clients may present a certificate issued by one of its CAs;
+// the /admin and /debug/pprof routes require one, while health and metrics stay
+// reachable by probes that have none.
+func adminTLSConfig() (*tls.Config, error) {
+	if cfg().AdminTLSCertFile == "" && cfg().AdminTLSKeyFile == "" {
+		if cfg().AdminClientCAFile != "" {
+			return nil, errors.New("ADMIN_CLIENT_CA_FILE requires ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg().AdminTLSCertFile, cfg().AdminTLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if cfg().AdminClientCAFile != "" {
+		bundle, err := os.ReadFile(cfg().AdminClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("ADMIN_CLIENT_CA_FILE holds no PEM certificates")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// newAdminRouter builds the admin router. Its paths are reserved like the public ones,
+// so the two listeners can be served behind one proxy.
+func newAdminRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(requestIDMiddleware(), gin.Recovery())
+
+	r.GET("/health", healthCheck)
+	r.GET("/metrics", gin.WrapH(expvar.Handler()))
+
+	// Profiles expose memory contents and command lines, so they need the same
+	// credentials as the admin API
+	debug := r.Group("/debug/pprof", adminClientCertMiddleware(), adminAuthMiddleware())
+	{
+		debug.GET("/", gin.WrapF(pprof.Index))
+		debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+		debug.GET("/profile", gin.WrapF(pprof.Profile))
+		debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+		debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+		debug.GET("/trace", gin.WrapF(pprof.Trace))
+		debug.GET("/:profile", func(c *gin.Context) {
+			pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+		})
+	}
+
+	admin := r.Group("/admin", adminClientCertMiddleware(), adminAuthMiddleware())
+	{
+		admin.GET("/urls/:code", adminGetURL)
+		admin.DELETE("/urls/:code", adminDeleteURL)
+		admin.POST("/urls/:code/disable", adminSetDisabled(true))
+		admin.POST("/urls/:code/enable", adminSetDisabled(false))
+		admin.PUT("/urls/:code/flag", adminFlagURL)
+		admin.DELETE("/urls/:code/flag", adminUnflagURL)
+		admin.POST("/urls/:code/merge", adminMergeURLs)
+		admin.GET("/duplicates", adminListDuplicates)
+		admin.GET("/blocklist", adminBlocklistReport)
+		admin.GET("/moderation", adminModerationQueue)
+		admin.POST("/moderation/approve", adminModerate(moderationApproved))
+		admin.POST("/moderation/reject", adminModerate(moderationRejected))
+		admin.GET("/mode", adminGetMode)
+		admin.PUT("/mode", adminSetMode)
+		admin.POST("/reload", adminReloadConfig)
+	}
+
+	reserveRoutes(r.Routes())
+	return r
+}
+
+// adminClientCertMiddleware requires a verified client certificate when
+// ADMIN_CLIENT_CA_FILE is set. The handshake has already checked it against the CA bundle.
+func adminClientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg().AdminClientCAFile == "" {
+			c.Next()
+			return
+		}
+
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			respondError(c, http.StatusUnauthorized, "A client certificate is required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminAuthMiddleware requires "Authorization: Bearer <ADMIN_TOKEN>" when ADMIN_TOKEN is set
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg().AdminToken == "" {
+			c.Next()
+			return
+		}
+
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg().AdminToken)) != 1 {
+			respondError(c, http.StatusUnauthorized, "Invalid admin token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminGetURL handles GET /admin/urls/:code
+func adminGetURL(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, c.Param("code"))
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+// adminDeleteURL handles DELETE /admin/urls/:code
+func adminDeleteURL(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, c.Param("code"))
+	if err == nil && isImmutable(u) {
+		respondError(c, http.StatusConflict, "Immutable links cannot be deleted; disable them instead")
+		return
+	}
+	if err == nil {
+		err = deleteURL(ctx, u.ShortCode)
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to delete URL")
+		return
+	}
+	publishEvent(eventLinkDeleted, gin.H{"short_code": u.ShortCode})
+	c.Status(http.StatusNoContent)
+}
+
+// adminSetDisabled handles POST /admin/urls/:code/disable and /enable
+func adminSetDisabled(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := dbContext(c)
+		defer cancel()
+
+		if err := setURLDisabled(ctx, c.Param("code"), disabled); err != nil {
+			respondStoreError(c, err, "URL not found", "Failed to update URL")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,58 @@
+package shorty
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shorty/internal/config"
+)
+
+// useAdminToken configures ADMIN_TOKEN for the test
+func useAdminToken(t *testing.T, token string) {
+	t.Helper()
+	previous := cfg()
+	t.Cleanup(func() { config.Set(previous) })
+	conf := *previous
+	conf.AdminToken = token
+	conf.AdminClientCAFile = ""
+	config.Set(&conf)
+}
+
+func TestCheckAdminExposure(t *testing.T) {
+	useAdminToken(t, "")
+	for _, addr := range []string{"127.0.0.1:9090", "localhost:9090", "[::1]:9090"} {
+		if err := checkAdminExposure(addr); err != nil {
+			t.Errorf("%s: %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:9090", ":9090", "10.0.0.5:9090", "9090"} {
+		if err := checkAdminExposure(addr); err == nil {
+			t.Errorf("%s without a token was accepted", addr)
+		}
+	}
+
+	useAdminToken(t, "secret")
+	if err := checkAdminExposure("0.0.0.0:9090"); err != nil {
+		t.Errorf("0.0.0.0:9090 with a token: %v", err)
+	}
+}
+
+func TestPprofRequiresAdminToken(t *testing.T) {
+	useAdminToken(t, "secret")
+	r := newAdminRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("pprof without a token: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("pprof with the token: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
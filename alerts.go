@@ -0,0 +1,209 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Alert kinds
+const (
+	alertKindClicks     = "clicks"
+	alertKindInactivity = "inactivity"
+)
+
+// Alert notifies a link's owner by email or webhook when a click condition is met
+type Alert struct {
+	ID         int        `json:"id"`
+	ShortCode  string     `json:"short_code"`
+	Kind       string     `json:"kind"`
+	Threshold  int64      `json:"threshold,omitempty"`
+	WindowDays int        `json:"window_days,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	WebhookURL string     `json:"webhook_url,omitempty"`
+	FiredAt    *time.Time `json:"fired_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AlertRequest is the body of POST /api/urls/:code/alerts
+type AlertRequest struct {
+	Kind       string `json:"kind" binding:"required,oneof=clicks inactivity"`
+	Threshold  int64  `json:"threshold" binding:"omitempty,min=1"`
+	WindowDays int    `json:"window_days" binding:"omitempty,min=1,max=365"`
+	Email      string `json:"email" binding:"omitempty,email,max=254"`
+	WebhookURL string `json:"webhook_url" binding:"omitempty,max=2048"`
+}
+
+// dueAlert is an alert whose condition holds, with the link state that triggered it
+type dueAlert struct {
+	Alert
+	OriginalURL  string
+	Clicks       int64
+	LastActivity time.Time
+}
+
+// alertNotification is the data passed to the alert mail template and webhook
+type alertNotification struct {
+	Kind         string    `json:"kind"`
+	ShortCode    string    `json:"short_code"`
+	ShortURL     string    `json:"short_url"`
+	OriginalURL  string    `json:"original_url"`
+	Clicks       int64     `json:"clicks"`
+	Threshold    int64     `json:"threshold,omitempty"`
+	WindowDays   int       `json:"window_days,omitempty"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// createAlert handles POST /api/urls/:code/alerts
+func createAlert(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	var req AlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	if details := validateAlertRequest(req); len(details) > 0 {
+		respondValidationError(c, details...)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	alert := Alert{
+		ShortCode:  u.ShortCode,
+		Kind:       req.Kind,
+		Threshold:  req.Threshold,
+		WindowDays: req.WindowDays,
+		Email:      strings.TrimSpace(req.Email),
+		WebhookURL: strings.TrimSpace(req.WebhookURL),
+	}
+	if err := insertAlert(ctx, &alert); err != nil {
+		respondStoreError(c, err, "", "Failed to save alert")
+		return
+	}
+	c.JSON(http.StatusCreated, alert)
+}
+
+// validateAlertRequest checks the rules that depend on the alert kind
+func validateAlertRequest(req AlertRequest) []FieldError {
+	var details []FieldError
+	if req.Kind == alertKindClicks && req.Threshold == 0 {
+		details = append(details, FieldError{Field: "threshold", Rule: "required", Message: "threshold is required for clicks alerts"})
+	}
+	if req.Kind == alertKindInactivity && req.WindowDays == 0 {
+		details = append(details, FieldError{Field: "window_days", Rule: "required", Message: "window_days is required for inactivity alerts"})
+	}
+	if req.Email == "" && req.WebhookURL == "" {
+		details = append(details, FieldError{Field: "email", Rule: "required", Message: "email or webhook_url is required"})
+	}
+	if req.WebhookURL != "" {
+		if fe := validateDestination("webhook_url", strings.TrimSpace(req.WebhookURL)); fe != nil {
+			details = append(details, *fe)
+		}
+	}
+	return details
+}
+
+// listAlertsHandler handles GET /api/urls/:code/alerts
+func listAlertsHandler(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	alerts, err := listAlerts(ctx, u.ShortCode)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch alerts")
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+// deleteAlertHandler handles DELETE /api/urls/:code/alerts/:id
+func deleteAlertHandler(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "Alert not found")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := deleteAlert(ctx, u.ShortCode, id); err != nil {
+		respondStoreError(c, err, "Alert not found", "Failed to delete alert")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkAlerts is the ALERT_CHECK_INTERVAL job. Each due alert is claimed before it is
+// delivered, so with several instances running every alert fires at most once.
+func checkAlerts(ctx context.Context) error {
+	due, err := listDueAlerts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, alert := range due {
+		claimed, err := claimAlert(ctx, alert.ID, alert.FiredAt)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := deliverAlert(ctx, alert); err != nil {
+			log.Printf("Failed to deliver alert %d for %s: %v", alert.ID, alert.ShortCode, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d alerts failed to deliver", failed, len(due))
+	}
+	return nil
+}
+
+// deliverAlert sends a fired alert to its email address and webhook
+func deliverAlert(ctx context.Context, alert dueAlert) error {
+	notification := alertNotification{
+		Kind:         alert.Kind,
+		ShortCode:    alert.ShortCode,
+		ShortURL:     publicShortURL(alert.ShortCode),
+		OriginalURL:  alert.OriginalURL,
+		Clicks:       alert.Clicks,
+		Threshold:    alert.Threshold,
+		WindowDays:   alert.WindowDays,
+		LastActivity: alert.LastActivity,
+	}
+
+	var errs []error
+	if alert.Email != "" {
+		errs = append(errs, sendMail(ctx, []string{alert.Email}, "alert", notification))
+	}
+	if alert.WebhookURL != "" {
+		errs = append(errs, postWebhook(ctx, alert.WebhookURL, "alert."+alert.Kind, notification))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+package shorty
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AliasAvailability is the response of GET /api/aliases/:code/available
+type AliasAvailability struct {
+	Alias     string       `json:"alias"`
+	Available bool         `json:"available"`
+	Reason    string       `json:"reason,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// aliasInput applies the same rules to an alias as ShortenRequest
+type aliasInput struct {
+	Alias string `json:"alias" binding:"required,min=3,max=32,alias"`
+}
+
+// aliasAvailable handles GET /api/aliases/:code/available. Invalid, reserved and taken
+// aliases are all reported with 200 and available=false, so a form can check as the
+// user types.
+func aliasAvailable(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	result, err := checkAlias(ctx, c.Param("code"))
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to check alias")
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// checkAlias reports whether alias could be used as a custom short code right now
+func checkAlias(ctx context.Context, alias string) (AliasAvailability, error) {
+	result := AliasAvailability{Alias: alias}
+	if details := validateRequest(&aliasInput{Alias: alias}); len(details) > 0 {
+		result.Reason = "invalid"
+		result.Details = details
+		return result, nil
+	}
+	if isReservedAlias(alias) {
+		result.Reason = "reserved"
+		return result, nil
+	}
+
+	exists, err := codeExists(ctx, alias)
+	if err != nil {
+		return result, err
+	}
+	if exists {
+		result.Reason = "taken"
+		return result, nil
+	}
+	similar, err := confusableCode(ctx, alias)
+	if err != nil {
+		return result, err
+	}
+	if similar != "" {
+		result.Reason = "confusable"
+		return result, nil
+	}
+	result.Available = true
+	return result, nil
+}
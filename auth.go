@@ -0,0 +1,85 @@
+package shorty
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyContextKey stores the name of the authenticated API key on the Gin context
+const apiKeyContextKey = "api_key_name"
+
+// apiKeyMiddleware authenticates requests that present an API key via
+// "Authorization: Bearer <key>" or "X-API-Key". Requests without a key continue anonymously.
+func apiKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+				key = strings.TrimSpace(token)
+			}
+		}
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		name, ok := lookupAPIKey(key)
+		if !ok {
+			respondError(c, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		c.Set(apiKeyContextKey, name)
+		c.Next()
+	}
+}
+
+// lookupAPIKey returns the name configured for key
+func lookupAPIKey(key string) (string, bool) {
+	for name, configured := range cfg().APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(configured)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// apiKeyName returns the name of the API key that authenticated the request, if any
+func apiKeyName(c *gin.Context) (string, bool) {
+	name := c.GetString(apiKeyContextKey)
+	return name, name != ""
+}
+
+// requireAPIKey rejects requests that were not authenticated with an API key
+func requireAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := apiKeyName(c); !ok {
+			respondError(c, http.StatusUnauthorized, "API key required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// loadOwnedURL fetches the link named by the :code parameter, answering 404 unless it
+// belongs to the calling API key. Links owned by another key are reported as missing so
+// their existence is not revealed.
+func loadOwnedURL(c *gin.Context) (URL, bool) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, c.Param("code"))
+	if err == nil {
+		if name, _ := apiKeyName(c); u.Owner != name {
+			err = errNotFound
+		}
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return URL{}, false
+	}
+	return u, true
+}
@@ -0,0 +1,249 @@
+package shorty
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+)
+
+const (
+	// backupFormatVersion is written to every backup header and checked on restore.
+	// Version 2 added every urls column after last_clicked_at and the hourly clicks;
+	// version 1 records restore with those left at their defaults.
+	backupFormatVersion = 2
+
+	// restoreBatchSize is how many links are inserted per restore transaction
+	restoreBatchSize = 1000
+)
+
+// backupHeader is the first line of every backup
+type backupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupRecord is one link and its click aggregates. Destinations are stored decrypted
+// so a backup can be restored under a different URL_ENCRYPTION_KEY.
+type backupRecord struct {
+	ShortCode        string         `json:"short_code"`
+	OriginalURL      string         `json:"original_url"`
+	Owner            string         `json:"owner,omitempty"`
+	Tags             []string       `json:"tags,omitempty"`
+	Clicks           int64          `json:"clicks"`
+	CreatedAt        time.Time      `json:"created_at"`
+	LastClickedAt    *time.Time     `json:"last_clicked_at,omitempty"`
+	DisabledAt       *time.Time     `json:"disabled_at,omitempty"`
+	DisabledBy       string         `json:"disabled_by,omitempty"`
+	AliasOf          string         `json:"alias_of,omitempty"`
+	Immutable        bool           `json:"immutable,omitempty"`
+	ExpiresAt        *time.Time     `json:"expires_at,omitempty"`
+	FlaggedReason    string         `json:"flagged_reason,omitempty"`
+	FlaggedAt        *time.Time     `json:"flagged_at,omitempty"`
+	Moderation       string         `json:"moderation,omitempty"`
+	ModeratedAt      *time.Time     `json:"moderated_at,omitempty"`
+	RedirectStatus   int            `json:"redirect_status,omitempty"`
+	HTMLRedirect     bool           `json:"html_redirect,omitempty"`
+	UnavailableAt    *time.Time     `json:"unavailable_at,omitempty"`
+	WaybackFallback  bool           `json:"wayback_fallback,omitempty"`
+	ArchiveURL       string         `json:"archive_url,omitempty"`
+	Visibility       string         `json:"visibility,omitempty"`
+	AllowedNetworks  []netip.Prefix `json:"allowed_networks,omitempty"`
+	AllowedCountries []string       `json:"allowed_countries,omitempty"`
+	BlockedCountries []string       `json:"blocked_countries,omitempty"`
+	Schedule         *LinkSchedule  `json:"schedule,omitempty"`
+	Metadata         map[string]any `json:"metadata,omitempty"`
+	Hourly           []backupHourly `json:"hourly,omitempty"`
+}
+
+// backupHourly is one row of a link's click_hourly aggregates
+type backupHourly struct {
+	Hour   time.Time `json:"hour"`
+	Clicks int64     `json:"clicks"`
+}
+
+// writeBackup streams every link to w as gzip-compressed NDJSON, returning the link count
+func writeBackup(ctx context.Context, w io.Writer) (int, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+
+	if err := enc.Encode(backupHeader{Format: "shorty-backup", Version: backupFormatVersion, CreatedAt: time.Now().UTC()}); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err := eachBackupRecord(ctx, func(r backupRecord) error {
+		count++
+		return enc.Encode(r)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, gz.Close()
+}
+
+// readBackup restores links from a backup written by writeBackup. Links whose short code
+// already exists are left untouched, and so are their hourly clicks.
+func readBackup(ctx context.Context, r io.Reader) (restored, skipped int64, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("opening backup: %w", err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		return 0, 0, fmt.Errorf("backup is empty")
+	}
+	var header backupHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != "shorty-backup" {
+		return 0, 0, fmt.Errorf("not a shorty backup")
+	}
+	if header.Version > backupFormatVersion {
+		return 0, 0, fmt.Errorf("backup version %d is newer than supported version %d", header.Version, backupFormatVersion)
+	}
+
+	batch := make([]backupRecord, 0, restoreBatchSize)
+	flush := func() error {
+		inserted, err := restoreBackupRecords(ctx, batch)
+		restored += inserted
+		skipped += int64(len(batch)) - inserted
+		batch = batch[:0]
+		return err
+	}
+
+	for scanner.Scan() {
+		var record backupRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return restored, skipped, fmt.Errorf("decoding backup record: %w", err)
+		}
+		batch = append(batch, record)
+		if len(batch) == restoreBatchSize {
+			if err := flush(); err != nil {
+				return restored, skipped, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return restored, skipped, err
+	}
+	if len(batch) > 0 {
+		err = flush()
+	}
+	return restored, skipped, err
+}
+
+// backupKey returns the object key for a backup taken at t
+func backupKey(t time.Time) string {
+	return cfg().BackupS3Prefix + "shorty-" + t.UTC().Format("20060102T150405Z") + ".ndjson.gz"
+}
+
+// uploadBackup exports every link to the configured bucket and returns the object key
+func uploadBackup(ctx context.Context) (string, error) {
+	client, err := newBackupS3Client()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	count, err := writeBackup(ctx, &buf)
+	if err != nil {
+		return "", fmt.Errorf("exporting links: %w", err)
+	}
+
+	key := backupKey(time.Now())
+	if err := client.PutObject(ctx, key, "application/gzip", buf.Bytes()); err != nil {
+		return "", err
+	}
+	log.Printf("✓ Backed up %d links to s3://%s/%s (%d bytes)", count, cfg().BackupS3Bucket, key, buf.Len())
+	return key, nil
+}
+
+// scheduledBackup is the BACKUP_INTERVAL job
+func scheduledBackup(ctx context.Context) error {
+	_, err := uploadBackup(ctx)
+	return err
+}
+
+// runBackupCommand implements "shorty backup [list | restore <key|latest>]"
+func runBackupCommand(args []string) error {
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Hour, "maximum time for the backup or restore")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: shorty backup [flags] [list | restore <key|latest>]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch fs.Arg(0) {
+	case "":
+		_, err := uploadBackup(ctx)
+		return err
+
+	case "list":
+		client, err := newBackupS3Client()
+		if err != nil {
+			return err
+		}
+		keys, err := client.ListObjects(ctx, cfg().BackupS3Prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return nil
+
+	case "restore":
+		key := fs.Arg(1)
+		if key == "" {
+			fs.Usage()
+			return fmt.Errorf("restore needs a backup key or \"latest\"")
+		}
+		client, err := newBackupS3Client()
+		if err != nil {
+			return err
+		}
+		if key == "latest" {
+			keys, err := client.ListObjects(ctx, cfg().BackupS3Prefix+"shorty-")
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return fmt.Errorf("no backups found under s3://%s/%s", cfg().BackupS3Bucket, cfg().BackupS3Prefix)
+			}
+			key = keys[len(keys)-1]
+		}
+
+		data, err := client.GetObject(ctx, key)
+		if err != nil {
+			return err
+		}
+		restored, skipped, err := readBackup(ctx, bytes.NewReader(data))
+		log.Printf("✓ Restored %d links from %s (%d already existed)", restored, key, skipped)
+		return err
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown backup command %q", fs.Arg(0))
+	}
+}
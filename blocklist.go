@@ -0,0 +1,318 @@
+package shorty
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/idna"
+)
+
+// Blocklist feed sync. Feeds are fetched whole, so the limits allow for the full
+// URLhaus and OpenPhish lists; entries are stored in batches.
+const (
+	maxBlocklistFeedBytes = 64 << 20
+	blocklistBatchSize    = 5000
+)
+
+// Kinds of blocklist entries
+const (
+	blockDomain = "domain"
+	blockURL    = "url"
+)
+
+// BLOCKLIST_ACTION values: what happens to existing links a new entry matches
+const (
+	blocklistDisable = "disable"
+	blocklistWarn    = "warn"
+)
+
+// blocklistWarning is the flag reason of links a blocklist entry matched under the warn
+// action, shown on their warning page
+const blocklistWarning = "it is listed by a threat intelligence feed"
+
+// blocklistClient fetches the feeds, which are configured by the operator and so are not
+// restricted to public addresses like destinations are
+var blocklistClient = &http.Client{Timeout: 2 * time.Minute}
+
+// BlocklistEntry is a domain or URL listed by a threat feed. Domains also cover their
+// subdomains; URLs match links with the same normalized destination.
+type BlocklistEntry struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// BlocklistFeedStatus is the state of one configured feed
+type BlocklistFeedStatus struct {
+	URL      string     `json:"url"`
+	Entries  int64      `json:"entries"`
+	LastSeen *time.Time `json:"last_synced_at,omitempty"`
+
+	// The result of the last sync run by this instance
+	LastRun   *time.Time `json:"last_run_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Added     int        `json:"added"`
+	Removed   int64      `json:"removed"`
+	Blocked   int        `json:"blocked_links"`
+}
+
+// BlockedLink is a link disabled or flagged because its destination appeared on a feed
+type BlockedLink struct {
+	ShortCode   string    `json:"short_code"`
+	OriginalURL string    `json:"original_url"`
+	Owner       string    `json:"owner,omitempty"`
+	Kind        string    `json:"kind"`
+	Value       string    `json:"value"`
+	Source      string    `json:"source"`
+	Action      string    `json:"action"`
+	BlockedAt   time.Time `json:"blocked_at"`
+}
+
+// feedStatuses holds the last sync result of each feed on this instance
+var feedStatuses = struct {
+	sync.Mutex
+	byURL map[string]BlocklistFeedStatus
+}{byURL: map[string]BlocklistFeedStatus{}}
+
+// syncBlocklists is the blocklist job, refreshing every feed in BLOCKLIST_FEEDS. A feed
+// that fails keeps its previous entries; feeds removed from the setting lose theirs.
+func syncBlocklists(ctx context.Context) error {
+	removed, err := deleteUnlistedBlocklists(ctx, cfg().BlocklistFeeds)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("✓ Removed %d entries of blocklist feeds no longer configured", removed)
+	}
+
+	var failed []string
+	for _, feed := range cfg().BlocklistFeeds {
+		status, err := syncBlocklistFeed(ctx, feed)
+		now := time.Now().UTC()
+		status.URL, status.LastRun = feed, &now
+		if err != nil {
+			status.LastError = err.Error()
+			failed = append(failed, feed)
+			log.Printf("Blocklist feed %s failed: %v", feed, err)
+		}
+		feedStatuses.Lock()
+		feedStatuses.byURL[feed] = status
+		feedStatuses.Unlock()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d feeds failed", len(failed), len(cfg().BlocklistFeeds))
+	}
+	return nil
+}
+
+// syncBlocklistFeed replaces a feed's stored entries with its current contents and
+// applies BLOCKLIST_ACTION to active links matching entries it did not list before
+func syncBlocklistFeed(ctx context.Context, feed string) (BlocklistFeedStatus, error) {
+	var status BlocklistFeedStatus
+	entries, err := fetchBlocklistFeed(ctx, feed)
+	if err != nil {
+		return status, err
+	}
+	if len(entries) == 0 {
+		return status, errors.New("feed has no entries")
+	}
+
+	started := time.Now().UTC()
+	var added []BlocklistEntry
+	for start := 0; start < len(entries); start += blocklistBatchSize {
+		batch := entries[start:min(start+blocklistBatchSize, len(entries))]
+		inserted, err := upsertBlocklistEntries(ctx, feed, batch, started)
+		if err != nil {
+			return status, err
+		}
+		added = append(added, inserted...)
+	}
+	status.Added = len(added)
+	if status.Removed, err = deleteStaleBlocklistEntries(ctx, feed, started); err != nil {
+		return status, err
+	}
+
+	for start := 0; start < len(added); start += blocklistBatchSize {
+		batch := added[start:min(start+blocklistBatchSize, len(added))]
+		codes, err := blockMatchingLinks(ctx, batch, blocklistAction())
+		if err != nil {
+			return status, err
+		}
+		status.Blocked += len(codes)
+	}
+	if status.Added > 0 || status.Removed > 0 || status.Blocked > 0 {
+		log.Printf("✓ Blocklist %s: %d added, %d removed, %d links blocked", feed, status.Added, status.Removed, status.Blocked)
+	}
+	return status, nil
+}
+
+// blocklistAction returns BLOCKLIST_ACTION, disabling links unless warn is set
+func blocklistAction() string {
+	if strings.EqualFold(cfg().BlocklistAction, blocklistWarn) {
+		return blocklistWarn
+	}
+	return blocklistDisable
+}
+
+// fetchBlocklistFeed downloads and parses one feed
+func fetchBlocklistFeed(ctx context.Context, feed string) ([]BlocklistEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Shorty/"+version+" (blocklist sync)")
+	resp, err := blocklistClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+	return parseBlocklistFeed(io.LimitReader(resp.Body, maxBlocklistFeedBytes), feed)
+}
+
+// parseBlocklistFeed reads a plain list or CSV feed such as URLhaus or OpenPhish. Lines
+// starting with # are comments. Each record contributes its first http(s) URL, or
+// failing that its first field that is a domain name; header rows and other records are
+// skipped.
+func parseBlocklistFeed(r io.Reader, source string) ([]BlocklistEntry, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	seen := map[BlocklistEntry]bool{}
+	var entries []BlocklistEntry
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := blocklistRecordEntry(record)
+		if !ok {
+			continue
+		}
+		entry.Source = source
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// blocklistRecordEntry picks the listed URL or domain out of one feed record
+func blocklistRecordEntry(record []string) (BlocklistEntry, bool) {
+	for _, field := range record {
+		field = strings.TrimSpace(field)
+		lower := strings.ToLower(field)
+		if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+			if u, err := url.Parse(asciiURL(field)); err == nil && u.Host != "" {
+				return BlocklistEntry{Kind: blockURL, Value: normalizeDestination(field)}, true
+			}
+		}
+	}
+	for _, field := range record {
+		if domain, ok := blocklistDomain(field); ok {
+			return BlocklistEntry{Kind: blockDomain, Value: domain}, true
+		}
+	}
+	return BlocklistEntry{}, false
+}
+
+// blocklistDomain returns a host name in the form links' domains are stored in: Unicode,
+// lowercase and without "www."
+func blocklistDomain(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil || !domainPattern.MatchString(ascii) {
+		return "", false
+	}
+	display, err := idna.Display.ToUnicode(ascii)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimPrefix(display, "www."), true
+}
+
+// parentDomains returns a domain and every domain above it, most specific first
+func parentDomains(domain string) []string {
+	domains := []string{domain}
+	for {
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok || parent == "" {
+			return domains
+		}
+		domains = append(domains, parent)
+		domain = parent
+	}
+}
+
+// checkBlocklist returns a validation error when a destination, or its domain or any
+// domain above it, is on a blocklist feed
+func checkBlocklist(ctx context.Context, field, destination string) error {
+	var domains []string
+	if u, err := url.Parse(asciiURL(destination)); err == nil {
+		if domain, ok := blocklistDomain(u.Hostname()); ok {
+			domains = parentDomains(domain)
+		}
+	}
+	entry, err := findBlocklistEntry(ctx, normalizeDestination(destination), domains)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &FieldError{Field: field, Rule: "blocklist", Param: entry.Value, Message: field + " is listed as malicious by a blocklist feed"}
+}
+
+// adminBlocklistReport handles GET /admin/blocklist?limit=100, reporting each feed's
+// state and the links disabled or flagged because of blocklist entries, newest first
+func adminBlocklistReport(c *gin.Context) {
+	limit := 100
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, 1000)
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	stored, err := blocklistSourceStats(ctx)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch blocklist")
+		return
+	}
+	links, err := listBlockedLinks(ctx, limit)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch blocklist")
+		return
+	}
+
+	feeds := make([]BlocklistFeedStatus, 0, len(cfg().BlocklistFeeds))
+	feedStatuses.Lock()
+	for _, feed := range cfg().BlocklistFeeds {
+		status := feedStatuses.byURL[feed]
+		status.URL = feed
+		status.Entries, status.LastSeen = stored[feed].Entries, stored[feed].LastSeen
+		feeds = append(feeds, status)
+	}
+	feedStatuses.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"feeds": feeds, "action": blocklistAction(), "blocked_links": links})
+}
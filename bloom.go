@@ -0,0 +1,295 @@
+package shorty
+
+import (
+	"context"
+	"expvar"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bloomSyncOverlap is how many ids below the highest one seen each incremental sync
+// re-reads, so codes committed slightly out of id order are not missed
+const bloomSyncOverlap = 1000
+
+// bloomFilter is a fixed-size Bloom filter over short codes, compared by codeKey. Bits
+// are set atomically, so codes can be added while the filter is being filled.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloomFilter sizes a filter for n items at the given false positive rate
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// positions returns the k bit positions for s using double hashing
+func (f *bloomFilter) positions(s string, fn func(pos uint64)) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(s))
+	h2 := fnv.New64()
+	h2.Write([]byte(s))
+	a, b := h1.Sum64(), h2.Sum64()|1
+
+	for i := uint64(0); i < f.k; i++ {
+		fn((a + i*b) % f.m)
+	}
+}
+
+func (f *bloomFilter) add(s string) {
+	f.positions(codeKey(s), func(pos uint64) {
+		word, bit := &f.bits[pos/64], uint64(1)<<(pos%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&bit != 0 || atomic.CompareAndSwapUint64(word, old, old|bit) {
+				return
+			}
+		}
+	})
+}
+
+func (f *bloomFilter) contains(s string) bool {
+	found := true
+	f.positions(codeKey(s), func(pos uint64) {
+		if atomic.LoadUint64(&f.bits[pos/64])&(1<<(pos%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// codeFilter answers "might this short code exist?" without querying the link. It is
+// rebuilt from the urls table periodically, updated on insert, and synced with codes
+// inserted by other instances every BLOOM_SYNC_INTERVAL and before a miss is trusted.
+// Syncs follow urls.id rather than created_at, which restored links keep from their
+// backup. Restores can insert ids below those already synced, so a change of the
+// restore generation sets the filter aside until it is rebuilt.
+type codeFilter struct {
+	mu         sync.RWMutex
+	current    *bloomFilter
+	building   *bloomFilter
+	lastID     int64
+	generation int64
+
+	// syncMu serializes syncs; lastSync is when the last successful one started
+	syncMu   sync.Mutex
+	lastSync time.Time
+
+	// rebuildNow asks Run for a rebuild after a restore
+	rebuildNow chan struct{}
+
+	// scan calls fn with the id and code of every link with an id above afterID
+	scan func(ctx context.Context, afterID int64, fn func(id int64, code string)) error
+
+	// restores returns the restore generation, or is nil when restores are not tracked
+	restores func(ctx context.Context) (int64, error)
+
+	rejected *expvar.Int
+}
+
+var knownCodes = &codeFilter{
+	scan:       eachShortCode,
+	restores:   restoreGeneration,
+	rebuildNow: make(chan struct{}, 1),
+	rejected:   expvar.NewInt("shorty_bloom_rejected_total"),
+}
+
+// MightContain reports false only for codes that definitely do not exist. Until the
+// first build completes every code is allowed through. A code missing from the filter
+// is only rejected after a sync that started after the call, so codes created by other
+// instances are never missed; if that sync fails the code is allowed through.
+func (f *codeFilter) MightContain(ctx context.Context, code string) bool {
+	asked := time.Now()
+	if f.has(code) {
+		return true
+	}
+	if err := f.syncSince(ctx, asked); err != nil || f.has(code) {
+		return true
+	}
+	f.rejected.Add(1)
+	return false
+}
+
+// has reports whether code is in the current filter, or true without one
+func (f *codeFilter) has(code string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.current == nil || f.current.contains(code)
+}
+
+// Add records a newly created code, including in a filter that is being rebuilt
+func (f *codeFilter) Add(code string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.current != nil {
+		f.current.add(code)
+	}
+	if f.building != nil {
+		f.building.add(code)
+	}
+}
+
+// Run builds the filter and keeps it fresh until the server is closed
+func (f *codeFilter) Run() {
+	if !cfg().BloomEnabled {
+		return
+	}
+
+	f.rebuild()
+	rebuild := time.NewTicker(cfg().BloomRebuildInterval)
+	incremental := time.NewTicker(cfg().BloomSyncInterval)
+	for {
+		select {
+		case <-rebuild.C:
+			f.rebuild()
+		case <-incremental.C:
+			f.sync()
+		case <-f.rebuildNow:
+			f.rebuild()
+		case <-stopped:
+			rebuild.Stop()
+			incremental.Stop()
+			return
+		}
+	}
+}
+
+// rebuild replaces the filter with one sized for the current number of codes
+func (f *codeFilter) rebuild() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	count, err := countURLs(ctx)
+	if err != nil {
+		log.Printf("Failed to count codes for bloom filter: %v", err)
+		return
+	}
+
+	// Leave room to grow until the next rebuild
+	filter := newBloomFilter(int(count)*2+100000, cfg().BloomFalsePositiveRate)
+	started := time.Now()
+	if err := f.fill(ctx, filter); err != nil {
+		log.Printf("Failed to rebuild bloom filter: %v", err)
+		return
+	}
+	log.Printf("✓ Bloom filter rebuilt with %d codes in %s", count, time.Since(started).Round(time.Millisecond))
+}
+
+// fill adds every code to filter and makes it the current one. Codes inserted on this
+// instance while the table is scanned are added to filter by Add. The restore generation
+// is read first, so a restore during the scan leads to another rebuild.
+func (f *codeFilter) fill(ctx context.Context, filter *bloomFilter) error {
+	var generation int64
+	if f.restores != nil {
+		var err error
+		if generation, err = f.restores(ctx); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	f.building = filter
+	f.mu.Unlock()
+
+	var lastID int64
+	err := f.scan(ctx, 0, func(id int64, code string) {
+		filter.add(code)
+		lastID = max(lastID, id)
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.building = nil
+	if err != nil {
+		return err
+	}
+	f.current = filter
+	f.lastID = lastID
+	f.generation = generation
+	return nil
+}
+
+// sync adds codes inserted since the last sync, including by other instances
+func (f *codeFilter) sync() {
+	ctx, cancel := backgroundDBContext()
+	defer cancel()
+	if err := f.syncSince(ctx, time.Now()); err != nil {
+		log.Printf("Failed to sync bloom filter: %v", err)
+	}
+}
+
+// syncSince returns once a sync that started at or after since has succeeded, running
+// one unless another caller already did. Concurrent callers share syncs, so a burst of
+// misses costs about two syncs rather than one each.
+func (f *codeFilter) syncSince(ctx context.Context, since time.Time) error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+	if !f.lastSync.Before(since) {
+		return nil
+	}
+	started := time.Now()
+	if err := f.syncFrom(ctx); err != nil {
+		return err
+	}
+	f.lastSync = started
+	return nil
+}
+
+// syncFrom adds the codes with ids above the highest one seen, less bloomSyncOverlap.
+// After a restore the filter is dropped, letting every code through, and Run is asked
+// to rebuild it.
+func (f *codeFilter) syncFrom(ctx context.Context) error {
+	f.mu.RLock()
+	ready := f.current != nil
+	afterID := max(0, f.lastID-bloomSyncOverlap)
+	generation := f.generation
+	f.mu.RUnlock()
+	if !ready {
+		return nil
+	}
+
+	if f.restores != nil {
+		latest, err := f.restores(ctx)
+		if err != nil {
+			return err
+		}
+		if latest != generation {
+			f.mu.Lock()
+			f.current = nil
+			f.mu.Unlock()
+			select {
+			case f.rebuildNow <- struct{}{}:
+			default:
+			}
+			return nil
+		}
+	}
+
+	var recent []string
+	lastID := afterID
+	err := f.scan(ctx, afterID, func(id int64, code string) {
+		recent = append(recent, code)
+		lastID = max(lastID, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, code := range recent {
+		f.current.add(code)
+	}
+	f.lastID = max(f.lastID, lastID)
+	return nil
+}
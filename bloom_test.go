@@ -0,0 +1,187 @@
+package shorty
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeCodes is a urls table for codeFilter.scan
+type fakeCodes struct {
+	mu    sync.Mutex
+	codes map[int64]string
+}
+
+func (t *fakeCodes) insert(id int64, code string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.codes[id] = code
+}
+
+func (t *fakeCodes) scan(ctx context.Context, afterID int64, fn func(id int64, code string)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, code := range t.codes {
+		if id > afterID {
+			fn(id, code)
+		}
+	}
+	return nil
+}
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	filter := newBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		filter.add(fmt.Sprintf("code%d", i))
+	}
+	for i := 0; i < 10000; i++ {
+		if code := fmt.Sprintf("code%d", i); !filter.contains(code) {
+			t.Fatalf("filter lost %q", code)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.contains(fmt.Sprintf("absent%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("%d of 10000 absent codes matched, want about 100", falsePositives)
+	}
+}
+
+func TestBloomFilterConcurrentAdd(t *testing.T) {
+	filter := newBloomFilter(1000, 0.01)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				filter.add(fmt.Sprintf("w%d-%d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 500; i++ {
+			if code := fmt.Sprintf("w%d-%d", w, i); !filter.contains(code) {
+				t.Fatalf("filter lost %q added concurrently", code)
+			}
+		}
+	}
+}
+
+func TestCodeFilterAllowsEverythingUntilBuilt(t *testing.T) {
+	f := &codeFilter{scan: (&fakeCodes{codes: map[int64]string{}}).scan, rejected: new(expvar.Int)}
+	if !f.MightContain(context.Background(), "anything") {
+		t.Error("an unbuilt filter rejected a code")
+	}
+}
+
+func TestCodeFilterAddDuringFill(t *testing.T) {
+	table := &fakeCodes{codes: map[int64]string{1: "first"}}
+	f := &codeFilter{rejected: new(expvar.Int)}
+	f.scan = func(ctx context.Context, afterID int64, fn func(id int64, code string)) error {
+		// A code created on this instance while the table is scanned
+		f.Add("during")
+		return table.scan(ctx, afterID, fn)
+	}
+	if err := f.fill(context.Background(), newBloomFilter(100, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"first", "during"} {
+		if !f.MightContain(context.Background(), code) {
+			t.Errorf("%q is missing after the rebuild", code)
+		}
+	}
+	if f.MightContain(context.Background(), "absent") {
+		t.Error("an absent code passed the filter")
+	}
+}
+
+func TestCodeFilterSync(t *testing.T) {
+	table := &fakeCodes{codes: map[int64]string{1: "a", 2: "b"}}
+	f := &codeFilter{scan: table.scan, rejected: new(expvar.Int)}
+	if err := f.fill(context.Background(), newBloomFilter(100, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance inserts a link, and a restore inserts one with an old created_at
+	table.insert(3, "other-instance")
+	table.insert(4, "restored")
+	if err := f.syncFrom(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"a", "b", "other-instance", "restored"} {
+		if !f.MightContain(context.Background(), code) {
+			t.Errorf("%q is missing after the sync", code)
+		}
+	}
+	if f.lastID != 4 {
+		t.Errorf("lastID = %d, want 4", f.lastID)
+	}
+
+	// An insert that commits after a higher id was synced is still picked up
+	table.insert(6, "later")
+	f.syncFrom(context.Background())
+	table.insert(5, "committed-late")
+	f.syncFrom(context.Background())
+	if !f.MightContain(context.Background(), "committed-late") {
+		t.Error("a code committed out of id order was missed")
+	}
+}
+
+func TestCodeFilterSyncsBeforeRejecting(t *testing.T) {
+	table := &fakeCodes{codes: map[int64]string{1: "a"}}
+	f := &codeFilter{scan: table.scan, rejected: new(expvar.Int)}
+	if err := f.fill(context.Background(), newBloomFilter(100, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance creates a link before the next scheduled sync
+	table.insert(2, "just-created")
+	if !f.MightContain(context.Background(), "just-created") {
+		t.Error("a code created by another instance was rejected")
+	}
+	if f.MightContain(context.Background(), "absent") {
+		t.Error("an absent code passed the filter")
+	}
+}
+
+func TestCodeFilterRestore(t *testing.T) {
+	table := &fakeCodes{codes: map[int64]string{5000: "a"}}
+	var generation int64
+	f := &codeFilter{
+		scan:       table.scan,
+		restores:   func(ctx context.Context) (int64, error) { return generation, nil },
+		rebuildNow: make(chan struct{}, 1),
+		rejected:   new(expvar.Int),
+	}
+	if err := f.fill(context.Background(), newBloomFilter(100, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restore inserts a link with an id far below the ones already synced
+	table.insert(1, "restored")
+	generation++
+	if !f.MightContain(context.Background(), "restored") {
+		t.Error("a restored code was rejected")
+	}
+	select {
+	case <-f.rebuildNow:
+	default:
+		t.Error("the restore did not ask for a rebuild")
+	}
+
+	if err := f.fill(context.Background(), newBloomFilter(100, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+	if !f.MightContain(context.Background(), "restored") || f.MightContain(context.Background(), "absent") {
+		t.Error("the rebuilt filter does not match the table")
+	}
+}
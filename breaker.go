@@ -0,0 +1,110 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// errCircuitOpen is returned without touching the database while the breaker is open
+var errCircuitOpen = errors.New("database circuit breaker is open")
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker fails fast after repeated database failures. Once open it rejects calls
+// for a cooldown period, then lets a single probe through to decide whether to close again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold func() int
+	cooldown  func() time.Duration
+}
+
+var dbBreaker = &circuitBreaker{
+	state:     breakerClosed,
+	threshold: func() int { return cfg().DBBreakerThreshold },
+	cooldown:  func() time.Duration { return cfg().DBBreakerCooldown },
+}
+
+// call runs fn unless the breaker is open, recording whether it failed
+func (b *circuitBreaker) call(fn func() error) error {
+	if !b.allow() {
+		return errCircuitOpen
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// allow reports whether a call may proceed
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown() {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		// Only the probe is allowed until it reports back
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a call
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isDatabaseFailure(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold() {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the current breaker state
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// isDatabaseFailure reports whether err means the database is unreachable or unresponsive.
+// Missing rows, constraint violations and other errors reported by Postgres itself prove
+// the database is up, and a client disconnecting says nothing about its health.
+func isDatabaseFailure(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) || errors.Is(err, errNotFound) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	return !errors.As(err, &pgErr)
+}
@@ -0,0 +1,163 @@
+package shorty
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk operation limits; up to 1000 codes can also be listed explicitly
+const (
+	maxBulkMatches = 10000
+	bulkBatchSize  = 500
+)
+
+// Bulk actions
+const (
+	bulkDelete    = "delete"
+	bulkArchive   = "archive"
+	bulkUnarchive = "unarchive"
+	bulkTag       = "tag"
+	bulkUntag     = "untag"
+)
+
+// BulkRequest is the body of POST /api/urls/bulk. It targets either Codes or the links
+// matching Filter, never both.
+type BulkRequest struct {
+	Action string            `json:"action" binding:"required,oneof=delete archive unarchive tag untag"`
+	Codes  []string          `json:"codes" binding:"omitempty,max=1000,dive,required,max=32"`
+	Filter *LinkFilterParams `json:"filter"`
+	Tags   []string          `json:"tags" binding:"omitempty,max=10,dive,tag"`
+}
+
+// BulkFailure reports a link the action was not applied to
+type BulkFailure struct {
+	ShortCode string `json:"short_code"`
+	Reason    string `json:"reason"`
+}
+
+// BulkReport is the result of a bulk operation
+type BulkReport struct {
+	Action    string        `json:"action"`
+	Matched   int           `json:"matched"`
+	Succeeded int           `json:"succeeded"`
+	Failed    []BulkFailure `json:"failed"`
+	Batches   int           `json:"batches"`
+
+	// More is set when a filter matched more than maxBulkMatches links; run the request
+	// again to process the rest
+	More bool `json:"more"`
+}
+
+// bulkLinkState is what a bulk action needs to know about a link before applying it
+type bulkLinkState struct {
+	Immutable bool
+	Alias     bool
+}
+
+// bulkUpdateURLs handles POST /api/urls/bulk, applying one action to many of the
+// caller's links in batches
+func bulkUpdateURLs(c *gin.Context) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	if (len(req.Codes) == 0) == (req.Filter == nil) {
+		respondValidationError(c, FieldError{Field: "codes", Rule: "required_without", Param: "filter", Message: "exactly one of codes and filter is required"})
+		return
+	}
+	if (req.Action == bulkTag || req.Action == bulkUntag) && len(req.Tags) == 0 {
+		respondValidationError(c, FieldError{Field: "tags", Rule: "required", Message: "tags is required for the " + req.Action + " action"})
+		return
+	}
+
+	owner, _ := apiKeyName(c)
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	codes := req.Codes
+	report := BulkReport{Action: req.Action, Failed: []BulkFailure{}}
+	if req.Filter != nil {
+		filter, details := parseLinkFilter(*req.Filter)
+		// An empty filter is refused so that a mistake cannot select every link
+		if len(details) == 0 && filter.empty() {
+			details = append(details, FieldError{Field: "filter", Rule: "required", Message: "filter must have at least one condition"})
+		}
+		if len(details) > 0 {
+			respondValidationError(c, details...)
+			return
+		}
+		var err error
+		codes, err = listOwnerCodes(ctx, owner, filter, maxBulkMatches+1)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to find links")
+			return
+		}
+		if len(codes) > maxBulkMatches {
+			codes, report.More = codes[:maxBulkMatches], true
+		}
+	}
+	slices.Sort(codes)
+	codes = slices.Compact(codes)
+	report.Matched = len(codes)
+
+	tags := normalizeTags(req.Tags)
+	for start := 0; start < len(codes); start += bulkBatchSize {
+		batch := codes[start:min(start+bulkBatchSize, len(codes))]
+		report.Batches++
+
+		states, err := bulkLinkStates(ctx, owner, batch)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to update links")
+			return
+		}
+		eligible := make([]string, 0, len(batch))
+		for _, code := range batch {
+			state, ok := states[code]
+			switch {
+			case !ok:
+				report.Failed = append(report.Failed, BulkFailure{ShortCode: code, Reason: "not_found"})
+			case req.Action == bulkDelete && (state.Immutable || cfg().ImmutableLinks):
+				report.Failed = append(report.Failed, BulkFailure{ShortCode: code, Reason: "immutable"})
+			case (req.Action == bulkTag || req.Action == bulkUntag) && state.Alias:
+				report.Failed = append(report.Failed, BulkFailure{ShortCode: code, Reason: "alias"})
+			default:
+				eligible = append(eligible, code)
+			}
+		}
+
+		var applied []string
+		switch req.Action {
+		case bulkDelete:
+			applied, err = deleteOwnerURLs(ctx, owner, eligible)
+		case bulkArchive, bulkUnarchive:
+			applied, err = setOwnerURLsDisabled(ctx, owner, eligible, req.Action == bulkArchive)
+		case bulkTag:
+			applied, err = retagURLs(ctx, owner, eligible, tags, nil)
+		case bulkUntag:
+			applied, err = retagURLs(ctx, owner, eligible, nil, tags)
+		}
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to update links")
+			return
+		}
+		report.Succeeded += len(applied)
+		for _, code := range eligible {
+			if !containsString(applied, code) {
+				report.Failed = append(report.Failed, BulkFailure{ShortCode: code, Reason: bulkFailureReason(req.Action)})
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// bulkFailureReason explains why an eligible link was left out by the store
+func bulkFailureReason(action string) string {
+	if action == bulkTag {
+		return "too_many_tags"
+	}
+	return "not_found"
+}
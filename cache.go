@@ -0,0 +1,168 @@
+package shorty
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// lruCache is a fixed-capacity, least-recently-used cache of short code destinations,
+// keyed by codeKey.
+// Entries expire after a TTL as a safety net for changes made by other instances;
+// changes made by this instance invalidate entries explicitly.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+
+	hits   *expvar.Int
+	misses *expvar.Int
+}
+
+// cacheEntry is a cached resolvedLink
+type cacheEntry struct {
+	resolvedLink
+	expiresAt time.Time
+}
+
+// destinationCache caches nothing until start replaces it with one sized from
+// CACHE_SIZE and CACHE_TTL
+var destinationCache = newLRUCache(0, 0)
+
+var (
+	cacheHits   = expvar.NewInt("shorty_cache_hits_total")
+	cacheMisses = expvar.NewInt("shorty_cache_misses_total")
+)
+
+func init() {
+	registerHealthCheck("cache", false, func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{
+			"entries":  destinationCache.Len(),
+			"capacity": destinationCache.capacity,
+			"hits":     destinationCache.hits.Value(),
+			"misses":   destinationCache.misses.Value(),
+		}, nil
+	})
+}
+
+// warmCache preloads the most clicked and most recently created codes so a fresh
+// deploy does not serve peak traffic from a cold cache. Each code is resolved like a
+// cache miss would be, so links that cannot be followed are never cached.
+func warmCache() {
+	n := cfg().CacheWarmCount
+	if n <= 0 || destinationCache.capacity <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	warmed := 0
+	codes, err := listHotCodes(ctx, n)
+	if err != nil {
+		log.Printf("Cache warm-up failed: %v", err)
+		return
+	}
+	for _, code := range codes {
+		link, expiresAt, err := queryDestination(ctx, code, false)
+		if ctx.Err() != nil {
+			log.Printf("Cache warm-up stopped after %d codes: %v", warmed, ctx.Err())
+			return
+		}
+		// Links expiring before a cache entry would are not cached, as in lookupDestination
+		if err != nil || (expiresAt != nil && time.Until(*expiresAt) <= cfg().CacheTTL) {
+			continue
+		}
+		destinationCache.Set(link)
+		warmed++
+	}
+	log.Printf("✓ Warmed cache with %d codes in %s", warmed, time.Since(start).Round(time.Millisecond))
+}
+
+func newLRUCache(capacity int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		hits:     cacheHits,
+		misses:   cacheMisses,
+	}
+}
+
+// Get returns the cached link for code
+func (c *lruCache) Get(code string) (resolvedLink, bool) {
+	if c.capacity <= 0 {
+		return resolvedLink{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[codeKey(code)]
+	if !ok {
+		c.misses.Add(1)
+		return resolvedLink{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(el)
+		c.misses.Add(1)
+		return resolvedLink{}, false
+	}
+
+	c.order.MoveToFront(el)
+	c.hits.Add(1)
+	return entry.resolvedLink, true
+}
+
+// Set caches a link under its code, evicting the least recently used entry when full
+func (c *lruCache) Set(link resolvedLink) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if el, ok := c.entries[codeKey(link.Code)]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.resolvedLink = link
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[codeKey(link.Code)] = c.order.PushFront(&cacheEntry{resolvedLink: link, expiresAt: expiresAt})
+	if c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate drops code from the cache after it is edited or deleted
+func (c *lruCache) Invalidate(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[codeKey(code)]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of cached entries
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *lruCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, codeKey(el.Value.(*cacheEntry).Code))
+}
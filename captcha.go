@@ -0,0 +1,114 @@
+package shorty
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const captchaHeaderName = "X-Captcha-Token"
+
+// captchaProvider describes a supported CAPTCHA service
+type captchaProvider struct {
+	VerifyURL string
+	ScriptURL string
+	Class     string
+}
+
+var captchaProviders = map[string]captchaProvider{
+	"hcaptcha": {
+		VerifyURL: "https://api.hcaptcha.com/siteverify",
+		ScriptURL: "https://js.hcaptcha.com/1/api.js",
+		Class:     "h-captcha",
+	},
+	"turnstile": {
+		VerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		ScriptURL: "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		Class:     "cf-turnstile",
+	},
+}
+
+var captchaClient = &http.Client{Timeout: 5 * time.Second}
+
+// captchaMiddleware requires anonymous callers to pass a CAPTCHA challenge when
+// CAPTCHA_PROVIDER is configured. API key clients skip the check.
+func captchaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, enabled := captchaProviders[cfg().CaptchaProvider]
+		if !enabled {
+			c.Next()
+			return
+		}
+		if _, ok := apiKeyName(c); ok {
+			c.Next()
+			return
+		}
+
+		token := c.GetHeader(captchaHeaderName)
+		if token == "" {
+			respondError(c, http.StatusForbidden, "CAPTCHA token is required")
+			return
+		}
+
+		ok, err := verifyCaptcha(c.Request.Context(), provider, token, c.ClientIP())
+		if err != nil {
+			logRequest(c, "CAPTCHA verification failed: %v", err)
+			respondError(c, http.StatusBadGateway, "CAPTCHA verification is unavailable")
+			return
+		}
+		if !ok {
+			respondError(c, http.StatusForbidden, "CAPTCHA verification failed")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// verifyCaptcha checks a widget response token with the provider
+func verifyCaptcha(ctx context.Context, provider captchaProvider, token, remoteIP string) (bool, error) {
+	form := url.Values{
+		"secret":   {cfg().CaptchaSecret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := captchaClient.Do(withRequestID(ctx, req))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
+
+// captchaWidget returns the HTML that renders the configured CAPTCHA widget
+func captchaWidget() string {
+	provider, enabled := captchaProviders[cfg().CaptchaProvider]
+	if !enabled {
+		return ""
+	}
+	siteKey := template.HTMLEscapeString(cfg().CaptchaSiteKey)
+	return strings.Join([]string{
+		`<script src="` + provider.ScriptURL + `" async defer></script>`,
+		`<div class="captcha ` + provider.Class + `" data-sitekey="` + siteKey + `"></div>`,
+	}, "\n        ")
+}
@@ -0,0 +1,174 @@
+package shorty
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxBufferedClickEventsFactor bounds buffered click events to this many flushes' worth
+// while the database is unavailable; older events are dropped beyond that
+const maxBufferedClickEventsFactor = 10
+
+// clickBatcher aggregates redirects per short code in memory and writes them in one
+// UPDATE per flush, instead of one UPDATE per click. Individual click events are
+// buffered alongside and copied into click_events in bulk. Visits turned away by a
+// link's country rules are counted per country, apart from clicks.
+type clickBatcher struct {
+	mu      sync.Mutex
+	counts  map[string]int64
+	events  []clickEvent
+	blocked map[geoBlock]int64
+	pending int
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+var clicks = newClickBatcher()
+
+func newClickBatcher() *clickBatcher {
+	return &clickBatcher{
+		counts:   map[string]int64{},
+		blocked:  map[geoBlock]int64{},
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Add records one click, triggering an early flush once CLICK_FLUSH_SIZE clicks are buffered
+func (b *clickBatcher) Add(event clickEvent) {
+	b.mu.Lock()
+	b.counts[event.ShortCode]++
+	if cfg().ClickEventsEnabled {
+		b.events = append(b.events, event)
+	}
+	b.pending++
+	full := b.pending >= cfg().ClickFlushSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// AddBlocked records a visit from country that the link's country rules turned away
+func (b *clickBatcher) AddBlocked(code, country string) {
+	b.mu.Lock()
+	b.blocked[geoBlock{ShortCode: code, Country: country}]++
+	b.mu.Unlock()
+}
+
+// Run flushes buffered clicks every CLICK_FLUSH_INTERVAL until Stop is called
+func (b *clickBatcher) Run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(cfg().ClickFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.flushNow:
+			b.flush()
+		case <-b.stop:
+			b.flush()
+			return
+		}
+	}
+}
+
+// Stop writes any buffered clicks and waits for the final flush to finish
+func (b *clickBatcher) Stop() {
+	close(b.stop)
+	<-b.stopped
+}
+
+// flush writes the buffered counts and events, putting them back to retry on the next flush
+// if a write fails
+func (b *clickBatcher) flush() {
+	b.mu.Lock()
+	if len(b.counts) == 0 && len(b.events) == 0 && len(b.blocked) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	batch := b.counts
+	events := b.events
+	blocked := b.blocked
+	b.counts = map[string]int64{}
+	b.events = nil
+	b.blocked = map[geoBlock]int64{}
+	b.pending = 0
+	b.mu.Unlock()
+
+	b.flushEvents(events)
+	b.flushBlocked(blocked)
+	if len(batch) == 0 {
+		return
+	}
+
+	codes := make([]string, 0, len(batch))
+	counts := make([]int64, 0, len(batch))
+	for code, n := range batch {
+		codes = append(codes, code)
+		counts = append(counts, n)
+	}
+
+	ctx, cancel := backgroundDBContext()
+	defer cancel()
+
+	if err := addClicks(ctx, codes, counts); err != nil {
+		log.Printf("Failed to flush clicks for %d codes, will retry: %v", len(codes), err)
+		b.mu.Lock()
+		for code, n := range batch {
+			b.counts[code] += n
+			b.pending += int(n)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// flushEvents copies buffered click events into click_events, keeping a bounded backlog on failure
+func (b *clickBatcher) flushEvents(events []clickEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := backgroundDBContext()
+	defer cancel()
+
+	if err := insertClickEvents(ctx, events); err != nil {
+		log.Printf("Failed to write %d click events, will retry: %v", len(events), err)
+		b.mu.Lock()
+		b.events = append(events, b.events...)
+		if limit := maxBufferedClickEventsFactor * cfg().ClickFlushSize; len(b.events) > limit {
+			b.events = b.events[len(b.events)-limit:]
+		}
+		b.mu.Unlock()
+	}
+}
+
+// flushBlocked adds buffered blocked visits to geo_blocks, putting them back to retry on
+// the next flush if the write fails
+func (b *clickBatcher) flushBlocked(blocked map[geoBlock]int64) {
+	if len(blocked) == 0 {
+		return
+	}
+
+	ctx, cancel := backgroundDBContext()
+	defer cancel()
+
+	if err := addGeoBlocks(ctx, blocked); err != nil {
+		log.Printf("Failed to flush %d blocked visit counts, will retry: %v", len(blocked), err)
+		b.mu.Lock()
+		for key, n := range blocked {
+			b.blocked[key] += n
+		}
+		b.mu.Unlock()
+	}
+}
@@ -0,0 +1,65 @@
+package shorty
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloneRequest is the optional body of POST /api/urls/:code/clone. Fields left out are
+// copied from the source link.
+type CloneRequest struct {
+	URL   string    `json:"url"`
+	Alias string    `json:"alias" binding:"omitempty,min=3,max=32,alias"`
+	Tags  *[]string `json:"tags" binding:"omitempty,max=10,dive,tag"`
+}
+
+// cloneURL handles POST /api/urls/:code/clone, copying one of the caller's links into a
+// new code. Clicks and alerts are not copied.
+func cloneURL(c *gin.Context) {
+	source, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	var req CloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	link := URL{OriginalURL: source.OriginalURL, ShortCode: req.Alias, Owner: source.Owner, Tags: source.Tags, Immutable: source.Immutable, RedirectStatus: source.RedirectStatus, HTMLRedirect: source.HTMLRedirect, WaybackFallback: source.WaybackFallback, Visibility: source.Visibility, AllowedNetworks: source.AllowedNetworks, AllowedCountries: source.AllowedCountries, BlockedCountries: source.BlockedCountries, Schedule: source.Schedule, Metadata: source.Metadata}
+	if req.URL != "" {
+		link.OriginalURL = strings.TrimSpace(req.URL)
+		if !strings.Contains(link.OriginalURL, "://") {
+			link.OriginalURL = "https://" + link.OriginalURL
+		}
+		if fe := validateDestination("url", link.OriginalURL); fe != nil {
+			respondValidationError(c, *fe)
+			return
+		}
+		link.OriginalURL = unicodeURL(link.OriginalURL)
+	}
+	if req.Tags != nil {
+		link.Tags = normalizeTags(*req.Tags)
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	link, err := insertLink(ctx, link)
+	if err != nil {
+		respondCreateError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, ShortenResponse{
+		ShortURL:    buildShortURL(c, link.ShortCode),
+		ShortCode:   link.ShortCode,
+		OriginalURL: link.OriginalURL,
+		Moderation:  pendingState(link),
+		Links:       linkHyperlinks(c, link.ShortCode, true),
+	})
+}
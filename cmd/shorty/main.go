@@ -0,0 +1,29 @@
+// Command shorty runs the Shorty URL shortener and its operational subcommands.
+//
+//	shorty [serve]
+//	shorty migrate
+//	shorty import [-format csv|backup] [-owner name] <file>
+//	shorty export [-format csv|backup] [file]
+//	shorty backup [list | restore <key|latest>]
+//	shorty snapshot create|restore [-force] <file>
+//	shorty admin disable|enable <code>
+//	shorty codes collisions
+//	shorty encrypt
+//	shorty loadtest [-url base] [-rps n] [-duration d]
+//	shorty mail test <address>
+//	shorty discord register
+//	shorty help
+package main
+
+import (
+	"log"
+	"os"
+
+	"shorty"
+)
+
+func main() {
+	if err := shorty.Run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
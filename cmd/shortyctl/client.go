@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// config holds the server to talk to and the credentials to use
+type config struct {
+	Server string
+	APIKey string
+}
+
+// configPath returns $SHORTYCTL_CONFIG, or shortyctl/config in the user config directory
+func configPath() string {
+	if path := os.Getenv("SHORTYCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "shortyctl", "config")
+}
+
+// loadConfig reads KEY=VALUE lines from the config file; SHORTY_SERVER and SHORTY_API_KEY
+// in the environment take precedence
+func loadConfig() (config, error) {
+	cfg := config{Server: "http://localhost:8080"}
+
+	if path := configPath(); path != "" {
+		f, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return cfg, err
+		}
+		if err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				key, value, ok := strings.Cut(line, "=")
+				if !ok {
+					continue
+				}
+				value = strings.Trim(strings.TrimSpace(value), `"'`)
+				switch strings.TrimSpace(key) {
+				case "SHORTY_SERVER":
+					cfg.Server = value
+				case "SHORTY_API_KEY":
+					cfg.APIKey = value
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				return cfg, fmt.Errorf("reading %s: %w", path, err)
+			}
+		}
+	}
+
+	if v := os.Getenv("SHORTY_SERVER"); v != "" {
+		cfg.Server = v
+	}
+	if v := os.Getenv("SHORTY_API_KEY"); v != "" {
+		cfg.APIKey = v
+	}
+	cfg.Server = strings.TrimSuffix(cfg.Server, "/")
+	return cfg, nil
+}
+
+// client calls the Shorty JSON API
+type client struct {
+	cfg  config
+	http *http.Client
+}
+
+func newClient(cfg config) *client {
+	return &client{cfg: cfg, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// apiError is the error body returned by the API
+type apiError struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
+	Details   []struct {
+		Message string `json:"message"`
+	} `json:"details"`
+}
+
+// do sends a request and decodes a JSON response into out, returning the raw body
+func (c *client) do(method, path string, body, out any) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.cfg.Server+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", c.cfg.APIKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		var e apiError
+		if json.Unmarshal(raw, &e) != nil || e.Error == "" {
+			return raw, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(raw)))
+		}
+		msg := e.Error
+		for _, d := range e.Details {
+			msg += "; " + d.Message
+		}
+		if e.RequestID != "" {
+			msg += " (request " + e.RequestID + ")"
+		}
+		return raw, fmt.Errorf("%s", msg)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return raw, fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return raw, nil
+}
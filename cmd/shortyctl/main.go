@@ -0,0 +1,187 @@
+// Command shortyctl is a command line client for a Shorty instance.
+//
+//	shortyctl shorten <url> [--alias foo] [--tag campaign]
+//	shortyctl stats <code>
+//	shortyctl list [--tag campaign]
+//
+// The server URL and API key are read from $SHORTYCTL_CONFIG (default
+// ~/.config/shortyctl/config) as SHORTY_SERVER=... and SHORTY_API_KEY=... lines,
+// and may be overridden by environment variables of the same names.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// link mirrors the fields shortyctl prints from the API's URL and stats responses
+type link struct {
+	ShortURL    string    `json:"short_url"`
+	ShortCode   string    `json:"short_code"`
+	OriginalURL string    `json:"original_url"`
+	Clicks      int       `json:"clicks"`
+	CreatedAt   time.Time `json:"created_at"`
+	Tags        []string  `json:"tags"`
+}
+
+// stringList collects a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	c := newClient(cfg)
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "shorten":
+		err = shorten(c, args)
+	case "stats":
+		err = stats(c, args)
+	case "list":
+		err = list(c, args)
+	case "help", "-h", "--help":
+		usage()
+	default:
+		usage()
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  shortyctl shorten <url> [--alias code] [--tag tag]... [--json]
+  shortyctl stats <code> [--json]
+  shortyctl list [--tag tag] [--json]
+
+Configuration is read from `+configPath()+` (SHORTY_SERVER, SHORTY_API_KEY).`)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "shortyctl:", err)
+	os.Exit(1)
+}
+
+// parseArgs parses flags that may appear before or after positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func shorten(c *client, args []string) error {
+	fs := flag.NewFlagSet("shorten", flag.ExitOnError)
+	alias := fs.String("alias", "", "custom short code")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag to attach (repeatable)")
+	asJSON := fs.Bool("json", false, "print the raw JSON response")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: shortyctl shorten <url> [--alias code] [--tag tag]")
+	}
+
+	var created link
+	raw, err := c.do(http.MethodPost, "/api/shorten", map[string]any{
+		"url":   positional[0],
+		"alias": *alias,
+		"tags":  []string(tags),
+	}, &created)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		fmt.Println(string(raw))
+		return nil
+	}
+	fmt.Println(created.ShortURL)
+	return nil
+}
+
+func stats(c *client, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the raw JSON response")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: shortyctl stats <code>")
+	}
+
+	var l link
+	raw, err := c.do(http.MethodGet, "/api/stats/"+url.PathEscape(positional[0]), nil, &l)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		fmt.Println(string(raw))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Code:\t%s\n", l.ShortCode)
+	fmt.Fprintf(w, "Destination:\t%s\n", l.OriginalURL)
+	fmt.Fprintf(w, "Clicks:\t%d\n", l.Clicks)
+	fmt.Fprintf(w, "Created:\t%s\n", l.CreatedAt.Local().Format(time.RFC1123))
+	if len(l.Tags) > 0 {
+		fmt.Fprintf(w, "Tags:\t%s\n", strings.Join(l.Tags, ", "))
+	}
+	return w.Flush()
+}
+
+func list(c *client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	tag := fs.String("tag", "", "only links with this tag")
+	asJSON := fs.Bool("json", false, "print the raw JSON response")
+	parseArgs(fs, args)
+
+	path := "/api/urls"
+	if *tag != "" {
+		path += "?tag=" + url.QueryEscape(*tag)
+	}
+
+	var links []link
+	raw, err := c.do(http.MethodGet, path, nil, &links)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		fmt.Println(string(raw))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tCLICKS\tCREATED\tTAGS\tDESTINATION")
+	for _, l := range links {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
+			l.ShortCode, l.Clicks, l.CreatedAt.Local().Format("2006-01-02"), strings.Join(l.Tags, ","), l.OriginalURL)
+	}
+	return w.Flush()
+}
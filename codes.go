@@ -0,0 +1,82 @@
+package shorty
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// Generated codes leave out glyphs that are easily confused in print and QR captions:
+// 0/O/o and 1/l/I. Under CASE_INSENSITIVE_CODES i is left out as well, since it is what
+// a capital I turns into.
+const (
+	codeAlphabet          = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+	lowercaseCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// generatedCodeLength is the length of generated short codes
+const generatedCodeLength = 6
+
+// codeSkeletonFrom and codeSkeletonTo map lowercased look-alike glyphs onto one another.
+// codeSkeletonSQL must apply the same mapping for idx_urls_code_skeleton to be used.
+const (
+	codeSkeletonFrom = "0i1"
+	codeSkeletonTo   = "oll"
+	codeSkeletonSQL  = "translate(lower(short_code), '" + codeSkeletonFrom + "', '" + codeSkeletonTo + "')"
+)
+
+// generateShortCode creates a random code of unambiguous characters that is not a
+// reserved path
+func generateShortCode() (string, error) {
+	alphabet := codeAlphabet
+	if cfg().CaseInsensitiveCodes {
+		alphabet = lowercaseCodeAlphabet
+	}
+	// Bytes at or above limit are skipped so every character is equally likely
+	limit := 256 - 256%len(alphabet)
+
+	for {
+		code := make([]byte, 0, generatedCodeLength)
+		buf := make([]byte, 2*generatedCodeLength)
+		for len(code) < generatedCodeLength {
+			if _, err := rand.Read(buf); err != nil {
+				return "", err
+			}
+			for _, b := range buf {
+				if int(b) < limit && len(code) < generatedCodeLength {
+					code = append(code, alphabet[int(b)%len(alphabet)])
+				}
+			}
+		}
+		if !isReservedAlias(string(code)) {
+			return string(code), nil
+		}
+	}
+}
+
+// codeKey is the form of a short code that identifies it: the code itself, or the code
+// lowercased under CASE_INSENSITIVE_CODES
+func codeKey(code string) string {
+	if cfg().CaseInsensitiveCodes {
+		return strings.ToLower(code)
+	}
+	return code
+}
+
+// cleanCode strips what messaging apps and copy and paste leave around a short code in
+// a path, such as spaces and punctuation in "abc123)." Codes consist only of letters,
+// digits, '-' and '_', so nothing that belongs to one is removed.
+func cleanCode(code string) string {
+	return strings.TrimFunc(code, func(r rune) bool {
+		return !(r == '-' || r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z'))
+	})
+}
+
+// codeSkeleton is what a code looks like once case and look-alike glyphs are ignored;
+// two aliases with the same skeleton are easily mistaken for each other
+func codeSkeleton(code string) string {
+	code = strings.ToLower(code)
+	for i := range codeSkeletonFrom {
+		code = strings.ReplaceAll(code, codeSkeletonFrom[i:i+1], codeSkeletonTo[i:i+1])
+	}
+	return code
+}
@@ -0,0 +1,398 @@
+package shorty
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"shorty/internal/config"
+)
+
+// command is a "shorty <name>" subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the subcommands in the order shown by "shorty help"
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "serve", "Run the HTTP server (default)", serve},
+		{"migrate", "migrate", "Apply the database schema", runMigrateCommand},
+		{"import", "import [-format csv|backup] [-owner name] <file>", "Import links from a CSV file or backup", runImportCommand},
+		{"export", "export [-format csv|backup] [file]", "Export links as CSV or as a backup", runExportCommand},
+		{"backup", "backup [list | restore <key|latest>]", "Back up links to S3-compatible storage", runBackupCommand},
+		{"snapshot", "snapshot create|restore [-force] <file>", "Dump or load the whole database", runSnapshotCommand},
+		{"admin", "admin disable|enable <code>", "Disable or re-enable a short code", runAdminCommand},
+		{"codes", "codes collisions", "List short codes that differ only in case", runCodesCommand},
+		{"encrypt", "encrypt", "Encrypt stored destinations with URL_ENCRYPTION_KEY", runEncryptCommand},
+		{"loadtest", "loadtest [-url base] [-rps n] [-duration d]", "Send synthetic traffic and report latencies", runLoadTestCommand},
+		{"mail", "mail test <address>", "Send a test message through SMTP", runMailCommand},
+		{"discord", "discord register", "Register the Discord slash commands", runDiscordRegisterCommand},
+		{"help", "help", "Show this help", runHelpCommand},
+	}
+}
+
+// Run dispatches to the named subcommand, serving when none is given. It is what the
+// shorty binary runs with its command line arguments.
+func Run(args []string) error {
+	if err := config.InitialError(); err != nil {
+		return err
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return serve(args)
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	printUsage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func runHelpCommand([]string) error {
+	printUsage()
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: shorty <command> [flags]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-48s %s\n", cmd.usage, cmd.summary)
+	}
+}
+
+// commandContext bounds a one-off command's database work
+func commandContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Hour)
+}
+
+// runMigrateCommand implements "shorty migrate"
+func runMigrateCommand(args []string) error {
+	flag.NewFlagSet("migrate", flag.ExitOnError).Parse(args)
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := migrateSchema(ctx); err != nil {
+		return fmt.Errorf("applying schema: %w", err)
+	}
+	log.Println("✓ Database schema is up to date")
+	return nil
+}
+
+// runImportCommand implements "shorty import". CSV files need a header row with a "url"
+// column and may include a "short_code" column; rows without a code get a generated one.
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "csv", "input format: csv or backup")
+	owner := fs.String("owner", "", "API key name recorded as the owner of imported links")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: shorty import [-format csv|backup] [-owner name] <file>")
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Imported codes follow the API's alias rules, which need the validator and the
+	// paths the routers reserve
+	setupValidator()
+	if _, err := newRouter(); err != nil {
+		return err
+	}
+	newAdminRouter()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	var imported, skipped int64
+	switch *format {
+	case "backup":
+		imported, skipped, err = readBackup(ctx, in)
+	case "csv":
+		imported, skipped, err = importCSV(ctx, in, *owner)
+	default:
+		return fmt.Errorf("unknown import format %q", *format)
+	}
+	log.Printf("✓ Imported %d links (%d skipped)", imported, skipped)
+	return err
+}
+
+// importCSV inserts the links in a CSV file, skipping invalid rows and short codes that
+// createLink would refuse as an alias
+func importCSV(ctx context.Context, r io.Reader, owner string) (imported, skipped int64, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading CSV header: %w", err)
+	}
+	urlColumn, codeColumn := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "url", "original_url":
+			urlColumn = i
+		case "short_code", "code":
+			codeColumn = i
+		}
+	}
+	if urlColumn < 0 {
+		return 0, 0, errors.New(`CSV header must include a "url" column`)
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return imported, skipped, nil
+		}
+		if err != nil {
+			return imported, skipped, err
+		}
+		if urlColumn >= len(row) {
+			log.Printf("Line %d: missing url, skipped", line)
+			skipped++
+			continue
+		}
+
+		originalURL := strings.TrimSpace(row[urlColumn])
+		if fe := validateDestination("url", originalURL); fe != nil {
+			log.Printf("Line %d: %s, skipped", line, fe.Message)
+			skipped++
+			continue
+		}
+		originalURL = unicodeURL(originalURL)
+		var fe *FieldError
+		err = checkBlocklist(ctx, "url", originalURL)
+		if errors.As(err, &fe) {
+			log.Printf("Line %d: %s, skipped", line, fe.Message)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return imported, skipped, err
+		}
+
+		code := ""
+		if codeColumn >= 0 && codeColumn < len(row) {
+			code = strings.TrimSpace(row[codeColumn])
+		}
+		if code != "" {
+			code, err = checkCustomAlias(ctx, code)
+			switch {
+			case errors.As(err, &fe):
+				log.Printf("Line %d: short code %q: %s, skipped", line, row[codeColumn], fe.Message)
+				skipped++
+				continue
+			case errors.Is(err, errAliasTaken), errors.Is(err, errAliasConfusable):
+				log.Printf("Line %d: short code %q: %v, skipped", line, row[codeColumn], err)
+				skipped++
+				continue
+			case err != nil:
+				return imported, skipped, err
+			}
+		}
+		link := URL{ShortCode: code, OriginalURL: originalURL, Owner: owner}
+		if code == "" {
+			_, err = insertGeneratedURL(ctx, link)
+		} else {
+			err = insertURL(ctx, link)
+		}
+		switch {
+		case isUniqueViolation(err):
+			log.Printf("Line %d: short code %q already exists, skipped", line, code)
+			skipped++
+		case err != nil:
+			return imported, skipped, fmt.Errorf("line %d: %w", line, err)
+		default:
+			imported++
+		}
+	}
+}
+
+// runExportCommand implements "shorty export", writing to stdout unless a file is given
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format: csv or backup")
+	fs.Parse(args)
+	if *format != "csv" && *format != "backup" {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	out := os.Stdout
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var count int
+	var err error
+	if *format == "backup" {
+		count, err = writeBackup(ctx, out)
+	} else {
+		count, err = exportCSV(ctx, out)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("✓ Exported %d links", count)
+	if out != os.Stdout {
+		return out.Close()
+	}
+	return nil
+}
+
+// exportCSV writes every link as short_code,original_url,clicks,created_at
+func exportCSV(ctx context.Context, w io.Writer) (int, error) {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"short_code", "original_url", "clicks", "created_at"})
+
+	count := 0
+	err := eachBackupRecord(ctx, func(r backupRecord) error {
+		count++
+		return writer.Write([]string{
+			r.ShortCode,
+			r.OriginalURL,
+			strconv.FormatInt(r.Clicks, 10),
+			r.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+	writer.Flush()
+	return count, writer.Error()
+}
+
+// runAdminCommand implements "shorty admin disable|enable <code>". Running instances may
+// keep serving a cached destination for up to CACHE_TTL; use the admin API to disable a
+// link on a live instance immediately.
+func runAdminCommand(args []string) error {
+	if len(args) != 2 || (args[0] != "disable" && args[0] != "enable") {
+		return errors.New("usage: shorty admin disable|enable <code>")
+	}
+	action, code := args[0], args[1]
+
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := setURLDisabled(ctx, code, action == "disable"); err != nil {
+		if errors.Is(err, errNotFound) {
+			return fmt.Errorf("short code %q not found", code)
+		}
+		return err
+	}
+	log.Printf("✓ %s %sd", code, action)
+	return nil
+}
+
+// runCodesCommand implements "shorty codes collisions", listing the codes that resolve
+// to the oldest of them once CASE_INSENSITIVE_CODES is enabled
+func runCodesCommand(args []string) error {
+	if len(args) != 1 || args[0] != "collisions" {
+		return errors.New("usage: shorty codes collisions")
+	}
+
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	groups, err := listCaseCollisions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, codes := range groups {
+		fmt.Printf("%s\t(shadows %s)\n", codes[0], strings.Join(codes[1:], ", "))
+	}
+	log.Printf("✓ %d groups of short codes differ only in case", len(groups))
+	return nil
+}
+
+// runEncryptCommand implements "shorty encrypt", encrypting destinations stored before
+// URL_ENCRYPTION_KEY was set, or with a key since moved to URL_ENCRYPTION_OLD_KEYS
+func runEncryptCommand(args []string) error {
+	flag.NewFlagSet("encrypt", flag.ExitOnError).Parse(args)
+
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !urlEncryptionEnabled() {
+		return errors.New("URL_ENCRYPTION_KEY is not set")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	for _, table := range []string{"urls", "url_versions"} {
+		var total int
+		for {
+			n, err := resealDestinations(ctx, table, normalizeBatchSize)
+			if err != nil {
+				return err
+			}
+			total += n
+			if n < normalizeBatchSize {
+				break
+			}
+		}
+		log.Printf("✓ Encrypted %d destinations in %s", total, table)
+	}
+	return nil
+}
+
+// openInput opens path for reading, treating "-" as stdin
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,164 @@
+package shorty
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressWriter buffers the start of a response until it knows whether the body is
+// worth compressing, then streams the rest through the chosen encoder
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+	types    []string
+
+	buf     bytes.Buffer
+	decided bool
+	encoder io.WriteCloser
+}
+
+// compressionMiddleware gzip or deflate encodes responses for clients that accept it
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if !cfg().CompressionEnabled || encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		writer := &compressWriter{
+			ResponseWriter: c.Writer,
+			encoding:       encoding,
+			minSize:        cfg().CompressionMinSize,
+			types:          cfg().CompressionTypes,
+		}
+		c.Writer = writer
+		c.Next()
+		writer.finish()
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf.Write(b)
+	if w.buf.Len() >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush commits to an encoding so streamed responses are not held back by the size threshold
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if gz, ok := w.encoder.(*gzip.Writer); ok {
+		gz.Flush()
+	} else if zw, ok := w.encoder.(*zlib.Writer); ok {
+		zw.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide picks between compressed and plain output and writes any buffered bytes
+func (w *compressWriter) decide(largeEnough bool) error {
+	w.decided = true
+	if largeEnough && w.shouldCompress() {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+
+		if w.encoding == "gzip" {
+			w.encoder = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			w.encoder = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish writes out small responses and closes the encoder
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
+
+// shouldCompress reports whether the response status and content type allow compression
+func (w *compressWriter) shouldCompress() bool {
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent ||
+		(status >= http.StatusMultipleChoices && status < http.StatusBadRequest) {
+		// Skip redirects and bodiless responses
+		return false
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range w.types {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns "gzip", "deflate" or "" based on an Accept-Encoding header
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	default:
+		return ""
+	}
+}
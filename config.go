@@ -0,0 +1,8 @@
+package shorty
+
+import "shorty/internal/config"
+
+// cfg returns the active configuration, which may be swapped by a reload
+func cfg() *config.Config {
+	return config.Get()
+}
@@ -0,0 +1,168 @@
+package shorty
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultConversionEvent names conversions reported without an event
+const defaultConversionEvent = "conversion"
+
+// ConversionRequest is the body of POST /api/conversions
+type ConversionRequest struct {
+	ClickID  string  `json:"click_id" binding:"required,max=128"`
+	Event    string  `json:"event" binding:"omitempty,max=64,tag"`
+	Value    float64 `json:"value" binding:"omitempty,min=0"`
+	Currency string  `json:"currency" binding:"omitempty,len=3,alpha"`
+}
+
+// Conversion is a recorded conversion
+type Conversion struct {
+	ShortCode string  `json:"short_code"`
+	ClickID   string  `json:"click_id"`
+	Event     string  `json:"event"`
+	Value     float64 `json:"value"`
+	Currency  string  `json:"currency,omitempty"`
+}
+
+// ConversionTotals summarizes one event's conversions for a link
+type ConversionTotals struct {
+	Event       string  `json:"event"`
+	Conversions int64   `json:"conversions"`
+	Value       float64 `json:"value"`
+}
+
+// clickIDsEnabled reports whether redirects carry a click ID for conversion tracking
+func clickIDsEnabled() bool {
+	return cfg().ClickIDParam != "" && cfg().ClickIDSecret != ""
+}
+
+// newClickID returns "<code>.<nonce>.<mac>". The short code travels inside the ID and
+// the MAC proves Shorty issued it, so a conversion can be attributed without looking up
+// the click, which may not be written yet or may have been pruned.
+func newClickID(code string) string {
+	nonce := make([]byte, 9)
+	rand.Read(nonce)
+	payload := code + "." + base64.RawURLEncoding.EncodeToString(nonce)
+	return payload + "." + clickIDMAC(payload)
+}
+
+// parseClickID verifies a click ID and returns the short code it was issued for
+func parseClickID(id string) (string, bool) {
+	i := strings.LastIndexByte(id, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, mac := id[:i], id[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(clickIDMAC(payload))) {
+		return "", false
+	}
+	code, _, ok := strings.Cut(payload, ".")
+	return code, ok && code != ""
+}
+
+func clickIDMAC(payload string) string {
+	mac := hmac.New(sha256.New, []byte(cfg().ClickIDSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// appendClickID adds the CLICK_ID_PARAM query parameter to a destination, keeping its
+// existing query and fragment
+func appendClickID(destination, clickID string) string {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	query := u.Query()
+	query.Set(cfg().ClickIDParam, clickID)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// recordConversion handles POST /api/conversions, called by an advertiser's backend with
+// the click ID Shorty appended to the destination. Reporting the same click ID and event
+// again is acknowledged with 200 and not counted twice.
+func recordConversion(c *gin.Context) {
+	var req ConversionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	code, ok := parseClickID(req.ClickID)
+	if !ok || !clickIDsEnabled() {
+		respondError(c, http.StatusNotFound, "Unknown click ID")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	// Only the link's owner may report conversions for it
+	u, err := getURL(ctx, code)
+	if name, _ := apiKeyName(c); err == nil && u.Owner != name {
+		err = errNotFound
+	}
+	if err != nil {
+		respondStoreError(c, err, "Unknown click ID", "Failed to record conversion")
+		return
+	}
+
+	conversion := Conversion{
+		ShortCode: u.ShortCode,
+		ClickID:   req.ClickID,
+		Event:     strings.ToLower(req.Event),
+		Value:     req.Value,
+		Currency:  strings.ToUpper(req.Currency),
+	}
+	if conversion.Event == "" {
+		conversion.Event = defaultConversionEvent
+	}
+	created, err := insertConversion(ctx, conversion)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to record conversion")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, conversion)
+}
+
+// listConversions handles GET /api/urls/:code/conversions
+func listConversions(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	totals, err := conversionTotals(ctx, u.ShortCode)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch conversions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"short_code": u.ShortCode, "clicks": u.Clicks, "events": totals})
+}
+
+// redirectTarget returns where a redirect for code should go and the click ID to record
+// with it, if conversion tracking is on
+func redirectTarget(code, destination string) (string, string) {
+	if !clickIDsEnabled() {
+		return destination, ""
+	}
+	clickID := newClickID(code)
+	return appendClickID(destination, clickID), clickID
+}
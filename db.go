@@ -0,0 +1,178 @@
+package shorty
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var db *pgxpool.Pool
+
+// schemaSQL is sql/init.sql, which is idempotent and safe to apply to an existing database
+//
+//go:embed sql/init.sql
+var schemaSQL string
+
+// schemaVersion identifies the schema this build applies: the SHA-256 of sql/init.sql.
+// migrateSchema records it in schema_version, so the health check can tell when a
+// database has not been migrated for this build.
+var schemaVersion = func() string {
+	sum := sha256.Sum256([]byte(schemaSQL))
+	return hex.EncodeToString(sum[:])
+}()
+
+// Hot-path queries. pgx prepares each distinct query once per connection and reuses the
+// cached statement, so keeping these as constants avoids per-request parse overhead.
+const (
+	queryLookupURL = "SELECT u.short_code, COALESCE(t.original_url, u.original_url), u.disabled_at IS NOT NULL OR t.disabled_at IS NOT NULL, LEAST(u.expires_at, t.expires_at), COALESCE(t.flagged_reason, u.flagged_reason, ''), (u.moderation = 'pending' OR t.moderation = 'pending') IS TRUE, COALESCE(t.redirect_status, u.redirect_status, 0), COALESCE(t.html_redirect, u.html_redirect), COALESCE(t.unavailable_at, u.unavailable_at) IS NOT NULL, COALESCE(t.archive_url, u.archive_url, ''), COALESCE(t.owner, u.owner, ''), COALESCE(t.visibility, u.visibility, ''), COALESCE(t.allowed_networks, u.allowed_networks), COALESCE(t.allowed_countries, u.allowed_countries), COALESCE(t.blocked_countries, u.blocked_countries), COALESCE(t.schedule, u.schedule), ((u.disabled_at IS NOT NULL AND u.disabled_by IS DISTINCT FROM 'owner') OR (t.disabled_at IS NOT NULL AND t.disabled_by IS DISTINCT FROM 'owner')) IS TRUE FROM urls u LEFT JOIN urls t ON t.short_code = u.alias_of WHERE u.short_code = $1"
+
+	// queryLookupURLFolded is queryLookupURL ignoring case; of codes that differ only in
+	// case the oldest wins, so every spelling resolves to the same link
+	queryLookupURLFolded = "SELECT u.short_code, COALESCE(t.original_url, u.original_url), u.disabled_at IS NOT NULL OR t.disabled_at IS NOT NULL, LEAST(u.expires_at, t.expires_at), COALESCE(t.flagged_reason, u.flagged_reason, ''), (u.moderation = 'pending' OR t.moderation = 'pending') IS TRUE, COALESCE(t.redirect_status, u.redirect_status, 0), COALESCE(t.html_redirect, u.html_redirect), COALESCE(t.unavailable_at, u.unavailable_at) IS NOT NULL, COALESCE(t.archive_url, u.archive_url, ''), COALESCE(t.owner, u.owner, ''), COALESCE(t.visibility, u.visibility, ''), COALESCE(t.allowed_networks, u.allowed_networks), COALESCE(t.allowed_countries, u.allowed_countries), COALESCE(t.blocked_countries, u.blocked_countries), COALESCE(t.schedule, u.schedule), ((u.disabled_at IS NOT NULL AND u.disabled_by IS DISTINCT FROM 'owner') OR (t.disabled_at IS NOT NULL AND t.disabled_by IS DISTINCT FROM 'owner')) IS TRUE FROM urls u LEFT JOIN urls t ON t.short_code = u.alias_of WHERE lower(u.short_code) = lower($1) ORDER BY u.id LIMIT 1"
+
+	queryAddClicks = `
+		WITH batch AS (
+			SELECT COALESCE(a.alias_of, b.code) AS code, SUM(b.n) AS n
+			FROM unnest($1::text[], $2::bigint[]) AS b(code, n) LEFT JOIN urls a ON a.short_code = b.code
+			GROUP BY 1
+		), hourly AS (
+			INSERT INTO click_hourly (short_code, hour, clicks)
+			SELECT code, date_trunc('hour', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', n FROM batch
+			ON CONFLICT (short_code, hour) DO UPDATE SET clicks = click_hourly.clicks + EXCLUDED.clicks
+		)
+		UPDATE urls SET clicks = urls.clicks + batch.n, last_clicked_at = NOW()
+		FROM batch WHERE urls.short_code = batch.code`
+	queryInsertURL = "INSERT INTO urls (short_code, original_url, normalized_url, owner, tags, immutable, expires_at, moderation, redirect_status, html_redirect, wayback_fallback, visibility, allowed_networks, allowed_countries, blocked_countries, schedule, metadata, clicks, created_at) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, NULLIF($8, ''), NULLIF($9, 0), $10, $11, NULLIF($12, ''), $13, $14, $15, $16, $17, 0, NOW())"
+)
+
+// schemaLockID is the advisory lock held while the schema is applied, so replicas
+// starting together with AUTO_MIGRATE do not race to create the same tables
+const schemaLockID = 0x73686f727479
+
+// pgUniqueViolation is the SQLSTATE Postgres reports for duplicate keys
+const pgUniqueViolation = "23505"
+
+// pgUndefinedTable is the SQLSTATE Postgres reports for a missing table
+const pgUndefinedTable = "42P01"
+
+// connectDB establishes database connection with retry logic
+func connectDB() error {
+	poolConfig, err := pgxpool.ParseConfig(cfg().DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	configurePool(poolConfig)
+
+	// Connections opened after DATABASE_PASSWORD is rotated and reloaded use the new one
+	poolConfig.BeforeConnect = func(ctx context.Context, conn *pgx.ConnConfig) error {
+		if password := cfg().DatabasePassword; password != "" {
+			conn.Password = password
+		}
+		return nil
+	}
+
+	// Every reader and writer of destinations needs the keys, so they are checked here
+	if err := setupURLEncryption(); err != nil {
+		return fmt.Errorf("invalid URL_ENCRYPTION_KEY: %w", err)
+	}
+
+	// Retry connection up to 10 times (useful for Docker startup)
+	for i := 0; i < 10; i++ {
+		db, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
+		if err == nil {
+			err = db.Ping(context.Background())
+			if err == nil {
+				log.Println("✓ Connected to database")
+				return nil
+			}
+			db.Close()
+		}
+		log.Printf("Waiting for database... (attempt %d/10)", i+1)
+		time.Sleep(2 * time.Second)
+	}
+
+	return fmt.Errorf("connecting to database: %w", err)
+}
+
+// configurePool applies the connection pool limits so redirect bursts queue for a
+// connection instead of exhausting Postgres. Sessions run in UTC so NOW() and date
+// arithmetic in SQL never depend on the server's time zone.
+func configurePool(poolConfig *pgxpool.Config) {
+	poolConfig.ConnConfig.RuntimeParams["timezone"] = "UTC"
+	poolConfig.MaxConns = int32(cfg().DBMaxOpenConns)
+	poolConfig.MinConns = int32(cfg().DBMinConns)
+	poolConfig.MaxConnLifetime = cfg().DBConnMaxLifetime
+	poolConfig.MaxConnIdleTime = cfg().DBConnMaxIdleTime
+}
+
+// migrateSchema applies sql/init.sql, creating any tables and columns that are missing
+func migrateSchema(ctx context.Context) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", schemaLockID); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", schemaLockID)
+
+	if _, err = conn.Exec(ctx, schemaSQL); err != nil {
+		return err
+	}
+	_, err = conn.Exec(ctx,
+		"INSERT INTO schema_version (version, applied_at) VALUES ($1, NOW()) ON CONFLICT (id) DO UPDATE SET version = EXCLUDED.version, applied_at = EXCLUDED.applied_at",
+		schemaVersion,
+	)
+	return err
+}
+
+// appliedSchemaVersion returns the version of sql/init.sql last applied to the database
+// and when, with an empty version when it predates schema_version
+func appliedSchemaVersion(ctx context.Context) (string, *time.Time, error) {
+	var version string
+	var appliedAt *time.Time
+	err := db.QueryRow(ctx, "SELECT version, applied_at FROM schema_version").Scan(&version, &appliedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil, nil
+	}
+	if isUndefinedTable(err) {
+		return "", nil, nil
+	}
+	return version, appliedAt, err
+}
+
+// isUndefinedTable reports whether err is a Postgres missing table error
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUndefinedTable
+}
+
+// isUniqueViolation reports whether err is a Postgres duplicate key error
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// dbContext returns a context for queries made on behalf of a request. It is cancelled
+// when the client disconnects or DB_QUERY_TIMEOUT elapses, whichever comes first.
+func dbContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request.Context(), cfg().DBQueryTimeout)
+}
+
+// backgroundDBContext returns a context for writes that must finish even if the
+// request that triggered them has already completed
+func backgroundDBContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cfg().DBQueryTimeout)
+}
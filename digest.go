@@ -0,0 +1,201 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/analytics"
+)
+
+// digestTopLinks is how many links a digest lists
+const digestTopLinks = 10
+
+// DigestSubscription configures an account's periodic performance report
+type DigestSubscription struct {
+	Frequency     string     `json:"frequency" binding:"required,oneof=weekly monthly"`
+	Email         string     `json:"email" binding:"omitempty,email,max=254"`
+	WebhookURL    string     `json:"webhook_url" binding:"omitempty,max=2048"`
+	LastPeriodEnd *time.Time `json:"last_period_end,omitempty"`
+}
+
+// Digest summarizes an account's links over one period
+type Digest struct {
+	Owner          string    `json:"owner"`
+	Frequency      string    `json:"frequency"`
+	PeriodStart    time.Time `json:"period_start"`
+	PeriodEnd      time.Time `json:"period_end"`
+	LastDay        time.Time `json:"-"`
+	TotalClicks    int64     `json:"total_clicks"`
+	PreviousClicks int64     `json:"previous_clicks"`
+	Trend          string    `json:"trend,omitempty"`
+	NewLinks       int64     `json:"new_links"`
+	TopLinks       []TopLink `json:"top_links"`
+}
+
+// TopLink is a link and its clicks over a period, as listed in digests and leaderboards
+type TopLink struct {
+	ShortCode   string `json:"short_code"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	Clicks      int64  `json:"clicks"`
+}
+
+// getDigestSubscription handles GET /api/account/digest
+func getDigestSubscription(c *gin.Context) {
+	owner, _ := apiKeyName(c)
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	sub, err := findDigestSubscription(ctx, owner)
+	if err != nil {
+		respondStoreError(c, err, "No digest subscription", "Failed to fetch digest subscription")
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// putDigestSubscription handles PUT /api/account/digest
+func putDigestSubscription(c *gin.Context) {
+	var sub DigestSubscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	sub.Email = strings.TrimSpace(sub.Email)
+	sub.WebhookURL = strings.TrimSpace(sub.WebhookURL)
+	if sub.Email == "" && sub.WebhookURL == "" {
+		respondValidationError(c, FieldError{Field: "email", Rule: "required", Message: "email or webhook_url is required"})
+		return
+	}
+	if sub.WebhookURL != "" {
+		if fe := validateDestination("webhook_url", sub.WebhookURL); fe != nil {
+			respondValidationError(c, *fe)
+			return
+		}
+	}
+
+	owner, _ := apiKeyName(c)
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := saveDigestSubscription(ctx, owner, sub); err != nil {
+		respondStoreError(c, err, "", "Failed to save digest subscription")
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// deleteDigestSubscription handles DELETE /api/account/digest
+func deleteDigestSubscription(c *gin.Context) {
+	owner, _ := apiKeyName(c)
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := removeDigestSubscription(ctx, owner); err != nil {
+		respondStoreError(c, err, "No digest subscription", "Failed to delete digest subscription")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// digestPeriod returns the most recently completed period for a frequency: the previous
+// Monday-to-Monday week or the previous calendar month, in UTC
+func digestPeriod(frequency string, now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if frequency == "monthly" {
+		end = analytics.MonthStart(now)
+		return end.AddDate(0, -1, 0), end
+	}
+	end = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	return end.AddDate(0, 0, -7), end
+}
+
+// sendDigests is the hourly digest job. Each subscription is claimed for its period
+// before sending, so digests go out once even with several instances.
+func sendDigests(ctx context.Context) error {
+	subs, err := listDigestSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for owner, sub := range subs {
+		start, end := digestPeriod(sub.Frequency, time.Now())
+		if sub.LastPeriodEnd != nil && !sub.LastPeriodEnd.Before(end) {
+			continue
+		}
+		claimed, err := claimDigestPeriod(ctx, owner, end)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		digest, err := buildDigest(ctx, owner, sub.Frequency, start, end)
+		if err == nil {
+			err = deliverDigest(ctx, sub, digest)
+		}
+		if err != nil {
+			log.Printf("Failed to send %s digest to %s: %v", sub.Frequency, owner, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d digests failed", failed)
+	}
+	return nil
+}
+
+// buildDigest aggregates an owner's click events for [start, end) and the period before
+func buildDigest(ctx context.Context, owner, frequency string, start, end time.Time) (Digest, error) {
+	previousStart := start.Add(-end.Sub(start))
+	if frequency == "monthly" {
+		previousStart = start.AddDate(0, -1, 0)
+	}
+
+	digest := Digest{
+		Owner:       owner,
+		Frequency:   frequency,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		LastDay:     end.AddDate(0, 0, -1),
+	}
+	var err error
+	digest.TotalClicks, digest.PreviousClicks, digest.NewLinks, err = ownerClickTotals(ctx, owner, previousStart, start, end)
+	if err != nil {
+		return digest, err
+	}
+	digest.TopLinks, err = ownerTopLinks(ctx, owner, start, end, digestTopLinks)
+	if err != nil {
+		return digest, err
+	}
+	for i := range digest.TopLinks {
+		digest.TopLinks[i].ShortURL = publicShortURL(digest.TopLinks[i].ShortCode)
+	}
+	if digest.PreviousClicks > 0 {
+		change := float64(digest.TotalClicks-digest.PreviousClicks) / float64(digest.PreviousClicks) * 100
+		digest.Trend = fmt.Sprintf("%+.0f%%", change)
+	}
+	return digest, nil
+}
+
+// deliverDigest sends a digest by email and/or webhook
+func deliverDigest(ctx context.Context, sub DigestSubscription, digest Digest) error {
+	var errs []error
+	if sub.Email != "" {
+		errs = append(errs, sendMail(ctx, []string{sub.Email}, "digest", digest))
+	}
+	if sub.WebhookURL != "" {
+		errs = append(errs, postWebhook(ctx, sub.WebhookURL, "digest."+sub.Frequency, digest))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,259 @@
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// discordAPI is the base URL of the Discord REST API
+const discordAPI = "https://discord.com/api/v10"
+
+// Discord interaction and response types used by the bot
+const (
+	discordInteractionPing    = 1
+	discordInteractionCommand = 2
+
+	discordResponsePong          = 1
+	discordResponseMessage       = 4
+	discordResponseDeferredReply = 5
+
+	discordFlagEphemeral = 64
+)
+
+var discordClient = &http.Client{Timeout: 10 * time.Second}
+
+// discordInteraction is the subset of an incoming interaction the bot reads
+type discordInteraction struct {
+	Type          int    `json:"type"`
+	Token         string `json:"token"`
+	ApplicationID string `json:"application_id"`
+	GuildID       string `json:"guild_id"`
+	Data          struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value any    `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// option returns a string option of the invoked command
+func (i discordInteraction) option(name string) string {
+	for _, o := range i.Data.Options {
+		if o.Name == name {
+			if s, ok := o.Value.(string); ok {
+				return strings.TrimSpace(s)
+			}
+		}
+	}
+	return ""
+}
+
+// discordCommands are registered with "shorty discord register"
+var discordCommands = []map[string]any{
+	{
+		"name":        "shorten",
+		"description": "Create a short link",
+		"options": []map[string]any{
+			{"type": 3, "name": "url", "description": "URL to shorten", "required": true},
+			{"type": 3, "name": "alias", "description": "Custom short code"},
+		},
+	},
+	{
+		"name":        "stats",
+		"description": "Show click stats for a short link",
+		"options": []map[string]any{
+			{"type": 3, "name": "code", "description": "Short code", "required": true},
+		},
+	},
+}
+
+// discordInteractionHandler handles POST /api/integrations/discord, the interactions
+// endpoint URL configured for the Discord application. Commands are acknowledged with a
+// deferred reply and answered from a background worker, since Discord only waits three
+// seconds for the initial response.
+func discordInteractionHandler(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 64*1024))
+	if err != nil || !verifyDiscordSignature(c.GetHeader("X-Signature-Ed25519"), c.GetHeader("X-Signature-Timestamp"), body) {
+		respondError(c, http.StatusUnauthorized, "Invalid request signature")
+		return
+	}
+
+	var interaction discordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid interaction")
+		return
+	}
+
+	switch interaction.Type {
+	case discordInteractionPing:
+		c.JSON(http.StatusOK, gin.H{"type": discordResponsePong})
+		return
+	case discordInteractionCommand:
+	default:
+		respondError(c, http.StatusBadRequest, "Unsupported interaction type")
+		return
+	}
+
+	baseURL := buildShortURL(c, "")
+	submitted := workers.Submit("discord_"+interaction.Data.Name, func(ctx context.Context) {
+		content := runDiscordCommand(ctx, interaction, baseURL)
+
+		// The database deadline may be nearly spent; give the reply its own
+		replyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), discordClient.Timeout)
+		defer cancel()
+		if err := editDiscordReply(replyCtx, interaction, content); err != nil {
+			log.Printf("Failed to send Discord reply: %v", err)
+		}
+	})
+	if !submitted {
+		c.JSON(http.StatusOK, gin.H{
+			"type": discordResponseMessage,
+			"data": gin.H{"content": "Shorty is busy, please try again.", "flags": discordFlagEphemeral},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"type": discordResponseDeferredReply})
+}
+
+// verifyDiscordSignature checks the Ed25519 signature Discord sends with every interaction
+func verifyDiscordSignature(signature, timestamp string, body []byte) bool {
+	publicKey, err := hex.DecodeString(cfg().DiscordPublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize || timestamp == "" {
+		return false
+	}
+	return ed25519.Verify(publicKey, append([]byte(timestamp), body...), sig)
+}
+
+// runDiscordCommand executes a slash command and returns the reply text
+func runDiscordCommand(ctx context.Context, interaction discordInteraction, baseURL string) string {
+	switch interaction.Data.Name {
+	case "shorten":
+		req := ShortenRequest{URL: interaction.option("url"), Alias: interaction.option("alias")}
+		if details := validateRequest(&req); len(details) > 0 {
+			return "⚠️ " + details[0].Message
+		}
+
+		owner := "discord"
+		if interaction.GuildID != "" {
+			owner += ":" + interaction.GuildID
+		}
+		link, _, err := createLink(ctx, req, owner)
+		var fe *FieldError
+		switch {
+		case errors.As(err, &fe):
+			return "⚠️ " + fe.Message
+		case errors.Is(err, errAliasTaken):
+			return "⚠️ That alias is already taken."
+		case errors.Is(err, errAliasConfusable):
+			return "⚠️ That alias looks too much like an existing one."
+		case err != nil:
+			return "⚠️ Failed to create the link, please try again."
+		}
+		return fmt.Sprintf("🔗 %s%s → <%s>", baseURL, link.ShortCode, link.OriginalURL)
+
+	case "stats":
+		// Anyone in the guild sees the reply, so links not open to everyone are only
+		// reported to the guild that created them
+		u, err := getURL(ctx, interaction.option("code"))
+		if err == nil && !discordMayShow(u, interaction) {
+			err = errNotFound
+		}
+		if errors.Is(err, errNotFound) {
+			return "⚠️ No link with that code."
+		}
+		if err != nil {
+			return "⚠️ Failed to fetch stats, please try again."
+		}
+		return fmt.Sprintf("📊 %s%s → <%s>\n%d clicks since %s",
+			baseURL, u.ShortCode, u.OriginalURL, u.Clicks, u.CreatedAt.Format("2 Jan 2006"))
+
+	default:
+		return "⚠️ Unknown command."
+	}
+}
+
+// discordMayShow reports whether a link may be described in the interaction's channel:
+// it is public and unrestricted, or the interaction's guild created it
+func discordMayShow(u URL, interaction discordInteraction) bool {
+	if interaction.GuildID != "" && u.Owner == "discord:"+interaction.GuildID {
+		return true
+	}
+	return u.Visibility == "" && len(u.AllowedNetworks) == 0 && len(u.AllowedCountries) == 0 && len(u.BlockedCountries) == 0
+}
+
+// editDiscordReply replaces the deferred "thinking" message with content
+func editDiscordReply(ctx context.Context, interaction discordInteraction, content string) error {
+	endpoint := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", discordAPI, interaction.ApplicationID, interaction.Token)
+	return discordRequest(ctx, http.MethodPatch, endpoint, "", map[string]any{
+		"content":          content,
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+}
+
+// discordRequest sends a JSON request to the Discord API
+func discordRequest(ctx context.Context, method, endpoint, authorization string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := discordClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// runDiscordRegisterCommand implements "shorty discord register", which installs the
+// /shorten and /stats slash commands for DISCORD_APPLICATION_ID using DISCORD_BOT_TOKEN
+func runDiscordRegisterCommand(args []string) error {
+	fs := flag.NewFlagSet("discord", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 || fs.Arg(0) != "register" {
+		return errors.New("usage: shorty discord register")
+	}
+	if cfg().DiscordApplicationID == "" || cfg().DiscordBotToken == "" {
+		return errors.New("DISCORD_APPLICATION_ID and DISCORD_BOT_TOKEN must be set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("%s/applications/%s/commands", discordAPI, cfg().DiscordApplicationID)
+	if err := discordRequest(ctx, http.MethodPut, endpoint, "Bot "+cfg().DiscordBotToken, discordCommands); err != nil {
+		return err
+	}
+	log.Printf("✓ Registered %d Discord commands", len(discordCommands))
+	return nil
+}
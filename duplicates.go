@@ -0,0 +1,147 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// normalizeBatchSize is how many links the normalize_urls job updates per statement
+const normalizeBatchSize = 5000
+
+// errMergeIntoAlias is returned when the merge target is itself an alias
+var errMergeIntoAlias = errors.New("cannot merge into a link that is an alias")
+
+// trackingParams are query parameters that do not change where a link leads
+var trackingParams = []string{"utm_", "fbclid", "gclid", "msclkid", "mc_eid"}
+
+// DuplicateGroup is a set of one owner's links sharing a normalized destination, oldest
+// code first
+type DuplicateGroup struct {
+	Owner         string   `json:"owner,omitempty"`
+	NormalizedURL string   `json:"normalized_url"`
+	Codes         []string `json:"codes"`
+	TotalClicks   int64    `json:"total_clicks"`
+}
+
+// MergeRequest is the body of POST /admin/urls/:code/merge
+type MergeRequest struct {
+	Codes []string `json:"codes" binding:"required,min=1,max=100,dive,max=32"`
+}
+
+// normalizeDestination reduces a destination to a form shared by URLs that lead to the
+// same page: scheme, "www.", default ports, trailing slashes, fragments and tracking
+// parameters are dropped, and the remaining query parameters are sorted.
+func normalizeDestination(raw string) string {
+	u, err := url.Parse(asciiURL(strings.TrimSpace(raw)))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(raw)
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	query := u.Query()
+	for key := range query {
+		for _, param := range trackingParams {
+			if strings.HasPrefix(strings.ToLower(key), param) {
+				query.Del(key)
+			}
+		}
+	}
+
+	normalized := host + strings.TrimRight(u.EscapedPath(), "/")
+	if encoded := query.Encode(); encoded != "" {
+		normalized += "?" + encoded
+	}
+	return normalized
+}
+
+// backfillNormalizedURLs is the normalize_urls job, filling normalized_url for links
+// created before it existed or restored from backups
+func backfillNormalizedURLs(ctx context.Context) error {
+	var total int
+	for {
+		ids, destinations, err := listUnnormalizedURLs(ctx, normalizeBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		normalized := make([]string, len(destinations))
+		for i, d := range destinations {
+			normalized[i] = destinationIndex(d)
+		}
+		if err := setNormalizedURLs(ctx, ids, normalized); err != nil {
+			return err
+		}
+		total += len(ids)
+		if len(ids) < normalizeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("✓ Normalized %d destinations", total)
+	}
+	return nil
+}
+
+// adminListDuplicates handles GET /admin/duplicates?limit=50
+func adminListDuplicates(c *gin.Context) {
+	limit := 50
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, 500)
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	groups, err := listDuplicateGroups(ctx, limit)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to list duplicates")
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// adminMergeURLs handles POST /admin/urls/:code/merge
+func adminMergeURLs(c *gin.Context) {
+	var req MergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	// Merging redirects the duplicates elsewhere, which immutable links forbid
+	if cfg().ImmutableLinks {
+		respondError(c, http.StatusConflict, "Links are immutable on this instance")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	canonical := c.Param("code")
+	merged, err := mergeURLs(ctx, canonical, req.Codes)
+	if errors.Is(err, errMergeIntoAlias) {
+		respondError(c, http.StatusConflict, "Cannot merge into a link that is itself an alias")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to merge URLs")
+		return
+	}
+	if merged == nil {
+		merged = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"short_code": canonical, "merged": merged})
+}
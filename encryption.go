@@ -0,0 +1,157 @@
+package shorty
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// encryptedPrefix marks destinations stored encrypted, as "enc:<key id>:<base64 nonce and
+// ciphertext>". Destinations without it are plaintext from before encryption was on.
+const encryptedPrefix = "enc:"
+
+// indexPrefix marks normalized destinations stored as a keyed hash, so duplicates can
+// still be grouped without the database learning the URL
+const indexPrefix = "h:"
+
+// urlKey is one AES-256 key destinations are encrypted with, named by its ID
+type urlKey struct {
+	id   string
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// urlKeyring holds URL_ENCRYPTION_KEY, which seals new destinations, and the
+// URL_ENCRYPTION_OLD_KEYS still accepted for opening them
+type urlKeyring struct {
+	current *urlKey
+	byID    map[string]*urlKey
+}
+
+// urlKeys is nil when destinations are stored in plaintext
+var urlKeys *urlKeyring
+
+// setupURLEncryption loads the destination encryption keys from the config
+func setupURLEncryption() error {
+	if cfg().URLEncryptionKey == "" {
+		if len(cfg().URLEncryptionOldKeys) > 0 {
+			return errors.New("URL_ENCRYPTION_OLD_KEYS requires URL_ENCRYPTION_KEY")
+		}
+		urlKeys = nil
+		return nil
+	}
+
+	current, err := parseURLKey(cfg().URLEncryptionKey)
+	if err != nil {
+		return err
+	}
+	keys := &urlKeyring{current: current, byID: map[string]*urlKey{current.id: current}}
+	for _, encoded := range cfg().URLEncryptionOldKeys {
+		key, err := parseURLKey(encoded)
+		if err != nil {
+			return err
+		}
+		keys.byID[key.id] = key
+	}
+	urlKeys = keys
+	return nil
+}
+
+// parseURLKey decodes a base64 AES-256 key. Its ID is the start of its SHA-256 hash, so
+// the key a destination was sealed with can be found without storing the key.
+func parseURLKey(encoded string) (*urlKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("keys must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	mac := hmac.New(sha256.New, raw)
+	mac.Write([]byte("normalized_url"))
+	return &urlKey{id: hex.EncodeToString(sum[:4]), aead: aead, mac: mac.Sum(nil)}, nil
+}
+
+// urlEncryptionEnabled reports whether destinations are stored encrypted
+func urlEncryptionEnabled() bool {
+	return urlKeys != nil
+}
+
+// sealURL returns a destination as it is stored: encrypted with the current key when
+// encryption is on, otherwise unchanged
+func sealURL(destination string) string {
+	if urlKeys == nil || destination == "" {
+		return destination
+	}
+	key := urlKeys.current
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Fatalf("Failed to generate nonce: %v", err)
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(destination), []byte(key.id))
+	return encryptedPrefix + key.id + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// openURL returns the destination of a stored value. Plaintext values are returned as
+// they are, so links stored before encryption was turned on keep working.
+func openURL(stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+	if urlKeys == nil {
+		return "", errors.New("destination is encrypted but URL_ENCRYPTION_KEY is not set")
+	}
+	id, encoded, _ := strings.Cut(strings.TrimPrefix(stored, encryptedPrefix), ":")
+	key, ok := urlKeys.byID[id]
+	if !ok {
+		return "", fmt.Errorf("destination is encrypted with unknown key %s", id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", errors.New("destination is not validly encrypted")
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plain, err := key.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("decrypting destination: %w", err)
+	}
+	return string(plain), nil
+}
+
+// openURLs decrypts stored destinations in place, logging and blanking any that cannot
+// be opened so one bad row does not fail a whole listing
+func openURLs(values ...*string) {
+	for _, v := range values {
+		plain, err := openURL(*v)
+		if err != nil {
+			log.Printf("Failed to open destination: %v", err)
+		}
+		*v = plain
+	}
+}
+
+// destinationIndex returns what is stored as a destination's normalized_url: its
+// normalized form, or a keyed hash of it when destinations are encrypted
+func destinationIndex(destination string) string {
+	normalized := normalizeDestination(destination)
+	if urlKeys == nil {
+		return normalized
+	}
+	mac := hmac.New(sha256.New, urlKeys.current.mac)
+	mac.Write([]byte(normalized))
+	return indexPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
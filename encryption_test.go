@@ -0,0 +1,144 @@
+package shorty
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"shorty/internal/config"
+)
+
+// newTestURLKey returns a random base64 URL_ENCRYPTION_KEY
+func newTestURLKey(t *testing.T) string {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// useURLKeys configures destination encryption with current and old keys for the test
+func useURLKeys(t *testing.T, current string, old ...string) {
+	t.Helper()
+	previous := cfg()
+	t.Cleanup(func() {
+		config.Set(previous)
+		setupURLEncryption()
+	})
+
+	conf := *previous
+	conf.URLEncryptionKey, conf.URLEncryptionOldKeys = current, old
+	config.Set(&conf)
+	if err := setupURLEncryption(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSealURLRoundTrip(t *testing.T) {
+	const destination = "https://intranet.example.com/reports?q=1"
+	useURLKeys(t, newTestURLKey(t))
+
+	sealed := sealURL(destination)
+	if !strings.HasPrefix(sealed, encryptedPrefix) || strings.Contains(sealed, "intranet") {
+		t.Fatalf("sealURL = %q, want ciphertext", sealed)
+	}
+	if again := sealURL(destination); again == sealed {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+	opened, err := openURL(sealed)
+	if err != nil || opened != destination {
+		t.Fatalf("openURL = %q, %v, want %q", opened, err, destination)
+	}
+	if plain, err := openURL(destination); err != nil || plain != destination {
+		t.Errorf("openURL(plaintext) = %q, %v, want it unchanged", plain, err)
+	}
+	if sealURL("") != "" {
+		t.Error("sealURL sealed an empty destination")
+	}
+}
+
+func TestSealURLDisabled(t *testing.T) {
+	useURLKeys(t, "")
+	if got := sealURL("https://example.com/"); got != "https://example.com/" {
+		t.Errorf("sealURL without a key = %q", got)
+	}
+	if got := destinationIndex("https://www.example.com/a/"); got != "example.com/a" {
+		t.Errorf("destinationIndex without a key = %q", got)
+	}
+}
+
+func TestOpenURLKeyRotation(t *testing.T) {
+	const destination = "https://example.com/rotated"
+	oldKey, newKey := newTestURLKey(t), newTestURLKey(t)
+
+	useURLKeys(t, oldKey)
+	sealedOld := sealURL(destination)
+	indexOld := destinationIndex(destination)
+
+	useURLKeys(t, newKey, oldKey)
+	if opened, err := openURL(sealedOld); err != nil || opened != destination {
+		t.Fatalf("openURL with the old key moved to URL_ENCRYPTION_OLD_KEYS = %q, %v", opened, err)
+	}
+	sealedNew := sealURL(destination)
+	if sealedNew[:len(encryptedPrefix)+8] == sealedOld[:len(encryptedPrefix)+8] {
+		t.Error("new destinations are still sealed with the old key ID")
+	}
+	if destinationIndex(destination) == indexOld {
+		t.Error("destinationIndex did not change with the key")
+	}
+
+	useURLKeys(t, newKey)
+	if _, err := openURL(sealedOld); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("openURL after dropping the old key = %v, want an unknown key error", err)
+	}
+	if opened, err := openURL(sealedNew); err != nil || opened != destination {
+		t.Errorf("openURL with the current key = %q, %v", opened, err)
+	}
+}
+
+func TestOpenURLRejectsInvalid(t *testing.T) {
+	useURLKeys(t, newTestURLKey(t))
+	sealed := sealURL("https://example.com/")
+	id, _, _ := strings.Cut(strings.TrimPrefix(sealed, encryptedPrefix), ":")
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-2] ^= 1
+	for name, stored := range map[string]string{
+		"tampered":    string(tampered),
+		"unknown key": encryptedPrefix + "00000000:" + strings.SplitN(sealed, ":", 3)[2],
+		"truncated":   encryptedPrefix + id + ":AAAA",
+		"not base64":  encryptedPrefix + id + ":!!!",
+	} {
+		if _, err := openURL(stored); err == nil {
+			t.Errorf("openURL(%s) succeeded", name)
+		}
+	}
+
+	useURLKeys(t, "")
+	if _, err := openURL(sealed); err == nil {
+		t.Error("openURL without a key succeeded")
+	}
+}
+
+func TestSetupURLEncryptionRejectsBadKeys(t *testing.T) {
+	for name, keys := range map[string][2]string{
+		"short key":           {"c2hvcnQ=", ""},
+		"not base64":          {"not a key", ""},
+		"old key without new": {"", newTestURLKey(t)},
+	} {
+		previous := cfg()
+		conf := *previous
+		conf.URLEncryptionKey = keys[0]
+		if keys[1] != "" {
+			conf.URLEncryptionOldKeys = []string{keys[1]}
+		}
+		config.Set(&conf)
+		if err := setupURLEncryption(); err == nil {
+			t.Errorf("%s: setupURLEncryption succeeded", name)
+		}
+		config.Set(previous)
+		setupURLEncryption()
+	}
+}
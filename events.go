@@ -0,0 +1,144 @@
+package shorty
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/analytics"
+)
+
+const (
+	// clickPartitionsAhead is how many future monthly partitions are kept ready
+	clickPartitionsAhead = 2
+
+	// maxUserAgentLength caps the user agent stored with each click event
+	maxUserAgentLength = 512
+
+	// Limits of GET /api/stats/:code/events
+	defaultEventExportLimit = 10000
+	maxEventExportLimit     = 100000
+	eventExportTimeout      = 10 * time.Minute
+
+	// eventSettleDelay hides the newest events from exports, so events still being
+	// written by another instance's flush are not skipped by a cursor that passed them
+	eventSettleDelay = time.Minute
+)
+
+// clickEvent is a single anonymized redirect
+type clickEvent struct {
+	ShortCode    string
+	ClickedAt    time.Time
+	ReferrerHost string
+	UserAgent    string
+	IPPrefix     string
+	ClickID      string
+}
+
+// ClickEventRecord is a stored click event as exported by GET /api/stats/:code/events
+type ClickEventRecord struct {
+	ID           int64     `json:"id"`
+	ShortCode    string    `json:"short_code"`
+	ClickedAt    time.Time `json:"clicked_at"`
+	ReferrerHost string    `json:"referrer_host"`
+	UserAgent    string    `json:"user_agent"`
+	IPPrefix     string    `json:"ip_prefix"`
+	ClickID      string    `json:"click_id,omitempty"`
+}
+
+// newClickEvent captures an anonymized click from a redirect request: only the referrer's
+// host is kept and the client IP is truncated to its network prefix
+func newClickEvent(c *gin.Context, code string) clickEvent {
+	event := clickEvent{
+		ShortCode: code,
+		ClickedAt: time.Now().UTC(),
+		UserAgent: c.Request.UserAgent(),
+		IPPrefix:  analytics.AnonymizeIP(c.ClientIP()),
+	}
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+	if ref, err := url.Parse(c.Request.Referer()); err == nil {
+		event.ReferrerHost = strings.ToLower(ref.Hostname())
+	}
+	return event
+}
+
+// maintainClickPartitions creates the current and upcoming monthly partitions so inserts
+// never hit a missing range; expired partitions are dropped by the retention job
+func maintainClickPartitions(ctx context.Context) error {
+	current := analytics.MonthStart(time.Now())
+	for i := 0; i <= clickPartitionsAhead; i++ {
+		month := current.AddDate(0, i, 0)
+		if err := createClickPartition(ctx, analytics.PartitionName(month), month, month.AddDate(0, 1, 0)); err != nil {
+			return fmt.Errorf("creating partition for %s: %w", month.Format("2006-01"), err)
+		}
+	}
+	return nil
+}
+
+// exportClickEvents handles GET /api/stats/:code/events?format=ndjson&after=<id>&limit=,
+// streaming one of the caller's links' click events in id order. Clients resume from the
+// last id they received.
+func exportClickEvents(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		respondValidationError(c, FieldError{Field: "format", Rule: "oneof", Allowed: []string{"ndjson", "csv"}, Message: "format must be one of ndjson, csv"})
+		return
+	}
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		respondValidationError(c, FieldError{Field: "after", Rule: "numeric", Message: "after must be an event id"})
+		return
+	}
+	limit := defaultEventExportLimit
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, maxEventExportLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), eventExportTimeout)
+	defer cancel()
+
+	var write func(e ClickEventRecord) error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		defer w.Flush()
+		w.Write([]string{"id", "short_code", "clicked_at", "referrer_host", "user_agent", "ip_prefix", "click_id"})
+		write = func(e ClickEventRecord) error {
+			return w.Write([]string{strconv.FormatInt(e.ID, 10), e.ShortCode, e.ClickedAt.Format(time.RFC3339Nano), e.ReferrerHost, e.UserAgent, e.IPPrefix, e.ClickID})
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(e ClickEventRecord) error { return enc.Encode(e) }
+	}
+	c.Status(http.StatusOK)
+
+	var written int
+	err = eachClickEvent(ctx, u.ShortCode, after, limit, time.Now().Add(-eventSettleDelay), func(e ClickEventRecord) error {
+		written++
+		if written%1000 == 0 {
+			c.Writer.Flush()
+		}
+		return write(e)
+	})
+	if err != nil {
+		// Headers are already sent; the client sees a truncated stream and resumes from
+		// the last id it received
+		c.Error(err)
+	}
+}
@@ -0,0 +1,238 @@
+package shorty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Events published to the sinks in EVENT_SINKS
+const (
+	eventLinkCreated = "link.created"
+	eventLinkUpdated = "link.updated"
+	eventLinkDeleted = "link.deleted"
+	eventLinkClicked = "link.clicked"
+)
+
+// maxQueuedEvents bounds the events waiting for one sink; newer ones are dropped beyond that
+const maxQueuedEvents = 10000
+
+// maxEventBatch bounds how many queued events a sink is handed at once
+const maxEventBatch = 500
+
+// eventDeliveryTimeout bounds delivering one event, or one batch of events to NATS
+const eventDeliveryTimeout = 10 * time.Second
+
+// eventSink delivers a batch of events, returning how many of them it delivered
+type eventSink func(batch []webhookEvent) (int, error)
+
+// eventSinks deliver events, by their name in EVENT_SINKS
+var eventSinks = map[string]eventSink{
+	"webhook": publishWebhookEvents,
+	"nats":    publishNATSEvents,
+}
+
+// eventPublisher queues link and click events and delivers them in the background, so
+// requests never wait for a sink. Every sink has its own queue and worker, so a slow
+// sink does not hold back the others. Each sink is tried once per event; events that
+// cannot be delivered are counted and dropped.
+type eventPublisher struct {
+	mu      sync.RWMutex
+	workers []*eventWorker
+	wg      sync.WaitGroup
+}
+
+// eventWorker delivers the events queued for one sink
+type eventWorker struct {
+	name    string
+	sink    eventSink
+	queue   chan webhookEvent
+	stop    chan struct{}
+	failing atomic.Bool
+}
+
+var linkEvents = &eventPublisher{}
+
+func init() {
+	registerHealthCheck("events", false, func(ctx context.Context) (map[string]any, error) {
+		sinks := map[string]any{}
+		linkEvents.mu.RLock()
+		for _, w := range linkEvents.workers {
+			sinks[w.name] = map[string]any{
+				"depth":    len(w.queue),
+				"capacity": cap(w.queue),
+				"failing":  w.failing.Load(),
+			}
+		}
+		linkEvents.mu.RUnlock()
+		return map[string]any{
+			"sinks":   sinks,
+			"failed":  metricEventsFailed.Value(),
+			"dropped": metricEventsDropped.Value(),
+		}, nil
+	})
+}
+
+// checkEventSinks rejects unknown names in EVENT_SINKS and the webhook sink without a URL
+func checkEventSinks() error {
+	for _, name := range cfg().EventSinks {
+		if _, ok := eventSinks[name]; !ok {
+			return fmt.Errorf("unknown event sink %q in EVENT_SINKS", name)
+		}
+		if name == "webhook" && cfg().EventWebhookURL == "" {
+			return errors.New("the webhook event sink requires EVENT_WEBHOOK_URL")
+		}
+	}
+	return nil
+}
+
+// publishEvent queues event with data for the configured sinks
+func publishEvent(event string, data any) {
+	linkEvents.mu.RLock()
+	defer linkEvents.mu.RUnlock()
+	if len(linkEvents.workers) == 0 {
+		return
+	}
+	queued := webhookEvent{Event: event, CreatedAt: time.Now().UTC(), Data: data}
+	for _, w := range linkEvents.workers {
+		select {
+		case w.queue <- queued:
+		default:
+			metricEventsDropped.Add(1)
+		}
+	}
+}
+
+// Start launches a worker for every sink in EVENT_SINKS
+func (p *eventPublisher) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range cfg().EventSinks {
+		w := &eventWorker{
+			name:  name,
+			sink:  eventSinks[name],
+			queue: make(chan webhookEvent, maxQueuedEvents),
+			stop:  make(chan struct{}),
+		}
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			w.run()
+		}()
+	}
+}
+
+// Stop waits, at most shutdownTimeout, for the queued events to be delivered
+func (p *eventPublisher) Stop() {
+	p.mu.Lock()
+	workers := p.workers
+	p.workers = nil
+	p.mu.Unlock()
+	for _, w := range workers {
+		close(w.stop)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		for _, w := range workers {
+			if n := len(w.queue); n > 0 {
+				log.Printf("⚠️  Gave up on %d events for %s", n, w.name)
+			}
+		}
+	}
+	nats.Close()
+}
+
+// run delivers queued events in batches until Stop is called, then delivers those still
+// queued
+func (w *eventWorker) run() {
+	for {
+		select {
+		case event := <-w.queue:
+			w.deliver(w.batch(event))
+		case <-w.stop:
+			for len(w.queue) > 0 {
+				w.deliver(w.batch(<-w.queue))
+			}
+			return
+		}
+	}
+}
+
+// batch returns first followed by the events queued behind it, up to maxEventBatch
+func (w *eventWorker) batch(first webhookEvent) []webhookEvent {
+	batch := []webhookEvent{first}
+	for len(batch) < maxEventBatch {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// deliver hands batch to the sink, logging when the sink starts failing and when it
+// recovers rather than for every event
+func (w *eventWorker) deliver(batch []webhookEvent) {
+	delivered, err := w.sink(batch)
+	metricEventsFailed.Add(int64(len(batch) - delivered))
+
+	switch {
+	case err != nil:
+		if !w.failing.Swap(true) {
+			log.Printf("⚠️  Publishing events to %s failed: %v", w.name, err)
+		}
+	case w.failing.Swap(false):
+		log.Printf("✓ Publishing events to %s works again", w.name)
+	}
+}
+
+// publishWebhookEvents POSTs each event to EVENT_WEBHOOK_URL as it was queued, so
+// created_at is when the event happened rather than when it was delivered
+func publishWebhookEvents(batch []webhookEvent) (int, error) {
+	delivered := 0
+	var first error
+	for _, event := range batch {
+		ctx, cancel := context.WithTimeout(context.Background(), eventDeliveryTimeout)
+		err := deliverWebhook(ctx, webhookClient, cfg().EventWebhookURL, event)
+		cancel()
+		if err == nil {
+			delivered++
+		} else if first == nil {
+			first = err
+		}
+	}
+	return delivered, first
+}
+
+// publishNATSEvents publishes each event as JSON to NATS_SUBJECT_PREFIX.<event>, such as
+// shorty.link.clicked. The batch is written at once and, with JetStream, its
+// acknowledgements are awaited together.
+func publishNATSEvents(batch []webhookEvent) (int, error) {
+	messages := make([]natsMessage, 0, len(batch))
+	for _, event := range batch {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, natsMessage{Subject: cfg().NATSSubjectPrefix + "." + event.Event, Data: body})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventDeliveryTimeout)
+	defer cancel()
+	return nats.Publish(ctx, messages)
+}
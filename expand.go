@@ -0,0 +1,96 @@
+package shorty
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Expand statuses
+const (
+	expandActive   = "active"
+	expandDisabled = "disabled"
+	expandNotFound = "not_found"
+	expandInvalid  = "invalid"
+)
+
+// ExpandRequest is the body of POST /api/expand, with at most 100 links
+type ExpandRequest struct {
+	Links []string `json:"links" binding:"required,min=1,max=100,dive,max=2048"`
+}
+
+// ExpandResult describes one link of an expand request, in request order
+type ExpandResult struct {
+	Input       string `json:"input"`
+	ShortCode   string `json:"short_code,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	Status      string `json:"status"`
+}
+
+// expandLinks handles POST /api/expand, resolving many short codes or short URLs in one
+// query without counting clicks
+func expandLinks(c *gin.Context) {
+	var req ExpandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	results := make([]ExpandResult, len(req.Links))
+	codes := make([]string, 0, len(req.Links))
+	for i, input := range req.Links {
+		results[i] = ExpandResult{Input: input, Status: expandInvalid}
+		if code, ok := shortCodeFromInput(input); ok {
+			results[i].ShortCode = code
+			codes = append(codes, code)
+		}
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	states, err := lookupLinkStates(ctx, codes)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to expand links")
+		return
+	}
+
+	for i := range results {
+		r := &results[i]
+		if r.ShortCode == "" {
+			continue
+		}
+		state, ok := states[r.ShortCode]
+		switch {
+		case !ok || !visibleTo(c, state.Owner, state.Visibility) || !reachableBy(c, state.Owner, state.AllowedNetworks, state.AllowedCountries, state.BlockedCountries):
+			r.Status = expandNotFound
+		case state.Disabled:
+			r.Status = expandDisabled
+		default:
+			r.Status = expandActive
+			r.OriginalURL = state.OriginalURL
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// shortCodeFromInput extracts the short code from a bare code or a short URL
+func shortCodeFromInput(input string) (string, bool) {
+	code := strings.TrimSpace(input)
+	if strings.Contains(code, "/") {
+		if !strings.Contains(code, "://") {
+			code = "https://" + code
+		}
+		u, err := url.Parse(code)
+		if err != nil {
+			return "", false
+		}
+		code = strings.Trim(u.Path, "/")
+	}
+	if code == "" || len(code) > 32 || !aliasPattern.MatchString(code) {
+		return "", false
+	}
+	return code, true
+}
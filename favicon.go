@@ -0,0 +1,159 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/idna"
+)
+
+// Favicon proxy limits
+const (
+	maxFaviconBytes      = 100 * 1024
+	faviconFetchTimeout  = 5 * time.Second
+	faviconCacheControl  = "public, max-age=86400"
+	faviconLinkTagLimit  = 20
+	faviconAcceptedTypes = "image/x-icon, image/vnd.microsoft.icon, image/png, image/gif, image/jpeg, image/webp"
+)
+
+var (
+	// domainPattern accepts ASCII host names made of DNS labels, with at least one dot;
+	// internationalized names must be converted to punycode first
+	domainPattern  = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+([a-z]{2,63}|xn--[a-z0-9-]{1,59})$`)
+	linkTagPattern = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+
+	// faviconTypes maps accepted icon types to whether the body must be sniffed as that
+	// type; ICO files are not recognized by http.DetectContentType
+	faviconTypes = map[string]bool{
+		"image/x-icon": false, "image/vnd.microsoft.icon": false,
+		"image/png": true, "image/gif": true, "image/jpeg": true, "image/webp": true,
+	}
+
+	errNoFavicon = errors.New("no usable favicon")
+)
+
+// Favicon is a site icon fetched for the favicon proxy; a nil Image records a failed
+// lookup
+type Favicon struct {
+	Domain      string
+	ContentType string
+	Image       []byte
+	FetchedAt   time.Time
+}
+
+// getFavicon handles GET /api/favicon?domain=example.com. Icons are fetched by Shorty and
+// served from its own origin, so dashboards showing them do not reveal their users'
+// browsers to the linked sites.
+func getFavicon(c *gin.Context) {
+	domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(c.Query("domain")), "."))
+	if err != nil || !domainPattern.MatchString(domain) || len(domain) > 253 {
+		respondValidationError(c, FieldError{Field: "domain", Rule: "hostname", Message: "domain must be a host name such as example.com"})
+		return
+	}
+
+	icon, err := siteFavicon(c.Request.Context(), domain)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch favicon")
+		return
+	}
+	if icon.Image == nil {
+		respondError(c, http.StatusNotFound, "Favicon not found")
+		return
+	}
+	c.Header("Cache-Control", faviconCacheControl)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, icon.ContentType, icon.Image)
+}
+
+// fetchFavicon finds a domain's icon: the first <link rel="icon"> on its home page, then
+// /favicon.ico
+func fetchFavicon(ctx context.Context, domain string) (Favicon, error) {
+	home := "https://" + domain + "/"
+	candidates := []string{}
+	if resp, body, err := fetchDestination(ctx, home); err == nil && isHTML(resp) {
+		candidates = append(candidates, iconLinks(resp.Request.URL, body)...)
+	}
+	candidates = append(candidates, home+"favicon.ico")
+
+	for _, candidate := range candidates {
+		icon, err := fetchIcon(ctx, candidate)
+		if err == nil {
+			return icon, nil
+		}
+		if ctx.Err() != nil {
+			return Favicon{}, ctx.Err()
+		}
+	}
+	return Favicon{}, errNoFavicon
+}
+
+// iconLinks returns the icon URLs declared in a page's <link rel="icon"> tags
+func iconLinks(base *url.URL, body []byte) []string {
+	var links []string
+	for _, tag := range linkTagPattern.FindAll(body, faviconLinkTagLimit) {
+		attrs := map[string]string{}
+		for _, m := range attributePattern.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(m[1]))] = string(m[2]) + string(m[3])
+		}
+		rel := strings.Fields(strings.ToLower(attrs["rel"]))
+		if !containsString(rel, "icon") || attrs["href"] == "" || strings.HasSuffix(strings.ToLower(attrs["href"]), ".svg") {
+			continue
+		}
+		ref, err := url.Parse(strings.TrimSpace(attrs["href"]))
+		if err != nil {
+			continue
+		}
+		resolved := base.ResolveReference(ref)
+		if resolved.Scheme == "http" || resolved.Scheme == "https" {
+			links = append(links, resolved.String())
+		}
+	}
+	return links
+}
+
+// fetchIcon downloads one icon, checking its declared type, its size and, where
+// possible, that the body really is that type
+func fetchIcon(ctx context.Context, target string) (Favicon, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asciiURL(target), nil)
+	if err != nil {
+		return Favicon{}, err
+	}
+	req.Header.Set("User-Agent", "Shorty/"+version+" (+link preview)")
+	req.Header.Set("Accept", faviconAcceptedTypes)
+
+	resp, err := destinationClient.Do(req)
+	if err != nil {
+		return Favicon{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return Favicon{}, fmt.Errorf("icon answered %s", resp.Status)
+	}
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	sniff, ok := faviconTypes[contentType]
+	if !ok {
+		return Favicon{}, fmt.Errorf("unsupported icon type %q", contentType)
+	}
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxFaviconBytes+1))
+	if err != nil {
+		return Favicon{}, err
+	}
+	if len(image) == 0 || len(image) > maxFaviconBytes {
+		return Favicon{}, fmt.Errorf("icon size %d is outside 1..%d bytes", len(image), maxFaviconBytes)
+	}
+	if sniff && http.DetectContentType(image) != contentType {
+		return Favicon{}, fmt.Errorf("icon body is not %s", contentType)
+	}
+	return Favicon{ContentType: contentType, Image: image, FetchedAt: time.Now().UTC()}, nil
+}
@@ -0,0 +1,90 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxFetchBytes caps how much of a destination page is read
+const maxFetchBytes = 512 * 1024
+
+// errPrivateAddress is returned when a destination resolves to a non-public address
+var errPrivateAddress = errors.New("destination resolves to a private address")
+
+// destinationClient fetches user-supplied destinations. It refuses to connect to
+// loopback, private and link-local addresses, checked after DNS resolution and on every
+// redirect, so links cannot be used to probe the internal network.
+var destinationClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil || !isPublicAddr(addrPort.Addr()) {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost:   2,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// isPublicAddr reports whether addr is a globally routable unicast address
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnatPrefix.Contains(addr)
+}
+
+// cgnatPrefix is the shared address space used by carrier-grade NAT (RFC 6598)
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// fetchDestination GETs a destination URL, returning at most maxFetchBytes of the body
+// of a successful response
+func fetchDestination(ctx context.Context, target string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asciiURL(target), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", "Shorty/"+version+" (+link preview)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+
+	resp, err := destinationClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes))
+	if err != nil {
+		return resp, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return resp, body, fmt.Errorf("destination answered %s", resp.Status)
+	}
+	return resp, body, nil
+}
+
+// isHTML reports whether a response declares an HTML body
+func isHTML(resp *http.Response) bool {
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	return strings.HasPrefix(contentType, "text/html") || strings.HasPrefix(contentType, "application/xhtml")
+}
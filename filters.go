@@ -0,0 +1,282 @@
+package shorty
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// Link states selectable with the state filter
+const (
+	stateActive   = "active"
+	stateArchived = "archived"
+	stateExpired  = "expired"
+)
+
+// linkDomainSQL extracts a link's destination host without "www.". It must match the
+// expression of idx_urls_domain for the index to be used.
+const linkDomainSQL = `regexp_replace(lower(substring(original_url from '^[A-Za-z][A-Za-z0-9+.-]*://([^/?#:]+)')), '^www\.', '')`
+
+// LinkFilterParams are the link filters accepted as query parameters by the list and
+// count endpoints and as JSON by bulk operations. Times are RFC 3339 timestamps or
+// dates in UTC.
+type LinkFilterParams struct {
+	Tag           string `form:"tag" json:"tag" binding:"omitempty,tag"`
+	Owner         string `form:"owner" json:"owner" binding:"omitempty,max=64"`
+	Domain        string `form:"domain" json:"domain" binding:"omitempty,max=253"`
+	CreatedAfter  string `form:"created_after" json:"created_after"`
+	CreatedBefore string `form:"created_before" json:"created_before"`
+	MinClicks     *int   `form:"min_clicks" json:"min_clicks" binding:"omitempty,min=0"`
+	MaxClicks     *int   `form:"max_clicks" json:"max_clicks" binding:"omitempty,min=0"`
+	State         string `form:"state" json:"state" binding:"omitempty,oneof=active archived expired"`
+	Metadata      string `form:"metadata" json:"metadata" binding:"omitempty,max=256"`
+}
+
+// defaultListLimit is the page size of GET /api/urls when no limit is given
+const defaultListLimit = 100
+
+// ListParams are the query parameters of GET /api/urls
+type ListParams struct {
+	LinkFilterParams
+	Sort  string `form:"sort" binding:"omitempty,oneof=clicks created_at last_clicked"`
+	Order string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Page  int    `form:"page" binding:"omitempty,min=1,max=100000"`
+
+	// After is a "<created_at>,<id>" cursor from the previous page's Link header. Unlike
+	// page it costs the same however deep it goes.
+	After string `form:"after" binding:"omitempty,max=64"`
+}
+
+// listCursor is the position after the last link of a page in created_at order
+type listCursor struct {
+	CreatedAt time.Time
+	ID        int
+	Ascending bool
+}
+
+// parseCursor validates After, which only applies to the created_at sort
+func (p ListParams) parseCursor() (*listCursor, []FieldError) {
+	if p.After == "" {
+		return nil, nil
+	}
+	if p.Sort != "" && p.Sort != "created_at" {
+		return nil, []FieldError{{Field: "after", Rule: "excluded_unless", Param: "sort created_at", Message: "after can only be used when sorting by created_at"}}
+	}
+	if p.Page != 0 {
+		return nil, []FieldError{{Field: "after", Rule: "excluded_with", Param: "page", Message: "after cannot be combined with page"}}
+	}
+	createdAt, id, _ := strings.Cut(p.After, ",")
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	n, idErr := strconv.Atoi(id)
+	if err != nil || idErr != nil || n < 1 {
+		return nil, []FieldError{{Field: "after", Rule: "cursor", Message: "after must be a cursor of the form <created_at>,<id>"}}
+	}
+	return &listCursor{CreatedAt: t.UTC(), ID: n, Ascending: p.Order == "asc"}, nil
+}
+
+// String formats the cursor as accepted by parseCursor
+func (c listCursor) String() string {
+	return c.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + strconv.Itoa(c.ID)
+}
+
+// orderBy returns the ORDER BY clause for the requested sort, newest first by default.
+// Links never clicked sort as the least recently clicked.
+func (p ListParams) orderBy() string {
+	direction := "DESC"
+	if p.Order == "asc" {
+		direction = "ASC"
+	}
+	switch p.Sort {
+	case "clicks":
+		return "clicks " + direction + ", id " + direction
+	case "last_clicked":
+		if direction == "ASC" {
+			return "last_clicked_at ASC NULLS FIRST, id ASC"
+		}
+		return "last_clicked_at DESC NULLS LAST, id DESC"
+	}
+	return "created_at " + direction + ", id " + direction
+}
+
+// LinkCounts is the number of links matching a filter, in total and by state
+type LinkCounts struct {
+	Total    int64 `json:"total"`
+	Active   int64 `json:"active"`
+	Expired  int64 `json:"expired"`
+	Archived int64 `json:"archived"`
+}
+
+// linkFilter is a parsed LinkFilterParams; zero fields do not filter
+type linkFilter struct {
+	Owner         string
+	Tag           string
+	Domain        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	MinClicks     *int
+	MaxClicks     *int
+	State         string
+	Metadata      *metadataFilter
+
+	// After restricts a created_at listing to links past a cursor
+	After *listCursor
+
+	// Visible restricts a listing to the links a visitor may resolve
+	Visible *linkVisibility
+}
+
+// linkVisibility is who a listing is for: anonymous, or authenticated as the users and
+// groups in Groups, connecting from Addr in Country. Links restricted to other networks
+// or countries are left out unless User owns them.
+type linkVisibility struct {
+	Authenticated bool
+	Groups        []string
+	User          string
+	Addr          *string
+	Country       string
+}
+
+// parseLinkFilter validates filter parameters that struct tags cannot express
+func parseLinkFilter(p LinkFilterParams) (linkFilter, []FieldError) {
+	filter := linkFilter{
+		Owner:     p.Owner,
+		Tag:       strings.ToLower(p.Tag),
+		Domain:    strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p.Domain)), "."), "www."),
+		MinClicks: p.MinClicks,
+		MaxClicks: p.MaxClicks,
+		State:     p.State,
+	}
+
+	var details []FieldError
+	for _, bound := range []struct {
+		field string
+		value string
+		dest  **time.Time
+	}{
+		{"created_after", p.CreatedAfter, &filter.CreatedAfter},
+		{"created_before", p.CreatedBefore, &filter.CreatedBefore},
+	} {
+		if bound.value == "" {
+			continue
+		}
+		t, ok := parseStatsTime(bound.value, time.UTC)
+		if !ok {
+			details = append(details, FieldError{Field: bound.field, Rule: "datetime", Message: bound.field + " must be an RFC 3339 timestamp or a date"})
+			continue
+		}
+		*bound.dest = &t
+	}
+	// Destinations are stored with Unicode host names, so punycode is matched as Unicode
+	if filter.Domain != "" {
+		ascii, err := idna.Lookup.ToASCII(filter.Domain)
+		if urlEncryptionEnabled() {
+			// The database cannot see the host of encrypted destinations
+			details = append(details, FieldError{Field: "domain", Rule: "unsupported", Message: "domain filtering is unavailable while destinations are encrypted"})
+		} else if err != nil || !domainPattern.MatchString(ascii) {
+			details = append(details, FieldError{Field: "domain", Rule: "hostname", Message: "domain must be a host name such as example.com"})
+		} else if display, err := idna.Display.ToUnicode(ascii); err == nil {
+			filter.Domain = display
+		}
+	}
+	if metadata, fe := parseMetadataFilter(p.Metadata); fe != nil {
+		details = append(details, *fe)
+	} else {
+		filter.Metadata = metadata
+	}
+	if filter.MinClicks != nil && filter.MaxClicks != nil && *filter.MinClicks > *filter.MaxClicks {
+		details = append(details, FieldError{Field: "max_clicks", Rule: "gtefield", Param: "min_clicks", Message: "max_clicks must be at least min_clicks"})
+	}
+	if filter.CreatedAfter != nil && filter.CreatedBefore != nil && !filter.CreatedBefore.After(*filter.CreatedAfter) {
+		details = append(details, FieldError{Field: "created_before", Rule: "gtfield", Param: "created_after", Message: "created_before must be after created_after"})
+	}
+	return filter, details
+}
+
+// empty reports whether the filter matches every link
+func (f linkFilter) empty() bool {
+	return f == linkFilter{}
+}
+
+// stateSQL returns the condition selecting links in state
+func stateSQL(state string) string {
+	switch state {
+	case stateActive:
+		return "disabled_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())"
+	case stateArchived:
+		return "disabled_at IS NOT NULL"
+	case stateExpired:
+		return "disabled_at IS NULL AND expires_at <= NOW()"
+	}
+	return "TRUE"
+}
+
+// where returns the filter as a SQL condition on urls, appending its parameters to args
+func (f linkFilter) where(args []any) (string, []any) {
+	var conditions []string
+	add := func(condition string, values ...any) {
+		for _, value := range values {
+			args = append(args, value)
+			condition = strings.Replace(condition, "$?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if f.Owner != "" {
+		add("owner = $?", f.Owner)
+	}
+	if f.Tag != "" {
+		add("tags @> ARRAY[$?::text]", f.Tag)
+	}
+	if f.Domain != "" {
+		add(linkDomainSQL+" = $?", f.Domain)
+	}
+	if f.CreatedAfter != nil {
+		add("created_at >= $?", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		add("created_at < $?", *f.CreatedBefore)
+	}
+	if f.MinClicks != nil {
+		add("clicks >= $?", *f.MinClicks)
+	}
+	if f.MaxClicks != nil {
+		add("clicks <= $?", *f.MaxClicks)
+	}
+	if f.Metadata != nil {
+		if f.Metadata.HasValue {
+			add("metadata ? $? AND metadata->>$? = $?", f.Metadata.Key, f.Metadata.Key, f.Metadata.Value)
+		} else {
+			add("metadata ? $?", f.Metadata.Key)
+		}
+	}
+	if f.State != "" {
+		conditions = append(conditions, stateSQL(f.State))
+	}
+	if f.Visible != nil {
+		if f.Visible.Authenticated {
+			add("(visibility IS DISTINCT FROM 'members' OR owner = ANY($?))", f.Visible.Groups)
+		} else {
+			conditions = append(conditions, "visibility IS NULL")
+		}
+		add(`(owner = $? OR (
+			(COALESCE(cardinality(allowed_networks), 0) = 0 OR ($?::inet <<= ANY(allowed_networks)) IS TRUE)
+			AND (COALESCE(cardinality(allowed_countries), 0) = 0 OR $? = ANY(allowed_countries))
+			AND (COALESCE(cardinality(blocked_countries), 0) = 0 OR $? = '' OR $? <> ALL(blocked_countries))))`,
+			f.Visible.User, f.Visible.Addr, f.Visible.Country, f.Visible.Country, f.Visible.Country)
+	}
+	if f.After != nil {
+		operator := "<"
+		if f.After.Ascending {
+			operator = ">"
+		}
+		add("(created_at, id) "+operator+" ($?, $?)", f.After.CreatedAt, f.After.ID)
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", args
+	}
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,166 @@
+package shorty
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geoBlock identifies the per-country count of visits a link turned away
+type geoBlock struct {
+	ShortCode string
+	Country   string
+}
+
+// unknownCountry is recorded for blocked visitors whose country is not known
+const unknownCountry = "ZZ"
+
+// parseCountries normalizes the allowed_countries or blocked_countries of a request to
+// upper-case ISO 3166-1 alpha-2 codes
+func parseCountries(field string, values []string) ([]string, *FieldError) {
+	var countries []string
+	for _, value := range values {
+		country := strings.ToUpper(strings.TrimSpace(value))
+		if !isCountryCode(country) {
+			return nil, &FieldError{Field: field, Rule: "iso3166_1_alpha2", Param: value, Message: field + " must be two-letter country codes such as DE"}
+		}
+		if !containsString(countries, country) {
+			countries = append(countries, country)
+		}
+	}
+	return countries, nil
+}
+
+// countryAllowed reports whether visitors from country may follow the link
+func (l resolvedLink) countryAllowed(country string) bool {
+	return countryPermitted(l.AllowedCountries, l.BlockedCountries, country)
+}
+
+// countryPermitted reports whether visitors from country may follow a link with the
+// given allowed or blocked countries. With allowed countries, visitors whose country is
+// unknown are refused.
+func countryPermitted(allowed, blocked []string, country string) bool {
+	if len(allowed) > 0 {
+		return containsString(allowed, country)
+	}
+	return country == "" || !containsString(blocked, country)
+}
+
+// allowCountry answers requests for a link from a country it is not available in with
+// 403 and a page in the visitor's language, counting the attempt in the link's stats.
+// It reports whether the request may continue.
+func allowCountry(c *gin.Context, link resolvedLink) bool {
+	if len(link.AllowedCountries) == 0 && len(link.BlockedCountries) == 0 {
+		return true
+	}
+	country := visitorCountry(c)
+	if link.countryAllowed(country) {
+		return true
+	}
+
+	if country == "" {
+		country = unknownCountry
+	}
+	clicks.AddBlocked(link.Code, country)
+	metricGeoBlocked.Add(1)
+
+	lang, page := geoBlockedPage(c.GetHeader("Accept-Language"))
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Language", lang)
+	c.Header("Vary", "Accept-Language")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusForbidden, "text/html; charset=utf-8", page)
+	c.Abort()
+	return false
+}
+
+// acceptedLanguages lists the primary language subtags of an Accept-Language header in
+// the order given, such as ["de", "en"] for "de-CH,de;q=0.9,en;q=0.8"
+func acceptedLanguages(header string) []string {
+	var langs []string
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		lang, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+		if lang = strings.ToLower(lang); lang != "" && lang != "*" && !containsString(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// geoBlockedPage returns the page shown to visitors a link is not available to, and its
+// language. GEO_BLOCKED_PAGE is used when set; a {lang} in its path is replaced by the
+// visitor's preferred languages in turn, then by "en". Otherwise the built-in page is
+// served in the first language it has.
+func geoBlockedPage(acceptLanguage string) (string, []byte) {
+	langs := append(acceptedLanguages(acceptLanguage), "en")
+
+	if path := cfg().GeoBlockedPage; path != "" {
+		for _, lang := range langs {
+			page, err := os.ReadFile(strings.ReplaceAll(path, "{lang}", lang))
+			if err == nil {
+				return lang, page
+			}
+			if !strings.Contains(path, "{lang}") {
+				log.Printf("Failed to read GEO_BLOCKED_PAGE: %v", err)
+				break
+			}
+		}
+	}
+
+	for _, lang := range langs {
+		if text, ok := geoBlockedTexts[lang]; ok {
+			var page bytes.Buffer
+			geoBlockedTemplate.Execute(&page, struct {
+				Lang string
+				geoBlockedText
+			}{lang, text})
+			return lang, page.Bytes()
+		}
+	}
+	return "en", nil
+}
+
+// geoBlockedText is the wording of the built-in page in one language
+type geoBlockedText struct {
+	Title   string
+	Message string
+}
+
+// geoBlockedTexts are the languages of the built-in page
+var geoBlockedTexts = map[string]geoBlockedText{
+	"en": {"Not available in your region", "The content behind this link is not available in your country or region."},
+	"de": {"In Ihrer Region nicht verfügbar", "Der Inhalt hinter diesem Link ist in Ihrem Land oder Ihrer Region nicht verfügbar."},
+	"fr": {"Non disponible dans votre région", "Le contenu de ce lien n'est pas disponible dans votre pays ou votre région."},
+	"es": {"No disponible en tu región", "El contenido de este enlace no está disponible en tu país o región."},
+	"it": {"Non disponibile nella tua area", "Il contenuto di questo link non è disponibile nel tuo paese o nella tua area."},
+	"pt": {"Indisponível na sua região", "O conteúdo deste link não está disponível no seu país ou região."},
+	"nl": {"Niet beschikbaar in jouw regio", "De inhoud achter deze link is niet beschikbaar in jouw land of regio."},
+}
+
+var geoBlockedTemplate = template.Must(template.New("geoblocked").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>Shorty - {{.Title}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 560px; width: 100%; box-sizing: border-box; text-align: center; }
+        h1 { color: #333; margin: 0 0 12px; }
+        p { color: #555; line-height: 1.5; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🌍 {{.Title}}</h1>
+        <p>{{.Message}}</p>
+    </div>
+</body>
+</html>`))
@@ -0,0 +1,100 @@
+package shorty
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geoRange is one row of the GeoIP database: the addresses from start to end, inclusive,
+// are in country
+type geoRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// geoRanges is the GeoIP database loaded from GEOIP_DATABASE, sorted by start address
+var geoRanges []geoRange
+
+// loadGeoIP reads GEOIP_DATABASE, a CSV file of start address, end address and ISO
+// country code per row such as the free DB-IP "IP to Country Lite" download
+func loadGeoIP(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	var ranges []geoRange
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			return fmt.Errorf("line %d: expected start,end,country", line)
+		}
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		ranges = append(ranges, geoRange{start: start.Unmap(), end: end.Unmap(), country: strings.ToUpper(strings.TrimSpace(record[2]))})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	geoRanges = ranges
+	return nil
+}
+
+// lookupCountry returns the country of addr in the GeoIP database, or "" when it is not
+// listed
+func lookupCountry(addr netip.Addr) string {
+	addr = addr.Unmap()
+	i := sort.Search(len(geoRanges), func(i int) bool { return addr.Less(geoRanges[i].start) })
+	if i == 0 {
+		return ""
+	}
+	if r := geoRanges[i-1]; addr.Compare(r.end) <= 0 {
+		return r.country
+	}
+	return ""
+}
+
+// visitorCountry is the ISO country code of the request's client, or "" when unknown.
+// A CDN's GEOIP_COUNTRY_HEADER, such as CF-IPCountry, is used when set; otherwise the
+// client address is looked up in GEOIP_DATABASE.
+func visitorCountry(c *gin.Context) string {
+	if header := cfg().GeoIPCountryHeader; header != "" {
+		country := strings.ToUpper(strings.TrimSpace(c.GetHeader(header)))
+		if isCountryCode(country) {
+			return country
+		}
+	}
+	if addr, ok := networkClientIP(c); ok {
+		return lookupCountry(addr)
+	}
+	return ""
+}
+
+// isCountryCode reports whether s looks like an upper-case ISO 3166-1 alpha-2 code
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
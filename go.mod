@@ -4,5 +4,10 @@ go 1.21
 
 require (
 	github.com/gin-gonic/gin v1.9.1
-	github.com/lib/pq v1.10.9
+	github.com/go-playground/validator/v10 v10.14.0
+	github.com/jackc/pgx/v5 v5.5.5
+	github.com/quic-go/quic-go v0.42.0
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.10.0
+	golang.org/x/sync v0.2.0
 )
@@ -0,0 +1,915 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// URL represents a shortened URL entry
+type URL struct {
+	ID             int        `json:"id"`
+	ShortCode      string     `json:"short_code"`
+	OriginalURL    string     `json:"original_url"`
+	Clicks         int        `json:"clicks"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastClickedAt  *time.Time `json:"last_clicked_at,omitempty"`
+	Owner          string     `json:"owner,omitempty"`
+	Tags           []string   `json:"tags"`
+	AliasOf        string     `json:"alias_of,omitempty"`
+	Immutable      bool       `json:"immutable,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	FlaggedReason  string     `json:"flagged_reason,omitempty"`
+	Moderation     string     `json:"moderation,omitempty"`
+	RedirectStatus int        `json:"redirect_status,omitempty"`
+	HTMLRedirect   bool       `json:"html_redirect,omitempty"`
+
+	// UnavailableSince is set while the destination is failing LINK_CHECK_FAILURES
+	// checks in a row
+	UnavailableSince *time.Time `json:"unavailable_since,omitempty"`
+
+	// Unavailable links with WaybackFallback redirect to ArchiveURL once a snapshot of
+	// their destination is found
+	WaybackFallback bool   `json:"wayback_fallback,omitempty"`
+	ArchiveURL      string `json:"archive_url,omitempty"`
+
+	// Visibility is "private" or "members" for links only signed-in visitors can follow
+	Visibility string `json:"visibility,omitempty"`
+
+	// AllowedNetworks are the addresses the link can be followed from, if restricted
+	AllowedNetworks []netip.Prefix `json:"allowed_networks,omitempty"`
+
+	// AllowedCountries or BlockedCountries limit the countries the link can be followed from
+	AllowedCountries []string `json:"allowed_countries,omitempty"`
+	BlockedCountries []string `json:"blocked_countries,omitempty"`
+
+	// Schedule limits the hours the link redirects in
+	Schedule *LinkSchedule `json:"schedule,omitempty"`
+
+	// Metadata is free-form JSON stored for integrators, such as a CRM campaign ID
+	Metadata map[string]any `json:"metadata,omitempty"`
+
+	Links *Hyperlinks `json:"_links,omitempty"`
+}
+
+// ShortenRequest represents the request body for creating a short URL
+type ShortenRequest struct {
+	URL   string   `json:"url" binding:"required"`
+	Alias string   `json:"alias" binding:"omitempty,min=3,max=32,alias"`
+	Tags  []string `json:"tags" binding:"omitempty,max=10,dive,tag"`
+
+	// Immutable links can never change destination; they can only be disabled
+	Immutable bool `json:"immutable"`
+
+	// Expiring links answer 410 Gone from ExpiresAt on
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	// RedirectStatus replaces the default 301; 307 and 308 make clients repeat the
+	// request's method and body at the destination
+	RedirectStatus int `json:"redirect_status" binding:"omitempty,oneof=301 302 307 308"`
+
+	// Fragment replaces the destination's #fragment. HTMLRedirect sends visitors on with
+	// a page that keeps the fragment of the short URL they opened.
+	Fragment     string `json:"fragment" binding:"omitempty,max=256"`
+	HTMLRedirect bool   `json:"html_redirect"`
+
+	// WaybackFallback redirects to the latest Internet Archive snapshot of the destination
+	// once destination checks find it gone
+	WaybackFallback bool `json:"wayback_fallback"`
+
+	// Private links only redirect visitors who are signed in, members links only those in
+	// the group named like the owning API key
+	Visibility string `json:"visibility" binding:"omitempty,oneof=public private members"`
+
+	// AllowedNetworks limits the link to visitors from these addresses or CIDR ranges
+	AllowedNetworks []string `json:"allowed_networks" binding:"omitempty,max=50"`
+
+	// AllowedCountries limits the link to visitors from these countries, BlockedCountries
+	// turns away visitors from them; both are ISO 3166-1 alpha-2 codes
+	AllowedCountries []string `json:"allowed_countries" binding:"omitempty,max=250"`
+	BlockedCountries []string `json:"blocked_countries" binding:"omitempty,max=250"`
+
+	// Schedule only lets the link redirect during its windows, such as weekdays from
+	// 09:00 to 17:00, sending visitors elsewhere at other times
+	Schedule *LinkSchedule `json:"schedule"`
+
+	// Metadata is kept with the link and returned as given, for the caller's own IDs
+	Metadata map[string]any `json:"metadata"`
+}
+
+// ShortenResponse represents the response after creating a short URL
+type ShortenResponse struct {
+	ShortURL    string `json:"short_url"`
+	ShortCode   string `json:"short_code"`
+	OriginalURL string `json:"original_url"`
+
+	// Moderation is "pending" when the link waits for approval before it redirects
+	Moderation string `json:"moderation,omitempty"`
+
+	// Metadata is the link's metadata as stored
+	Metadata map[string]any `json:"metadata,omitempty"`
+
+	Links *Hyperlinks `json:"_links,omitempty"`
+}
+
+// StatsResponse represents URL statistics
+type StatsResponse struct {
+	ShortCode   string    `json:"short_code"`
+	OriginalURL string    `json:"original_url"`
+	Clicks      int       `json:"clicks"`
+	CreatedAt   time.Time `json:"created_at"`
+	Tags        []string  `json:"tags"`
+
+	// Blocked counts visits turned away by the link's country rules, in total and per
+	// country
+	Blocked          int64            `json:"blocked,omitempty"`
+	BlockedCountries map[string]int64 `json:"blocked_by_country,omitempty"`
+}
+
+// maxCodeAttempts bounds retries when a generated short code already exists
+const maxCodeAttempts = 5
+
+// insertGeneratedURL stores u under a new random short code, retrying if the code
+// collides with an existing one
+func insertGeneratedURL(ctx context.Context, u URL) (string, error) {
+	var err error
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		u.ShortCode, err = generateShortCode()
+		if err != nil {
+			return "", err
+		}
+
+		err = insertURL(ctx, u)
+		if err == nil {
+			return u.ShortCode, nil
+		}
+		if !isUniqueViolation(err) {
+			break
+		}
+	}
+	return "", err
+}
+
+// buildShortURL constructs the full short URL
+func buildShortURL(c *gin.Context, code string) string {
+	return requestBaseURL(c) + "/" + code
+}
+
+// requestBaseURL is the scheme, host and mount prefix the request was made to
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + mountPrefix(c.Request)
+}
+
+// mountPrefix returns the path an embedding application serves Shorty under, such as
+// "/links" for a handler mounted with http.StripPrefix("/links", ...). It is what the
+// path the client asked for has in front of the path being routed.
+func mountPrefix(r *http.Request) string {
+	requested, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil {
+		return ""
+	}
+	prefix, ok := strings.CutSuffix(requested.EscapedPath(), r.URL.EscapedPath())
+	if !ok {
+		return ""
+	}
+	return prefix
+}
+
+// publicShortURL builds a short URL outside a request, such as in mail or webhooks,
+// from PUBLIC_URL
+func publicShortURL(code string) string {
+	base := cfg().PublicURL
+	if base == "" {
+		base = "http://localhost:" + cfg().Port
+	}
+	return base + "/" + code
+}
+
+// createShortURL handles POST /api/shorten
+func createShortURL(c *gin.Context) {
+	var req ShortenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	link, created, err := createLink(ctx, req, owner)
+	if err != nil {
+		respondCreateError(c, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, ShortenResponse{
+		ShortURL:    buildShortURL(c, link.ShortCode),
+		ShortCode:   link.ShortCode,
+		OriginalURL: link.OriginalURL,
+		Moderation:  pendingState(link),
+		Metadata:    link.Metadata,
+		Links:       linkHyperlinks(c, link.ShortCode, created && owner != ""),
+	})
+}
+
+// createShortURLFromQuery handles GET /api/shorten?url=...&alias=...&tag=..., for tools
+// that can only issue GET requests. It answers with the bare short URL as plain text
+// unless JSON is asked for with format=json or an Accept header.
+func createShortURLFromQuery(c *gin.Context) {
+	req := ShortenRequest{URL: c.Query("url"), Alias: c.Query("alias"), Tags: c.QueryArray("tag")}
+	if details := validateRequest(&req); len(details) > 0 {
+		respondValidationError(c, details...)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	link, created, err := createLink(ctx, req, owner)
+	if err != nil {
+		respondCreateError(c, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	resp := ShortenResponse{
+		ShortURL:    buildShortURL(c, link.ShortCode),
+		ShortCode:   link.ShortCode,
+		OriginalURL: link.OriginalURL,
+		Moderation:  pendingState(link),
+		Links:       linkHyperlinks(c, link.ShortCode, created),
+	}
+	format := c.Query("format")
+	if format == "" && c.NegotiateFormat(gin.MIMEPlain, gin.MIMEJSON) == gin.MIMEJSON {
+		format = "json"
+	}
+	if format == "json" {
+		c.JSON(status, resp)
+		return
+	}
+	c.String(status, resp.ShortURL+"\n")
+}
+
+var (
+	// errAliasTaken is returned by createLink when the requested alias already exists
+	errAliasTaken = errors.New("alias is already taken")
+
+	// errAliasConfusable is returned by createLink when the requested alias differs from an
+	// existing code only in case or in look-alike characters such as 0 and O
+	errAliasConfusable = errors.New("alias looks like an existing short code")
+)
+
+// createLink stores the link described by an already bound request. It reports false when
+// an existing code for the same URL was returned instead of creating one. Invalid input
+// is returned as a *FieldError.
+func createLink(ctx context.Context, req ShortenRequest, owner string) (URL, bool, error) {
+	// Add protocol if missing
+	originalURL := strings.TrimSpace(req.URL)
+	if !strings.Contains(originalURL, "://") {
+		originalURL = "https://" + originalURL
+	}
+	if fragment := strings.TrimPrefix(req.Fragment, "#"); fragment != "" {
+		base, _, _ := strings.Cut(originalURL, "#")
+		originalURL = base + "#" + fragment
+	}
+
+	if fe := validateDestination("url", originalURL); fe != nil {
+		return URL{}, false, fe
+	}
+	originalURL = unicodeURL(originalURL)
+	if cfg().ExpandShorteners {
+		expanded, err := expandShorteners(ctx, originalURL)
+		if err != nil {
+			return URL{}, false, err
+		}
+		originalURL = expanded
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return URL{}, false, &FieldError{Field: "expires_at", Rule: "gt", Message: "expires_at must be in the future"}
+	}
+
+	if req.Visibility == visibilityMembers && owner == "" {
+		return URL{}, false, &FieldError{Field: "visibility", Rule: "required_with", Param: "api_key", Message: "members links must be created with an API key"}
+	}
+
+	networks, fe := parseAllowedNetworks(req.AllowedNetworks)
+	if fe != nil {
+		return URL{}, false, fe
+	}
+
+	if len(req.AllowedCountries) > 0 && len(req.BlockedCountries) > 0 {
+		return URL{}, false, &FieldError{Field: "blocked_countries", Rule: "excluded_with", Param: "allowed_countries", Message: "blocked_countries cannot be combined with allowed_countries"}
+	}
+	allowedCountries, fe := parseCountries("allowed_countries", req.AllowedCountries)
+	if fe != nil {
+		return URL{}, false, fe
+	}
+	blockedCountries, fe := parseCountries("blocked_countries", req.BlockedCountries)
+	if fe != nil {
+		return URL{}, false, fe
+	}
+
+	schedule, fe := parseSchedule(req.Schedule)
+	if fe != nil {
+		return URL{}, false, fe
+	}
+	if schedule != nil && schedule.FallbackURL != "" {
+		if err := checkBlocklist(ctx, "schedule.fallback_url", schedule.FallbackURL); err != nil {
+			return URL{}, false, err
+		}
+	}
+
+	metadata, fe := parseLinkMetadata(req.Metadata)
+	if fe != nil {
+		return URL{}, false, fe
+	}
+
+	link := URL{OriginalURL: originalURL, ShortCode: req.Alias, Owner: owner, Tags: normalizeTags(req.Tags), Immutable: req.Immutable, ExpiresAt: req.ExpiresAt, HTMLRedirect: req.HTMLRedirect, WaybackFallback: req.WaybackFallback, AllowedNetworks: networks, AllowedCountries: allowedCountries, BlockedCountries: blockedCountries, Schedule: schedule, Metadata: metadata}
+	if req.Visibility != visibilityPublic {
+		link.Visibility = req.Visibility
+	}
+	if req.RedirectStatus != http.StatusMovedPermanently {
+		link.RedirectStatus = req.RedirectStatus
+	}
+
+	// Check if URL already exists; aliased, tagged, immutable and expiring links, and
+	// links with redirect options, always get their own code
+	if link.ShortCode == "" && len(link.Tags) == 0 && !link.Immutable && link.ExpiresAt == nil && link.RedirectStatus == 0 && !link.HTMLRedirect && !link.WaybackFallback && link.Visibility == "" && len(link.AllowedNetworks) == 0 && len(link.AllowedCountries) == 0 && len(link.BlockedCountries) == 0 && link.Schedule == nil && link.Metadata == nil {
+		existingCode, moderation, err := findCodeByURL(ctx, originalURL)
+		if err == nil {
+			link.ShortCode, link.Moderation = existingCode, moderation
+			return link, false, nil
+		}
+		if !errors.Is(err, errNotFound) {
+			return URL{}, false, err
+		}
+	}
+
+	link, err := insertLink(ctx, link)
+	if err != nil {
+		return URL{}, false, err
+	}
+	return link, true, nil
+}
+
+// checkCustomAlias applies the rules for a custom alias: its characters and length, the
+// reserved paths, codes differing only in case under CASE_INSENSITIVE_CODES and codes
+// that look alike. It returns the alias as it is stored.
+func checkCustomAlias(ctx context.Context, alias string) (string, error) {
+	if details := validateRequest(&aliasInput{Alias: alias}); len(details) > 0 {
+		return "", &details[0]
+	}
+	if isReservedAlias(alias) {
+		return "", &FieldError{Field: "alias", Rule: "reserved", Message: "alias is reserved"}
+	}
+	// Existing codes may differ only in case, which the unique index does not catch
+	if cfg().CaseInsensitiveCodes {
+		alias = strings.ToLower(alias)
+		exists, err := codeExists(ctx, alias)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "", errAliasTaken
+		}
+	}
+	similar, err := confusableCode(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	if similar != "" {
+		return "", errAliasConfusable
+	}
+	return alias, nil
+}
+
+// insertLink stores a new link under its ShortCode, a custom alias that must not be
+// reserved or taken, or under a generated code if it has none. Destinations on a
+// blocklist feed are refused, and links of callers MODERATION applies to start pending.
+func insertLink(ctx context.Context, link URL) (URL, error) {
+	if needsModeration(link.Owner) {
+		link.Moderation = moderationPending
+	}
+	if link.ShortCode != "" {
+		alias, err := checkCustomAlias(ctx, link.ShortCode)
+		if err != nil {
+			return URL{}, err
+		}
+		link.ShortCode = alias
+	}
+	if err := checkBlocklist(ctx, "url", link.OriginalURL); err != nil {
+		return URL{}, err
+	}
+	if link.ShortCode == "" {
+		code, err := insertGeneratedURL(ctx, link)
+		if err != nil {
+			return URL{}, err
+		}
+		link.ShortCode = code
+	} else {
+		if err := insertURL(ctx, link); err != nil {
+			if isUniqueViolation(err) {
+				return URL{}, errAliasTaken
+			}
+			return URL{}, err
+		}
+	}
+	metricLinksCreated.Add(1)
+	queueScreenshot(link)
+	publishEvent(eventLinkCreated, link)
+	return link, nil
+}
+
+// respondCreateError answers a failed createLink call
+func respondCreateError(c *gin.Context, err error) {
+	var fe *FieldError
+	switch {
+	case errors.As(err, &fe):
+		respondValidationError(c, *fe)
+	case errors.Is(err, errAliasTaken):
+		respondError(c, http.StatusConflict, "Alias is already taken")
+	case errors.Is(err, errAliasConfusable):
+		respondError(c, http.StatusConflict, "Alias looks too much like an existing short code")
+	default:
+		respondStoreError(c, err, "", "Failed to save URL")
+	}
+}
+
+// redirectToURL handles GET /:code
+func redirectToURL(c *gin.Context) {
+	link, ok := resolveCode(c)
+	if !ok {
+		return
+	}
+
+	// Suspicious destinations are shown with a warning; visitors proceed by posting
+	// the page's form to proceedToURL
+	if link.Warning != "" {
+		respondWarningPage(c, link)
+		return
+	}
+	redirectVisitor(c, link, link.redirectStatus())
+}
+
+// forwardToURL handles PUT, PATCH and DELETE on /:code, which only links with a 307 or
+// 308 redirect status accept
+func forwardToURL(c *gin.Context) {
+	link, ok := resolveCode(c)
+	if !ok {
+		return
+	}
+	if link.Warning != "" || !preservesMethod(link.Status) {
+		c.Header("Allow", "GET, HEAD, POST")
+		respondError(c, http.StatusMethodNotAllowed, "Short URL only accepts GET")
+		return
+	}
+	redirectVisitor(c, link, link.Status)
+}
+
+// resolveCode looks up the link of the request's code, answering the request itself
+// when there is none
+func resolveCode(c *gin.Context) (resolvedLink, bool) {
+	code := cleanCode(c.Param("code"))
+
+	// Skip file requests and paths of routes this method does not serve
+	if strings.Contains(code, ".") || isReservedAlias(code) {
+		c.Status(http.StatusNotFound)
+		return resolvedLink{}, false
+	}
+
+	// Signed share URLs lead past the link being private or disabled until they expire
+	lookup := lookupDestination
+	shared := isShareRequest(c)
+	if shared {
+		switch err := verifyShare(c, code); {
+		case errors.Is(err, errShareExpired):
+			respondError(c, http.StatusGone, "Share URL has expired")
+			return resolvedLink{}, false
+		case err != nil:
+			respondError(c, http.StatusForbidden, "Invalid share signature")
+			return resolvedLink{}, false
+		}
+		lookup = lookupSharedDestination
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	link, err := lookup(ctx, code)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			metricNotFound.Add(1)
+		}
+		if errors.Is(err, errUnavailable) {
+			respondUnavailablePage(c)
+			return resolvedLink{}, false
+		}
+		respondStoreError(c, err, "Short URL not found", "Failed to look up short URL")
+		return resolvedLink{}, false
+	}
+	link.Shared = shared
+	if !shared && !authorizeVisitor(c, link) {
+		return resolvedLink{}, false
+	}
+	if !allowNetwork(c, link) || !allowCountry(c, link) || !allowSchedule(c, link) {
+		return resolvedLink{}, false
+	}
+	return link, true
+}
+
+// restricted reports whether only some visitors may follow the link: signed-in ones,
+// holders of a share URL, those on its allowed networks or in its allowed countries, or
+// those arriving within its opening hours
+func (l resolvedLink) restricted() bool {
+	return l.Visibility != "" || l.Shared || len(l.AllowedNetworks) > 0 || len(l.AllowedCountries) > 0 || len(l.BlockedCountries) > 0 || l.Schedule != nil
+}
+
+// redirectStatus returns the status GET requests for the link are redirected with;
+// redirects to an archived copy are always temporary
+func (l resolvedLink) redirectStatus() int {
+	if l.Archived {
+		return http.StatusFound
+	}
+	if l.Status == 0 {
+		return http.StatusMovedPermanently
+	}
+	return l.Status
+}
+
+// preservesMethod reports whether a redirect status makes clients repeat the request's
+// method and body
+func preservesMethod(status int) bool {
+	return status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect
+}
+
+// uncachedStatus returns the temporary equivalent of a permanent redirect status, for
+// redirects browsers must not reuse
+func uncachedStatus(status int) int {
+	switch status {
+	case http.StatusMovedPermanently:
+		return http.StatusFound
+	case http.StatusPermanentRedirect:
+		return http.StatusTemporaryRedirect
+	}
+	return status
+}
+
+// redirectVisitor counts a click on link and redirects to its destination with status,
+// made temporary when the redirect carries a per-click ID
+func redirectVisitor(c *gin.Context, link resolvedLink, status int) {
+	target := asciiURL(link.Destination)
+
+	// Monitoring tools and mail scanners probe links with HEAD; unless COUNT_HEAD_REQUESTS
+	// is set they get the same redirect without a click or click ID being recorded
+	if c.Request.Method != http.MethodHead || cfg().CountHeadRequests {
+		// Archived copies are not the advertiser's site, so they get no click ID
+		var clickID string
+		if !link.Archived {
+			target, clickID = redirectTarget(link.Code, target)
+		}
+
+		// Count the click in the next batched write; read-only mode avoids all writes
+		if currentMode() != modeReadOnly {
+			event := newClickEvent(c, link.Code)
+			event.ClickID = clickID
+			clicks.Add(event)
+			publishEvent(eventLinkClicked, ClickEventRecord{
+				ShortCode:    event.ShortCode,
+				ClickedAt:    event.ClickedAt,
+				ReferrerHost: event.ReferrerHost,
+				UserAgent:    event.UserAgent,
+				IPPrefix:     event.IPPrefix,
+				ClickID:      event.ClickID,
+			})
+		}
+	}
+	metricRedirects.Add(1)
+
+	// A redirect carrying a per-click ID must not be cached by the browser, nor one that
+	// only some visitors may follow
+	uncached := clickIDsEnabled() || link.restricted()
+	if uncached {
+		status = uncachedStatus(status)
+	}
+	if link.restricted() {
+		c.Header("Cache-Control", "private, no-store")
+	}
+	if link.HTMLRedirect && (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) {
+		respondHTMLRedirect(c, target, uncached)
+		return
+	}
+	c.Redirect(status, target)
+}
+
+// getStats handles GET /api/stats/:code
+func getStats(c *gin.Context) {
+	code := c.Param("code")
+	loc, fe := parseTimezone(c)
+	if fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, code)
+	if err == nil && (!visibleTo(c, u.Owner, u.Visibility) || !reachableBy(c, u.Owner, u.AllowedNetworks, u.AllowedCountries, u.BlockedCountries)) {
+		err = errNotFound
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return
+	}
+
+	resp := StatsResponse{
+		ShortCode:   u.ShortCode,
+		OriginalURL: u.OriginalURL,
+		Clicks:      u.Clicks,
+		CreatedAt:   u.CreatedAt.In(loc),
+		Tags:        u.Tags,
+	}
+	if len(u.AllowedCountries) > 0 || len(u.BlockedCountries) > 0 {
+		resp.BlockedCountries, err = geoBlockCounts(ctx, u.ShortCode)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to fetch stats")
+			return
+		}
+		for _, n := range resp.BlockedCountries {
+			resp.Blocked += n
+		}
+	}
+	jsonWithETag(c, http.StatusOK, resp)
+}
+
+// listURLs handles GET /api/urls
+func listURLs(c *gin.Context) {
+	var params ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	respondLinkList(c, params)
+}
+
+// respondLinkList writes the page of links selected by params
+func respondLinkList(c *gin.Context, params ListParams) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	filter, details := parseLinkFilter(params.LinkFilterParams)
+	cursor, cursorDetails := params.parseCursor()
+	details = append(details, cursorDetails...)
+	if len(details) > 0 {
+		respondValidationError(c, details...)
+		return
+	}
+	filter.After = cursor
+	filter.Visible = visibilityScope(c)
+	if params.Limit == 0 {
+		params.Limit = defaultListLimit
+	}
+	if params.Page == 0 {
+		params.Page = 1
+	}
+
+	urls, err := listRecentURLs(ctx, filter, params.orderBy(), params.Limit, (params.Page-1)*params.Limit)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch URLs")
+		return
+	}
+
+	// A full page in created_at order links to the next one by cursor
+	if (params.Sort == "" || params.Sort == "created_at") && len(urls) == params.Limit {
+		last := urls[len(urls)-1]
+		next := listCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		query := c.Request.URL.Query()
+		query.Del("page")
+		query.Set("after", next.String())
+		c.Header("Link", "<"+mountPrefix(c.Request)+c.Request.URL.Path+"?"+query.Encode()+`>; rel="next"`)
+	}
+
+	withHyperlinks(c, urls)
+	jsonWithETag(c, http.StatusOK, urls)
+}
+
+// countListedURLs handles GET /api/urls/count, tallying the links matching the list filters
+// by state
+func countListedURLs(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	var params LinkFilterParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	filter, details := parseLinkFilter(params)
+	if len(details) > 0 {
+		respondValidationError(c, details...)
+		return
+	}
+	filter.Visible = visibilityScope(c)
+
+	counts, err := countLinks(ctx, filter)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to count URLs")
+		return
+	}
+
+	jsonWithETag(c, http.StatusOK, counts)
+}
+
+// homeHandler serves the frontend
+func homeHandler(c *gin.Context) {
+	html := `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="csrf-token" content="{{CSRF_TOKEN}}">
+    <title>Shorty - URL Shortener</title>
+    <style>
+        * { box-sizing: border-box; margin: 0; padding: 0; }
+        body { 
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; 
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); 
+            min-height: 100vh; 
+            display: flex; 
+            align-items: center; 
+            justify-content: center;
+            padding: 20px;
+        }
+        .container { 
+            background: white; 
+            padding: 40px; 
+            border-radius: 16px; 
+            box-shadow: 0 20px 60px rgba(0,0,0,0.3); 
+            max-width: 500px; 
+            width: 100%; 
+        }
+        h1 { color: #333; margin-bottom: 8px; font-size: 2.5em; }
+        .subtitle { color: #666; margin-bottom: 30px; }
+        .input-group { display: flex; gap: 10px; margin-bottom: 20px; flex-wrap: wrap; }
+        input[type="text"] { 
+            flex: 1; 
+            min-width: 200px;
+            padding: 14px 18px; 
+            border: 2px solid #e0e0e0; 
+            border-radius: 8px; 
+            font-size: 16px; 
+            transition: border-color 0.3s; 
+        }
+        input[type="text"]:focus { outline: none; border-color: #667eea; }
+        button { 
+            padding: 14px 28px; 
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); 
+            color: white; 
+            border: none; 
+            border-radius: 8px; 
+            font-size: 16px; 
+            cursor: pointer; 
+            transition: transform 0.2s, box-shadow 0.2s; 
+        }
+        button:hover { transform: translateY(-2px); box-shadow: 0 4px 12px rgba(102,126,234,0.4); }
+        button:disabled { opacity: 0.7; cursor: not-allowed; transform: none; }
+        .captcha { margin-bottom: 20px; }
+        .result { 
+            background: #f0fdf4; 
+            border: 1px solid #86efac;
+            padding: 20px; 
+            border-radius: 8px; 
+            margin-top: 20px; 
+            display: none; 
+        }
+        .result.show { display: block; animation: fadeIn 0.3s ease; }
+        .result.error { background: #fef2f2; border-color: #fca5a5; }
+        .result a { color: #667eea; font-weight: bold; word-break: break-all; font-size: 18px; }
+        .result .original { color: #666; font-size: 14px; margin-top: 8px; word-break: break-all; }
+        .copy-btn { 
+            margin-top: 12px; 
+            padding: 8px 16px; 
+            font-size: 14px; 
+            background: #667eea; 
+        }
+        .stats { margin-top: 30px; padding-top: 20px; border-top: 1px solid #eee; }
+        .stats h3 { color: #333; margin-bottom: 15px; }
+        .api-info { font-size: 14px; color: #666; line-height: 1.8; }
+        .api-info code { background: #f0f0f0; padding: 2px 8px; border-radius: 4px; font-family: monospace; }
+        @keyframes fadeIn { from { opacity: 0; transform: translateY(-10px); } to { opacity: 1; transform: translateY(0); } }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>✂️ Shorty</h1>
+        <p class="subtitle">Fast & simple URL shortener</p>
+        <div class="input-group">
+            <input type="text" id="urlInput" placeholder="Paste your long URL here..." onkeypress="if(event.key==='Enter')shortenURL()" />
+            <button onclick="shortenURL()" id="shortenBtn">Shorten</button>
+        </div>
+        {{CAPTCHA_WIDGET}}
+        <div class="result" id="result"></div>
+        <div class="stats">
+            <h3>📡 API Endpoints</h3>
+            <div class="api-info">
+                <p><code>POST /api/shorten</code> — Create short URL</p>
+                <p><code>GET /api/stats/{code}</code> — Get URL statistics</p>
+                <p><code>GET /api/urls</code> — List all URLs</p>
+                <p><code>GET /{code}</code> — Redirect to original</p>
+            </div>
+        </div>
+    </div>
+    <script>
+        async function shortenURL() {
+            const input = document.getElementById('urlInput');
+            const result = document.getElementById('result');
+            const btn = document.getElementById('shortenBtn');
+            const url = input.value.trim();
+            
+            if (!url) {
+                showResult('Please enter a URL', true);
+                return;
+            }
+            
+            btn.disabled = true;
+            btn.textContent = 'Shortening...';
+            
+            try {
+                const response = await fetch('api/shorten', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-CSRF-Token': document.querySelector('meta[name="csrf-token"]').content,
+                        'X-Captcha-Token': captchaToken()
+                    },
+                    body: JSON.stringify({ url: url })
+                });
+                
+                const data = await response.json();
+                
+                if (response.ok) {
+                    showResult(` + "`" + `
+                        <a href="${data.short_url}" target="_blank">${data.short_url}</a>
+                        <p class="original">Original: ${data.original_url}</p>
+                        <button class="copy-btn" onclick="copyToClipboard('${data.short_url}')">📋 Copy to Clipboard</button>
+                    ` + "`" + `);
+                    input.value = '';
+                } else {
+                    showResult(data.error || 'Something went wrong', true);
+                }
+            } catch (error) {
+                showResult('Failed to connect to server', true);
+            }
+            
+            btn.disabled = false;
+            btn.textContent = 'Shorten';
+            if (window.hcaptcha) hcaptcha.reset();
+            if (window.turnstile) turnstile.reset();
+        }
+        
+        function captchaToken() {
+            const field = document.querySelector('[name="h-captcha-response"], [name="cf-turnstile-response"]');
+            return field ? field.value : '';
+        }
+        
+        function showResult(content, isError = false) {
+            const result = document.getElementById('result');
+            result.innerHTML = content;
+            result.className = 'result show' + (isError ? ' error' : '');
+        }
+        
+        function copyToClipboard(text) {
+            navigator.clipboard.writeText(text).then(() => {
+                const btn = document.querySelector('.copy-btn');
+                btn.textContent = '✓ Copied!';
+                setTimeout(() => btn.textContent = '📋 Copy to Clipboard', 2000);
+            });
+        }
+    </script>
+</body>
+</html>`
+	html = strings.Replace(html, "{{CSRF_TOKEN}}", issueCSRFToken(c), 1)
+	html = strings.Replace(html, "{{CAPTCHA_WIDGET}}", captchaWidget(), 1)
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, html)
+}
@@ -0,0 +1,161 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// dependencyCheck probes one dependency. Critical checks make the service unhealthy when
+// they fail; non-critical ones only degrade it unless HEALTH_STRICT is set.
+type dependencyCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) (map[string]any, error)
+}
+
+// DependencyStatus is the result of a single dependency check
+type DependencyStatus struct {
+	Status    string         `json:"status"`
+	Critical  bool           `json:"critical"`
+	LatencyMs float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// HealthResponse is returned by GET /api/health
+type HealthResponse struct {
+	Status       string                      `json:"status"`
+	Version      string                      `json:"version"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+var (
+	healthChecksMu sync.Mutex
+	healthChecks   []dependencyCheck
+)
+
+// registerHealthCheck adds a dependency to the health report
+func registerHealthCheck(name string, critical bool, check func(ctx context.Context) (map[string]any, error)) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	healthChecks = append(healthChecks, dependencyCheck{Name: name, Critical: critical, Check: check})
+}
+
+func init() {
+	registerHealthCheck("database", true, func(ctx context.Context) (map[string]any, error) {
+		// Ping directly so the health check can observe recovery while the breaker is open
+		if err := db.Ping(ctx); err != nil {
+			return nil, err
+		}
+		stats := db.Stat()
+		return map[string]any{
+			"circuit":          dbBreaker.State(),
+			"open_connections": stats.TotalConns(),
+			"in_use":           stats.AcquiredConns(),
+			"idle":             stats.IdleConns(),
+		}, nil
+	})
+
+	// A database not migrated for this build may lack the columns its queries use
+	registerHealthCheck("schema", false, func(ctx context.Context) (map[string]any, error) {
+		applied, appliedAt, err := appliedSchemaVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]any{"expected": shortSchemaVersion(schemaVersion), "applied": shortSchemaVersion(applied)}
+		if appliedAt != nil {
+			details["applied_at"] = appliedAt.UTC()
+		}
+		if applied != schemaVersion {
+			return details, errors.New("the database schema does not match this build; run shorty migrate")
+		}
+		return details, nil
+	})
+}
+
+// shortSchemaVersion abbreviates a schema version for the health report
+func shortSchemaVersion(version string) string {
+	return version[:min(len(version), 12)]
+}
+
+// runHealthChecks probes every registered dependency concurrently
+func runHealthChecks(ctx context.Context) HealthResponse {
+	healthChecksMu.Lock()
+	checks := append([]dependencyCheck(nil), healthChecks...)
+	healthChecksMu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	response := HealthResponse{
+		Status:       "healthy",
+		Version:      version,
+		Dependencies: make(map[string]DependencyStatus, len(checks)),
+	}
+
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check.Check(checkCtx)
+			status := DependencyStatus{
+				Status:    "up",
+				Critical:  check.Critical,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				Details:   details,
+			}
+			if err != nil {
+				status.Status = "down"
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			response.Dependencies[check.Name] = status
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	for _, dep := range response.Dependencies {
+		if dep.Status == "up" {
+			continue
+		}
+		if dep.Critical || cfg().HealthStrict {
+			response.Status = "unhealthy"
+		} else if response.Status == "healthy" {
+			response.Status = "degraded"
+		}
+	}
+	return response
+}
+
+// healthCheck handles GET /health on the admin listener with the full report
+func healthCheck(c *gin.Context) {
+	response := runHealthChecks(c.Request.Context())
+	c.JSON(healthStatusCode(response), response)
+}
+
+// publicHealthCheck handles GET /api/health on the public port. Load balancers only
+// need the status; the dependencies' details stay on the admin listener.
+func publicHealthCheck(c *gin.Context) {
+	response := runHealthChecks(c.Request.Context())
+	c.JSON(healthStatusCode(response), gin.H{"status": response.Status})
+}
+
+// healthStatusCode is 503 for an unhealthy report and 200 otherwise
+func healthStatusCode(response HealthResponse) int {
+	if response.Status == "unhealthy" {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,245 @@
+package shorty
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// errEditAlias is returned when editing a link that was merged into another
+	errEditAlias = errors.New("link is an alias")
+
+	// errImmutable is returned when changing the destination of an immutable link
+	errImmutable = errors.New("link is immutable")
+)
+
+// UpdateURLRequest is the body of PATCH /api/urls/:code; fields left out are unchanged.
+// Immutable can only be turned on.
+type UpdateURLRequest struct {
+	URL       *string   `json:"url"`
+	Tags      *[]string `json:"tags" binding:"omitempty,max=10,dive,tag"`
+	Immutable *bool     `json:"immutable"`
+
+	// Metadata replaces the link's metadata; {} removes it. It is not versioned.
+	Metadata *map[string]any `json:"metadata"`
+}
+
+// LinkVersion is one recorded state of a link's destination and tags. Version 1 is the
+// link as created; every edit and rollback adds the next version.
+type LinkVersion struct {
+	Version     int       `json:"version"`
+	OriginalURL string    `json:"original_url"`
+	Tags        []string  `json:"tags"`
+	EditedBy    string    `json:"edited_by,omitempty"`
+	RollbackOf  *int      `json:"rollback_of,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// EditResponse is a link after an edit or rollback, with the version it now has
+type EditResponse struct {
+	URL
+	Version int `json:"version"`
+}
+
+// updateURL handles PATCH /api/urls/:code, changing the destination or tags of one of
+// the caller's links and recording the result as a new version
+func updateURL(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	change := LinkVersion{OriginalURL: u.OriginalURL, Tags: u.Tags}
+	if req.URL != nil {
+		change.OriginalURL = strings.TrimSpace(*req.URL)
+		if !strings.Contains(change.OriginalURL, "://") {
+			change.OriginalURL = "https://" + change.OriginalURL
+		}
+		if fe := validateDestination("url", change.OriginalURL); fe != nil {
+			respondValidationError(c, *fe)
+			return
+		}
+		change.OriginalURL = unicodeURL(change.OriginalURL)
+	}
+	if req.Tags != nil {
+		change.Tags = normalizeTags(*req.Tags)
+	}
+	if req.Immutable != nil && !*req.Immutable && isImmutable(u) {
+		respondError(c, http.StatusConflict, "Immutable links cannot be made mutable again")
+		return
+	}
+	if req.Metadata != nil {
+		metadata, fe := parseLinkMetadata(*req.Metadata)
+		if fe != nil {
+			respondValidationError(c, *fe)
+			return
+		}
+		ctx, cancel := dbContext(c)
+		err := setURLMetadata(ctx, u.ShortCode, metadata)
+		cancel()
+		if err != nil {
+			respondStoreError(c, err, "URL not found", "Failed to update URL")
+			return
+		}
+		u.Metadata = metadata
+	}
+	applyLinkVersion(c, u, change, req.Immutable != nil && *req.Immutable)
+}
+
+// isImmutable reports whether a link's destination is fixed, because the link was
+// created immutable or IMMUTABLE_LINKS is set for the whole instance
+func isImmutable(u URL) bool {
+	return u.Immutable || cfg().ImmutableLinks
+}
+
+// linkHistory handles GET /api/urls/:code/history, newest version first
+func linkHistory(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	versions, err := listLinkVersions(ctx, u.ShortCode)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch history")
+		return
+	}
+	// Links that were never edited have no recorded versions yet
+	if len(versions) == 0 {
+		versions = []LinkVersion{initialVersion(u)}
+	}
+	c.JSON(http.StatusOK, gin.H{"short_code": u.ShortCode, "current_version": versions[0].Version, "versions": versions})
+}
+
+// rollbackURL handles POST /api/urls/:code/rollback/:version. The old destination and
+// tags are restored as a new version, so the rollback itself can be undone.
+func rollbackURL(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		respondValidationError(c, FieldError{Field: "version", Rule: "min", Param: "1", Message: "version must be a positive integer"})
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	target, err := getLinkVersion(ctx, u.ShortCode, version)
+	if errors.Is(err, errNotFound) && version == 1 {
+		target, err = initialVersion(u), nil
+	}
+	if err != nil {
+		respondStoreError(c, err, "Version not found", "Failed to fetch version")
+		return
+	}
+
+	change := LinkVersion{OriginalURL: target.OriginalURL, Tags: target.Tags, RollbackOf: &version}
+	applyLinkVersion(c, u, change, false)
+}
+
+// applyLinkVersion stores change as the link's new state, unless nothing would change.
+// With lock the link also becomes immutable.
+func applyLinkVersion(c *gin.Context, u URL, change LinkVersion, lock bool) {
+	if change.OriginalURL != u.OriginalURL && isImmutable(u) {
+		respondError(c, http.StatusConflict, "Link is immutable; its destination cannot be changed")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if change.OriginalURL != u.OriginalURL {
+		var fe *FieldError
+		err := checkBlocklist(ctx, "url", change.OriginalURL)
+		if errors.As(err, &fe) {
+			respondValidationError(c, *fe)
+			return
+		}
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to update URL")
+			return
+		}
+	}
+
+	if change.OriginalURL == u.OriginalURL && slices.Equal(change.Tags, u.Tags) {
+		if lock && !u.Immutable {
+			if err := setURLImmutable(ctx, u.ShortCode); err != nil {
+				respondStoreError(c, err, "URL not found", "Failed to update URL")
+				return
+			}
+			u.Immutable = true
+		}
+		versions, err := listLinkVersions(ctx, u.ShortCode)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to fetch history")
+			return
+		}
+		current := 1
+		if len(versions) > 0 {
+			current = versions[0].Version
+		}
+		c.JSON(http.StatusOK, EditResponse{URL: u, Version: current})
+		return
+	}
+
+	change.EditedBy, _ = apiKeyName(c)
+	version, err := saveLinkVersion(ctx, u.ShortCode, change, lock)
+	switch {
+	case errors.Is(err, errEditAlias):
+		respondError(c, http.StatusConflict, "Link is an alias of "+u.AliasOf+"; edit that link instead")
+		return
+	case errors.Is(err, errImmutable):
+		respondError(c, http.StatusConflict, "Link is immutable; its destination cannot be changed")
+		return
+	case err != nil:
+		respondStoreError(c, err, "URL not found", "Failed to update URL")
+		return
+	}
+
+	// A new destination is checked from scratch
+	if change.OriginalURL != u.OriginalURL {
+		if err := resetLinkCheck(ctx, u.ShortCode); err != nil {
+			respondStoreError(c, err, "URL not found", "Failed to update URL")
+			return
+		}
+		u.UnavailableSince = nil
+	}
+
+	// A new destination is reviewed like a new link, so approval cannot be reused
+	if change.OriginalURL != u.OriginalURL && needsModeration(u.Owner) && u.Moderation != moderationRejected {
+		if err := setURLPending(ctx, u.ShortCode); err != nil {
+			respondStoreError(c, err, "URL not found", "Failed to update URL")
+			return
+		}
+		u.Moderation = moderationPending
+	}
+
+	u.OriginalURL, u.Tags = change.OriginalURL, change.Tags
+	u.Immutable = u.Immutable || lock
+	response := EditResponse{URL: u, Version: version}
+	publishEvent(eventLinkUpdated, response)
+	c.JSON(http.StatusOK, response)
+}
+
+// initialVersion describes a link as it was created, for links never edited
+func initialVersion(u URL) LinkVersion {
+	return LinkVersion{Version: 1, OriginalURL: u.OriginalURL, Tags: u.Tags, EditedBy: u.Owner, CreatedAt: u.CreatedAt}
+}
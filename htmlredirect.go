@@ -0,0 +1,44 @@
+package shorty
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondHTMLRedirect sends visitors of a link with html_redirect on to target with a page
+// instead of a Location header. The page's script adds the fragment of the short URL the
+// visitor opened, which some clients drop when following redirects, unless the
+// destination has a fragment of its own.
+func respondHTMLRedirect(c *gin.Context, target string, uncached bool) {
+	var page bytes.Buffer
+	if err := htmlRedirectPage.Execute(&page, target); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to render redirect page")
+		return
+	}
+	if uncached {
+		c.Header("Cache-Control", "no-store")
+	}
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+var htmlRedirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="robots" content="noindex">
+    <title>Shorty - Redirecting</title>
+    <script>
+        var target = {{.}};
+        if (location.hash && target.indexOf("#") < 0) target += location.hash;
+        location.replace(target);
+    </script>
+    <noscript><meta http-equiv="refresh" content="0; url={{.}}"></noscript>
+</head>
+<body>
+    <p>Redirecting to <a href="{{.}}">{{.}}</a></p>
+</body>
+</html>`))
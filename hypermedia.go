@@ -0,0 +1,90 @@
+package shorty
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hyperlink is a related operation on a link; Method is set for operations other than GET
+type Hyperlink struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// Hyperlinks are the _links of a link in API responses, so clients need not build
+// URLs themselves. Hrefs use the host the request was made to.
+type Hyperlinks struct {
+	Self   *Hyperlink `json:"self,omitempty"`
+	Stats  *Hyperlink `json:"stats,omitempty"`
+	QR     *Hyperlink `json:"qr,omitempty"`
+	Delete *Hyperlink `json:"delete,omitempty"`
+}
+
+// linkHyperlinks returns the _links of a short code. Delete is included only for the
+// caller's own links, and qr only when QR_URL is configured.
+func linkHyperlinks(c *gin.Context, code string, owned bool) *Hyperlinks {
+	base := requestBaseURL(c)
+	escaped := url.PathEscape(code)
+	links := &Hyperlinks{
+		Self:  &Hyperlink{Href: base + "/api/urls/" + escaped},
+		Stats: &Hyperlink{Href: base + "/api/stats/" + escaped},
+	}
+	if qr := cfg().QRURL; qr != "" {
+		links.QR = &Hyperlink{Href: strings.ReplaceAll(qr, "{url}", url.QueryEscape(base+"/"+escaped))}
+	}
+	if owned {
+		links.Delete = &Hyperlink{Href: links.Self.Href, Method: http.MethodDelete}
+	}
+	return links
+}
+
+// withHyperlinks sets the _links of listed links
+func withHyperlinks(c *gin.Context, urls []URL) {
+	caller, _ := apiKeyName(c)
+	for i := range urls {
+		urls[i].Links = linkHyperlinks(c, urls[i].ShortCode, caller != "" && urls[i].Owner == caller)
+	}
+}
+
+// getURLRecord handles GET /api/urls/:code, returning one link as listed by GET /api/urls
+func getURLRecord(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, c.Param("code"))
+	if err == nil && (!visibleTo(c, u.Owner, u.Visibility) || !reachableBy(c, u.Owner, u.AllowedNetworks, u.AllowedCountries, u.BlockedCountries)) {
+		err = errNotFound
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return
+	}
+	caller, _ := apiKeyName(c)
+	u.Links = linkHyperlinks(c, u.ShortCode, caller != "" && u.Owner == caller)
+	jsonWithETag(c, http.StatusOK, u)
+}
+
+// deleteOwnURL handles DELETE /api/urls/:code, removing one of the caller's links
+func deleteOwnURL(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+	if isImmutable(u) {
+		respondError(c, http.StatusConflict, "Immutable links cannot be deleted; disable them instead")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := deleteURL(ctx, u.ShortCode); err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to delete URL")
+		return
+	}
+	publishEvent(eventLinkDeleted, gin.H{"short_code": u.ShortCode})
+	c.Status(http.StatusNoContent)
+}
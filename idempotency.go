@@ -0,0 +1,210 @@
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// idempotencyKeyTTL is how long a stored response is replayed for retries
+	idempotencyKeyTTL = 24 * time.Hour
+
+	// maxIdempotencyKeyLength matches the idempotency_keys.key column
+	maxIdempotencyKeyLength = 255
+)
+
+// bodyCaptureWriter records the response body while still writing it to the client
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware replays the stored response when a request is retried with the
+// same Idempotency-Key header, so client retries never mint a second short URL. Keys are
+// scoped to the caller, so one caller can neither replay nor block another's requests.
+func idempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			respondValidationError(c, FieldError{
+				Field:   "Idempotency-Key",
+				Rule:    "max",
+				Param:   "255",
+				Message: "Idempotency-Key must be at most 255 characters",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(c.Request, body)
+		caller := idempotencyCaller(c)
+
+		// Reserve the key; a status of 0 marks the request as in progress
+		ctx, cancel := dbContext(c)
+		reserved, err := reserveIdempotencyKey(ctx, caller, key, fingerprint)
+		cancel()
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to check Idempotency-Key")
+			return
+		}
+
+		if !reserved {
+			replayIdempotentResponse(c, caller, key, fingerprint)
+			return
+		}
+
+		// A handler that panics leaves no response to store; release the key so the
+		// client can retry instead of being told the request is in progress for a day
+		completed := false
+		defer func() {
+			if !completed {
+				releaseIdempotencyKey(c, caller, key)
+			}
+		}()
+
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		completed = true
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			// Let the client retry failed requests with the same key
+			releaseIdempotencyKey(c, caller, key)
+			return
+		}
+
+		// Record the outcome even if the client has already disconnected
+		ctx, cancel = backgroundDBContext()
+		defer cancel()
+
+		err = dbBreaker.call(func() error {
+			_, err := db.Exec(ctx,
+				"UPDATE idempotency_keys SET status_code = $3, response_body = $4 WHERE caller = $1 AND key = $2",
+				caller, key, status, writer.body.String(),
+			)
+			return err
+		})
+		if err != nil {
+			logRequest(c, "Failed to store idempotent response for key %q: %v", key, err)
+		}
+	}
+}
+
+// idempotencyCaller names the caller an Idempotency-Key belongs to: its API key, or the
+// address it connected from, which forwarding headers only change behind TRUSTED_PROXIES
+func idempotencyCaller(c *gin.Context) string {
+	if name, ok := apiKeyName(c); ok {
+		return "key:" + name
+	}
+	addr, _ := networkClientIP(c)
+	return "ip:" + addr.String()
+}
+
+// releaseIdempotencyKey deletes the caller's reservation of key, so a retry runs the
+// request again
+func releaseIdempotencyKey(c *gin.Context, caller, key string) {
+	ctx, cancel := backgroundDBContext()
+	defer cancel()
+
+	err := dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, "DELETE FROM idempotency_keys WHERE caller = $1 AND key = $2", caller, key)
+		return err
+	})
+	if err != nil {
+		logRequest(c, "Failed to release Idempotency-Key %q: %v", key, err)
+	}
+}
+
+// requestFingerprint identifies a request by method, path and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reserveIdempotencyKey claims the caller's key for this request, clearing it first if it
+// has expired. It reports false when the key is already held by another request.
+func reserveIdempotencyKey(ctx context.Context, caller, key, fingerprint string) (bool, error) {
+	reserved := false
+	err := dbBreaker.call(func() error {
+		_, err := db.Exec(ctx,
+			"DELETE FROM idempotency_keys WHERE caller = $1 AND key = $2 AND created_at < $3",
+			caller, key, time.Now().Add(-idempotencyKeyTTL),
+		)
+		if err != nil {
+			return err
+		}
+
+		result, err := db.Exec(ctx,
+			"INSERT INTO idempotency_keys (caller, key, fingerprint, status_code, response_body, created_at) VALUES ($1, $2, $3, 0, '', NOW()) ON CONFLICT (caller, key) DO NOTHING",
+			caller, key, fingerprint,
+		)
+		if err != nil {
+			return err
+		}
+		reserved = result.RowsAffected() == 1
+		return nil
+	})
+	return reserved, err
+}
+
+// replayIdempotentResponse answers a retried request from the stored response
+func replayIdempotentResponse(c *gin.Context, caller, key, fingerprint string) {
+	var storedFingerprint, responseBody string
+	var status int
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	err := dbBreaker.call(func() error {
+		return db.QueryRow(ctx,
+			"SELECT fingerprint, status_code, response_body FROM idempotency_keys WHERE caller = $1 AND key = $2",
+			caller, key,
+		).Scan(&storedFingerprint, &status, &responseBody)
+	})
+
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		respondError(c, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, "Failed to check Idempotency-Key")
+	case storedFingerprint != fingerprint:
+		respondError(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+	case status == 0:
+		respondError(c, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(status, "application/json; charset=utf-8", []byte(responseBody))
+		c.Abort()
+	}
+}
@@ -0,0 +1,178 @@
+package shorty
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Destinations are stored and listed in their readable form, with internationalized
+// host names in Unicode and UTF-8 in the path, query and fragment unescaped. Redirects
+// and fetches use the ASCII form, with hosts in punycode and everything else
+// percent-encoded, which is what Location headers and HTTP requests must carry.
+
+// unicodeURL returns the readable form of a destination. Escaped ASCII characters stay
+// escaped, since unescaping them could change what the URL means, and so do characters
+// that would not be visible.
+func unicodeURL(raw string) string {
+	scheme, userinfo, host, port, rest, ok := splitURL(raw)
+	if !ok {
+		return raw
+	}
+	if !strings.HasPrefix(host, "[") {
+		if display, err := idna.Display.ToUnicode(host); err == nil {
+			host = display
+		}
+	}
+	return joinURL(scheme, userinfo, host, port, unescapeNonASCII(rest))
+}
+
+// asciiURL returns the form of a destination to redirect to or fetch
+func asciiURL(raw string) string {
+	if isASCII(raw) && !strings.Contains(strings.ToLower(raw), "xn--") {
+		return raw
+	}
+	scheme, userinfo, host, port, rest, ok := splitURL(raw)
+	if !ok {
+		return escapeNonASCII(raw)
+	}
+	if !strings.HasPrefix(host, "[") {
+		if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+			host = ascii
+		}
+	}
+	return joinURL(scheme, escapeNonASCII(userinfo), host, port, escapeNonASCII(rest))
+}
+
+// validHostName reports whether a destination's host is a host name that can be
+// converted to punycode, or an IP address
+func validHostName(host string) bool {
+	if strings.HasPrefix(host, "[") || (isASCII(host) && !strings.Contains(strings.ToLower(host), "xn--")) {
+		return true
+	}
+	_, err := idna.Lookup.ToASCII(host)
+	return err == nil
+}
+
+// splitURL splits an absolute URL into its parts without unescaping anything. userinfo
+// keeps its '@' and port its ':'; rest is everything from the path on.
+func splitURL(raw string) (scheme, userinfo, host, port, rest string, ok bool) {
+	scheme, after, found := strings.Cut(raw, "://")
+	if !found || scheme == "" {
+		return "", "", "", "", "", false
+	}
+	end := strings.IndexAny(after, "/?#")
+	if end < 0 {
+		end = len(after)
+	}
+	authority, rest := after[:end], after[end:]
+
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		userinfo, authority = authority[:at+1], authority[at+1:]
+	}
+	host = authority
+	if colon := strings.LastIndex(authority, ":"); colon >= 0 && !strings.Contains(authority[colon:], "]") {
+		host, port = authority[:colon], authority[colon:]
+	}
+	if host == "" {
+		return "", "", "", "", "", false
+	}
+	return scheme, userinfo, host, port, rest, true
+}
+
+func joinURL(scheme, userinfo, host, port, rest string) string {
+	return scheme + "://" + userinfo + host + port + rest
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeNonASCII percent-encodes every byte of s outside ASCII
+func escapeNonASCII(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= utf8.RuneSelf {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeNonASCII decodes runs of percent-encoded bytes that spell visible non-ASCII
+// characters, leaving every other escape as it is
+func unescapeNonASCII(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		// Collect a run of escaped bytes outside ASCII
+		var run []byte
+		j := i
+		for j+2 < len(s) && s[j] == '%' {
+			c, ok := unhexByte(s[j+1], s[j+2])
+			if !ok || c < utf8.RuneSelf {
+				break
+			}
+			run = append(run, c)
+			j += 3
+		}
+		if len(run) == 0 {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		if visibleUTF8(run) {
+			b.Write(run)
+		} else {
+			b.WriteString(s[i:j])
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// visibleUTF8 reports whether p is valid UTF-8 of printable characters other than spaces
+func visibleUTF8(p []byte) bool {
+	if !utf8.Valid(p) {
+		return false
+	}
+	for _, r := range string(p) {
+		if !unicode.IsGraphic(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func unhexByte(hi, lo byte) (byte, bool) {
+	h, ok1 := unhex(hi)
+	l, ok2 := unhex(lo)
+	return h<<4 | l, ok1 && ok2
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
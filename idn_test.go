@@ -0,0 +1,93 @@
+package shorty
+
+import "testing"
+
+func TestUnicodeURL(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii unchanged", "https://example.com/a/b?q=1#top", "https://example.com/a/b?q=1#top"},
+		{"punycode host", "https://xn--bcher-kva.de/", "https://bücher.de/"},
+		{"unicode host kept", "https://bücher.de/katalog", "https://bücher.de/katalog"},
+		{"mixed case punycode host", "https://XN--BCHER-KVA.de/", "https://bücher.de/"},
+		{"port and userinfo", "https://user:pw@xn--bcher-kva.de:8443/x", "https://user:pw@bücher.de:8443/x"},
+		{"escaped utf-8 path", "https://example.com/%E6%97%A5%E6%9C%AC", "https://example.com/日本"},
+		{"escaped utf-8 query and fragment", "https://example.com/?q=%C3%BCber#%C3%A9t%C3%A9", "https://example.com/?q=über#été"},
+		{"ascii escapes kept", "https://example.com/a%2Fb%20c?x=%26", "https://example.com/a%2Fb%20c?x=%26"},
+		{"mixed escapes", "https://example.com/caf%C3%A9%2F%20menu", "https://example.com/café%2F%20menu"},
+		{"invalid utf-8 kept", "https://example.com/%C3%28", "https://example.com/%C3%28"},
+		{"invisible characters kept", "https://example.com/a%E2%80%AEb%C2%A0", "https://example.com/a%E2%80%AEb%C2%A0"},
+		{"truncated escape", "https://example.com/100%", "https://example.com/100%"},
+		{"ipv6 host", "http://[2001:db8::1]:8080/%C3%A9", "http://[2001:db8::1]:8080/é"},
+		{"not absolute", "example.com/%C3%A9", "example.com/%C3%A9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unicodeURL(tt.in); got != tt.want {
+				t.Errorf("unicodeURL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestASCIIURL(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii unchanged", "https://example.com/a/b?q=1#top", "https://example.com/a/b?q=1#top"},
+		{"unicode host", "https://bücher.de/", "https://xn--bcher-kva.de/"},
+		{"uppercase unicode host", "https://BÜCHER.de", "https://xn--bcher-kva.de"},
+		{"port and userinfo", "https://user@bücher.de:8443/x", "https://user@xn--bcher-kva.de:8443/x"},
+		{"unicode path", "https://example.com/日本", "https://example.com/%E6%97%A5%E6%9C%AC"},
+		{"unicode query and fragment", "https://example.com/?q=über#été", "https://example.com/?q=%C3%BCber#%C3%A9t%C3%A9"},
+		{"ascii escapes kept", "https://bücher.de/a%2Fb%20c", "https://xn--bcher-kva.de/a%2Fb%20c"},
+		{"punycode host normalized", "https://XN--BCHER-KVA.de/", "https://xn--bcher-kva.de/"},
+		{"ipv6 host", "http://[2001:db8::1]/é", "http://[2001:db8::1]/%C3%A9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := asciiURL(tt.in); got != tt.want {
+				t.Errorf("asciiURL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDNRoundTrip(t *testing.T) {
+	for _, raw := range []string{
+		"https://bücher.de/katalog/über-uns?seite=2#kontakt",
+		"https://例え.テスト/パス?q=値",
+		"https://пример.рф/путь",
+		"https://example.com/a%2Fb/caf%C3%A9",
+	} {
+		stored := unicodeURL(raw)
+		if got := unicodeURL(asciiURL(stored)); got != stored {
+			t.Errorf("%q: stored as %q but round trip gives %q", raw, stored, got)
+		}
+		if ascii := asciiURL(stored); !isASCII(ascii) {
+			t.Errorf("%q: asciiURL gives non-ASCII %q", raw, ascii)
+		}
+	}
+}
+
+func TestValidateDestinationHosts(t *testing.T) {
+	for _, raw := range []string{"https://bücher.de/", "https://xn--bcher-kva.de/", "https://пример.рф", "http://[::1]/"} {
+		if fe := validateDestination("url", raw); fe != nil {
+			t.Errorf("%q rejected: %s", raw, fe.Message)
+		}
+	}
+	for _, raw := range []string{"https://xn--a.example/", "https://a‎b.example/"} {
+		if fe := validateDestination("url", raw); fe == nil {
+			t.Errorf("%q accepted", raw)
+		}
+	}
+}
+
+func TestNormalizeDestinationMatchesIDNForms(t *testing.T) {
+	if a, b := normalizeDestination("https://bücher.de/katalog"), normalizeDestination("https://xn--bcher-kva.de/katalog"); a != b {
+		t.Errorf("unicode and punycode hosts normalize differently: %q and %q", a, b)
+	}
+	if a, b := normalizeDestination("https://example.com/日本"), normalizeDestination("https://example.com/%E6%97%A5%E6%9C%AC"); a != b {
+		t.Errorf("unicode and escaped paths normalize differently: %q and %q", a, b)
+	}
+}
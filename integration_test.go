@@ -0,0 +1,381 @@
+//go:build integration
+
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/config"
+)
+
+// The integration tests run the public router against a real Postgres:
+//
+//	go test -tags integration ./...
+//
+// They use TEST_DATABASE_URL when it is set and otherwise start a throwaway
+// postgres:15-alpine container with docker, removed when the tests finish.
+
+// testRouter is the public router the tests send requests to
+var testRouter *gin.Engine
+
+func TestMain(m *testing.M) {
+	url, stop, err := testDatabase()
+	if err != nil {
+		log.Fatalf("Starting test database: %v", err)
+	}
+
+	conf := *cfg()
+	conf.DatabaseURL = url
+	conf.DatabasePassword = ""
+	conf.URLEncryptionKey = ""
+	conf.URLEncryptionOldKeys = nil
+	conf.AdminAddr = "off"
+	config.Set(&conf)
+
+	if err := connectDB(); err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := commandContext()
+	if err := migrateSchema(ctx); err != nil {
+		log.Fatalf("Applying schema: %v", err)
+	}
+	cancel()
+	setupValidator()
+	if testRouter, err = newRouter(); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	db.Close()
+	stop()
+	os.Exit(code)
+}
+
+// testDatabase returns the URL of an empty database and a function that removes it
+func testDatabase() (string, func(), error) {
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		return url, func() {}, nil
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_USER=shorty", "-e", "POSTGRES_PASSWORD=shorty", "-e", "POSTGRES_DB=shorty",
+		"-p", "127.0.0.1::5432", "postgres:15-alpine").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("docker run: %w", err)
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() { exec.Command("docker", "stop", id).Run() }
+
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("docker port: %w", err)
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+
+	// The server restarts once after initializing; wait until it accepts queries
+	for i := 0; i < 60; i++ {
+		if exec.Command("docker", "exec", id, "psql", "-U", "shorty", "-c", "SELECT 1").Run() == nil {
+			return "postgres://shorty:shorty@" + addr + "/shorty?sslmode=disable", stop, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	stop()
+	return "", nil, fmt.Errorf("postgres in container %s did not become ready", id)
+}
+
+// doRequest sends a request to the public router, encoding body as JSON when it is set
+func doRequest(t *testing.T, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (integration test)")
+	req.RemoteAddr = "192.0.2.10:1234"
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody decodes a JSON response into v
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// shorten creates a link and returns the response, failing the test unless status matches
+func shorten(t *testing.T, req ShortenRequest, status int) ShortenResponse {
+	t.Helper()
+	w := doRequest(t, http.MethodPost, "/api/shorten", req)
+	if w.Code != status {
+		t.Fatalf("POST /api/shorten %+v: status %d, want %d: %s", req, w.Code, status, w.Body.String())
+	}
+	var resp ShortenResponse
+	decodeBody(t, w, &resp)
+	return resp
+}
+
+// uniqueName returns a name no other test run has used
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, time.Now().UnixNano())
+}
+
+func TestIntegrationCreateAndRedirect(t *testing.T) {
+	destination := "https://example.com/" + uniqueName("page-")
+	created := shorten(t, ShortenRequest{URL: destination}, http.StatusCreated)
+	if created.ShortCode == "" || created.OriginalURL != destination {
+		t.Fatalf("unexpected response %+v", created)
+	}
+
+	// The same destination is answered with the existing code
+	again := shorten(t, ShortenRequest{URL: destination}, http.StatusOK)
+	if again.ShortCode != created.ShortCode {
+		t.Errorf("second shorten got code %q, want %q", again.ShortCode, created.ShortCode)
+	}
+
+	w := doRequest(t, http.MethodGet, "/"+created.ShortCode, nil)
+	if w.Code < 300 || w.Code > 399 {
+		t.Fatalf("GET /%s: status %d, want a redirect", created.ShortCode, w.Code)
+	}
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, destination) {
+		t.Errorf("Location %q does not lead to %q", location, destination)
+	}
+
+	if w := doRequest(t, http.MethodGet, "/"+uniqueName("missing"), nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown code: status %d, want 404", w.Code)
+	}
+}
+
+func TestIntegrationCreateValidation(t *testing.T) {
+	w := doRequest(t, http.MethodPost, "/api/shorten", map[string]string{"url": "not a url"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid url: status %d, want 400: %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, http.MethodPost, "/api/shorten", ShortenRequest{URL: "https://example.com", Alias: "api"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("reserved alias: status %d, want 400: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIntegrationAliasCollision(t *testing.T) {
+	alias := uniqueName("a")
+	shorten(t, ShortenRequest{URL: "https://example.com/first", Alias: alias}, http.StatusCreated)
+
+	w := doRequest(t, http.MethodPost, "/api/shorten", ShortenRequest{URL: "https://example.com/second", Alias: alias})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("taken alias: status %d, want 409: %s", w.Code, w.Body.String())
+	}
+
+	// The first link keeps its destination
+	w = doRequest(t, http.MethodGet, "/"+alias, nil)
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "https://example.com/first") {
+		t.Errorf("Location %q, want the first destination", location)
+	}
+}
+
+func TestIntegrationStats(t *testing.T) {
+	link := shorten(t, ShortenRequest{URL: "https://example.com/" + uniqueName("stats-")}, http.StatusCreated)
+
+	for i := 0; i < 3; i++ {
+		doRequest(t, http.MethodGet, "/"+link.ShortCode, nil)
+	}
+	clicks.flush()
+
+	w := doRequest(t, http.MethodGet, "/api/stats/"+link.ShortCode, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/stats: status %d: %s", w.Code, w.Body.String())
+	}
+	var stats StatsResponse
+	decodeBody(t, w, &stats)
+	if stats.Clicks != 3 {
+		t.Errorf("clicks = %d, want 3", stats.Clicks)
+	}
+
+	if w := doRequest(t, http.MethodGet, "/api/stats/"+uniqueName("missing"), nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown code: status %d, want 404", w.Code)
+	}
+}
+
+func TestIntegrationPagination(t *testing.T) {
+	tag := uniqueName("page")
+	var codes []string
+	for i := 0; i < 5; i++ {
+		link := shorten(t, ShortenRequest{URL: fmt.Sprintf("https://example.com/%s/%d", tag, i), Tags: []string{tag}}, http.StatusCreated)
+		codes = append(codes, link.ShortCode)
+	}
+
+	// Follow the Link headers through every page, newest first
+	var seen []string
+	path := "/api/urls?limit=2&tag=" + tag
+	for path != "" {
+		w := doRequest(t, http.MethodGet, path, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", path, w.Code, w.Body.String())
+		}
+		var page []URL
+		decodeBody(t, w, &page)
+		for _, u := range page {
+			seen = append(seen, u.ShortCode)
+		}
+
+		path = ""
+		if next, ok := strings.CutPrefix(w.Header().Get("Link"), "<"); ok {
+			path, _, _ = strings.Cut(next, ">")
+		}
+		if len(seen) > len(codes) {
+			t.Fatalf("pagination did not end after %d links", len(seen))
+		}
+	}
+
+	if len(seen) != len(codes) {
+		t.Fatalf("listed %v, want %d links", seen, len(codes))
+	}
+	for i, code := range seen {
+		if want := codes[len(codes)-1-i]; code != want {
+			t.Errorf("link %d is %s, want %s", i, code, want)
+		}
+	}
+
+	// Offset pages agree with the cursor
+	w := doRequest(t, http.MethodGet, "/api/urls?limit=2&page=3&tag="+tag, nil)
+	var last []URL
+	decodeBody(t, w, &last)
+	if len(last) != 1 || last[0].ShortCode != codes[0] {
+		t.Errorf("page 3 is %+v, want only %s", last, codes[0])
+	}
+}
+
+func TestIntegrationBackupRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	target := shorten(t, ShortenRequest{URL: "https://example.com/" + uniqueName("backup-")}, http.StatusCreated).ShortCode
+	alias := uniqueName("b")
+	codes := []string{target, alias}
+	_, err := db.Exec(ctx, `
+		INSERT INTO urls (short_code, original_url, normalized_url, alias_of)
+		SELECT $1, original_url, normalized_url, short_code FROM urls WHERE short_code = $2`,
+		alias, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(ctx, `
+		UPDATE urls SET disabled_at = NOW(), disabled_by = 'admin', flagged_reason = 'phishing', flagged_at = NOW(),
+			moderation = 'rejected', moderated_at = NOW(), expires_at = NOW() + INTERVAL '1 day', immutable = TRUE,
+			redirect_status = 307, visibility = 'private', allowed_networks = '{10.0.0.0/8}',
+			allowed_countries = '{DE}', blocked_countries = '{FR}', metadata = '{"campaign": "spring"}',
+			schedule = '{"timezone": "UTC", "windows": [{"from": "09:00", "to": "17:00"}]}'
+		WHERE short_code = $1`,
+		target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(ctx, "INSERT INTO click_hourly (short_code, hour, clicks) VALUES ($1, date_trunc('hour', NOW()), 5), ($1, date_trunc('hour', NOW()) - INTERVAL '1 hour', 2)", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := func() map[string]backupRecord {
+		t.Helper()
+		found := map[string]backupRecord{}
+		err := eachBackupRecord(ctx, func(r backupRecord) error {
+			if r.ShortCode == target || r.ShortCode == alias {
+				found[r.ShortCode] = r
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+	before := records()
+	if len(before[target].Hourly) != 2 || before[alias].AliasOf != target {
+		t.Fatalf("unexpected records before the backup: %+v", before)
+	}
+
+	var backup bytes.Buffer
+	if _, err := writeBackup(ctx, &backup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM urls WHERE short_code = ANY($1)", codes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM click_hourly WHERE short_code = ANY($1)", codes); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _, err := readBackup(ctx, &backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != int64(len(codes)) {
+		t.Errorf("restored %d links, want %d", restored, len(codes))
+	}
+	if after := records(); !reflect.DeepEqual(after, before) {
+		t.Errorf("restored records differ:\n got %+v\nwant %+v", after, before)
+	}
+
+	var normalized bool
+	if err := db.QueryRow(ctx, "SELECT normalized_url IS NOT NULL FROM urls WHERE short_code = $1", target).Scan(&normalized); err != nil || !normalized {
+		t.Errorf("restored link has no normalized_url (err %v)", err)
+	}
+}
+
+func TestIntegrationImportCSVValidatesAliases(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	existing := uniqueName("imp0")
+	shorten(t, ShortenRequest{URL: "https://example.com/existing", Alias: existing}, http.StatusCreated)
+	valid := uniqueName("imp")
+	csv := "url,short_code\n" +
+		"https://example.com/valid," + valid + "\n" +
+		"https://example.com/reserved,api\n" +
+		"https://example.com/invalid,not valid!\n" +
+		"https://example.com/confusable," + strings.Replace(existing, "0", "o", 1) + "\n"
+
+	imported, skipped, err := importCSV(ctx, strings.NewReader(csv), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 1 || skipped != 3 {
+		t.Errorf("imported %d and skipped %d rows, want 1 and 3", imported, skipped)
+	}
+	if exists, err := codeExists(ctx, valid); err != nil || !exists {
+		t.Errorf("valid alias %q was not imported (err %v)", valid, err)
+	}
+}
+
+func TestIntegrationSchemaIsIdempotent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := migrateSchema(ctx); err != nil {
+		t.Fatalf("applying the schema twice: %v", err)
+	}
+}
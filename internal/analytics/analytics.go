@@ -0,0 +1,66 @@
+// Package analytics holds the click analytics that do not depend on the server: IP
+// anonymization, the monthly click partitions and the time buckets of stats series.
+package analytics
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Bucket sizes of a stats series
+const (
+	Day  = "day"
+	Hour = "hour"
+)
+
+// AnonymizeIP truncates IPv4 addresses to /24 and IPv6 addresses to /48
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// PartitionName returns the partition holding clicks for the month starting at month
+func PartitionName(month time.Time) string {
+	return fmt.Sprintf("click_events_%04d_%02d", month.Year(), int(month.Month()))
+}
+
+// MonthStart returns midnight UTC on the first day of t's month
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// TruncateToBucket returns the start of the day or hour containing t in loc
+func TruncateToBucket(t time.Time, interval string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	hour := 0
+	if interval == Hour {
+		hour = t.Hour()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, loc)
+}
+
+// NextBucket returns the start of the bucket after start. Days are advanced by calendar
+// date so buckets stay aligned to local midnight across DST changes.
+func NextBucket(start time.Time, interval string) time.Time {
+	if interval == Hour {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Buckets lists the start of every bucket from from until to
+func Buckets(from, to time.Time, interval string) []time.Time {
+	var starts []time.Time
+	for t := from; t.Before(to); t = NextBucket(t, interval) {
+		starts = append(starts, t)
+	}
+	return starts
+}
@@ -0,0 +1,67 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.57":        "203.0.113.0/24",
+		"::ffff:203.0.113.57": "203.0.113.0/24",
+		"2001:db8:abcd:12::1": "2001:db8:abcd::/48",
+		"not an address":      "",
+		"":                    "",
+	}
+	for ip, want := range tests {
+		if got := AnonymizeIP(ip); got != want {
+			t.Errorf("AnonymizeIP(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	month := MonthStart(time.Date(2026, 3, 31, 23, 30, 0, 0, time.FixedZone("", -2*3600)))
+	if want := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC); !month.Equal(want) {
+		t.Fatalf("MonthStart = %v, want %v", month, want)
+	}
+	if got := PartitionName(month); got != "click_events_2026_04" {
+		t.Errorf("PartitionName = %q", got)
+	}
+}
+
+func TestBucketsAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+
+	// Clocks move forward on 29 March 2026, so that day has 23 hours
+	from := TruncateToBucket(time.Date(2026, 3, 28, 15, 0, 0, 0, berlin), Day, berlin)
+	to := time.Date(2026, 3, 31, 0, 0, 0, 0, berlin)
+	days := Buckets(from, to, Day)
+	if len(days) != 3 {
+		t.Fatalf("got %d daily buckets, want 3", len(days))
+	}
+	for _, start := range days {
+		if start.Hour() != 0 {
+			t.Errorf("bucket %v does not start at local midnight", start)
+		}
+	}
+
+	hours := Buckets(days[1], days[2], Hour)
+	if len(hours) != 23 {
+		t.Errorf("got %d hourly buckets on the DST day, want 23", len(hours))
+	}
+}
+
+func TestTruncateToBucket(t *testing.T) {
+	at := time.Date(2026, 5, 4, 13, 47, 12, 0, time.UTC)
+	if got := TruncateToBucket(at, Hour, time.UTC); !got.Equal(time.Date(2026, 5, 4, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("hour bucket = %v", got)
+	}
+	tokyo := time.FixedZone("JST", 9*3600)
+	if got := TruncateToBucket(at, Day, tokyo); !got.Equal(time.Date(2026, 5, 3, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("day bucket in JST = %v", got.UTC())
+	}
+}
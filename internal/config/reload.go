@@ -0,0 +1,151 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// reloadableFields lists the Config fields that can change without a restart.
+// Listener, TLS and database settings are fixed for the lifetime of the process, apart
+// from the password of new database connections.
+var reloadableFields = []string{
+	"AccessLogRedact",
+	"CompressionEnabled",
+	"CompressionMinSize",
+	"CompressionTypes",
+	"APIKeys",
+	"CSRFEnabled",
+	"CaptchaProvider",
+	"CaptchaSiteKey",
+	"CaptchaSecret",
+	"AdminToken",
+	"HealthStrict",
+	"DBQueryTimeout",
+	"DBBreakerThreshold",
+	"DBBreakerCooldown",
+	"ClickFlushSize",
+	"CountHeadRequests",
+	"ClickEventsEnabled",
+	"ClickEventsRetentionDays",
+	"ClickHourlyRetentionDays",
+	"AnonymousLinkRetentionDays",
+	"MetadataTTL",
+	"ImmutableLinks",
+	"MaxURLLength",
+	"BlocklistFeeds",
+	"BlocklistAction",
+	"LinkCheckFailures",
+	"ExpandShorteners",
+	"ShortenerDomains",
+	"ShortenerMaxHops",
+	"AuthUserHeader",
+	"AuthGroupsHeader",
+	"AuthLoginURL",
+	"ShareURLSecret",
+	"WebhookSecret",
+	"SMTPPassword",
+	"ScreenshotToken",
+	"DiscordBotToken",
+	"BackupS3AccessKey",
+	"BackupS3SecretKey",
+	"DatabasePassword",
+	"ShareURLMaxTTL",
+	"AccessDeniedPage",
+	"GeoIPCountryHeader",
+	"GeoBlockedPage",
+	"MaxConcurrentRequests",
+	"APIConcurrencyShare",
+	"ShedRetryAfter",
+	"QuotaTiers",
+	"QuotaKeyTiers",
+	"Moderation",
+	"ModerationPending",
+	"ModerationTrustedKeys",
+}
+
+// configFileValues holds the KEY=VALUE pairs read from CONFIG_FILE by Load
+var configFileValues map[string]string
+
+// reloadMu serializes reloads triggered by SIGHUP and the admin API
+var reloadMu sync.Mutex
+
+// lookupEnv returns a setting from CONFIG_FILE, falling back to the environment. Values
+// referencing a secret store are replaced by the secret.
+func lookupEnv(key string) string {
+	value, ok := configFileValues[key]
+	if !ok {
+		value = os.Getenv(key)
+	}
+	return resolveSecret(key, value)
+}
+
+// readConfigFile parses a dotenv-style file of KEY=VALUE lines
+func readConfigFile(path string) map[string]string {
+	values := map[string]string{}
+	if path == "" {
+		return values
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read config file %s: %v", path, err)
+		}
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values
+}
+
+// Reload re-reads the configuration and applies the reloadable fields, returning the
+// names of the settings that changed. The configuration is kept as it is when a secret
+// cannot be loaded.
+func Reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := Get()
+	fresh, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	merged := *old
+
+	oldValue := reflect.ValueOf(old).Elem()
+	freshValue := reflect.ValueOf(fresh).Elem()
+	mergedValue := reflect.ValueOf(&merged).Elem()
+
+	changed := []string{}
+	for _, name := range reloadableFields {
+		if !reflect.DeepEqual(oldValue.FieldByName(name).Interface(), freshValue.FieldByName(name).Interface()) {
+			mergedValue.FieldByName(name).Set(freshValue.FieldByName(name))
+			changed = append(changed, name)
+		}
+	}
+
+	current.Store(&merged)
+	log.Printf("🔄 Configuration reloaded, changed: %v", changed)
+	return changed, nil
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"shorty/internal/awsauth"
+)
+
+// secretFetchTimeout bounds one request to a secret store
+const secretFetchTimeout = 10 * time.Second
+
+// Settings whose value starts with one of these prefixes are read from a secret store
+// instead: "vault:secret/data/shorty#db_password" reads a field of a HashiCorp Vault
+// secret, "aws-sm:shorty/prod#db_password" a field of an AWS Secrets Manager secret and
+// "file:/run/secrets/db_password" a file, such as one mounted by a secrets CSI driver.
+// The field may be left out of secrets holding a single value.
+const (
+	secretPrefixVault = "vault:"
+	secretPrefixAWS   = "aws-sm:"
+	secretPrefixFile  = "file:"
+)
+
+// secrets holds what secret references resolved to. Documents are fetched once per
+// configuration load, so several fields of one secret cost one request; the last good
+// value of each reference is kept for when a store cannot be reached during a reload.
+// failed collects the references of the current load that have no value at all.
+var secrets = struct {
+	sync.Mutex
+	documents map[string]map[string]string
+	last      map[string]string
+	failed    []error
+}{last: map[string]string{}}
+
+// beginSecretLoad forgets the documents fetched by the previous configuration load, so
+// rotated secrets are picked up
+func beginSecretLoad() {
+	secrets.Lock()
+	secrets.documents = map[string]map[string]string{}
+	secrets.failed = nil
+	secrets.Unlock()
+}
+
+// secretLoadError returns the secrets the current configuration load failed to resolve
+func secretLoadError() error {
+	secrets.Lock()
+	defer secrets.Unlock()
+	return errors.Join(secrets.failed...)
+}
+
+// resolveSecret returns the value of setting key, fetching it from a secret store when
+// it is a reference. A store that cannot be reached keeps the value from the previous
+// load; without one, such as at startup, the setting is left empty and the load fails.
+func resolveSecret(key, value string) string {
+	if !isSecretReference(value) {
+		return value
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	secret, err := fetchSecret(value)
+	if err != nil {
+		if last, ok := secrets.last[value]; ok {
+			log.Printf("Failed to refresh secret for %s, keeping the previous value: %v", key, err)
+			return last
+		}
+		secrets.failed = append(secrets.failed, fmt.Errorf("loading secret for %s: %w", key, err))
+		return ""
+	}
+	secrets.last[value] = secret
+	return secret
+}
+
+// isSecretReference reports whether a setting's value names a secret rather than being
+// the value itself
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretPrefixVault) || strings.HasPrefix(value, secretPrefixAWS) || strings.HasPrefix(value, secretPrefixFile)
+}
+
+// SecretsReferenced reports whether any setting was read from a secret store
+func SecretsReferenced() bool {
+	secrets.Lock()
+	defer secrets.Unlock()
+	return len(secrets.last) > 0
+}
+
+// fetchSecret reads the secret a reference names. Callers hold secrets.
+func fetchSecret(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, secretPrefixFile); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	path, field, _ := strings.Cut(ref, "#")
+	doc, ok := secrets.documents[path]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+		defer cancel()
+
+		var err error
+		if name, isVault := strings.CutPrefix(path, secretPrefixVault); isVault {
+			doc, err = fetchVaultSecret(ctx, name)
+		} else {
+			doc, err = fetchAWSSecret(ctx, strings.TrimPrefix(path, secretPrefixAWS))
+		}
+		if err != nil {
+			return "", err
+		}
+		if secrets.documents != nil {
+			secrets.documents[path] = doc
+		}
+	}
+
+	if field == "" {
+		if len(doc) != 1 {
+			return "", fmt.Errorf("%s holds %d values; name one after #", path, len(doc))
+		}
+		for _, value := range doc {
+			return value, nil
+		}
+	}
+	value, ok := doc[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no field %q", path, field)
+	}
+	return value, nil
+}
+
+// fetchVaultSecret reads a secret from VAULT_ADDR with VAULT_TOKEN, or the token in
+// VAULT_TOKEN_FILE as kept fresh by Vault Agent. Both KV version 1 and 2 paths work.
+func fetchVaultSecret(ctx context.Context, path string) (map[string]string, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if file := os.Getenv("VAULT_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := doSecretRequest(req, "vault "+path, &body); err != nil {
+		return nil, err
+	}
+	// KV version 2 nests the values under data.data, next to data.metadata
+	if inner, ok := body.Data["data"].(map[string]any); ok {
+		if _, versioned := body.Data["metadata"]; versioned {
+			body.Data = inner
+		}
+	}
+	return secretStrings(body.Data), nil
+}
+
+// fetchAWSSecret reads the current version of a secret from AWS Secrets Manager with the
+// standard AWS_REGION and AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// variables. A JSON object secret is split into fields; any other secret is one value.
+func fetchAWSSecret(ctx context.Context, id string) (map[string]string, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	creds := awsauth.Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if region == "" || creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, errors.New("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	payload, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://secretsmanager."+region+".amazonaws.com/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	awsauth.SignRequest(req, payload, time.Now().UTC(), creds, region, "secretsmanager")
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := doSecretRequest(req, "aws-sm "+id, &body); err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if json.Unmarshal([]byte(body.SecretString), &fields) == nil {
+		return secretStrings(fields), nil
+	}
+	return map[string]string{"": body.SecretString}, nil
+}
+
+// doSecretRequest sends a request to a secret store and decodes its JSON response
+func doSecretRequest(req *http.Request, name string, v any) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// secretStrings turns the fields of a secret into strings; numbers and other JSON values
+// are kept in their JSON form
+func secretStrings(fields map[string]any) map[string]string {
+	values := make(map[string]string, len(fields))
+	for name, value := range fields {
+		if s, ok := value.(string); ok {
+			values[name] = s
+			continue
+		}
+		encoded, _ := json.Marshal(value)
+		values[name] = string(encoded)
+	}
+	return values
+}
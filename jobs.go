@@ -0,0 +1,52 @@
+package shorty
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// jobTimeout bounds a single run of a scheduled job
+const jobTimeout = 10 * time.Minute
+
+// jobsStopped is closed to end the scheduled jobs
+var jobsStopped = make(chan struct{})
+
+// scheduleJob runs fn now and then every interval in the background until
+// stopScheduledJobs is called. Runs are skipped unless this instance is the jobs leader,
+// so replicas sharing a database run each job once.
+func scheduleJob(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	go func() {
+		for {
+			// Instances waiting to lead check back sooner, so a new leader picks up
+			// the jobs soon after the old one stops
+			wait := interval
+			if isJobsLeader() {
+				runJob(name, fn)
+			} else {
+				wait = min(interval, leaderCheckInterval)
+			}
+			select {
+			case <-time.After(wait):
+			case <-jobsStopped:
+				return
+			}
+		}
+	}()
+}
+
+// stopScheduledJobs ends the scheduled jobs once their current run, if any, finishes
+func stopScheduledJobs() {
+	close(jobsStopped)
+}
+
+// runJob runs a single job invocation with a timeout, logging failures
+func runJob(name string, fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := fn(ctx); err != nil {
+		log.Printf("Job %s failed after %s: %v", name, time.Since(start).Round(time.Millisecond), err)
+	}
+}
@@ -0,0 +1,102 @@
+package shorty
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// jobsLockID is the advisory lock held by the instance that runs the scheduled jobs
+const jobsLockID = schemaLockID + 1
+
+// leaderCheckInterval is how often instances try to take over the scheduled jobs, and
+// how often the leader checks that it still holds the lock
+const leaderCheckInterval = 15 * time.Second
+
+// jobsLeader tracks whether this instance runs the scheduled jobs. Of all instances
+// sharing a database, the one holding jobsLockID does. The lock belongs to a connection
+// taken out of the pool, so it is released when the leader stops or loses the database
+// and another instance takes over within leaderCheckInterval.
+var jobsLeader = struct {
+	sync.Mutex
+	conn    *pgx.Conn
+	leading atomic.Bool
+	stop    chan struct{}
+}{stop: make(chan struct{})}
+
+// isJobsLeader reports whether this instance runs the scheduled jobs
+func isJobsLeader() bool {
+	return jobsLeader.leading.Load()
+}
+
+// startLeaderElection tries to become the jobs leader now and then keeps trying in the
+// background, so the first scheduled runs already know whether to go ahead
+func startLeaderElection() {
+	registerHealthCheck("jobs", false, func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{"leader": isJobsLeader()}, nil
+	})
+
+	electJobsLeader()
+	go func() {
+		ticker := time.NewTicker(leaderCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				electJobsLeader()
+			case <-jobsLeader.stop:
+				return
+			}
+		}
+	}()
+}
+
+// electJobsLeader takes the jobs lock if it is free, or checks that this instance still
+// holds it
+func electJobsLeader() {
+	jobsLeader.Lock()
+	defer jobsLeader.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	if jobsLeader.conn != nil {
+		if err := jobsLeader.conn.Ping(ctx); err == nil {
+			return
+		}
+		log.Println("⚠️  Lost the connection holding the jobs lock; scheduled jobs are paused here")
+		jobsLeader.conn.Close(context.Background())
+		jobsLeader.conn = nil
+		jobsLeader.leading.Store(false)
+	}
+
+	pooled, err := db.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	var acquired bool
+	if err := pooled.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", jobsLockID).Scan(&acquired); err != nil || !acquired {
+		pooled.Release()
+		return
+	}
+	jobsLeader.conn = pooled.Hijack()
+	jobsLeader.leading.Store(true)
+	log.Println("✓ This instance runs the scheduled jobs")
+}
+
+// stopLeaderElection gives up the jobs lock so another instance takes over
+func stopLeaderElection() {
+	close(jobsLeader.stop)
+
+	jobsLeader.Lock()
+	defer jobsLeader.Unlock()
+	jobsLeader.leading.Store(false)
+	if jobsLeader.conn != nil {
+		jobsLeader.conn.Close(context.Background())
+		jobsLeader.conn = nil
+	}
+}
@@ -0,0 +1,229 @@
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// Destination checks. Each run checks the links checked longest ago, a few at a time so
+// that no single site sees a burst of requests.
+const (
+	linkCheckBatch       = 200
+	linkCheckConcurrency = 8
+)
+
+// errUnavailable is returned when resolving a link whose destination failed
+// LINK_CHECK_FAILURES checks in a row
+var errUnavailable = errors.New("destination unavailable")
+
+// linkCheckTarget is a link due for a destination check
+type linkCheckTarget struct {
+	ShortCode       string
+	OriginalURL     string
+	Owner           string
+	Unavailable     bool
+	WaybackFallback bool
+	ArchiveURL      string
+}
+
+// linkCheckResult is the outcome of one destination check
+type linkCheckResult struct {
+	Status int
+	Error  string
+
+	// Missing is set when the destination's host does not resolve
+	Missing bool
+}
+
+// dead reports whether a check found the destination gone or broken. Refusals such as
+// 401, 403 and 429 come from a live site and are not failures.
+func (r linkCheckResult) dead() bool {
+	return r.Error != "" || r.Status == http.StatusNotFound || r.Status == http.StatusGone || r.Status >= 500
+}
+
+// gone reports whether a check found the destination removed, rather than failing
+func (r linkCheckResult) gone() bool {
+	return r.Missing || r.Status == http.StatusNotFound || r.Status == http.StatusGone
+}
+
+// linkHealthNotification is the data passed to the link_health mail template and webhook
+type linkHealthNotification struct {
+	Event       string `json:"event"`
+	ShortCode   string `json:"short_code"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	Failures    int    `json:"failures"`
+	Status      int    `json:"status,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// checkLinkHealth is the LINK_CHECK_INTERVAL job. A link whose destination fails
+// LINK_CHECK_FAILURES checks in a row shows a "temporarily unavailable" page until a
+// check succeeds again; the owner is told of both changes.
+func checkLinkHealth(ctx context.Context) error {
+	targets, err := claimLinksToCheck(ctx, time.Now().Add(-cfg().LinkCheckInterval), linkCheckBatch)
+	if err != nil {
+		return err
+	}
+
+	var group errgroup.Group
+	group.SetLimit(linkCheckConcurrency)
+	for _, target := range targets {
+		target := target
+		group.Go(func() error {
+			return checkLink(ctx, target)
+		})
+	}
+	return group.Wait()
+}
+
+// checkLink checks one destination, records the result and switches the link to or from
+// unavailable
+func checkLink(ctx context.Context, target linkCheckTarget) error {
+	result := checkDestination(ctx, target.OriginalURL)
+	failures, err := saveLinkCheck(ctx, target.ShortCode, result)
+	if err != nil {
+		return err
+	}
+
+	if target.WaybackFallback && target.ArchiveURL == "" && result.gone() && (target.Unavailable || failures >= cfg().LinkCheckFailures) {
+		useWaybackSnapshot(ctx, target)
+	}
+
+	var event string
+	switch {
+	case !target.Unavailable && failures >= cfg().LinkCheckFailures:
+		event = "link.unavailable"
+		log.Printf("⚠️  Link %s is unavailable after %d failed checks of %s", target.ShortCode, failures, target.OriginalURL)
+	case target.Unavailable && failures == 0:
+		event = "link.recovered"
+		log.Printf("✓ Link %s recovered: %s", target.ShortCode, target.OriginalURL)
+	default:
+		return nil
+	}
+	if err := setURLUnavailable(ctx, target.ShortCode, event == "link.unavailable"); err != nil {
+		return err
+	}
+
+	notification := linkHealthNotification{
+		Event:       event,
+		ShortCode:   target.ShortCode,
+		ShortURL:    publicShortURL(target.ShortCode),
+		OriginalURL: target.OriginalURL,
+		Failures:    failures,
+		Status:      result.Status,
+		Error:       result.Error,
+	}
+	if err := notifyLinkHealth(ctx, target.Owner, notification); err != nil {
+		log.Printf("Failed to notify the owner of %s: %v", target.ShortCode, err)
+	}
+	return nil
+}
+
+// checkDestination requests a destination, with HEAD first and GET for sites that do
+// not answer HEAD properly. Destinations on private addresses are never contacted and
+// count as alive.
+func checkDestination(ctx context.Context, destination string) linkCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, destinationClient.Timeout)
+	defer cancel()
+
+	status, err := requestDestination(ctx, http.MethodHead, destination)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || status == http.StatusNotFound || status >= 500) {
+		status, err = requestDestination(ctx, http.MethodGet, destination)
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, errPrivateAddress):
+		return linkCheckResult{}
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return linkCheckResult{Error: err.Error(), Missing: true}
+	case err != nil:
+		return linkCheckResult{Error: err.Error()}
+	}
+	return linkCheckResult{Status: status}
+}
+
+// requestDestination sends one check request and returns the final status
+func requestDestination(ctx context.Context, method, destination string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, asciiURL(destination), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "Shorty/"+version+" (+link check)")
+	resp, err := destinationClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// notifyLinkHealth sends a link health change to the address and webhook of the owner's
+// digest subscription; owners without one and anonymous links are not notified
+func notifyLinkHealth(ctx context.Context, owner string, notification linkHealthNotification) error {
+	if owner == "" {
+		return nil
+	}
+	sub, err := findDigestSubscription(ctx, owner)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if sub.Email != "" {
+		errs = append(errs, sendMail(ctx, []string{sub.Email}, "link_health", notification))
+	}
+	if sub.WebhookURL != "" {
+		errs = append(errs, postWebhook(ctx, sub.WebhookURL, notification.Event, notification))
+	}
+	return errors.Join(errs...)
+}
+
+// respondUnavailablePage tells visitors of a link with a dead destination that it is
+// temporarily unavailable
+func respondUnavailablePage(c *gin.Context) {
+	var page bytes.Buffer
+	if err := unavailablePage.Execute(&page, nil); err != nil {
+		respondError(c, http.StatusServiceUnavailable, "Destination is temporarily unavailable")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Retry-After", fmt.Sprint(int(cfg().LinkCheckInterval.Seconds())))
+	c.Data(http.StatusServiceUnavailable, "text/html; charset=utf-8", page.Bytes())
+	c.Abort()
+}
+
+var unavailablePage = template.Must(template.New("unavailable").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>Shorty - Link unavailable</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 560px; width: 100%; box-sizing: border-box; text-align: center; }
+        h1 { color: #333; margin: 0 0 12px; }
+        p { color: #555; line-height: 1.5; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>This link is temporarily unavailable</h1>
+        <p>The page it leads to is not responding. Please try again later.</p>
+    </div>
+</body>
+</html>`))
@@ -0,0 +1,56 @@
+package shorty
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Limits of the free-form metadata integrators attach to a link
+const (
+	maxMetadataKeys   = 50
+	maxMetadataBytes  = 4096
+	maxMetadataKeyLen = 64
+)
+
+// parseLinkMetadata checks the metadata object of a request. Empty objects store no
+// metadata.
+func parseLinkMetadata(metadata map[string]any) (map[string]any, *FieldError) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	if len(metadata) > maxMetadataKeys {
+		return nil, &FieldError{Field: "metadata", Rule: "max", Param: fmt.Sprint(maxMetadataKeys), Message: fmt.Sprintf("metadata must have at most %d keys", maxMetadataKeys)}
+	}
+	for key := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLen {
+			return nil, &FieldError{Field: "metadata", Rule: "key", Param: key, Message: fmt.Sprintf("metadata keys must be 1 to %d characters", maxMetadataKeyLen)}
+		}
+	}
+	if encoded, err := json.Marshal(metadata); err != nil || len(encoded) > maxMetadataBytes {
+		return nil, &FieldError{Field: "metadata", Rule: "max", Param: fmt.Sprint(maxMetadataBytes), Message: fmt.Sprintf("metadata must be at most %d bytes of JSON", maxMetadataBytes)}
+	}
+	return metadata, nil
+}
+
+// metadataFilter is the metadata filter of a listing: links having Key, with Value when
+// HasValue is set
+type metadataFilter struct {
+	Key      string
+	Value    string
+	HasValue bool
+}
+
+// parseMetadataFilter reads the metadata filter parameter, "key" for links having the
+// key or "key=value" for links where it has that value. Values are compared as text, so
+// "campaign=42" matches both 42 and "42".
+func parseMetadataFilter(v string) (*metadataFilter, *FieldError) {
+	if v == "" {
+		return nil, nil
+	}
+	key, value, hasValue := strings.Cut(v, "=")
+	if key == "" || len(key) > maxMetadataKeyLen {
+		return nil, &FieldError{Field: "metadata", Rule: "key", Message: "metadata must be a key or key=value"}
+	}
+	return &metadataFilter{Key: key, Value: value, HasValue: hasValue}, nil
+}
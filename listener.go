@@ -0,0 +1,67 @@
+package shorty
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation
+const systemdListenFDsStart = 3
+
+// openListener returns the listener for the public server, in order of preference:
+// a socket inherited from systemd, a Unix socket at APP_SOCKET, or TCP on APP_PORT
+func openListener() (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	if cfg().Socket != "" {
+		return unixListener(cfg().Socket, os.FileMode(cfg().SocketMode))
+	}
+	return net.Listen("tcp", ":"+cfg().Port)
+}
+
+// systemdListener returns the first socket passed via LISTEN_FDS, or nil when the
+// process was not socket activated
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// Keep the sockets out of any child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(systemdListenFDsStart, "systemd-socket")
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("using systemd socket: %w", err)
+	}
+	return ln, nil
+}
+
+// unixListener listens on a Unix domain socket, replacing a stale socket file
+func unixListener(path string, mode os.FileMode) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("removing stale socket: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting socket permissions: %w", err)
+	}
+	return ln, nil
+}
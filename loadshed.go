@@ -0,0 +1,109 @@
+package shorty
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dbSaturationInterval is how often the connection pool is sampled for saturation
+const dbSaturationInterval = time.Second
+
+var (
+	// inFlight counts requests currently being served
+	inFlight atomic.Int64
+
+	// dbSaturated is set while every pooled connection is in use and requests queue for one
+	dbSaturated atomic.Bool
+
+	// metricShedRequests counts requests refused by load shedding, by class
+	metricShedRequests = expvar.NewMap("shorty_shed_requests_total")
+)
+
+func init() {
+	registerHealthCheck("load", false, func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{
+			"in_flight":     inFlight.Load(),
+			"limit":         cfg().MaxConcurrentRequests,
+			"db_saturated":  dbSaturated.Load(),
+			"shed_redirect": shedCount("redirect"),
+			"shed_api":      shedCount("api"),
+		}, nil
+	})
+}
+
+// shedCount returns the number of requests of a class shed so far
+func shedCount(class string) int64 {
+	if v, ok := metricShedRequests.Get(class).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// watchDBSaturation samples the connection pool. The pool counts as saturated when all
+// connections are in use and acquires had to wait since the last sample. It returns when
+// the server is closed.
+func watchDBSaturation() {
+	var lastEmpty int64
+	ticker := time.NewTicker(dbSaturationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+		}
+		stats := db.Stat()
+		waited := stats.EmptyAcquireCount() > lastEmpty
+		lastEmpty = stats.EmptyAcquireCount()
+		dbSaturated.Store(waited && stats.AcquiredConns() >= stats.MaxConns())
+	}
+}
+
+// isPriorityRequest reports whether a request is a redirect, which is served as long as
+// possible while API calls are shed
+func isPriorityRequest(c *gin.Context) bool {
+	return c.FullPath() == "/:code" || c.FullPath() == "/:code/"
+}
+
+// loadShedMiddleware refuses requests with 503 and Retry-After instead of letting them
+// queue until they time out. Past MAX_CONCURRENT_REQUESTS everything is refused; API
+// calls are refused earlier, past their API_CONCURRENCY_SHARE of the limit or while the
+// database pool is saturated, so that capacity is kept for redirects.
+func loadShedMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		priority := isPriorityRequest(c)
+		limit := int64(cfg().MaxConcurrentRequests)
+		var shed bool
+		switch {
+		case c.FullPath() == "/api/health":
+			// Load balancers must still see the instance as up
+		case priority:
+			shed = limit > 0 && n > limit
+		default:
+			apiLimit := limit * int64(cfg().APIConcurrencyShare) / 100
+			shed = (limit > 0 && n > max(apiLimit, 1)) || dbSaturated.Load()
+		}
+		if !shed {
+			c.Next()
+			return
+		}
+
+		class := "api"
+		if priority {
+			class = "redirect"
+		}
+		metricShedRequests.Add(class, 1)
+		retryAfter := max(int(cfg().ShedRetryAfter.Seconds()), 1)
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		respondError(c, http.StatusServiceUnavailable, "Server is overloaded; retry later")
+	}
+}
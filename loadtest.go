@@ -0,0 +1,230 @@
+package shorty
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadTestRequestTimeout bounds one request of a load test; slower ones count as errors
+const loadTestRequestTimeout = 10 * time.Second
+
+// loadTest drives a running instance with a mix of link creations and redirects
+type loadTest struct {
+	target  string
+	apiKey  string
+	client  *http.Client
+	results map[string]*loadTestResult
+
+	mu    sync.Mutex
+	codes []string
+	zipf  *rand.Zipf
+	rng   *rand.Rand
+}
+
+// loadTestResult collects the outcome of one kind of request
+type loadTestResult struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// runLoadTestCommand implements "shorty loadtest". Requests are sent at a fixed rate
+// whether or not earlier ones have been answered, so a slow server shows up as growing
+// latency rather than as a lower rate. Redirects favor a few popular links, as real
+// traffic does.
+func runLoadTestCommand(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := fs.String("url", "", "base URL of the instance (default PUBLIC_URL or http://localhost:APP_PORT)")
+	rps := fs.Int("rps", 100, "requests per second")
+	duration := fs.Duration("duration", 30*time.Second, "how long to send requests")
+	createShare := fs.Float64("create", 0.05, "share of requests that create a link, the rest follow redirects")
+	seed := fs.Int("links", 100, "links created before the test for redirects to follow")
+	concurrency := fs.Int("concurrency", 512, "most requests in flight; requests beyond it are skipped")
+	apiKey := fs.String("api-key", "", "API key sent with creations, so quotas of the key's tier apply")
+	fs.Parse(args)
+
+	if *rps <= 0 || *duration <= 0 || *seed <= 0 || *concurrency <= 0 || *createShare < 0 || *createShare > 1 {
+		return errors.New("usage: shorty loadtest [-url base] [-rps n] [-duration d] [-create share] [-links n] [-concurrency n] [-api-key key]")
+	}
+	if *target == "" {
+		*target = publicShortURL("")
+	}
+
+	lt := &loadTest{
+		target: strings.TrimSuffix(*target, "/"),
+		apiKey: *apiKey,
+		client: &http.Client{
+			Timeout:       loadTestRequestTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Transport:     &http.Transport{MaxIdleConnsPerHost: *concurrency},
+		},
+		results: map[string]*loadTestResult{"create": {}, "redirect": {}},
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	log.Printf("Creating %d links on %s", *seed, lt.target)
+	for i := 0; i < *seed; i++ {
+		if _, err := lt.create(); err != nil {
+			return fmt.Errorf("creating links: %w", err)
+		}
+	}
+	lt.results["create"] = &loadTestResult{}
+
+	log.Printf("Sending %d requests per second for %s", *rps, *duration)
+	slots := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	var skipped int
+
+	ticker := time.NewTicker(time.Second / time.Duration(*rps))
+	defer ticker.Stop()
+	start := time.Now()
+	for time.Since(start) < *duration {
+		<-ticker.C
+		select {
+		case slots <- struct{}{}:
+		default:
+			skipped++
+			continue
+		}
+
+		kind := "redirect"
+		lt.mu.Lock()
+		if lt.rng.Float64() < *createShare {
+			kind = "create"
+		}
+		lt.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if kind == "create" {
+				lt.create()
+			} else {
+				lt.redirect()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	lt.report(os.Stdout, elapsed)
+	if skipped > 0 {
+		log.Printf("⚠️  %d requests were skipped with %d in flight; the server is not keeping up with %d per second", skipped, *concurrency, *rps)
+	}
+	return nil
+}
+
+// create shortens a new destination, adding its code to the ones redirects pick from
+func (lt *loadTest) create() (string, error) {
+	lt.mu.Lock()
+	destination := fmt.Sprintf("https://example.com/loadtest/%d/%d", time.Now().UnixNano(), lt.rng.Int63())
+	lt.mu.Unlock()
+
+	body, _ := json.Marshal(ShortenRequest{URL: destination})
+	req, err := http.NewRequest(http.MethodPost, lt.target+"/api/shorten", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if lt.apiKey != "" {
+		req.Header.Set("X-API-Key", lt.apiKey)
+	}
+
+	var created ShortenResponse
+	err = lt.do("create", req, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("POST /api/shorten: %s", resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(&created)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	lt.mu.Lock()
+	lt.codes = append(lt.codes, created.ShortCode)
+	lt.zipf = rand.NewZipf(lt.rng, 1.1, 1, uint64(len(lt.codes)-1))
+	lt.mu.Unlock()
+	return created.ShortCode, nil
+}
+
+// redirect follows a link, picking popular ones more often
+func (lt *loadTest) redirect() error {
+	lt.mu.Lock()
+	code := lt.codes[lt.zipf.Uint64()]
+	lt.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, lt.target+"/"+code, nil)
+	if err != nil {
+		return err
+	}
+	return lt.do("redirect", req, func(resp *http.Response) error {
+		if resp.StatusCode/100 != 3 && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET /%s: %s", code, resp.Status)
+		}
+		return nil
+	})
+}
+
+// do sends req and records its latency under kind, or an error when it fails or check
+// rejects the response
+func (lt *loadTest) do(kind string, req *http.Request, check func(*http.Response) error) error {
+	req.Header.Set("User-Agent", "shorty-loadtest/"+version)
+	start := time.Now()
+	resp, err := lt.client.Do(req)
+	if err == nil {
+		err = check(resp)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	latency := time.Since(start)
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	result := lt.results[kind]
+	if err != nil {
+		result.errors++
+		return err
+	}
+	result.latencies = append(result.latencies, latency)
+	return nil
+}
+
+// report prints the rate, errors and latency percentiles of each kind of request
+func (lt *loadTest) report(out io.Writer, elapsed time.Duration) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REQUEST\tOK\tERRORS\tRPS\tP50\tP90\tP99\tMAX")
+	for _, kind := range []string{"create", "redirect"} {
+		result := lt.results[kind]
+		slices.Sort(result.latencies)
+		total := len(result.latencies) + result.errors
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\n", kind, len(result.latencies), result.errors,
+			float64(total)/elapsed.Seconds(),
+			percentile(result.latencies, 0.50), percentile(result.latencies, 0.90),
+			percentile(result.latencies, 0.99), percentile(result.latencies, 1))
+	}
+	w.Flush()
+}
+
+// percentile returns the latency below which share p of the sorted latencies fall
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return sorted[i].Round(10 * time.Microsecond)
+}
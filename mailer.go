@@ -0,0 +1,239 @@
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// mailTemplateFiles holds one template per message kind. Each file defines "subject",
+// "text" and "html" blocks; the HTML block is rendered with html/template escaping.
+//
+//go:embed templates/mail/*.tmpl
+var mailTemplateFiles embed.FS
+
+// mailTemplate is one parsed message kind
+type mailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// mailTemplates maps a template name, its file name without extension, to its blocks.
+// Each file is parsed on its own since they all define the same block names.
+var mailTemplates = map[string]mailTemplate{}
+
+func init() {
+	files, err := fs.Glob(mailTemplateFiles, "templates/mail/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		mailTemplates[name] = mailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(mailTemplateFiles, file)),
+			html: htmltemplate.Must(htmltemplate.ParseFS(mailTemplateFiles, file)),
+		}
+	}
+}
+
+// errMailDisabled is returned when SMTP_HOST is not configured
+var errMailDisabled = errors.New("mail is not configured")
+
+// mailEnabled reports whether outgoing mail is configured
+func mailEnabled() bool {
+	return cfg().SMTPHost != ""
+}
+
+// sendMail renders the named template (e.g. "digest" for templates/mail/digest.tmpl)
+// with data and delivers it to every recipient
+func sendMail(ctx context.Context, to []string, name string, data any) error {
+	if !mailEnabled() {
+		return errMailDisabled
+	}
+	msg, err := renderMail(to, name, data)
+	if err != nil {
+		return err
+	}
+	return deliverMail(ctx, to, msg)
+}
+
+// queueMail sends mail from a background worker, logging failures
+func queueMail(to []string, name string, data any) bool {
+	return workers.Submit("mail_"+name, func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg().SMTPTimeout)
+		defer cancel()
+		if err := sendMail(ctx, to, name, data); err != nil {
+			logMailError(name, to, err)
+		}
+	})
+}
+
+func logMailError(name string, to []string, err error) {
+	log.Printf("Failed to send %s mail to %s: %v", name, strings.Join(to, ", "), err)
+}
+
+// renderMail builds a multipart/alternative message with text and HTML parts
+func renderMail(to []string, name string, data any) ([]byte, error) {
+	tmpl, ok := mailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&textBody, "text", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&htmlBody, "html", data); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	parts := multipart.NewWriter(&msg)
+	headers := []string{
+		"From: " + cfg().SMTPFrom,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + newMessageID(),
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/alternative; boundary="` + parts.Boundary() + `"`,
+	}
+	msg.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", strings.TrimSpace(textBody.String()) + "\n"},
+		{"text/html; charset=utf-8", strings.TrimSpace(htmlBody.String()) + "\n"},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.body))
+		qp.Close()
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain
+func newMessageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	domain := "shorty.local"
+	if addr, err := mail.ParseAddress(cfg().SMTPFrom); err == nil {
+		if _, d, ok := strings.Cut(addr.Address, "@"); ok {
+			domain = d
+		}
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// deliverMail sends a rendered message over SMTP. SMTP_TLS selects "starttls" (upgrade
+// after connecting, the default), "tls" (implicit TLS, usually port 465) or "none".
+func deliverMail(ctx context.Context, to []string, msg []byte) error {
+	addr := net.JoinHostPort(cfg().SMTPHost, strconv.Itoa(cfg().SMTPPort))
+	tlsConfig := &tls.Config{ServerName: cfg().SMTPHost, MinVersion: tls.VersionTLS12}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if cfg().SMTPTLS == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, cfg().SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if cfg().SMTPTLS == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if cfg().SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg().SMTPUsername, cfg().SMTPPassword, cfg().SMTPHost)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(cfg().SMTPFrom)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s: %w", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// runMailCommand implements "shorty mail test <address>", which sends a test message
+func runMailCommand(args []string) error {
+	if len(args) != 2 || args[0] != "test" {
+		return errors.New("usage: shorty mail test <address>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg().SMTPTimeout)
+	defer cancel()
+
+	if err := sendMail(ctx, []string{args[1]}, "test", struct{ Version string }{version}); err != nil {
+		return err
+	}
+	log.Printf("✓ Test message sent to %s", args[1])
+	return nil
+}
@@ -0,0 +1,158 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// Destination metadata refresh. Failed fetches are stored too and retried after
+// metadataFailureTTL, so a broken site is not fetched on every request.
+const (
+	metadataFailureTTL   = time.Hour
+	metadataRefreshBatch = 100
+)
+
+// metadataGroup coalesces concurrent fetches of the same page or favicon
+var metadataGroup singleflight.Group
+
+// metadataStale reports whether an entry fetched at fetchedAt should be fetched again
+func metadataStale(fetchedAt time.Time, failed bool) bool {
+	ttl := cfg().MetadataTTL
+	if failed {
+		ttl = min(ttl, metadataFailureTTL)
+	}
+	return time.Since(fetchedAt) > ttl
+}
+
+// pageMetadata returns a destination's stored title and Open Graph data. Missing entries
+// are fetched now; stale ones are returned as they are and refreshed in the background.
+func pageMetadata(ctx context.Context, destination string) (LinkPreview, error) {
+	meta, err := loadPageMetadata(ctx, destination)
+	if errors.Is(err, errNotFound) {
+		return refreshPageMetadata(ctx, destination)
+	}
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	if metadataStale(meta.FetchedAt, meta.Error != "") {
+		workers.Submit("refresh_metadata", func(ctx context.Context) {
+			refreshPageMetadata(context.WithoutCancel(ctx), destination)
+		})
+	}
+	return meta, nil
+}
+
+// refreshPageMetadata fetches a destination's metadata and stores it. A fetch that fails
+// is stored with its error, unless ctx itself ran out.
+func refreshPageMetadata(ctx context.Context, destination string) (LinkPreview, error) {
+	v, err, _ := metadataGroup.Do("page:"+destination, func() (any, error) {
+		fetchCtx, cancel := context.WithTimeout(ctx, previewFetchTimeout)
+		defer cancel()
+
+		meta, err := fetchPreview(fetchCtx, destination)
+		if err != nil {
+			if ctx.Err() != nil {
+				return LinkPreview{}, ctx.Err()
+			}
+			meta = LinkPreview{URL: destination, FetchedAt: time.Now().UTC(), Error: err.Error()}
+		}
+		return meta, savePageMetadata(ctx, meta)
+	})
+	return v.(LinkPreview), err
+}
+
+// siteFavicon returns a domain's stored icon, fetching or refreshing it like pageMetadata
+func siteFavicon(ctx context.Context, domain string) (Favicon, error) {
+	icon, err := loadFavicon(ctx, domain)
+	if errors.Is(err, errNotFound) {
+		return refreshFavicon(ctx, domain)
+	}
+	if err != nil {
+		return Favicon{}, err
+	}
+	if metadataStale(icon.FetchedAt, icon.Image == nil) {
+		workers.Submit("refresh_favicon", func(ctx context.Context) {
+			refreshFavicon(context.WithoutCancel(ctx), domain)
+		})
+	}
+	return icon, nil
+}
+
+// refreshFavicon fetches a domain's icon and stores it, recording a failed lookup as an
+// icon without an image
+func refreshFavicon(ctx context.Context, domain string) (Favicon, error) {
+	v, err, _ := metadataGroup.Do("favicon:"+domain, func() (any, error) {
+		fetchCtx, cancel := context.WithTimeout(ctx, faviconFetchTimeout)
+		defer cancel()
+
+		icon, err := fetchFavicon(fetchCtx, domain)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Favicon{}, ctx.Err()
+			}
+			icon = Favicon{FetchedAt: time.Now().UTC()}
+		}
+		icon.Domain = domain
+		return icon, saveFavicon(ctx, icon)
+	})
+	return v.(Favicon), err
+}
+
+// refreshStaleMetadata re-fetches stale metadata of destinations that links still point
+// to, then drops metadata nothing has used for two refresh periods
+func refreshStaleMetadata(ctx context.Context) error {
+	now := time.Now()
+	destinations, err := listStaleMetadata(ctx, now.Add(-cfg().MetadataTTL), now.Add(-metadataFailureTTL), metadataRefreshBatch)
+	if err != nil {
+		return err
+	}
+	for _, destination := range destinations {
+		if destination == "" {
+			continue
+		}
+		if _, err := refreshPageMetadata(ctx, destination); err != nil {
+			return err
+		}
+	}
+
+	deleted, err := deleteUnusedMetadata(ctx, now.Add(-2*cfg().MetadataTTL))
+	if err != nil {
+		return err
+	}
+	if len(destinations) > 0 || deleted > 0 {
+		log.Printf("✓ Refreshed metadata of %d destinations, removed %d unused entries", len(destinations), deleted)
+	}
+	return nil
+}
+
+// refreshLinkMetadata handles POST /api/urls/:code/metadata/refresh, re-fetching the
+// preview and favicon of one of the caller's links now
+func refreshLinkMetadata(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	preview, err := refreshPageMetadata(ctx, u.OriginalURL)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to refresh metadata")
+		return
+	}
+	if target, err := url.Parse(asciiURL(u.OriginalURL)); err == nil {
+		if domain := strings.ToLower(target.Hostname()); domainPattern.MatchString(domain) {
+			if _, err := refreshFavicon(ctx, domain); err != nil {
+				respondStoreError(c, err, "", "Failed to refresh metadata")
+				return
+			}
+		}
+	}
+	respondPreview(c, u.ShortCode, preview)
+}
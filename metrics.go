@@ -0,0 +1,13 @@
+package shorty
+
+import "expvar"
+
+// Application counters, published on the admin listener at /metrics
+var (
+	metricRedirects     = expvar.NewInt("shorty_redirects_total")
+	metricLinksCreated  = expvar.NewInt("shorty_links_created_total")
+	metricNotFound      = expvar.NewInt("shorty_not_found_total")
+	metricGeoBlocked    = expvar.NewInt("shorty_geo_blocked_total")
+	metricEventsFailed  = expvar.NewInt("shorty_events_failed_total")
+	metricEventsDropped = expvar.NewInt("shorty_events_dropped_total")
+)
@@ -0,0 +1,185 @@
+package shorty
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Service modes
+const (
+	modeNormal      = "normal"
+	modeReadOnly    = "read-only"
+	modeMaintenance = "maintenance"
+)
+
+const modeFlagPollInterval = 5 * time.Second
+
+var (
+	// adminMode is the mode set through APP_MODE or the admin API
+	adminMode atomic.Value
+	// flagFileMode is the mode requested by MODE_FLAG_FILE, empty when the file is absent
+	flagFileMode atomic.Value
+)
+
+func init() {
+	mode := cfg().Mode
+	if !validMode(mode) {
+		log.Printf("Unknown APP_MODE %q, using %s", mode, modeNormal)
+		mode = modeNormal
+	}
+	adminMode.Store(mode)
+	flagFileMode.Store("")
+}
+
+// currentMode returns the effective service mode; the flag file overrides the admin API
+func currentMode() string {
+	if mode := flagFileMode.Load().(string); mode != "" {
+		return mode
+	}
+	return adminMode.Load().(string)
+}
+
+// validMode reports whether mode is a known service mode
+func validMode(mode string) bool {
+	return mode == modeNormal || mode == modeReadOnly || mode == modeMaintenance
+}
+
+// watchModeFlagFile polls MODE_FLAG_FILE. An empty file means maintenance; otherwise the
+// file holds the mode name.
+func watchModeFlagFile() {
+	if cfg().ModeFlagFile == "" {
+		return
+	}
+
+	check := func() {
+		mode := ""
+		data, err := os.ReadFile(cfg().ModeFlagFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			log.Printf("Failed to read mode flag file: %v", err)
+			return
+		default:
+			mode = strings.TrimSpace(string(data))
+			if mode == "" {
+				mode = modeMaintenance
+			}
+			if !validMode(mode) {
+				log.Printf("Ignoring unknown mode %q in %s", mode, cfg().ModeFlagFile)
+				return
+			}
+		}
+		if previous := flagFileMode.Swap(mode); previous != mode {
+			log.Printf("Mode flag file now requests %q (effective mode: %s)", mode, currentMode())
+		}
+	}
+
+	check()
+	go func() {
+		ticker := time.NewTicker(modeFlagPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+}
+
+// modeMiddleware rejects mutations in read-only mode and serves the maintenance page
+// for everything except health checks in maintenance mode
+func modeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch currentMode() {
+		case modeMaintenance:
+			if c.Request.URL.Path == "/api/health" {
+				break
+			}
+			c.Header("Retry-After", "300")
+			if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+				respondError(c, http.StatusServiceUnavailable, "Shorty is down for maintenance")
+				return
+			}
+			c.Data(http.StatusServiceUnavailable, "text/html; charset=utf-8", []byte(maintenancePage))
+			c.Abort()
+			return
+
+		case modeReadOnly:
+			// Proceeding past a link's warning page only redirects
+			if !isSafeMethod(c.Request.Method) && c.FullPath() != "/:code" {
+				c.Header("Retry-After", "300")
+				respondError(c, http.StatusServiceUnavailable, "Shorty is in read-only mode")
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// requireWritable rejects requests in read-only mode regardless of method, for GET
+// routes that change data
+func requireWritable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentMode() == modeReadOnly {
+			c.Header("Retry-After", "300")
+			respondError(c, http.StatusServiceUnavailable, "Shorty is in read-only mode")
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminGetMode handles GET /admin/mode
+func adminGetMode(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"mode":           currentMode(),
+		"admin_mode":     adminMode.Load(),
+		"flag_file_mode": flagFileMode.Load(),
+	})
+}
+
+// adminSetMode handles PUT /admin/mode
+func adminSetMode(c *gin.Context) {
+	var req struct {
+		Mode string `json:"mode" binding:"required,oneof=normal read-only maintenance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	adminMode.Store(req.Mode)
+	logRequest(c, "Mode set to %q via admin API (effective mode: %s)", req.Mode, currentMode())
+	adminGetMode(c)
+}
+
+const maintenancePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Shorty - Maintenance</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 500px; text-align: center; }
+        h1 { color: #333; margin-bottom: 12px; }
+        p { color: #666; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🛠 Down for maintenance</h1>
+        <p>Shorty is being upgraded and will be back shortly.</p>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,126 @@
+package shorty
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MODERATION values: which new links wait for approval
+const (
+	moderateOff       = "off"
+	moderateAnonymous = "anonymous"
+	moderateAll       = "all"
+)
+
+// MODERATION_PENDING values: how a pending link answers visitors
+const (
+	pendingHold = "hold"
+	pendingWarn = "warn"
+)
+
+// Moderation states of a link; links created without moderation have none
+const (
+	moderationPending  = "pending"
+	moderationApproved = "approved"
+	moderationRejected = "rejected"
+)
+
+// pendingWarning is the warning page reason of a pending link under MODERATION_PENDING=warn
+const pendingWarning = "it has not been reviewed by a moderator yet"
+
+// errPending is returned when resolving a link that awaits approval under
+// MODERATION_PENDING=hold
+var errPending = errors.New("link is pending review")
+
+// ModerationRequest is the body of POST /admin/moderation/approve and /reject
+type ModerationRequest struct {
+	Codes []string `json:"codes" binding:"required,min=1,max=1000,dive,required,max=32"`
+}
+
+// ModerationReport is the result of approving or rejecting pending links
+type ModerationReport struct {
+	Action    string        `json:"action"`
+	Succeeded int           `json:"succeeded"`
+	Failed    []BulkFailure `json:"failed"`
+}
+
+// needsModeration reports whether a link created by owner, an API key name or "" for
+// anonymous callers, starts pending under MODERATION
+func needsModeration(owner string) bool {
+	switch strings.ToLower(cfg().Moderation) {
+	case moderateAnonymous:
+		return owner == ""
+	case moderateAll:
+		return owner == "" || !slices.Contains(cfg().ModerationTrustedKeys, owner)
+	}
+	return false
+}
+
+// holdPending reports whether pending links answer 403 rather than a warning page
+func holdPending() bool {
+	return !strings.EqualFold(cfg().ModerationPending, pendingWarn)
+}
+
+// adminModerationQueue handles GET /admin/moderation?limit=100, listing pending links
+// oldest first
+func adminModerationQueue(c *gin.Context) {
+	limit := 100
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, 1000)
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	total, links, err := listPendingURLs(ctx, limit)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to list pending links")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pending": total, "links": links})
+}
+
+// adminModerate handles POST /admin/moderation/approve and /reject. Approved links
+// resolve normally; rejected links are disabled. Only pending links are changed.
+func adminModerate(state string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ModerationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondValidationError(c, bindingErrorDetails(err)...)
+			return
+		}
+		codes := slices.Clone(req.Codes)
+		slices.Sort(codes)
+		codes = slices.Compact(codes)
+
+		ctx, cancel := dbContext(c)
+		defer cancel()
+
+		applied, err := moderateURLs(ctx, codes, state)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to update links")
+			return
+		}
+		report := ModerationReport{Action: state, Succeeded: len(applied), Failed: []BulkFailure{}}
+		for _, code := range codes {
+			if !containsString(applied, code) {
+				report.Failed = append(report.Failed, BulkFailure{ShortCode: code, Reason: "not_pending"})
+			}
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// pendingState returns "pending" for a link that waits for approval, for responses to
+// its creator
+func pendingState(link URL) string {
+	if link.Moderation == moderationPending {
+		return moderationPending
+	}
+	return ""
+}
@@ -0,0 +1,331 @@
+package shorty
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsDialTimeout bounds connecting to the NATS server, including the handshake
+const natsDialTimeout = 5 * time.Second
+
+// natsAckTimeout bounds the wait for JetStream to acknowledge a message
+const natsAckTimeout = 5 * time.Second
+
+// natsClient is a minimal NATS client that publishes messages, speaking the text
+// protocol directly. With JetStream each message carries a reply subject and Publish
+// waits for the stream's acknowledgements. A broken connection is redialed by the next
+// Publish.
+type natsClient struct {
+	mu    sync.Mutex
+	conn  net.Conn
+	w     *bufio.Writer
+	inbox string
+	next  uint64
+	acks  map[string]chan []byte
+	err   error
+}
+
+// natsMessage is a message to publish
+type natsMessage struct {
+	Subject string
+	Data    []byte
+}
+
+// natsInfo is the part of the server's INFO message the client needs
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// natsAck is JetStream's answer to a published message
+type natsAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+var nats = &natsClient{}
+
+// Publish sends messages to NATS_URL in one write and returns how many were delivered.
+// With NATS_JETSTREAM set it then waits for all their acknowledgements at once, so a
+// batch costs one round trip. Writes give up at ctx's deadline, so a stalled server
+// cannot hold the connection, and Close, indefinitely.
+func (n *natsClient) Publish(ctx context.Context, messages []natsMessage) (int, error) {
+	n.mu.Lock()
+	if n.conn == nil || n.err != nil {
+		if err := n.dial(ctx); err != nil {
+			n.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsAckTimeout)
+	}
+	n.conn.SetWriteDeadline(deadline)
+
+	var replies []string
+	var acks []chan []byte
+	for _, msg := range messages {
+		if cfg().NATSJetStream {
+			n.next++
+			reply := n.inbox + "." + strconv.FormatUint(n.next, 10)
+			ack := make(chan []byte, 1)
+			n.acks[reply] = ack
+			replies, acks = append(replies, reply), append(acks, ack)
+			fmt.Fprintf(n.w, "PUB %s %s %d\r\n", msg.Subject, reply, len(msg.Data))
+		} else {
+			fmt.Fprintf(n.w, "PUB %s %d\r\n", msg.Subject, len(msg.Data))
+		}
+		n.w.Write(msg.Data)
+		n.w.WriteString("\r\n")
+	}
+	if err := n.w.Flush(); err != nil {
+		for _, reply := range replies {
+			delete(n.acks, reply)
+		}
+		n.fail(err)
+		n.mu.Unlock()
+		return 0, fmt.Errorf("nats: %w", err)
+	}
+	n.mu.Unlock()
+
+	if acks == nil {
+		return len(messages), nil
+	}
+	defer func() {
+		n.mu.Lock()
+		for _, reply := range replies {
+			delete(n.acks, reply)
+		}
+		n.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(natsAckTimeout)
+	defer timer.Stop()
+	delivered := 0
+	var first error
+	for i, ack := range acks {
+		select {
+		case data := <-ack:
+			var answer natsAck
+			var err error
+			switch {
+			case json.Unmarshal(data, &answer) != nil:
+				err = fmt.Errorf("nats: unexpected acknowledgement %q", data)
+			case answer.Error != nil:
+				err = fmt.Errorf("nats: jetstream: %s (%d)", answer.Error.Description, answer.Error.Code)
+			default:
+				delivered++
+			}
+			if first == nil {
+				first = err
+			}
+		case <-timer.C:
+			return delivered, fmt.Errorf("nats: no acknowledgement for %s; is a JetStream stream capturing it?", messages[i].Subject)
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		}
+	}
+	return delivered, first
+}
+
+// dial connects to NATS_URL, upgrading to TLS for tls:// URLs or when the server requires
+// it, and authenticates with the URL's user and password, or its user as a token.
+// Callers hold n.mu.
+func (n *natsClient) dial(ctx context.Context) error {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+
+	u, err := url.Parse(cfg().NATSURL)
+	if err != nil || u.Host == "" {
+		return errors.New("nats: invalid NATS_URL")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, natsDialTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("nats: reading INFO: %w", err)
+	}
+	var info natsInfo
+	if payload, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO "); ok {
+		json.Unmarshal([]byte(payload), &info)
+	} else {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	useTLS := u.Scheme == "tls" || u.Scheme == "nats+tls"
+	if useTLS || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("nats: %w", err)
+		}
+		conn, r = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	options := map[string]any{
+		"verbose":      false,
+		"pedantic":     false,
+		"tls_required": useTLS || info.TLSRequired,
+		"name":         "shorty",
+		"lang":         "go",
+		"version":      version,
+		"protocol":     1,
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options["user"], options["pass"] = u.User.Username(), password
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\nPING\r\n", connect)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return fmt.Errorf("nats: %w", err)
+	}
+
+	// The server answers the PING once it has accepted CONNECT, or reports why not
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("nats: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if msg, ok := strings.CutPrefix(line, "-ERR "); ok {
+			conn.Close()
+			return fmt.Errorf("nats: %s", strings.Trim(msg, "'"))
+		}
+	}
+	// Reads wait for the server from now on; writes keep a deadline, set by Publish
+	conn.SetReadDeadline(time.Time{})
+
+	n.conn, n.w, n.err = conn, w, nil
+	n.acks = map[string]chan []byte{}
+	if cfg().NATSJetStream {
+		suffix := make([]byte, 8)
+		rand.Read(suffix)
+		n.inbox = "_INBOX." + hex.EncodeToString(suffix)
+		fmt.Fprintf(w, "SUB %s.* 1\r\n", n.inbox)
+		if err := w.Flush(); err != nil {
+			n.fail(err)
+			return err
+		}
+	}
+	go n.read(conn, r)
+	return nil
+}
+
+// read answers the server's PINGs and hands JetStream acknowledgements to the publishers
+// waiting for them, until the connection fails
+func (n *natsClient) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			n.mu.Lock()
+			if n.conn == conn {
+				n.fail(err)
+			}
+			n.mu.Unlock()
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			n.mu.Lock()
+			if n.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(natsDialTimeout))
+				n.w.WriteString("PONG\r\n")
+				n.w.Flush()
+			}
+			n.mu.Unlock()
+
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <size>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				continue
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				continue
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				continue
+			}
+			n.mu.Lock()
+			if ack, ok := n.acks[fields[1]]; ok {
+				ack <- payload[:size]
+			}
+			n.mu.Unlock()
+
+		case strings.HasPrefix(line, "-ERR "):
+			n.mu.Lock()
+			if n.conn == conn {
+				n.fail(errors.New("nats: " + strings.Trim(strings.TrimPrefix(line, "-ERR "), "'")))
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// Close closes the connection, if any
+func (n *natsClient) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+// fail records a connection error, so the next Publish reconnects. Callers hold n.mu.
+func (n *natsClient) fail(err error) {
+	n.err = err
+	if n.conn != nil {
+		n.conn.Close()
+	}
+}
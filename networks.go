@@ -0,0 +1,110 @@
+package shorty
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseAllowedNetworks turns the allowed_networks of a request into prefixes. Single
+// addresses allow just that address.
+func parseAllowedNetworks(values []string) ([]netip.Prefix, *FieldError) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, &FieldError{Field: "allowed_networks", Rule: "cidr", Param: value, Message: "allowed_networks must be IP addresses or CIDR ranges such as 10.0.0.0/8"}
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// networkClientIP is the address a request comes from. Forwarding headers are only
+// believed from TRUSTED_PROXIES, since anyone can send them.
+func networkClientIP(c *gin.Context) (netip.Addr, bool) {
+	ip := c.RemoteIP()
+	if len(cfg().TrustedProxies) > 0 {
+		ip = c.ClientIP()
+	}
+	addr, err := netip.ParseAddr(ip)
+	return addr.Unmap(), err == nil
+}
+
+// allowNetwork answers requests for a link from outside its allowed networks with 403
+// and the ACCESS_DENIED_PAGE. It reports whether the request may continue.
+func allowNetwork(c *gin.Context, link resolvedLink) bool {
+	if networkPermitted(c, link.AllowedNetworks) {
+		return true
+	}
+
+	page := networkDeniedPage()
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusForbidden, "text/html; charset=utf-8", page)
+	c.Abort()
+	return false
+}
+
+// networkPermitted reports whether the request comes from one of networks, or networks
+// is empty
+func networkPermitted(c *gin.Context, networks []netip.Prefix) bool {
+	if len(networks) == 0 {
+		return true
+	}
+	if addr, ok := networkClientIP(c); ok {
+		for _, prefix := range networks {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// networkDeniedPage returns ACCESS_DENIED_PAGE, or the built-in page when it is not set
+// or cannot be read
+func networkDeniedPage() []byte {
+	if path := cfg().AccessDeniedPage; path != "" {
+		page, err := os.ReadFile(path)
+		if err == nil {
+			return page
+		}
+		log.Printf("Failed to read ACCESS_DENIED_PAGE: %v", err)
+	}
+	var page bytes.Buffer
+	deniedPage.Execute(&page, nil)
+	return page.Bytes()
+}
+
+var deniedPage = template.Must(template.New("denied").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>Shorty - Access restricted</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 560px; width: 100%; box-sizing: border-box; text-align: center; }
+        h1 { color: #333; margin: 0 0 12px; }
+        p { color: #555; line-height: 1.5; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🚫 Access restricted</h1>
+        <p>This link can only be followed from certain networks, such as a company VPN. Connect to one and try again.</p>
+    </div>
+</body>
+</html>`))
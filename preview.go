@@ -0,0 +1,136 @@
+package shorty
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// previewFetchTimeout bounds fetching one destination's metadata
+const previewFetchTimeout = 5 * time.Second
+
+var (
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// LinkPreview is the share-card metadata of a link's destination
+type LinkPreview struct {
+	ShortCode   string    `json:"short_code"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+
+	// Warning is set when the link's destination is flagged as suspicious
+	Warning string `json:"warning,omitempty"`
+
+	// Error records why the last fetch failed
+	Error string `json:"-"`
+}
+
+// getPreview handles GET /api/preview/:code, returning the Open Graph title, description
+// and image of the link's destination without counting a click
+func getPreview(c *gin.Context) {
+	code := c.Param("code")
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	link, err := lookupDestination(ctx, code)
+	if err == nil && (!visibleTo(c, link.Owner, link.Visibility) || !reachableBy(c, link.Owner, link.AllowedNetworks, link.AllowedCountries, link.BlockedCountries)) {
+		err = errNotFound
+	}
+	if err != nil {
+		respondStoreError(c, err, "Short URL not found", "Failed to fetch preview")
+		return
+	}
+
+	preview, err := pageMetadata(c.Request.Context(), link.Destination)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch preview")
+		return
+	}
+	preview.Warning = link.Warning
+	respondPreview(c, code, preview)
+}
+
+// respondPreview answers with a link's stored preview, or 502 if its destination could
+// not be fetched
+func respondPreview(c *gin.Context, code string, preview LinkPreview) {
+	if preview.Error != "" {
+		logRequest(c, "Preview fetch for %s failed: %s", code, preview.Error)
+		respondError(c, http.StatusBadGateway, "Failed to fetch destination")
+		return
+	}
+	preview.ShortCode = code
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, preview)
+}
+
+// fetchPreview reads a destination's Open Graph metadata, falling back to Twitter card
+// tags, the page title and the description meta tag. Non-HTML destinations get an empty
+// preview.
+func fetchPreview(ctx context.Context, destination string) (LinkPreview, error) {
+	preview := LinkPreview{URL: destination, FetchedAt: time.Now().UTC()}
+
+	resp, body, err := fetchDestination(ctx, destination)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	if !isHTML(resp) {
+		return preview, nil
+	}
+
+	meta := map[string]string{}
+	for _, tag := range metaTagPattern.FindAll(body, -1) {
+		attrs := map[string]string{}
+		for _, m := range attributePattern.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(m[1]))] = string(m[2]) + string(m[3])
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = strings.Join(strings.Fields(html.UnescapeString(attrs["content"])), " ")
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if v := meta[key]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	preview.Title = first("og:title", "twitter:title")
+	if preview.Title == "" {
+		if m := titlePattern.FindSubmatch(body); m != nil {
+			preview.Title = strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+		}
+	}
+	preview.Description = first("og:description", "twitter:description", "description")
+	preview.SiteName = first("og:site_name")
+
+	// Image URLs may be relative to the page they were found on, after redirects
+	if image := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image"); image != "" {
+		if ref, err := url.Parse(image); err == nil {
+			resolved := resp.Request.URL.ResolveReference(ref)
+			if resolved.Scheme == "http" || resolved.Scheme == "https" {
+				preview.Image = resolved.String()
+			}
+		}
+	}
+	return preview, nil
+}
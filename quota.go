@@ -0,0 +1,148 @@
+package shorty
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/config"
+)
+
+// Quota tiers with a fixed meaning: anonymous applies per client IP to callers without an
+// API key, default to API keys not assigned a tier in QUOTA_KEY_TIERS
+const (
+	quotaTierAnonymous = "anonymous"
+	quotaTierDefault   = "default"
+)
+
+// QuotaUsage is a caller's link creations in one quota period
+type QuotaUsage struct {
+	Period   string    `json:"period"`
+	Limit    int64     `json:"limit"`
+	Used     int64     `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// quotaPeriods are the current UTC day and month and when each ends
+type quotaPeriods struct {
+	Day, DayEnd     time.Time
+	Month, MonthEnd time.Time
+}
+
+// currentQuotaPeriods returns the quota periods containing now
+func currentQuotaPeriods(now time.Time) quotaPeriods {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return quotaPeriods{Day: day, DayEnd: day.AddDate(0, 0, 1), Month: month, MonthEnd: month.AddDate(0, 1, 0)}
+}
+
+// quotaSubject identifies the caller whose quota a request draws on: its API key, or its
+// IP address with IPv6 clients grouped by /64. Forwarding headers only count from
+// TRUSTED_PROXIES, so anonymous callers cannot pick a fresh address per request. It
+// reports false when the caller's tier is not configured.
+func quotaSubject(c *gin.Context) (string, string, config.QuotaTier, bool) {
+	var subject, tierName string
+	if name, ok := apiKeyName(c); ok {
+		subject, tierName = "key:"+name, cfg().QuotaKeyTiers[name]
+		if tierName == "" {
+			tierName = quotaTierDefault
+		}
+	} else {
+		addr, _ := networkClientIP(c)
+		subject, tierName = "ip:"+quotaIP(addr.String()), quotaTierAnonymous
+	}
+	tier, ok := cfg().QuotaTiers[tierName]
+	return subject, tierName, tier, ok
+}
+
+// quotaIP groups IPv6 addresses by /64, the smallest block usually assigned to one site
+func quotaIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// quotaMiddleware enforces QUOTA_TIERS on routes that create links. A creation is
+// counted up front so concurrent requests cannot overshoot, and handed back when the
+// request does not create a link.
+func quotaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject, _, tier, ok := quotaSubject(c)
+		if !ok || (tier.Daily == 0 && tier.Monthly == 0) {
+			c.Next()
+			return
+		}
+
+		periods := currentQuotaPeriods(time.Now())
+		ctx, cancel := dbContext(c)
+		day, month, err := reserveQuota(ctx, subject, periods)
+		cancel()
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to check creation quota")
+			return
+		}
+
+		var exceeded *QuotaUsage
+		switch {
+		case tier.Daily > 0 && day > tier.Daily:
+			exceeded = &QuotaUsage{Period: "day", Limit: tier.Daily, Used: day - 1, ResetsAt: periods.DayEnd}
+		case tier.Monthly > 0 && month > tier.Monthly:
+			exceeded = &QuotaUsage{Period: "month", Limit: tier.Monthly, Used: month - 1, ResetsAt: periods.MonthEnd}
+		}
+		if exceeded == nil {
+			c.Next()
+			if c.Writer.Status() == http.StatusCreated {
+				return
+			}
+		}
+
+		// Nothing was created; the reservation is returned even if the client is gone
+		releaseCtx, releaseCancel := backgroundDBContext()
+		defer releaseCancel()
+		if err := releaseQuota(releaseCtx, subject, periods); err != nil {
+			logRequest(c, "Failed to release creation quota of %s: %v", subject, err)
+		}
+		if exceeded != nil {
+			respondQuotaExceeded(c, *exceeded)
+		}
+	}
+}
+
+// respondQuotaExceeded answers 429 with the exhausted quota and when it resets
+func respondQuotaExceeded(c *gin.Context, usage QuotaUsage) {
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(usage.ResetsAt).Seconds())+1))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Link creation quota exceeded; try again after " + usage.ResetsAt.Format(time.RFC3339),
+		"request_id": requestID(c),
+		"quota":      usage,
+	})
+}
+
+// getQuota handles GET /api/account/quota, reporting the caller's creation quota
+func getQuota(c *gin.Context) {
+	subject, tierName, tier, ok := quotaSubject(c)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"tier": tierName, "quotas": []QuotaUsage{}})
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	periods := currentQuotaPeriods(time.Now())
+	day, month, err := quotaUsage(ctx, subject, periods)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch creation quota")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tier": tierName, "quotas": []QuotaUsage{
+		{Period: "day", Limit: tier.Daily, Used: day, ResetsAt: periods.DayEnd},
+		{Period: "month", Limit: tier.Monthly, Used: month, ResetsAt: periods.MonthEnd},
+	}})
+}
@@ -0,0 +1,64 @@
+package shorty
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/config"
+)
+
+// watchReloadSignal reloads the configuration whenever the process receives SIGHUP
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reloadConfig()
+		}
+	}()
+}
+
+// adminReloadConfig handles POST /admin/reload
+func adminReloadConfig(c *gin.Context) {
+	changed, err := config.Reload()
+	if err != nil {
+		log.Printf("⚠️  Configuration not reloaded: %v", err)
+		respondError(c, http.StatusBadGateway, "Failed to load a secret; the configuration was not reloaded")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changed": changed})
+}
+
+// reloadConfig reloads the configuration, keeping the current one when it fails
+func reloadConfig() {
+	if _, err := config.Reload(); err != nil {
+		log.Printf("⚠️  Configuration not reloaded: %v", err)
+	}
+}
+
+// watchSecretRotation reloads the configuration every SECRETS_REFRESH_INTERVAL while any
+// setting comes from a secret store, so rotated secrets take effect without a restart
+func watchSecretRotation() {
+	interval := cfg().SecretsRefreshInterval
+	if interval <= 0 || !config.SecretsReferenced() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				reloadConfig()
+			}
+		}
+	}()
+}
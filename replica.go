@@ -0,0 +1,115 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaCheckInterval is how often an unavailable replica is probed for recovery
+const replicaCheckInterval = 5 * time.Second
+
+// querier is the subset of *pgxpool.Pool used by the store, satisfied by the primary and replica pools
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+var (
+	replicaDB      *pgxpool.Pool
+	replicaHealthy atomic.Bool
+
+	// replicaStop ends monitorReplica when the replica pool is closed
+	replicaStop chan struct{}
+
+	replicaCheckOnce sync.Once
+)
+
+// connectReplica opens the optional read-only pool from DATABASE_REPLICA_URL. Unlike the
+// primary, an unreachable replica never blocks startup; reads use the primary until it recovers.
+func connectReplica() error {
+	if cfg().DatabaseReplicaURL == "" {
+		return nil
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(cfg().DatabaseReplicaURL)
+	if err != nil {
+		return fmt.Errorf("invalid DATABASE_REPLICA_URL: %w", err)
+	}
+	configurePool(poolConfig)
+
+	replicaDB, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return fmt.Errorf("creating replica pool: %w", err)
+	}
+
+	// A failed start may be retried; the check is only added once
+	replicaCheckOnce.Do(func() {
+		registerHealthCheck("database_replica", false, func(ctx context.Context) (map[string]any, error) {
+			return map[string]any{"serving_reads": replicaHealthy.Load()}, replicaDB.Ping(ctx)
+		})
+	})
+
+	replicaStop = make(chan struct{})
+	go monitorReplica(replicaStop)
+	return nil
+}
+
+// closeReplica stops monitoring the replica and closes its pool, routing every read to
+// the primary
+func closeReplica() {
+	if replicaDB == nil {
+		return
+	}
+	close(replicaStop)
+	replicaHealthy.Store(false)
+	replicaDB.Close()
+}
+
+// monitorReplica keeps replicaHealthy up to date with periodic pings until stop is closed
+func monitorReplica(stop <-chan struct{}) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err := replicaDB.Ping(ctx)
+		cancel()
+
+		if healthy := err == nil; replicaHealthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Println("✓ Read replica available, routing reads to it")
+			} else {
+				log.Printf("Read replica unavailable, routing reads to primary: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(replicaCheckInterval):
+		}
+	}
+}
+
+// readQuery runs a read on the replica when one is healthy, falling back to the primary
+// if the replica fails. A missing row is also re-checked on the primary because the
+// replica may lag behind a link that was just created.
+func readQuery(fn func(q querier) error) error {
+	if replicaDB != nil && replicaHealthy.Load() {
+		err := fn(replicaDB)
+		if !isDatabaseFailure(err) && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		if isDatabaseFailure(err) {
+			replicaHealthy.Store(false)
+			log.Printf("Read replica query failed, routing reads to primary: %v", err)
+		}
+	}
+	return dbBreaker.call(func() error { return fn(db) })
+}
@@ -0,0 +1,84 @@
+package shorty
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader     = "X-Request-ID"
+	requestIDContextKey = "request_id"
+	maxRequestIDLength  = 128
+)
+
+type requestIDKey struct{}
+
+// requestIDMiddleware assigns every request an ID, reusing a well-formed incoming
+// X-Request-ID so a request can be traced across services
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// newRequestID returns a random 32 character hex ID
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// validRequestID accepts short IDs made of URL-safe characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID assigned to the current request
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// requestIDFromContext returns the request ID carried by ctx, if any
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID copies the request ID from ctx onto an outgoing HTTP request
+func withRequestID(ctx context.Context, req *http.Request) *http.Request {
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	return req
+}
+
+// logRequest logs a message prefixed with the request ID
+func logRequest(c *gin.Context, format string, args ...any) {
+	log.Printf("[%s] "+format, append([]any{requestID(c)}, args...)...)
+}
+
+// respondError aborts the request with a JSON error that includes the request ID
+func respondError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": message, "request_id": requestID(c)})
+}
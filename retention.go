@@ -0,0 +1,144 @@
+package shorty
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// pruneBatchSize bounds how many rows a single pruning statement deletes, keeping locks short
+const pruneBatchSize = 5000
+
+// metricPrunedRows counts rows removed by each retention policy
+var metricPrunedRows = expvar.NewMap("shorty_retention_pruned_rows_total")
+
+// retentionPolicy removes expired data of one kind, returning the number of rows removed
+type retentionPolicy struct {
+	name  string
+	prune func(ctx context.Context, now time.Time) (int64, error)
+}
+
+// retentionPolicies are applied in order on every RETENTION_INTERVAL. Click counts on urls
+// are aggregates and are never pruned.
+var retentionPolicies = []retentionPolicy{
+	{name: "click_events", prune: pruneClickEvents},
+	{name: "click_hourly", prune: pruneClickHourly},
+	{name: "anonymous_links", prune: pruneAnonymousLinks},
+	{name: "idempotency_keys", prune: pruneIdempotencyKeys},
+	{name: "quota_usage", prune: pruneQuotaUsage},
+}
+
+// enforceRetention runs every retention policy, continuing past individual failures
+func enforceRetention(ctx context.Context) error {
+	var failed []string
+	for _, policy := range retentionPolicies {
+		removed, err := policy.prune(ctx, time.Now())
+		if removed > 0 {
+			metricPrunedRows.Add(policy.name, removed)
+			log.Printf("🗑  Retention %s removed %d rows", policy.name, removed)
+		}
+		if err != nil {
+			log.Printf("Retention %s failed: %v", policy.name, err)
+			failed = append(failed, policy.name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("retention policies failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// pruneClickEvents drops monthly partitions entirely older than CLICK_EVENTS_RETENTION_DAYS
+// and deletes the expired rows left in the oldest remaining partition
+func pruneClickEvents(ctx context.Context, now time.Time) (int64, error) {
+	days := cfg().ClickEventsRetentionDays
+	if days <= 0 {
+		return 0, nil
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days)
+
+	partitions, err := listClickPartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, name := range partitions {
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, "click_events_"))
+		if err != nil || month.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+		count, err := countClickEvents(ctx, name)
+		if err != nil {
+			return removed, err
+		}
+		if err := dropClickPartition(ctx, name); err != nil {
+			return removed, fmt.Errorf("dropping partition %s: %w", name, err)
+		}
+		removed += count
+	}
+
+	n, err := pruneInBatches(ctx, func(ctx context.Context) (int64, error) {
+		return deleteClickEventsBefore(ctx, cutoff, pruneBatchSize)
+	})
+	return removed + n, err
+}
+
+// pruneClickHourly deletes hourly click totals older than CLICK_HOURLY_RETENTION_DAYS
+func pruneClickHourly(ctx context.Context, now time.Time) (int64, error) {
+	days := cfg().ClickHourlyRetentionDays
+	if days <= 0 {
+		return 0, nil
+	}
+	cutoff := now.AddDate(0, 0, -days)
+
+	return pruneInBatches(ctx, func(ctx context.Context) (int64, error) {
+		return deleteClickHourlyBefore(ctx, cutoff, pruneBatchSize)
+	})
+}
+
+// pruneAnonymousLinks deletes links created without an API key that have not been
+// created or clicked within ANONYMOUS_LINK_RETENTION_DAYS
+func pruneAnonymousLinks(ctx context.Context, now time.Time) (int64, error) {
+	days := cfg().AnonymousLinkRetentionDays
+	if days <= 0 {
+		return 0, nil
+	}
+	cutoff := now.AddDate(0, 0, -days)
+
+	return pruneInBatches(ctx, func(ctx context.Context) (int64, error) {
+		codes, err := deleteInactiveAnonymousURLs(ctx, cutoff, pruneBatchSize)
+		for _, code := range codes {
+			destinationCache.Invalidate(code)
+		}
+		return int64(len(codes)), err
+	})
+}
+
+// pruneIdempotencyKeys deletes stored responses that can no longer be replayed
+func pruneIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	return deleteIdempotencyKeysBefore(ctx, now.Add(-idempotencyKeyTTL))
+}
+
+// pruneQuotaUsage removes creation quota counters of periods before last month
+func pruneQuotaUsage(ctx context.Context, now time.Time) (int64, error) {
+	return deleteQuotaUsageBefore(ctx, currentQuotaPeriods(now).Month.AddDate(0, -1, 0))
+}
+
+// pruneInBatches repeats fn until it removes fewer than pruneBatchSize rows
+func pruneInBatches(ctx context.Context, fn func(ctx context.Context) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		n, err := fn(ctx)
+		total += n
+		if err != nil || n < pruneBatchSize {
+			return total, err
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
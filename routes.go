@@ -0,0 +1,215 @@
+package shorty
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reservedPaths holds the lowercased first path segments no short code may take. The
+// routers add every segment they serve; the rest are paths kept free for the frontend
+// and for routes that are not registered on every instance.
+var reservedPaths = struct {
+	sync.RWMutex
+	segments map[string]bool
+}{segments: map[string]bool{"admin": true, "static": true, "assets": true, "health": true}}
+
+// reserveRoutes reserves the first path segment of every route that is not a parameter,
+// so a short code can never shadow a route added later
+func reserveRoutes(routes gin.RoutesInfo) {
+	reservedPaths.Lock()
+	defer reservedPaths.Unlock()
+	for _, route := range routes {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route.Path, "/"), "/")
+		if segment == "" || strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			continue
+		}
+		reservedPaths.segments[strings.ToLower(segment)] = true
+	}
+}
+
+// isReservedAlias reports whether a short code would collide with a route
+func isReservedAlias(code string) bool {
+	reservedPaths.RLock()
+	defer reservedPaths.RUnlock()
+	return reservedPaths.segments[strings.ToLower(code)]
+}
+
+// reservedSegments returns the reserved path segments in order
+func reservedSegments() []string {
+	reservedPaths.RLock()
+	defer reservedPaths.RUnlock()
+	segments := make([]string, 0, len(reservedPaths.segments))
+	for segment := range reservedPaths.segments {
+		segments = append(segments, segment)
+	}
+	slices.Sort(segments)
+	return segments
+}
+
+// warnShadowedCodes logs links created before a route took their path; they can no
+// longer be reached and should be given another code
+func warnShadowedCodes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	codes, err := listShadowedCodes(ctx, reservedSegments())
+	if err != nil {
+		log.Printf("⚠️  Could not check for short codes shadowed by routes: %v", err)
+		return
+	}
+	if len(codes) > 0 {
+		log.Printf("⚠️  %d short codes are shadowed by routes and no longer redirect: %s", len(codes), strings.Join(codes, ", "))
+	}
+}
+
+// newRouter builds the public router. Every top-level path it serves is reserved, so no
+// short code can shadow it.
+func newRouter() (*gin.Engine, error) {
+	r := gin.New()
+
+	// Only believe X-Forwarded-For from TRUSTED_PROXIES when it is set
+	if proxies := cfg().TrustedProxies; len(proxies) > 0 {
+		if err := r.SetTrustedProxies(proxies); err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+	}
+
+	// Tag every request with an ID for tracing
+	r.Use(requestIDMiddleware())
+
+	// Structured JSON access log
+	logger, err := newAccessLogger()
+	if err != nil {
+		return nil, err
+	}
+	if logger != nil {
+		r.Use(accessLogMiddleware(logger))
+	}
+	r.Use(gin.Recovery())
+
+	// Refuse work beyond capacity, keeping redirects up longest
+	r.Use(loadShedMiddleware())
+
+	// Enable CORS
+	r.Use(corsMiddleware())
+
+	// Read-only and maintenance modes
+	r.Use(modeMiddleware())
+
+	// Compress API and HTML responses
+	r.Use(compressionMiddleware())
+
+	// Identify API key callers and protect browser mutations from CSRF
+	r.Use(apiKeyMiddleware(), csrfMiddleware())
+
+	// API Routes
+	api := r.Group("/api", limitRequestBody())
+	{
+		api.POST("/shorten", captchaMiddleware(), idempotencyMiddleware(), quotaMiddleware(), createShortURL)
+		api.GET("/shorten", requireAPIKey(), requireWritable(), quotaMiddleware(), createShortURLFromQuery)
+		api.POST("/utm", captchaMiddleware(), idempotencyMiddleware(), quotaMiddleware(), buildUTMURL)
+		api.GET("/aliases/:code/available", aliasAvailable)
+		api.GET("/suggest", suggestAliases)
+		api.POST("/expand", expandLinks)
+		api.GET("/preview/:code", getPreview)
+		api.GET("/favicon", getFavicon)
+		api.GET("/stats/compare", compareStats)
+		api.GET("/stats/top", topStats)
+		api.GET("/stats/summary", statsSummary)
+		api.GET("/stats/:code", getStats)
+		api.GET("/stats/:code/heatmap", clickHeatmap)
+		api.GET("/stats/:code/events", requireAPIKey(), exportClickEvents)
+		api.GET("/urls", listURLs)
+		api.GET("/urls/count", countListedURLs)
+		api.GET("/search", searchURLs)
+		api.GET("/health", publicHealthCheck)
+		api.GET("/version", versionHandler)
+
+		// Per-account settings
+		account := api.Group("/account", requireAPIKey())
+		{
+			account.GET("/digest", getDigestSubscription)
+			account.PUT("/digest", putDigestSubscription)
+			account.DELETE("/digest", deleteDigestSubscription)
+			account.GET("/quota", getQuota)
+		}
+
+		api.POST("/urls/bulk", requireAPIKey(), bulkUpdateURLs)
+		api.GET("/tags", listTags)
+		api.POST("/tags/:tag/rename", requireAPIKey(), renameTag)
+		api.DELETE("/tags/:tag", requireAPIKey(), deleteTag)
+		api.GET("/urls/:code", getURLRecord)
+		api.PATCH("/urls/:code", requireAPIKey(), updateURL)
+		api.DELETE("/urls/:code", requireAPIKey(), deleteOwnURL)
+		api.GET("/urls/:code/history", requireAPIKey(), linkHistory)
+		api.POST("/urls/:code/rollback/:version", requireAPIKey(), rollbackURL)
+		api.POST("/urls/:code/clone", requireAPIKey(), quotaMiddleware(), cloneURL)
+		api.POST("/urls/:code/share", requireAPIKey(), createShareURL)
+		api.GET("/urls/:code/conversions", requireAPIKey(), listConversions)
+		api.GET("/urls/:code/screenshot", getScreenshot)
+		api.POST("/urls/:code/metadata/refresh", requireAPIKey(), refreshLinkMetadata)
+		api.POST("/conversions", requireAPIKey(), recordConversion)
+
+		// Saved link filters of the caller
+		views := api.Group("/views", requireAPIKey())
+		{
+			views.GET("", listViews)
+			views.POST("", createView)
+			views.GET("/:id", getView)
+			views.DELETE("/:id", deleteView)
+			views.GET("/:id/urls", listViewURLs)
+		}
+
+		// Owner-defined alerts on a link
+		alerts := api.Group("/urls/:code/alerts", requireAPIKey())
+		{
+			alerts.GET("", listAlertsHandler)
+			alerts.POST("", createAlert)
+			alerts.DELETE("/:id", deleteAlertHandler)
+		}
+
+		// Chat integrations
+		if cfg().DiscordPublicKey != "" {
+			api.POST("/integrations/discord", discordInteractionHandler)
+		}
+	}
+
+	// Root route - serve frontend
+	r.GET("/", homeHandler)
+
+	// Redirect route (catch-all for short codes)
+	r.GET("/:code", redirectToURL)
+	r.HEAD("/:code", redirectToURL)
+	r.GET("/:code/", redirectToURL)
+	r.HEAD("/:code/", redirectToURL)
+	r.POST("/:code", proceedToURL)
+	r.Match([]string{http.MethodPut, http.MethodPatch, http.MethodDelete}, "/:code", forwardToURL)
+
+	reserveRoutes(r.Routes())
+	return r, nil
+}
+
+// corsMiddleware adds CORS headers
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-CSRF-Token, X-Captcha-Token, X-Request-ID, Idempotency-Key, If-None-Match")
+		c.Header("Access-Control-Expose-Headers", "ETag, X-Request-ID")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
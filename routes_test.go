@@ -0,0 +1,139 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// buildRouter builds the public router for a test, with the validator set up as the
+// server does
+func buildRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	setupValidator()
+	r, err := newRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// firstSegment returns the first segment of a route path, "" for "/"
+func firstSegment(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return segment
+}
+
+func TestRouterPathsAreReserved(t *testing.T) {
+	routes := append(buildRouter(t).Routes(), newAdminRouter().Routes()...)
+	for _, route := range routes {
+		segment := firstSegment(route.Path)
+		if segment == "" || strings.HasPrefix(segment, ":") {
+			continue
+		}
+		if !isReservedAlias(segment) || !isReservedAlias(strings.ToUpper(segment)) {
+			t.Errorf("%s %s: segment %q is not reserved", route.Method, route.Path, segment)
+		}
+	}
+}
+
+func TestReserveRoutesCoversNewRoutes(t *testing.T) {
+	r := gin.New()
+	r.GET("/brand-new/:id", func(*gin.Context) {})
+	r.POST("/Another", func(*gin.Context) {})
+	r.GET("/:code", func(*gin.Context) {})
+
+	if isReservedAlias("brand-new") {
+		t.Fatal("brand-new is reserved before its route is registered")
+	}
+	reserveRoutes(r.Routes())
+
+	for _, code := range []string{"brand-new", "another", "ANOTHER"} {
+		if !isReservedAlias(code) {
+			t.Errorf("%q is not reserved after its route was registered", code)
+		}
+	}
+	for _, code := range []string{":code", ":id", "abc123"} {
+		if isReservedAlias(code) {
+			t.Errorf("%q is reserved", code)
+		}
+	}
+}
+
+func TestInsertLinkRejectsReservedAlias(t *testing.T) {
+	buildRouter(t)
+	for _, alias := range []string{"api", "API", "admin", "static"} {
+		_, err := insertLink(context.Background(), URL{ShortCode: alias, OriginalURL: "https://example.com"})
+		var fe *FieldError
+		if !errors.As(err, &fe) || fe.Rule != "reserved" {
+			t.Errorf("alias %q: got %v, want a reserved field error", alias, err)
+		}
+	}
+}
+
+func TestGeneratedCodesAreNotReserved(t *testing.T) {
+	buildRouter(t)
+	for i := 0; i < 1000; i++ {
+		code, err := generateShortCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isReservedAlias(code) {
+			t.Fatalf("generated reserved code %q", code)
+		}
+	}
+}
+
+func TestRedirectSkipsReservedPaths(t *testing.T) {
+	r := buildRouter(t)
+	for _, path := range []string{"/API", "/Admin", "/static"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestPublicHealthOnlyReportsStatus(t *testing.T) {
+	healthChecksMu.Lock()
+	checks := healthChecks
+	healthChecks = []dependencyCheck{{Name: "database", Critical: true, Check: func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{"open_connections": 2}, errors.New("connection refused")
+	}}}
+	healthChecksMu.Unlock()
+	t.Cleanup(func() {
+		healthChecksMu.Lock()
+		healthChecks = checks
+		healthChecksMu.Unlock()
+	})
+
+	w := httptest.NewRecorder()
+	buildRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"status":"unhealthy"}` {
+		t.Errorf("public health body %s exposes more than the status", body)
+	}
+}
+
+func TestCheckCustomAliasRejectsInvalidAliases(t *testing.T) {
+	buildRouter(t)
+	for _, alias := range []string{"ab", "has space", "dots.in.it", strings.Repeat("x", 33), "api", "Static"} {
+		_, err := checkCustomAlias(context.Background(), alias)
+		var fe *FieldError
+		if !errors.As(err, &fe) {
+			t.Errorf("alias %q: got %v, want a field error", alias, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"shorty/internal/awsauth"
+)
+
+// s3Client is a minimal S3-compatible object store client signing requests with AWS
+// Signature Version 4. It works against AWS S3, MinIO, R2 and similar services.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	http      *http.Client
+}
+
+// newBackupS3Client builds a client from the BACKUP_S3_* settings
+func newBackupS3Client() (*s3Client, error) {
+	if cfg().BackupS3Bucket == "" {
+		return nil, fmt.Errorf("BACKUP_S3_BUCKET is not set")
+	}
+	endpoint := cfg().BackupS3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg().BackupS3Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid BACKUP_S3_ENDPOINT %q", endpoint)
+	}
+
+	return &s3Client{
+		endpoint:  u,
+		region:    cfg().BackupS3Region,
+		bucket:    cfg().BackupS3Bucket,
+		accessKey: cfg().BackupS3AccessKey,
+		secretKey: cfg().BackupS3SecretKey,
+		pathStyle: cfg().BackupS3PathStyle,
+		http:      &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// PutObject uploads body under key
+func (s *s3Client) PutObject(ctx context.Context, key, contentType string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetObject downloads the object stored under key
+func (s *s3Client) GetObject(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ListObjects returns the keys starting with prefix in lexical order
+func (s *s3Client) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding object list: %w", err)
+		}
+
+		for _, c := range page.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			sort.Strings(keys)
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key (or the bucket itself when key is empty) and returns
+// the response, turning non-2xx statuses into errors
+func (s *s3Client) do(ctx context.Context, method, key string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	u := *s.endpoint
+	path := "/" + strings.TrimPrefix(key, "/")
+	if s.pathStyle {
+		path = "/" + s.bucket + path
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + awsauth.URIEscape(path, false)
+	u.RawQuery = awsauth.CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (s *s3Client) sign(req *http.Request, body []byte, now time.Time) {
+	awsauth.SignRequest(req, body, now, awsauth.Credentials{AccessKey: s.accessKey, SecretKey: s.secretKey}, s.region, "s3")
+}
@@ -0,0 +1,184 @@
+package shorty
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LinkSchedule limits the hours a link redirects in, such as 09:00 to 17:00 on weekdays
+// in Europe/Berlin. Outside them visitors are sent to FallbackURL, or shown a page
+// listing the hours.
+type LinkSchedule struct {
+	Timezone    string           `json:"timezone" binding:"required"`
+	Windows     []ScheduleWindow `json:"windows" binding:"required,min=1,max=14,dive"`
+	FallbackURL string           `json:"fallback_url,omitempty"`
+}
+
+// ScheduleWindow is a daily span of opening hours. A window whose end is before its start
+// runs past midnight into the next day.
+type ScheduleWindow struct {
+	// Days are the weekdays the window starts on; every day when empty
+	Days []string `json:"days,omitempty" binding:"omitempty,max=7,dive,oneof=mon tue wed thu fri sat sun"`
+
+	// From and To are local times as HH:MM; To may be 24:00
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+}
+
+// scheduleDays names the weekdays of ScheduleWindow.Days, indexed by time.Weekday
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// scheduleLocations caches the time zones of schedules, so redirects do not read the
+// zone database
+var scheduleLocations sync.Map
+
+// scheduleLocation loads a schedule's IANA time zone
+func scheduleLocation(name string) (*time.Location, error) {
+	if loc, ok := scheduleLocations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	scheduleLocations.Store(name, loc)
+	return loc, nil
+}
+
+// parseClock parses an HH:MM time of day into minutes since midnight; 24:00 is accepted
+// as the end of the day
+func parseClock(s string) (int, bool) {
+	if s == "24:00" {
+		return 24 * 60, true
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// parseSchedule checks the schedule of a request. Its fallback URL must be a valid
+// destination.
+func parseSchedule(s *LinkSchedule) (*LinkSchedule, *FieldError) {
+	if s == nil {
+		return nil, nil
+	}
+	if _, err := scheduleLocation(s.Timezone); err != nil {
+		return nil, &FieldError{Field: "schedule.timezone", Rule: "timezone", Message: "schedule.timezone must be an IANA time zone name such as Europe/Berlin"}
+	}
+	for _, w := range s.Windows {
+		from, fromOK := parseClock(w.From)
+		to, toOK := parseClock(w.To)
+		if !fromOK || !toOK || from == 24*60 {
+			return nil, &FieldError{Field: "schedule.windows", Rule: "time", Message: "schedule.windows must run from and to times such as 09:00 and 17:00"}
+		}
+		if from == to {
+			return nil, &FieldError{Field: "schedule.windows", Rule: "nefield", Message: "schedule.windows must not start and end at the same time"}
+		}
+	}
+	if s.FallbackURL != "" {
+		s.FallbackURL = strings.TrimSpace(s.FallbackURL)
+		if fe := validateDestination("schedule.fallback_url", s.FallbackURL); fe != nil {
+			return nil, fe
+		}
+	}
+	return s, nil
+}
+
+// onDay reports whether the window starts on weekday d
+func (w ScheduleWindow) onDay(d time.Weekday) bool {
+	return len(w.Days) == 0 || containsString(w.Days, scheduleDays[d])
+}
+
+// openAt reports whether the schedule lets the link redirect at t. Schedules that can no
+// longer be read, such as after a time zone was removed, are always open.
+func (s *LinkSchedule) openAt(t time.Time) bool {
+	loc, err := scheduleLocation(s.Timezone)
+	if err != nil {
+		return true
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	yesterday := (t.Weekday() + 6) % 7
+
+	for _, w := range s.Windows {
+		from, _ := parseClock(w.From)
+		to, _ := parseClock(w.To)
+		switch {
+		case from < to:
+			if w.onDay(t.Weekday()) && now >= from && now < to {
+				return true
+			}
+		case w.onDay(t.Weekday()) && now >= from, w.onDay(yesterday) && now < to:
+			return true
+		}
+	}
+	return false
+}
+
+// allowSchedule sends visitors of a link outside its opening hours to its fallback URL,
+// or answers with a page listing the hours. It reports whether the request may continue.
+func allowSchedule(c *gin.Context, link resolvedLink) bool {
+	if link.Schedule == nil || link.Schedule.openAt(time.Now()) {
+		return true
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	if link.Schedule.FallbackURL != "" {
+		c.Redirect(http.StatusFound, asciiURL(link.Schedule.FallbackURL))
+		c.Abort()
+		return false
+	}
+
+	var page bytes.Buffer
+	if err := closedPage.Execute(&page, link.Schedule); err != nil {
+		respondError(c, http.StatusForbidden, "Short URL is outside its opening hours")
+		return false
+	}
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusForbidden, "text/html; charset=utf-8", page.Bytes())
+	c.Abort()
+	return false
+}
+
+var closedPage = template.Must(template.New("closed").Funcs(template.FuncMap{
+	"days": func(days []string) string {
+		if len(days) == 0 {
+			return "Every day"
+		}
+		return strings.Join(days, ", ")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>Shorty - Outside opening hours</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 560px; width: 100%; box-sizing: border-box; text-align: center; }
+        h1 { color: #333; margin: 0 0 12px; }
+        p, li { color: #555; line-height: 1.5; }
+        ul { list-style: none; padding: 0; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🕘 Outside opening hours</h1>
+        <p>This link can only be followed at these times ({{.Timezone}}):</p>
+        <ul>
+            {{range .Windows}}<li>{{days .Days}}: {{.From}}–{{.To}}</li>
+            {{end}}
+        </ul>
+    </div>
+</body>
+</html>`))
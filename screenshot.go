@@ -0,0 +1,149 @@
+package shorty
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxScreenshotBytes caps the size of a stored screenshot
+const maxScreenshotBytes = 2 << 20
+
+// screenshotTypes are the image types accepted from the screenshot service
+var screenshotTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/webp": true}
+
+var screenshotClient = &http.Client{}
+
+// Screenshot is a stored thumbnail of a link's destination
+type Screenshot struct {
+	ShortCode   string
+	ContentType string
+	Image       []byte
+	CapturedAt  time.Time
+}
+
+// screenshotsEnabled reports whether a screenshot service is configured
+func screenshotsEnabled() bool {
+	return cfg().ScreenshotURL != ""
+}
+
+// queueScreenshot captures a new link's destination in the background, if enabled
+func queueScreenshot(link URL) {
+	if !screenshotsEnabled() {
+		return
+	}
+	workers.Submit("screenshot", func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg().ScreenshotTimeout)
+		defer cancel()
+
+		shot, err := captureScreenshot(ctx, link.OriginalURL)
+		if err == nil {
+			shot.ShortCode = link.ShortCode
+			err = saveScreenshot(ctx, shot)
+		}
+		if err != nil {
+			log.Printf("Failed to capture screenshot of %s: %v", link.ShortCode, err)
+		}
+	})
+}
+
+// captureScreenshot asks the configured service for an image of destination. The
+// service is called with {url} in SCREENSHOT_URL replaced by the escaped destination,
+// and must answer with a PNG, JPEG or WebP body.
+func captureScreenshot(ctx context.Context, destination string) (Screenshot, error) {
+	// The service browses on our behalf; keep it away from internal addresses too
+	u, err := url.Parse(destination)
+	if err != nil {
+		return Screenshot{}, err
+	}
+	if err := checkPublicHost(ctx, u.Hostname()); err != nil {
+		return Screenshot{}, err
+	}
+
+	target := strings.ReplaceAll(cfg().ScreenshotURL, "{url}", url.QueryEscape(asciiURL(destination)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return Screenshot{}, err
+	}
+	if token := cfg().ScreenshotToken; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := screenshotClient.Do(req)
+	if err != nil {
+		return Screenshot{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return Screenshot{}, fmt.Errorf("screenshot service answered %s", resp.Status)
+	}
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if !screenshotTypes[contentType] {
+		return Screenshot{}, fmt.Errorf("screenshot service returned unsupported type %q", contentType)
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxScreenshotBytes+1))
+	if err != nil {
+		return Screenshot{}, err
+	}
+	if len(image) > maxScreenshotBytes {
+		return Screenshot{}, fmt.Errorf("screenshot exceeds %d bytes", maxScreenshotBytes)
+	}
+	if http.DetectContentType(image) != contentType {
+		return Screenshot{}, fmt.Errorf("screenshot body is not %s", contentType)
+	}
+	return Screenshot{ContentType: contentType, Image: image, CapturedAt: time.Now().UTC()}, nil
+}
+
+// checkPublicHost resolves host and fails if any of its addresses is not public
+func checkPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
+
+// getScreenshot handles GET /api/urls/:code/screenshot
+func getScreenshot(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, c.Param("code"))
+	if err == nil && (!visibleTo(c, u.Owner, u.Visibility) || !reachableBy(c, u.Owner, u.AllowedNetworks, u.AllowedCountries, u.BlockedCountries)) {
+		err = errNotFound
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return
+	}
+
+	shot, err := loadScreenshot(ctx, u.ShortCode)
+	if err != nil {
+		respondStoreError(c, err, "Screenshot not found", "Failed to fetch screenshot")
+		return
+	}
+
+	// Shared caches must not hand the screenshot of a restricted link to anyone else
+	cacheControl := "public, max-age=3600"
+	if u.Visibility != "" || len(u.AllowedNetworks) > 0 || len(u.AllowedCountries) > 0 || len(u.BlockedCountries) > 0 {
+		cacheControl = "private, max-age=3600"
+	}
+	c.Header("Cache-Control", cacheControl)
+	c.Header("Last-Modified", shot.CapturedAt.Format(http.TimeFormat))
+	c.Data(http.StatusOK, shot.ContentType, shot.Image)
+}
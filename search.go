@@ -0,0 +1,68 @@
+package shorty
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSearchLimit is the number of results of GET /api/search when no limit is given
+const defaultSearchLimit = 20
+
+// SearchParams are the query parameters of GET /api/search. The link filters narrow the
+// matches too.
+type SearchParams struct {
+	LinkFilterParams
+	Q     string `form:"q" binding:"required,min=2,max=200"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// SearchResult is a link matching a search, with the destination title and description
+// it may have matched on
+type SearchResult struct {
+	URL
+	Title       string  `json:"title,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Rank        float64 `json:"rank"`
+}
+
+// searchURLs handles GET /api/search, matching links by a substring of their destination
+// or the words of its title and description, best matches first
+func searchURLs(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	var params SearchParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	filter, details := parseLinkFilter(params.LinkFilterParams)
+	if len(details) > 0 {
+		respondValidationError(c, details...)
+		return
+	}
+	filter.Visible = visibilityScope(c)
+	if params.Limit == 0 {
+		params.Limit = defaultSearchLimit
+	}
+
+	query := strings.TrimSpace(params.Q)
+	results, err := searchLinks(ctx, query, filter, params.Limit)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to search URLs")
+		return
+	}
+
+	caller, _ := apiKeyName(c)
+	for i := range results {
+		results[i].Links = linkHyperlinks(c, results[i].ShortCode, caller != "" && results[i].Owner == caller)
+	}
+	jsonWithETag(c, http.StatusOK, gin.H{"query": query, "results": results})
+}
+
+// likePattern matches s anywhere in a LIKE or ILIKE comparison
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
@@ -0,0 +1,267 @@
+package shorty
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take to finish on shutdown
+const shutdownTimeout = 15 * time.Second
+
+// serve runs the HTTP server until it receives SIGINT or SIGTERM
+func serve(args []string) error {
+	flag.NewFlagSet("serve", flag.ExitOnError).Parse(args)
+
+	srv, err := start()
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	watchReloadSignal()
+
+	if err := runServer(srv.Handler()); err != nil {
+		return fmt.Errorf("server stopped: %w", err)
+	}
+
+	srv.Close()
+	log.Println("👋 Shorty stopped")
+	return nil
+}
+
+// setup connects to the databases and loads what handlers need before the router is
+// built. The database pools are closed again when a later step fails.
+func setup() (err error) {
+	// Connect to database with retry logic
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			closeReplica()
+			db.Close()
+		}
+	}()
+	if err := connectReplica(); err != nil {
+		return err
+	}
+
+	// Create the schema of a fresh database, as "shorty migrate" would
+	if cfg().AutoMigrate {
+		ctx, cancel := commandContext()
+		err := migrateSchema(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("applying schema: %w", err)
+		}
+		log.Println("✓ Database schema is up to date")
+	}
+
+	// Load the GeoIP database for links limited to some countries
+	if path := cfg().GeoIPDatabase; path != "" {
+		if err := loadGeoIP(path); err != nil {
+			return fmt.Errorf("loading GEOIP_DATABASE: %w", err)
+		}
+		log.Printf("✓ Loaded %d GeoIP ranges", len(geoRanges))
+	}
+
+	// Refuse to start with event sinks that cannot work
+	if err := checkEventSinks(); err != nil {
+		return err
+	}
+
+	// Report JSON field names in validation errors
+	setupValidator()
+
+	// Set Gin mode
+	if os.Getenv("GIN_MODE") == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	return nil
+}
+
+// startBackgroundWork starts click batching, the worker pool and the scheduled jobs. It
+// runs after the router is built, so shadowed codes are checked against every route.
+func startBackgroundWork() {
+	// Batch click count updates and run background work on a bounded pool
+	go clicks.Run()
+	workers.Start()
+
+	// Publish link and click events to EVENT_SINKS
+	linkEvents.Start()
+
+	// Only the instance holding the jobs lock runs the scheduled jobs
+	startLeaderElection()
+
+	// Keep monthly click event partitions ahead of time and prune expired data
+	scheduleJob("click_partitions", 6*time.Hour, maintainClickPartitions)
+	scheduleJob("retention", cfg().RetentionInterval, enforceRetention)
+	scheduleJob("alerts", cfg().AlertCheckInterval, checkAlerts)
+	scheduleJob("digests", time.Hour, sendDigests)
+	scheduleJob("normalize_urls", time.Hour, backfillNormalizedURLs)
+	scheduleJob("metadata", 15*time.Minute, refreshStaleMetadata)
+	scheduleJob("blocklist", cfg().BlocklistSyncInterval, syncBlocklists)
+	if cfg().LinkCheckInterval > 0 {
+		scheduleJob("link_checks", cfg().LinkCheckInterval, checkLinkHealth)
+	}
+	if cfg().BackupInterval > 0 {
+		scheduleJob("backup", cfg().BackupInterval, scheduledBackup)
+	}
+
+	// Reject unknown codes from memory before they reach the database
+	go knownCodes.Run()
+
+	// Shed API calls while every database connection is busy
+	go watchDBSaturation()
+
+	// Preload hot links before accepting traffic
+	warmCache()
+	warnShadowedCodes()
+}
+
+// stopBackgroundWork finishes queued work and persists buffered clicks
+func stopBackgroundWork() {
+	stopScheduledJobs()
+	stopLeaderElection()
+	workers.Stop()
+	clicks.Stop()
+	linkEvents.Stop()
+}
+
+// runServer serves handler over plain HTTP, or over TLS using either the configured
+// certificate files or Let's Encrypt certificates obtained with autocert. With TLS
+// enabled it can also serve HTTP/3 over QUIC on the same port number. It returns nil
+// after a graceful shutdown triggered by SIGINT or SIGTERM.
+func runServer(handler http.Handler) error {
+	srv := &http.Server{Handler: handler}
+	shutdownOnSignal(srv)
+
+	ln, err := openListener()
+	if err != nil {
+		return err
+	}
+	log.Printf("🚀 Shorty %s (%s) is listening on %s", version, commit, ln.Addr())
+
+	switch {
+	case len(cfg().TLSAutocertHosts) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg().TLSAutocertHosts...),
+			Cache:      autocert.DirCache(cfg().TLSAutocertCache),
+			Email:      cfg().TLSAutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+
+		// The HTTP listener also answers ACME HTTP-01 challenges
+		startRedirectListener(manager.HTTPHandler(http.HandlerFunc(redirectToHTTPS)))
+		log.Printf("🔒 Serving TLS for %v with Let's Encrypt certificates", cfg().TLSAutocertHosts)
+
+	case cfg().TLSCertFile != "" && cfg().TLSKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg().TLSCertFile, cfg().TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		startRedirectListener(http.HandlerFunc(redirectToHTTPS))
+		log.Println("🔒 Serving TLS with certificate", cfg().TLSCertFile)
+
+	default:
+		if cfg().HTTP3Enabled {
+			log.Println("HTTP3_ENABLED requires TLS; serving HTTP/1.1 only")
+		}
+		return ignoreServerClosed(srv.Serve(ln))
+	}
+
+	if cfg().HTTP3Enabled {
+		srv.Handler = startHTTP3Listener(handler, srv.TLSConfig)
+	}
+	return ignoreServerClosed(srv.ServeTLS(ln, "", ""))
+}
+
+// shutdownOnSignal stops srv gracefully when the process is asked to terminate
+func shutdownOnSignal(srv *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Graceful shutdown failed: %v", err)
+		}
+	}()
+}
+
+// ignoreServerClosed treats the error returned after Shutdown as a clean exit
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// startHTTP3Listener serves handler over QUIC in the background and returns a handler
+// that advertises it to TCP clients with an Alt-Svc header
+func startHTTP3Listener(handler http.Handler, tlsConfig *tls.Config) http.Handler {
+	h3 := &http3.Server{
+		Addr:      ":" + cfg().Port,
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+
+	go func() {
+		log.Printf("⚡ Serving HTTP/3 on UDP port %s", cfg().Port)
+		if err := h3.ListenAndServe(); err != nil {
+			log.Printf("HTTP/3 listener stopped: %v", err)
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQuicHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// startRedirectListener serves handler on HTTP_REDIRECT_PORT in the background
+func startRedirectListener(handler http.Handler) {
+	if cfg().HTTPRedirectPort == "" {
+		return
+	}
+
+	go func() {
+		log.Printf("↪ Redirecting HTTP on port %s to HTTPS", cfg().HTTPRedirectPort)
+		if err := http.ListenAndServe(":"+cfg().HTTPRedirectPort, handler); err != nil {
+			log.Printf("HTTP redirect listener stopped: %v", err)
+		}
+	}()
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the TLS port
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if cfg().Port != "443" {
+		host = net.JoinHostPort(host, cfg().Port)
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
@@ -0,0 +1,117 @@
+package shorty
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// errShareInvalid is returned for a share URL whose signature does not match
+	errShareInvalid = errors.New("invalid share signature")
+
+	// errShareExpired is returned for a share URL past its expiry
+	errShareExpired = errors.New("share URL has expired")
+)
+
+// ShareRequest is the body of POST /api/urls/:code/share
+type ShareRequest struct {
+	ExpiresAt time.Time `json:"expires_at" binding:"required"`
+}
+
+// ShareResponse is a signed share URL of a link
+type ShareResponse struct {
+	ShortCode string    `json:"short_code"`
+	ShareURL  string    `json:"share_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sharesEnabled reports whether share URLs can be signed and are honoured
+func sharesEnabled() bool {
+	return cfg().ShareURLSecret != ""
+}
+
+// createShareURL handles POST /api/urls/:code/share, signing a URL of one of the caller's
+// links that anyone can follow until expires_at, even while the link is private or
+// archived by its owner. Links taken down by an administrator, moderation or a
+// blocklist cannot be shared.
+func createShareURL(c *gin.Context) {
+	u, ok := loadOwnedURL(c)
+	if !ok {
+		return
+	}
+	if !sharesEnabled() {
+		respondError(c, http.StatusNotFound, "Share URLs are not enabled")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+	takenDown, err := urlTakenDown(ctx, u.ShortCode)
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return
+	}
+	if takenDown {
+		respondError(c, http.StatusConflict, "Links disabled by an administrator, moderation or a blocklist cannot be shared")
+		return
+	}
+
+	var req ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	if !req.ExpiresAt.After(time.Now()) {
+		respondValidationError(c, FieldError{Field: "expires_at", Rule: "gt", Param: "now", Message: "expires_at must be in the future"})
+		return
+	}
+	if maxTTL := cfg().ShareURLMaxTTL; time.Until(req.ExpiresAt) > maxTTL {
+		respondValidationError(c, FieldError{Field: "expires_at", Rule: "max", Param: maxTTL.String(), Message: "expires_at must be within " + maxTTL.String()})
+		return
+	}
+
+	expires := req.ExpiresAt.Unix()
+	query := url.Values{"exp": {strconv.FormatInt(expires, 10)}, "sig": {shareSignature(u.ShortCode, expires)}}
+	c.JSON(http.StatusOK, ShareResponse{
+		ShortCode: u.ShortCode,
+		ShareURL:  buildShortURL(c, u.ShortCode) + "?" + query.Encode(),
+		ExpiresAt: time.Unix(expires, 0).UTC(),
+	})
+}
+
+// isShareRequest reports whether a request for a short URL carries a share signature
+func isShareRequest(c *gin.Context) bool {
+	return sharesEnabled() && (c.Query("sig") != "" || c.Query("exp") != "")
+}
+
+// verifyShare checks the sig and exp query parameters of a request for code
+func verifyShare(c *gin.Context, code string) error {
+	expires, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("sig")), []byte(shareSignature(code, expires))) {
+		return errShareInvalid
+	}
+	if time.Now().Unix() >= expires {
+		return errShareExpired
+	}
+	return nil
+}
+
+// shareQuery returns the share parameters of a request, for links that send the visitor
+// on to another request of the same URL
+func shareQuery(c *gin.Context) string {
+	return url.Values{"exp": {c.Query("exp")}, "sig": {c.Query("sig")}}.Encode()
+}
+
+func shareSignature(code string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(cfg().ShareURLSecret))
+	mac.Write([]byte(code + "." + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
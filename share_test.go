@@ -0,0 +1,89 @@
+package shorty
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/config"
+)
+
+// useShareSecret configures SHARE_URL_SECRET for the test
+func useShareSecret(t *testing.T, secret string) {
+	t.Helper()
+	previous := cfg()
+	t.Cleanup(func() { config.Set(previous) })
+	conf := *previous
+	conf.ShareURLSecret = secret
+	config.Set(&conf)
+}
+
+// shareContext returns a request context for /code with query
+func shareContext(code string, query url.Values) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/"+code+"?"+query.Encode(), nil)
+	return c
+}
+
+func TestVerifyShare(t *testing.T) {
+	useShareSecret(t, "share-secret")
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Second).Unix()
+	exp := func(unix int64) string { return strconv.FormatInt(unix, 10) }
+
+	tests := []struct {
+		name  string
+		code  string
+		query url.Values
+		want  error
+	}{
+		{"valid", "report", url.Values{"exp": {exp(future)}, "sig": {shareSignature("report", future)}}, nil},
+		{"expired", "report", url.Values{"exp": {exp(past)}, "sig": {shareSignature("report", past)}}, errShareExpired},
+		{"extended expiry", "report", url.Values{"exp": {exp(future + 3600)}, "sig": {shareSignature("report", future)}}, errShareInvalid},
+		{"other code", "other", url.Values{"exp": {exp(future)}, "sig": {shareSignature("report", future)}}, errShareInvalid},
+		{"tampered signature", "report", url.Values{"exp": {exp(future)}, "sig": {shareSignature("report", future)[1:]}}, errShareInvalid},
+		{"missing signature", "report", url.Values{"exp": {exp(future)}}, errShareInvalid},
+		{"missing expiry", "report", url.Values{"sig": {shareSignature("report", 0)}}, errShareInvalid},
+		{"malformed expiry", "report", url.Values{"exp": {"soon"}, "sig": {shareSignature("report", 0)}}, errShareInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyShare(shareContext(tt.code, tt.query), tt.code); !errors.Is(err, tt.want) {
+				t.Errorf("verifyShare = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareSignatureDependsOnSecret(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	useShareSecret(t, "first")
+	signed := shareSignature("report", expires)
+
+	useShareSecret(t, "second")
+	query := url.Values{"exp": {strconv.FormatInt(expires, 10)}, "sig": {signed}}
+	if err := verifyShare(shareContext("report", query), "report"); !errors.Is(err, errShareInvalid) {
+		t.Errorf("verifyShare after changing SHARE_URL_SECRET = %v, want %v", err, errShareInvalid)
+	}
+}
+
+func TestIsShareRequest(t *testing.T) {
+	query := url.Values{"exp": {"1"}, "sig": {"x"}}
+
+	useShareSecret(t, "")
+	if isShareRequest(shareContext("report", query)) {
+		t.Error("share parameters are honoured without SHARE_URL_SECRET")
+	}
+	useShareSecret(t, "share-secret")
+	if !isShareRequest(shareContext("report", query)) {
+		t.Error("share parameters are ignored")
+	}
+	if isShareRequest(shareContext("report", url.Values{"utm_source": {"mail"}})) {
+		t.Error("a request without share parameters is a share request")
+	}
+}
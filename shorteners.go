@@ -0,0 +1,145 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// shortenerExpandTimeout bounds the whole expansion of a destination's shortener chain
+const shortenerExpandTimeout = 5 * time.Second
+
+// shortenerClient follows a chain one hop at a time, with the same address checks as
+// destinationClient
+var shortenerClient = &http.Client{
+	Timeout:   shortenerExpandTimeout,
+	Transport: destinationClient.Transport,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// expandShorteners follows a destination through known URL shorteners, up to
+// SHORTENER_MAX_HOPS of them, and returns the first URL that is not a short link.
+// Short links of this instance are resolved from the database. Loops, overlong chains
+// and dead short links are refused; a shortener that cannot be reached leaves the
+// destination as it was.
+func expandShorteners(ctx context.Context, destination string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, shortenerExpandTimeout)
+	defer cancel()
+
+	seen := map[string]bool{}
+	current := destination
+	for hops := 0; ; hops++ {
+		u, err := url.Parse(asciiURL(current))
+		if err != nil {
+			return current, nil
+		}
+		own := isOwnShortURL(u)
+		if !own && !isShortenerHost(u.Hostname()) {
+			return current, nil
+		}
+		if seen[current] {
+			return "", &FieldError{Field: "url", Rule: "shortener", Message: "url is a short link that redirects in a loop"}
+		}
+		if hops >= cfg().ShortenerMaxHops {
+			return "", &FieldError{Field: "url", Rule: "shortener", Message: "url is a chain of more short links than allowed"}
+		}
+		seen[current] = true
+
+		var next string
+		if own {
+			link, err := lookupDestination(ctx, cleanCode(strings.Trim(u.Path, "/")))
+			if errors.Is(err, errNotFound) || errors.Is(err, errDisabled) || errors.Is(err, errExpired) || errors.Is(err, errPending) || errors.Is(err, errUnavailable) {
+				return "", &FieldError{Field: "url", Rule: "shortener", Message: "url is a short link of this instance that does not redirect"}
+			}
+			if err != nil {
+				return "", err
+			}
+			// Expanding a flagged or private link would skip its warning page or sign-in
+			if link.Visibility != "" {
+				return "", &FieldError{Field: "url", Rule: "shortener", Message: "url is a private short link of this instance"}
+			}
+			if link.Warning != "" {
+				return "", &FieldError{Field: "url", Rule: "shortener", Message: "url is a short link of this instance with a flagged destination"}
+			}
+			next = link.Destination
+		} else {
+			next, err = nextShortenerHop(ctx, u)
+			if err != nil {
+				return destination, nil
+			}
+			if next == "" {
+				return current, nil
+			}
+		}
+		if fe := validateDestination("url", next); fe != nil {
+			return "", &FieldError{Field: "url", Rule: "shortener", Message: "url is a short link leading to an invalid destination: " + fe.Message}
+		}
+		current = unicodeURL(next)
+	}
+}
+
+// nextShortenerHop asks a shortener where a short link leads, returning "" when it does
+// not redirect
+func nextShortenerHop(ctx context.Context, u *url.URL) (string, error) {
+	resp, err := shortenerRequest(ctx, http.MethodHead, u)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp, err = shortenerRequest(ctx, http.MethodGet, u)
+	}
+	if err != nil {
+		return "", err
+	}
+	location := resp.Header.Get("Location")
+	if resp.StatusCode/100 != 3 || location == "" {
+		return "", nil
+	}
+	next, err := u.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
+
+// shortenerRequest sends one request to a shortener without following its redirect
+func shortenerRequest(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Shorty/"+version+" (+link expansion)")
+	resp, err := shortenerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// isShortenerHost reports whether a host is one of SHORTENER_DOMAINS
+func isShortenerHost(host string) bool {
+	domain, ok := blocklistDomain(host)
+	if !ok {
+		return false
+	}
+	for _, shortener := range cfg().ShortenerDomains {
+		if candidate, ok := blocklistDomain(shortener); ok && candidate == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnShortURL reports whether a URL is a short link of this instance's PUBLIC_URL,
+// rather than one of its other pages
+func isOwnShortURL(u *url.URL) bool {
+	code := cleanCode(strings.Trim(u.Path, "/"))
+	if cfg().PublicURL == "" || code == "" || strings.ContainsAny(code, "/.") || isReservedAlias(code) {
+		return false
+	}
+	public, err := url.Parse(cfg().PublicURL)
+	return err == nil && strings.EqualFold(public.Host, u.Host) && strings.TrimSuffix(public.Path, "/") == ""
+}
@@ -0,0 +1,134 @@
+// Package shorty is the Shorty URL shortener. The shorty command serves it on its own;
+// other programs can embed it and mount its handler in their own server:
+//
+//	conf, err := shorty.LoadConfig()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	srv, err := shorty.New(conf)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer srv.Close()
+//	mux.Handle("/links/", http.StripPrefix("/links", srv.Handler()))
+//
+// Short URLs and API links then include the /links prefix.
+//
+// Handlers, storage and background jobs all live in this package and share its
+// database pool, caches and configuration; only settings (internal/config) and click
+// analytics (internal/analytics) are separate packages.
+package shorty
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/config"
+)
+
+// Config holds Shorty's settings; the fields correspond to the environment variables
+// documented in the README
+type Config = config.Config
+
+// LoadConfig reads the settings from the environment and CONFIG_FILE, like the shorty
+// command does. It fails when a setting refers to a secret that cannot be loaded.
+func LoadConfig() (*Config, error) {
+	return config.Load()
+}
+
+// Server is Shorty running inside another program. Its state is shared by the whole
+// process, so a program runs at most one, once: New fails after the first Server was
+// started, even if it has been closed.
+type Server struct {
+	router *gin.Engine
+	admin  *http.Server
+	close  sync.Once
+}
+
+var (
+	// started is set by the first start that succeeds
+	started atomic.Bool
+
+	// stopped is closed by Server.Close to end the goroutines that poll until then
+	stopped = make(chan struct{})
+)
+
+// New starts Shorty with conf: it connects to the database, applies the schema when
+// AutoMigrate is set and starts click batching and the scheduled jobs. It returns an
+// error if the database cannot be reached, a setting is invalid or a Server was already
+// started. The admin listener is started unless AdminAddr is "off"; SIGHUP is left to
+// the embedding program.
+func New(conf *Config) (*Server, error) {
+	if started.Load() {
+		return nil, errAlreadyStarted
+	}
+	config.Set(conf)
+	return start()
+}
+
+// errAlreadyStarted is returned when a second Server is started in one process
+var errAlreadyStarted = errors.New("shorty: a server was already started in this process")
+
+// start sets Shorty up with the current configuration, builds the public router and
+// starts the admin listener and the background work. The shorty command and New both
+// run the server this way. The database pools are closed again when it fails.
+func start() (*Server, error) {
+	if !started.CompareAndSwap(false, true) {
+		return nil, errAlreadyStarted
+	}
+	destinationCache = newLRUCache(cfg().CacheSize, cfg().CacheTTL)
+	workers = newWorkerPool(cfg().WorkerCount, cfg().WorkerQueueSize)
+
+	if err := setup(); err != nil {
+		started.Store(false)
+		return nil, err
+	}
+
+	router, err := newRouter()
+	var admin *http.Server
+	if err == nil {
+		admin, err = startAdminServer()
+	}
+	if err != nil {
+		closeReplica()
+		db.Close()
+		started.Store(false)
+		return nil, err
+	}
+
+	s := &Server{router: router, admin: admin}
+	watchModeFlagFile()
+	watchSecretRotation()
+	startBackgroundWork()
+	return s, nil
+}
+
+// Handler returns the handler serving Shorty's pages, API and redirects. It may be
+// mounted under a path prefix with http.StripPrefix.
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Close stops the background work, writing buffered clicks, shuts the admin listener
+// down and closes the database pools. The handler must no longer be served.
+func (s *Server) Close() {
+	s.close.Do(func() {
+		stopBackgroundWork()
+		close(stopped)
+		if s.admin != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := s.admin.Shutdown(ctx); err != nil {
+				log.Printf("Admin listener shutdown failed: %v", err)
+			}
+			cancel()
+		}
+		closeReplica()
+		db.Close()
+	})
+}
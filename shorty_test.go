@@ -0,0 +1,19 @@
+package shorty
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewRefusesSecondServer(t *testing.T) {
+	started.Store(true)
+	t.Cleanup(func() { started.Store(false) })
+
+	previous := cfg()
+	if _, err := New(&Config{}); !errors.Is(err, errAlreadyStarted) {
+		t.Fatalf("New after a server was started: err = %v, want %v", err, errAlreadyStarted)
+	}
+	if cfg() != previous {
+		t.Error("New replaced the configuration of the running server")
+	}
+}
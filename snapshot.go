@@ -0,0 +1,336 @@
+package shorty
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// snapshotFormatVersion is written to every snapshot manifest and checked on restore.
+// Version 2 added the tables after geo_blocks in snapshotTables.
+const snapshotFormatVersion = 2
+
+// snapshotTables are dumped in this order and restored in the same order. Every table in
+// sql/init.sql is either listed here or in snapshotSkippedTables.
+var snapshotTables = []string{
+	"urls", "click_events", "click_hourly", "conversions", "screenshots", "url_versions", "alerts",
+	"digest_subscriptions", "saved_views", "blocklist_hits", "geo_blocks",
+	"destination_metadata", "favicons", "blocklist", "link_checks", "creation_quota_usage", "idempotency_keys",
+}
+
+// snapshotSkippedTables describe the database they are in rather than its data: the
+// restore applies its own schema and counts itself as a restore
+var snapshotSkippedTables = []string{"schema_version", "restore_generation"}
+
+// serialTables are the snapshot tables with an id sequence to advance after a restore
+var serialTables = []string{"urls", "click_events", "conversions", "url_versions", "alerts", "saved_views"}
+
+// snapshotManifest describes the contents of a snapshot archive
+type snapshotManifest struct {
+	Format     string              `json:"format"`
+	Version    int                 `json:"version"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Columns    map[string][]string `json:"columns"`
+	Partitions []string            `json:"partitions"`
+}
+
+// createSnapshot writes the schema and every table to w as a gzip-compressed tar archive
+// holding manifest.json, schema.sql and one Postgres COPY text file per table. All tables
+// are read in one repeatable-read transaction so the snapshot is consistent.
+func createSnapshot(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	err := pgx.BeginTxFunc(ctx, db, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		manifest := snapshotManifest{
+			Format:    "shorty-snapshot",
+			Version:   snapshotFormatVersion,
+			CreatedAt: time.Now().UTC(),
+			Columns:   map[string][]string{},
+		}
+		for _, table := range snapshotTables {
+			columns, err := tableColumns(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+			manifest.Columns[table] = columns
+		}
+		partitions, err := listClickPartitions(ctx)
+		if err != nil {
+			return err
+		}
+		manifest.Partitions = partitions
+
+		manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(archive, "manifest.json", manifestJSON); err != nil {
+			return err
+		}
+		if err := writeTarFile(archive, "schema.sql", []byte(schemaSQL)); err != nil {
+			return err
+		}
+
+		for _, table := range snapshotTables {
+			if err := writeTableCopy(ctx, tx, archive, table, manifest.Columns[table]); err != nil {
+				return fmt.Errorf("dumping %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// restoreSnapshot loads a snapshot written by createSnapshot. The schema is applied first;
+// existing rows in the snapshot's tables are replaced only when force is set.
+func restoreSnapshot(ctx context.Context, r io.Reader, force bool) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("opening snapshot: %w", err)
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+
+	var manifest snapshotManifest
+	if err := readTarJSON(archive, "manifest.json", &manifest); err != nil {
+		return err
+	}
+	if manifest.Format != "shorty-snapshot" {
+		return errors.New("not a shorty snapshot")
+	}
+	if manifest.Version > snapshotFormatVersion {
+		return fmt.Errorf("snapshot version %d is newer than supported version %d", manifest.Version, snapshotFormatVersion)
+	}
+
+	schema, err := readTarFile(archive, "schema.sql")
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, string(schema)); err != nil {
+		return fmt.Errorf("applying schema: %w", err)
+	}
+	for _, name := range manifest.Partitions {
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, "click_events_"))
+		if err != nil {
+			continue
+		}
+		if err := createClickPartition(ctx, name, month, month.AddDate(0, 1, 0)); err != nil {
+			return fmt.Errorf("creating partition %s: %w", name, err)
+		}
+	}
+
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		for _, table := range snapshotTables {
+			var exists bool
+			if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM "+pgx.Identifier{table}.Sanitize()+")").Scan(&exists); err != nil {
+				return err
+			}
+			if exists && !force {
+				return fmt.Errorf("table %s is not empty; pass -force to replace its contents", table)
+			}
+		}
+		if _, err := tx.Exec(ctx, "TRUNCATE "+strings.Join(snapshotTables, ", ")); err != nil {
+			return err
+		}
+
+		for _, table := range snapshotTables {
+			// Older snapshots lack the tables added since; those are left empty
+			if _, ok := manifest.Columns[table]; !ok {
+				continue
+			}
+			header, err := archive.Next()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", table, err)
+			}
+			if header.Name != table+".copy" {
+				return fmt.Errorf("expected %s.copy in snapshot, found %s", table, header.Name)
+			}
+
+			columns := sanitizeColumns(manifest.Columns[table])
+			result, err := tx.Conn().PgConn().CopyFrom(ctx, archive,
+				fmt.Sprintf("COPY %s (%s) FROM STDIN", pgx.Identifier{table}.Sanitize(), columns))
+			if err != nil {
+				return fmt.Errorf("restoring %s: %w", table, err)
+			}
+			log.Printf("✓ Restored %d rows into %s", result.RowsAffected(), table)
+		}
+
+		// Move id sequences past the restored rows
+		for _, table := range serialTables {
+			_, err := tx.Exec(ctx, fmt.Sprintf(
+				"SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)",
+				table, pgx.Identifier{table}.Sanitize(),
+			))
+			if err != nil {
+				return err
+			}
+		}
+		return countRestore(ctx, tx)
+	})
+}
+
+// tableColumns returns a table's column names in definition order
+func tableColumns(ctx context.Context, tx pgx.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(ctx,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position",
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// writeTableCopy dumps a table in COPY text format into the archive. The dump is spooled
+// to a temporary file because tar needs each entry's size up front.
+func writeTableCopy(ctx context.Context, tx pgx.Tx, archive *tar.Writer, table string, columns []string) error {
+	spool, err := os.CreateTemp("", "shorty-snapshot-*.copy")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	_, err = tx.Conn().PgConn().CopyTo(ctx, spool,
+		fmt.Sprintf("COPY %s (%s) TO STDOUT", pgx.Identifier{table}.Sanitize(), sanitizeColumns(columns)))
+	if err != nil {
+		return err
+	}
+
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := archive.WriteHeader(&tar.Header{Name: table + ".copy", Mode: 0o644, Size: size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, spool)
+	return err
+}
+
+// sanitizeColumns quotes column names for use in a COPY column list
+func sanitizeColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func writeTarFile(archive *tar.Writer, name string, data []byte) error {
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
+
+// readTarFile reads the next archive entry, which must be named name
+func readTarFile(archive *tar.Reader, name string) ([]byte, error) {
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	if header.Name != name {
+		return nil, fmt.Errorf("expected %s in snapshot, found %s", name, header.Name)
+	}
+	return io.ReadAll(archive)
+}
+
+func readTarJSON(archive *tar.Reader, name string, v any) error {
+	data, err := readTarFile(archive, name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// runSnapshotCommand implements "shorty snapshot create|restore [-force] <file>"; a file
+// of "-" means stdout or stdin
+func runSnapshotCommand(args []string) error {
+	if err := connectDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	force := fs.Bool("force", false, "replace existing data when restoring")
+	timeout := fs.Duration("timeout", time.Hour, "maximum time for the snapshot or restore")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: shorty snapshot create <file>\n       shorty snapshot restore [-force] <file>")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing snapshot command")
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing snapshot file")
+	}
+	path := fs.Arg(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch command {
+	case "create":
+		out := os.Stdout
+		if path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := createSnapshot(ctx, out); err != nil {
+			return err
+		}
+		if out != os.Stdout {
+			log.Printf("✓ Snapshot written to %s", path)
+			return out.Close()
+		}
+		return nil
+
+	case "restore":
+		in := os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		return restoreSnapshot(ctx, in, *force)
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown snapshot command %q", command)
+	}
+}
@@ -0,0 +1,20 @@
+package shorty
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestSnapshotCoversEveryTable(t *testing.T) {
+	created := regexp.MustCompile(`(?i)CREATE TABLE IF NOT EXISTS (\w+)`).FindAllStringSubmatch(schemaSQL, -1)
+	if len(created) == 0 {
+		t.Fatal("no tables found in the schema")
+	}
+	for _, match := range created {
+		table := match[1]
+		if !slices.Contains(snapshotTables, table) && !slices.Contains(snapshotSkippedTables, table) {
+			t.Errorf("table %s is neither in snapshotTables nor in snapshotSkippedTables", table)
+		}
+	}
+}
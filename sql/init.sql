@@ -12,3 +12,361 @@ CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls(short_code);
 
 -- Create index on original_url to check for duplicates
 CREATE INDEX IF NOT EXISTS idx_urls_original_url ON urls(original_url);
+
+-- Create index on clicks for finding the most popular links
+CREATE INDEX IF NOT EXISTS idx_urls_clicks ON urls(clicks DESC);
+
+-- Create index on created_at for listings and syncing recently created codes
+CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls(created_at);
+
+-- Track who created each link (NULL for anonymous links) and when it was last clicked
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner VARCHAR(64);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS last_clicked_at TIMESTAMP;
+
+-- Disabled links answer 410 Gone instead of redirecting
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+
+-- Who disabled a link: owner, admin, moderation or blocklist. Only links their owner
+-- archived can still be followed through share URLs.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_by VARCHAR(16);
+
+-- Create index for pruning inactive anonymous links
+CREATE INDEX IF NOT EXISTS idx_urls_anonymous_activity ON urls(COALESCE(last_clicked_at, created_at)) WHERE owner IS NULL;
+
+-- Allow custom aliases longer than generated codes
+ALTER TABLE urls ALTER COLUMN short_code TYPE VARCHAR(32);
+
+-- Free-form labels for grouping and filtering links
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+-- Create index for filtering links by tag
+CREATE INDEX IF NOT EXISTS idx_urls_tags ON urls USING GIN(tags);
+
+-- Store responses for POST requests retried with an Idempotency-Key header
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL,
+    response_body TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Per-click events, partitioned by month. Partitions named click_events_YYYY_MM are
+-- created ahead of time and dropped after CLICK_EVENTS_RETENTION_DAYS by the app.
+CREATE TABLE IF NOT EXISTS click_events (
+    id BIGSERIAL,
+    short_code VARCHAR(32) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    referrer_host TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_prefix TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, clicked_at)
+) PARTITION BY RANGE (clicked_at);
+
+-- Create index for per-link analytics over a time range
+CREATE INDEX IF NOT EXISTS idx_click_events_code_time ON click_events(short_code, clicked_at);
+
+-- Create index for exporting a link's events in id order
+CREATE INDEX IF NOT EXISTS idx_click_events_code_id ON click_events(short_code, id);
+
+-- Owner-defined click alerts: "clicks" fires once at a threshold, "inactivity" fires
+-- after window_days without a click and re-arms when the link is clicked again
+CREATE TABLE IF NOT EXISTS alerts (
+    id SERIAL PRIMARY KEY,
+    short_code VARCHAR(32) NOT NULL REFERENCES urls(short_code) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    threshold BIGINT NOT NULL DEFAULT 0,
+    window_days INTEGER NOT NULL DEFAULT 0,
+    email TEXT NOT NULL DEFAULT '',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    fired_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create index for listing a link's alerts
+CREATE INDEX IF NOT EXISTS idx_alerts_short_code ON alerts(short_code);
+
+-- Per-account digest subscriptions; last_period_end is the end of the last period sent
+CREATE TABLE IF NOT EXISTS digest_subscriptions (
+    owner VARCHAR(64) PRIMARY KEY,
+    frequency VARCHAR(16) NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    last_period_end TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Destinations normalized for duplicate detection, filled in by the app
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS normalized_url TEXT;
+
+-- Create index for grouping links by normalized destination
+CREATE INDEX IF NOT EXISTS idx_urls_normalized_url ON urls(normalized_url);
+
+-- Codes merged into another link redirect to it and credit their clicks to it
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS alias_of VARCHAR(32) REFERENCES urls(short_code) ON DELETE CASCADE;
+
+-- Create index for finding a link's aliases
+CREATE INDEX IF NOT EXISTS idx_urls_alias_of ON urls(alias_of) WHERE alias_of IS NOT NULL;
+
+-- Clicks per link and hour, kept longer than click_events for leaderboards and trends
+CREATE TABLE IF NOT EXISTS click_hourly (
+    short_code VARCHAR(32) NOT NULL,
+    hour TIMESTAMPTZ NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_code, hour)
+);
+
+-- Create index for ranking links over a recent period
+CREATE INDEX IF NOT EXISTS idx_click_hourly_hour ON click_hourly(hour);
+
+-- Store every timestamp as TIMESTAMPTZ. Existing values are taken to be UTC, the time
+-- zone of the official Postgres image; columns already converted are skipped.
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name FROM information_schema.columns
+        WHERE table_schema = current_schema()
+        AND data_type = 'timestamp without time zone'
+        AND (table_name, column_name) IN (
+            ('urls', 'created_at'), ('urls', 'last_clicked_at'), ('urls', 'disabled_at'),
+            ('idempotency_keys', 'created_at'), ('alerts', 'fired_at'), ('alerts', 'created_at'),
+            ('digest_subscriptions', 'created_at')
+        )
+    LOOP
+        -- The expression index mixes both urls columns and is rebuilt below
+        DROP INDEX IF EXISTS idx_urls_anonymous_activity;
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMPTZ USING %I AT TIME ZONE ''UTC''',
+            col.table_name, col.column_name, col.column_name);
+    END LOOP;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_urls_anonymous_activity ON urls(COALESCE(last_clicked_at, created_at)) WHERE owner IS NULL;
+
+-- Click IDs appended to destinations when conversion tracking is on
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS click_id TEXT NOT NULL DEFAULT '';
+
+-- Conversions reported by advertisers for a click ID, once per event name
+CREATE TABLE IF NOT EXISTS conversions (
+    id BIGSERIAL PRIMARY KEY,
+    short_code VARCHAR(32) NOT NULL REFERENCES urls(short_code) ON DELETE CASCADE,
+    click_id TEXT NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    value NUMERIC(14, 4) NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (click_id, event)
+);
+
+-- Create index for per-link conversion totals
+CREATE INDEX IF NOT EXISTS idx_conversions_short_code ON conversions(short_code);
+
+-- Destination thumbnails captured by the optional screenshot service
+CREATE TABLE IF NOT EXISTS screenshots (
+    short_code VARCHAR(32) PRIMARY KEY REFERENCES urls(short_code) ON DELETE CASCADE,
+    content_type VARCHAR(32) NOT NULL,
+    image BYTEA NOT NULL,
+    captured_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Titles and Open Graph data fetched from destinations, refreshed after METADATA_TTL.
+-- url holds the destination's normalized_url, a keyed hash when destinations are encrypted.
+CREATE TABLE IF NOT EXISTS destination_metadata (
+    url TEXT PRIMARY KEY,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image TEXT NOT NULL DEFAULT '',
+    site_name TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Create index for the background refresh of stale metadata
+CREATE INDEX IF NOT EXISTS idx_destination_metadata_fetched_at ON destination_metadata(fetched_at);
+
+-- Entries from before metadata was keyed by normalized_url hold the plaintext destination
+DELETE FROM destination_metadata WHERE url LIKE '%://%';
+
+-- Site icons served by the favicon proxy; a NULL image records a failed lookup
+CREATE TABLE IF NOT EXISTS favicons (
+    domain VARCHAR(253) PRIMARY KEY,
+    content_type VARCHAR(32) NOT NULL DEFAULT '',
+    image BYTEA,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Every edit of a link's destination or tags, version 1 being the link as created
+CREATE TABLE IF NOT EXISTS url_versions (
+    id BIGSERIAL PRIMARY KEY,
+    short_code VARCHAR(32) NOT NULL REFERENCES urls(short_code) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    original_url TEXT NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    edited_by VARCHAR(64) NOT NULL DEFAULT '',
+    rollback_of INTEGER,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (short_code, version)
+);
+
+-- Immutable links can never change destination, only be disabled
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS immutable BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Expiring links answer 410 Gone once expires_at has passed
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+-- Create indexes for filtering link listings by owner, destination domain and state
+CREATE INDEX IF NOT EXISTS idx_urls_owner_created_at ON urls(owner, created_at);
+CREATE INDEX IF NOT EXISTS idx_urls_domain ON urls((regexp_replace(lower(substring(original_url from '^[A-Za-z][A-Za-z0-9+.-]*://([^/?#:]+)')), '^www\.', '')));
+CREATE INDEX IF NOT EXISTS idx_urls_disabled_at ON urls(disabled_at) WHERE disabled_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Create index for sorting link listings by most recent click
+CREATE INDEX IF NOT EXISTS idx_urls_last_clicked_at ON urls(last_clicked_at DESC NULLS LAST);
+
+-- Create index for cursor pagination of link listings in creation order
+CREATE INDEX IF NOT EXISTS idx_urls_created_at_id ON urls(created_at, id);
+
+-- Create indexes for searching links by destination and by destination title and description
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_urls_original_url_trgm ON urls USING GIN(original_url gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_destination_metadata_text ON destination_metadata USING GIN(to_tsvector('simple', title || ' ' || description));
+
+-- Named link filters saved per API key and listed through GET /api/views/:id/urls
+CREATE TABLE IF NOT EXISTS saved_views (
+    id SERIAL PRIMARY KEY,
+    owner VARCHAR(64) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    filter JSONB NOT NULL DEFAULT '{}',
+    sort VARCHAR(16) NOT NULL DEFAULT '',
+    sort_order VARCHAR(4) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (owner, name)
+);
+
+-- Create index for resolving short codes regardless of case when CASE_INSENSITIVE_CODES is set
+CREATE INDEX IF NOT EXISTS idx_urls_short_code_lower ON urls(lower(short_code));
+
+-- Create index for refusing custom aliases that look like existing codes; the expression
+-- must match codeSkeletonSQL
+CREATE INDEX IF NOT EXISTS idx_urls_code_skeleton ON urls(translate(lower(short_code), '0i1', 'oll'));
+
+-- Domains and URLs listed by the threat feeds in BLOCKLIST_FEEDS; domains cover their subdomains
+CREATE TABLE IF NOT EXISTS blocklist (
+    kind VARCHAR(8) NOT NULL,
+    value TEXT NOT NULL,
+    source TEXT NOT NULL,
+    first_seen TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (kind, value, source)
+);
+
+-- Links disabled because a blocklist entry matched their destination, for GET /admin/blocklist
+CREATE TABLE IF NOT EXISTS blocklist_hits (
+    short_code VARCHAR(32) PRIMARY KEY REFERENCES urls(short_code) ON DELETE CASCADE,
+    kind VARCHAR(8) NOT NULL,
+    value TEXT NOT NULL,
+    source TEXT NOT NULL,
+    disabled_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_blocklist_hits_disabled_at ON blocklist_hits(disabled_at DESC);
+
+-- Destinations flagged as suspicious are shown behind a warning page instead of redirecting
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS flagged_reason TEXT;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS flagged_at TIMESTAMPTZ;
+
+-- Whether a blocklist entry disabled a link or flagged it with a warning
+ALTER TABLE blocklist_hits ADD COLUMN IF NOT EXISTS action VARCHAR(8) NOT NULL DEFAULT 'disable';
+
+-- Moderation state of links created while MODERATION is on: pending, approved or rejected
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS moderation VARCHAR(8);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_urls_pending ON urls(created_at, id) WHERE moderation = 'pending';
+
+-- Links created per API key or client IP in each UTC day and month, for QUOTA_TIERS
+CREATE TABLE IF NOT EXISTS creation_quota_usage (
+    subject TEXT NOT NULL,
+    period VARCHAR(5) NOT NULL,
+    period_start DATE NOT NULL,
+    links INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (subject, period, period_start)
+);
+
+-- Redirect status of links answering something other than 301, such as 307 or 308 for
+-- webhook aliases whose callers must repeat their method and body
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_status SMALLINT;
+
+-- Links redirecting with a page whose script keeps the fragment of the short URL
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS html_redirect BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Destination checks: the last result of each link and how many checks in a row failed.
+-- Links failing LINK_CHECK_FAILURES checks show an unavailable page until one succeeds.
+CREATE TABLE IF NOT EXISTS link_checks (
+    short_code VARCHAR(32) PRIMARY KEY REFERENCES urls(short_code) ON DELETE CASCADE,
+    checked_at TIMESTAMPTZ NOT NULL,
+    status INTEGER,
+    error TEXT,
+    failures INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_link_checks_checked_at ON link_checks(checked_at);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS unavailable_at TIMESTAMPTZ;
+
+-- Links redirecting to an Internet Archive snapshot while their destination is gone
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS wayback_fallback BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS archive_url TEXT;
+
+-- Links only signed-in visitors (private) or members of the owner's group (members) can follow
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS visibility VARCHAR(8);
+
+-- Addresses a link may be followed from; NULL allows everyone
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS allowed_networks CIDR[];
+
+-- Countries a link may be followed from, or is not available in; NULL allows everyone
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS allowed_countries VARCHAR(2)[];
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS blocked_countries VARCHAR(2)[];
+
+-- Visits turned away by a link's country rules, per country; ZZ when it is unknown
+CREATE TABLE IF NOT EXISTS geo_blocks (
+    short_code VARCHAR(32) NOT NULL REFERENCES urls(short_code) ON DELETE CASCADE,
+    country VARCHAR(2) NOT NULL,
+    blocked BIGINT NOT NULL DEFAULT 0,
+    last_blocked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (short_code, country)
+);
+
+-- Opening hours of links that only redirect at certain times, as a JSON schedule
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS schedule JSONB;
+
+-- Free-form JSON integrators attach to links, such as a CRM campaign ID
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS metadata JSONB;
+CREATE INDEX IF NOT EXISTS idx_urls_metadata ON urls USING GIN (metadata);
+
+-- Idempotency keys belong to the caller that sent them, an API key or a client address,
+-- so callers cannot replay or block each other's requests
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS caller VARCHAR(128) NOT NULL DEFAULT '';
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+        WHERE i.indrelid = 'idempotency_keys'::regclass AND i.indisprimary AND a.attname = 'caller'
+    ) THEN
+        ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+        ALTER TABLE idempotency_keys ADD PRIMARY KEY (caller, key);
+    END IF;
+END $$;
+
+-- Counts restores of links. Restored links may get ids below those the instances have
+-- already synced into their Bloom filters, so a change makes every instance rebuild its filter.
+CREATE TABLE IF NOT EXISTS restore_generation (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    generation BIGINT NOT NULL DEFAULT 0
+);
+
+-- The version of this file last applied, as reported by the schema health check. It is
+-- written by shorty migrate and AUTO_MIGRATE after the statements above succeed.
+CREATE TABLE IF NOT EXISTS schema_version (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    version CHAR(64) NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
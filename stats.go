@@ -0,0 +1,332 @@
+package shorty
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shorty/internal/analytics"
+)
+
+// Stats query limits
+const (
+	maxCompareCodes     = 10
+	maxStatsRange       = 366 * 24 * time.Hour
+	maxHourlyStatsRange = 14 * 24 * time.Hour
+	defaultStatsRange   = 30 * 24 * time.Hour
+	defaultTopLinks     = 10
+	maxTopLinks         = 100
+
+	// summaryTTL is how long GET /api/stats/summary serves a computed summary
+	summaryTTL = 30 * time.Second
+)
+
+// topPeriods are the windows accepted by GET /api/stats/top
+var topPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// statsRange is the time window and bucket size of a time-series stats request
+type statsRange struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+	Location *time.Location
+}
+
+// CompareResponse is the response of GET /api/stats/compare
+type CompareResponse struct {
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Interval string       `json:"interval"`
+	Timezone string       `json:"timezone"`
+	Buckets  []time.Time  `json:"buckets"`
+	Links    []LinkSeries `json:"links"`
+}
+
+// LinkSeries is one link's clicks per bucket, aligned with CompareResponse.Buckets
+type LinkSeries struct {
+	ShortCode string  `json:"short_code"`
+	Total     int64   `json:"total"`
+	Series    []int64 `json:"series"`
+}
+
+// TopResponse is the response of GET /api/stats/top
+type TopResponse struct {
+	Period string    `json:"period"`
+	Since  time.Time `json:"since"`
+	Links  []TopLink `json:"links"`
+}
+
+// StatsSummary is the response of GET /api/stats/summary
+type StatsSummary struct {
+	Links              int64                   `json:"links"`
+	Clicks             int64                   `json:"clicks"`
+	LinksCreatedToday  int64                   `json:"links_created_today"`
+	Timezone           string                  `json:"timezone"`
+	RedirectsPerMinute float64                 `json:"redirects_per_minute"`
+	Storage            map[string]TableStorage `json:"storage"`
+	DatabaseBytes      int64                   `json:"database_bytes"`
+	GeneratedAt        time.Time               `json:"generated_at"`
+}
+
+// TableStorage is the estimated size of one table, including its partitions and indexes
+type TableStorage struct {
+	Rows  int64 `json:"rows"`
+	Bytes int64 `json:"bytes"`
+}
+
+// summaryCache holds the last computed summary per time zone, shared by all callers for
+// summaryTTL
+var summaryCache = struct {
+	sync.Mutex
+	byZone map[string]StatsSummary
+}{byZone: map[string]StatsSummary{}}
+
+// maxCachedSummaryZones bounds summaryCache; it is emptied when full
+const maxCachedSummaryZones = 100
+
+// HeatmapResponse is the response of GET /api/stats/:code/heatmap. Clicks[d][h] counts
+// clicks on weekday d (0 = Monday) during hour h, in Timezone.
+type HeatmapResponse struct {
+	ShortCode string       `json:"short_code"`
+	From      time.Time    `json:"from"`
+	To        time.Time    `json:"to"`
+	Timezone  string       `json:"timezone"`
+	Weekdays  []string     `json:"weekdays"`
+	Clicks    [7][24]int64 `json:"clicks"`
+	ByWeekday [7]int64     `json:"by_weekday"`
+	ByHour    [24]int64    `json:"by_hour"`
+}
+
+// heatmapWeekdays labels the rows of a heatmap, ISO order
+var heatmapWeekdays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// parseTimezone reads the tz parameter, an IANA zone name defaulting to UTC
+func parseTimezone(c *gin.Context) (*time.Location, *FieldError) {
+	name := c.DefaultQuery("tz", "UTC")
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, &FieldError{Field: "tz", Rule: "timezone", Message: "tz must be an IANA time zone name such as Europe/Berlin"}
+	}
+	return loc, nil
+}
+
+// parseStatsRange reads from, to, interval and tz. from and to accept RFC 3339 timestamps
+// or dates, taken as midnight in tz; the window defaults to the last 30 days and is
+// bucketed by day, or by hour with interval=hour, in tz.
+func parseStatsRange(c *gin.Context) (statsRange, *FieldError) {
+	loc, fe := parseTimezone(c)
+	if fe != nil {
+		return statsRange{}, fe
+	}
+	r := statsRange{To: time.Now(), Interval: c.DefaultQuery("interval", "day"), Location: loc}
+
+	if v := c.Query("to"); v != "" {
+		t, ok := parseStatsTime(v, r.Location)
+		if !ok {
+			return r, &FieldError{Field: "to", Rule: "datetime", Message: "to must be an RFC 3339 timestamp or a YYYY-MM-DD date"}
+		}
+		r.To = t
+	}
+	r.From = r.To.Add(-defaultStatsRange)
+	if v := c.Query("from"); v != "" {
+		t, ok := parseStatsTime(v, r.Location)
+		if !ok {
+			return r, &FieldError{Field: "from", Rule: "datetime", Message: "from must be an RFC 3339 timestamp or a YYYY-MM-DD date"}
+		}
+		r.From = t
+	}
+
+	switch {
+	case r.Interval != "day" && r.Interval != "hour":
+		return r, &FieldError{Field: "interval", Rule: "oneof", Allowed: []string{"day", "hour"}, Message: "interval must be one of day, hour"}
+	case !r.From.Before(r.To):
+		return r, &FieldError{Field: "from", Rule: "ltfield", Message: "from must be before to"}
+	case r.To.Sub(r.From) > maxStatsRange:
+		return r, &FieldError{Field: "from", Rule: "max", Message: "the range must be at most 366 days"}
+	case r.Interval == "hour" && r.To.Sub(r.From) > maxHourlyStatsRange:
+		return r, &FieldError{Field: "interval", Rule: "max", Message: "hourly buckets are limited to 14 days"}
+	}
+
+	// Align the window to whole buckets
+	r.From = analytics.TruncateToBucket(r.From, r.Interval, r.Location)
+	if end := analytics.TruncateToBucket(r.To, r.Interval, r.Location); end.Before(r.To) {
+		r.To = analytics.NextBucket(end, r.Interval)
+	}
+	return r, nil
+}
+
+// parseStatsTime parses an RFC 3339 timestamp, or a date at midnight in loc
+func parseStatsTime(v string, loc *time.Location) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, v, loc); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// compareStats handles GET /api/stats/compare?codes=a,b,c&from=&to=&interval=day
+func compareStats(c *gin.Context) {
+	var codes []string
+	for _, code := range strings.Split(c.Query("codes"), ",") {
+		if code = strings.TrimSpace(code); code != "" && !containsString(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 || len(codes) > maxCompareCodes {
+		respondValidationError(c, FieldError{
+			Field:   "codes",
+			Rule:    "max",
+			Param:   fmt.Sprint(maxCompareCodes),
+			Message: fmt.Sprintf("codes must list between 1 and %d short codes", maxCompareCodes),
+		})
+		return
+	}
+	r, fe := parseStatsRange(c)
+	if fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	states, err := lookupLinkStates(ctx, codes)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch stats")
+		return
+	}
+	for _, code := range codes {
+		state, ok := states[code]
+		if !ok || !visibleTo(c, state.Owner, state.Visibility) || !reachableBy(c, state.Owner, state.AllowedNetworks, state.AllowedCountries, state.BlockedCountries) {
+			respondError(c, http.StatusNotFound, "URL not found: "+code)
+			return
+		}
+	}
+
+	counts, err := clickSeries(ctx, codes, r)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch stats")
+		return
+	}
+
+	resp := CompareResponse{From: r.From, To: r.To, Interval: r.Interval, Timezone: r.Location.String(), Buckets: analytics.Buckets(r.From, r.To, r.Interval)}
+	for _, code := range codes {
+		series := LinkSeries{ShortCode: code, Series: make([]int64, len(resp.Buckets))}
+		for i, bucket := range resp.Buckets {
+			series.Series[i] = counts[code][bucket.Unix()]
+			series.Total += series.Series[i]
+		}
+		resp.Links = append(resp.Links, series)
+	}
+	jsonWithETag(c, http.StatusOK, resp)
+}
+
+// topStats handles GET /api/stats/top?period=7d&limit=10. Callers with an API key see
+// their own links; anonymous callers see the whole instance.
+func topStats(c *gin.Context) {
+	period := c.DefaultQuery("period", "7d")
+	window, ok := topPeriods[period]
+	if !ok {
+		respondValidationError(c, FieldError{Field: "period", Rule: "oneof", Allowed: []string{"24h", "7d", "30d"}, Message: "period must be one of 24h, 7d, 30d"})
+		return
+	}
+	limit := defaultTopLinks
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, maxTopLinks)
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	since := time.Now().UTC().Add(-window).Truncate(time.Hour)
+	links, err := topLinksSince(ctx, linkFilter{Owner: owner, Visible: visibilityScope(c)}, since, limit)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch top links")
+		return
+	}
+	for i := range links {
+		links[i].ShortURL = buildShortURL(c, links[i].ShortCode)
+	}
+	jsonWithETag(c, http.StatusOK, TopResponse{Period: period, Since: since, Links: links})
+}
+
+// statsSummary handles GET /api/stats/summary?tz=, the instance-wide numbers for a status
+// page. "Today" starts at midnight in tz. The summary is recomputed at most every 30
+// seconds.
+func statsSummary(c *gin.Context) {
+	loc, fe := parseTimezone(c)
+	if fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+
+	summaryCache.Lock()
+	defer summaryCache.Unlock()
+
+	summary, ok := summaryCache.byZone[loc.String()]
+	if !ok || time.Since(summary.GeneratedAt) > summaryTTL {
+		ctx, cancel := dbContext(c)
+		defer cancel()
+
+		now := time.Now().UTC()
+		var err error
+		summary, err = loadStatsSummary(ctx, analytics.TruncateToBucket(now, "day", loc), now)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to compute summary")
+			return
+		}
+		summary.Timezone = loc.String()
+		summary.GeneratedAt = now
+		if len(summaryCache.byZone) >= maxCachedSummaryZones {
+			clear(summaryCache.byZone)
+		}
+		summaryCache.byZone[loc.String()] = summary
+	}
+	jsonWithETag(c, http.StatusOK, summary)
+}
+
+// clickHeatmap handles GET /api/stats/:code/heatmap?tz=Europe/Berlin&from=&to=
+func clickHeatmap(c *gin.Context) {
+	r, fe := parseStatsRange(c)
+	if fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	u, err := getURL(ctx, c.Param("code"))
+	if err == nil && (!visibleTo(c, u.Owner, u.Visibility) || !reachableBy(c, u.Owner, u.AllowedNetworks, u.AllowedCountries, u.BlockedCountries)) {
+		err = errNotFound
+	}
+	if err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to fetch URL")
+		return
+	}
+	resp := HeatmapResponse{ShortCode: u.ShortCode, From: r.From, To: r.To, Timezone: r.Location.String(), Weekdays: heatmapWeekdays}
+	resp.Clicks, err = clickHeatmapCounts(ctx, u.ShortCode, r.From, r.To, r.Location)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch stats")
+		return
+	}
+	for d := range resp.Clicks {
+		for h, n := range resp.Clicks[d] {
+			resp.ByWeekday[d] += n
+			resp.ByHour[h] += n
+		}
+	}
+	jsonWithETag(c, http.StatusOK, resp)
+}
@@ -0,0 +1,2503 @@
+package shorty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// errNotFound is returned when a short code does not exist
+	errNotFound = errors.New("not found")
+
+	// errDisabled is returned when a short code exists but has been disabled by an operator
+	errDisabled = errors.New("disabled")
+
+	// errExpired is returned when a short code exists but its expiry time has passed
+	errExpired = errors.New("expired")
+)
+
+// lookupGroup coalesces concurrent lookups of the same short code into one query
+var lookupGroup singleflight.Group
+
+// All database access goes through these functions so that cross-cutting concerns such as
+// the circuit breaker and replica routing apply uniformly. Reads use readQuery; writes go
+// to the primary through dbBreaker.
+
+// resolvedLink is what a redirect needs to know about a short code
+type resolvedLink struct {
+	// Code is the stored code, which differs from the one asked for only in case under
+	// CASE_INSENSITIVE_CODES
+	Code        string
+	Destination string
+
+	// Warning is why the destination is flagged as suspicious; visitors are shown a
+	// warning page instead of being redirected
+	Warning string
+
+	// Status is the link's redirect status, or 0 for the default 301
+	Status int
+
+	// HTMLRedirect sends GET requests on with a page rather than a Location header
+	HTMLRedirect bool
+
+	// Archived is set when Destination is a Wayback snapshot standing in for a dead one
+	Archived bool
+
+	// Owner and Visibility decide who may resolve the link
+	Owner      string
+	Visibility string
+
+	// Shared is set when the link was resolved through a signed share URL
+	Shared bool
+
+	// AllowedNetworks are the only addresses the link may be followed from, if any
+	AllowedNetworks []netip.Prefix
+
+	// AllowedCountries are the only countries the link may be followed from, if any;
+	// visitors from BlockedCountries are turned away
+	AllowedCountries []string
+	BlockedCountries []string
+
+	// Schedule limits the hours the link redirects in, if set
+	Schedule *LinkSchedule
+}
+
+// lookupDestination returns where a short code leads, serving hot codes from the
+// in-process cache. On a miss, concurrent callers for the same code share a single
+// query, so a viral link costs one lookup at a time rather than one per visitor. The
+// shared query is detached from any one caller's cancellation.
+func lookupDestination(ctx context.Context, code string) (resolvedLink, error) {
+	if link, ok := destinationCache.Get(code); ok {
+		return link, nil
+	}
+	if !knownCodes.MightContain(ctx, code) {
+		return resolvedLink{}, errNotFound
+	}
+
+	result := lookupGroup.DoChan(codeKey(code), func() (any, error) {
+		queryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg().DBQueryTimeout)
+		defer cancel()
+
+		link, expiresAt, err := queryDestination(queryCtx, code, false)
+		// Links expiring before a cache entry would are not cached
+		if err == nil && (expiresAt == nil || time.Until(*expiresAt) > cfg().CacheTTL) {
+			destinationCache.Set(link)
+		}
+		return link, err
+	})
+
+	select {
+	case r := <-result:
+		return r.Val.(resolvedLink), r.Err
+	case <-ctx.Done():
+		return resolvedLink{}, ctx.Err()
+	}
+}
+
+// lookupSharedDestination resolves a code for a signed share URL, which leads past the
+// link being archived by its owner, but not past an admin, moderation or blocklist
+// disable. It skips the cache, which only holds enabled links.
+func lookupSharedDestination(ctx context.Context, code string) (resolvedLink, error) {
+	link, _, err := queryDestination(ctx, code, true)
+	return link, err
+}
+
+// queryDestination reads a code's link from the database and checks that it can be
+// followed, returning when it expires. Links their owner disabled are followed only when
+// includeDisabled is set; links disabled by anyone else never are.
+func queryDestination(ctx context.Context, code string, includeDisabled bool) (resolvedLink, *time.Time, error) {
+	var link resolvedLink
+	var disabled, takenDown, pending, unavailable bool
+	var archiveURL string
+	var expiresAt *time.Time
+	err := readQuery(func(q querier) error {
+		query := queryLookupURL
+		if cfg().CaseInsensitiveCodes {
+			query = queryLookupURLFolded
+		}
+		return q.QueryRow(ctx, query, code).Scan(&link.Code, &link.Destination, &disabled, &expiresAt, &link.Warning, &pending, &link.Status, &link.HTMLRedirect, &unavailable, &archiveURL, &link.Owner, &link.Visibility, &link.AllowedNetworks, &link.AllowedCountries, &link.BlockedCountries, &link.Schedule, &takenDown)
+	})
+	if err != nil {
+		return resolvedLink{}, nil, notFound(err)
+	}
+	if link.Destination, err = openURL(link.Destination); err != nil {
+		return resolvedLink{}, nil, err
+	}
+	if archiveURL, err = openURL(archiveURL); err != nil {
+		return resolvedLink{}, nil, err
+	}
+	if disabled && (!includeDisabled || takenDown) {
+		return resolvedLink{}, nil, errDisabled
+	}
+	if pending {
+		if holdPending() {
+			return resolvedLink{}, nil, errPending
+		}
+		if link.Warning == "" {
+			link.Warning = pendingWarning
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return resolvedLink{}, nil, errExpired
+	}
+	// Unavailable links with wayback_fallback lead to the archived page once one is known
+	if unavailable {
+		if archiveURL == "" {
+			return resolvedLink{}, nil, errUnavailable
+		}
+		link.Destination, link.Archived = archiveURL, true
+	}
+	return link, expiresAt, nil
+}
+
+// linkState is a short code's destination, whether it is disabled and who may see it
+// from where
+type linkState struct {
+	OriginalURL      string
+	Disabled         bool
+	Owner            string
+	Visibility       string
+	AllowedNetworks  []netip.Prefix
+	AllowedCountries []string
+	BlockedCountries []string
+}
+
+// lookupLinkStates resolves many short codes in one query. Unknown codes are absent from
+// the result.
+func lookupLinkStates(ctx context.Context, codes []string) (map[string]linkState, error) {
+	states := make(map[string]linkState, len(codes))
+	if len(codes) == 0 {
+		return states, nil
+	}
+	err := readQuery(func(q querier) error {
+		rows, err := q.Query(ctx,
+			"SELECT short_code, original_url, disabled_at IS NOT NULL OR expires_at <= NOW() IS TRUE, COALESCE(owner, ''), COALESCE(visibility, ''), allowed_networks, allowed_countries, blocked_countries FROM urls WHERE short_code = ANY($1)",
+			codes,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var code string
+			var state linkState
+			if err := rows.Scan(&code, &state.OriginalURL, &state.Disabled, &state.Owner, &state.Visibility, &state.AllowedNetworks, &state.AllowedCountries, &state.BlockedCountries); err != nil {
+				return err
+			}
+			openURLs(&state.OriginalURL)
+			states[code] = state
+		}
+		return rows.Err()
+	})
+	return states, err
+}
+
+// findCodeByURL returns the existing short code for an original URL and its moderation
+// state. Encrypted destinations cannot be compared, so none is found while encryption is
+// on.
+func findCodeByURL(ctx context.Context, originalURL string) (string, string, error) {
+	if urlEncryptionEnabled() {
+		return "", "", errNotFound
+	}
+	var code, moderation string
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx, "SELECT short_code, COALESCE(moderation, '') FROM urls WHERE original_url = $1 AND expires_at IS NULL AND redirect_status IS NULL AND NOT html_redirect AND NOT wayback_fallback AND visibility IS NULL AND allowed_networks IS NULL AND allowed_countries IS NULL AND blocked_countries IS NULL AND schedule IS NULL AND metadata IS NULL", originalURL).Scan(&code, &moderation)
+	})
+	return code, moderation, notFound(err)
+}
+
+// insertURL stores a new short code; an empty Owner marks an anonymous link
+func insertURL(ctx context.Context, u URL) error {
+	if u.Tags == nil {
+		u.Tags = []string{}
+	}
+	err := dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, queryInsertURL, u.ShortCode, sealURL(u.OriginalURL), destinationIndex(u.OriginalURL), u.Owner, u.Tags, isImmutable(u), u.ExpiresAt, u.Moderation, u.RedirectStatus, u.HTMLRedirect, u.WaybackFallback, u.Visibility, u.AllowedNetworks, u.AllowedCountries, u.BlockedCountries, u.Schedule, u.Metadata)
+		return err
+	})
+	if err == nil {
+		knownCodes.Add(u.ShortCode)
+	}
+	return err
+}
+
+// addClicks adds counts[i] clicks to codes[i] and to the current hour's totals in a
+// single statement
+func addClicks(ctx context.Context, codes []string, counts []int64) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, queryAddClicks, codes, counts)
+		return err
+	})
+}
+
+// insertClickEvents bulk copies click events into the partitioned click_events table
+func insertClickEvents(ctx context.Context, events []clickEvent) error {
+	return dbBreaker.call(func() error {
+		_, err := db.CopyFrom(ctx,
+			pgx.Identifier{"click_events"},
+			[]string{"short_code", "clicked_at", "referrer_host", "user_agent", "ip_prefix", "click_id"},
+			pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+				e := events[i]
+				return []any{e.ShortCode, e.ClickedAt, e.ReferrerHost, e.UserAgent, e.IPPrefix, e.ClickID}, nil
+			}),
+		)
+		return err
+	})
+}
+
+// addGeoBlocks adds visits turned away by country rules to each link's per-country totals.
+// Codes deleted since the visit are skipped.
+func addGeoBlocks(ctx context.Context, blocked map[geoBlock]int64) error {
+	codes := make([]string, 0, len(blocked))
+	countries := make([]string, 0, len(blocked))
+	counts := make([]int64, 0, len(blocked))
+	for key, n := range blocked {
+		codes = append(codes, key.ShortCode)
+		countries = append(countries, key.Country)
+		counts = append(counts, n)
+	}
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			INSERT INTO geo_blocks (short_code, country, blocked, last_blocked_at)
+			SELECT b.code, b.country, b.n, NOW()
+			FROM unnest($1::text[], $2::text[], $3::bigint[]) AS b(code, country, n)
+			JOIN urls u ON u.short_code = b.code
+			ON CONFLICT (short_code, country) DO UPDATE
+			SET blocked = geo_blocks.blocked + EXCLUDED.blocked, last_blocked_at = EXCLUDED.last_blocked_at`,
+			codes, countries, counts)
+		return err
+	})
+}
+
+// geoBlockCounts returns how many visits to a link its country rules turned away, per
+// country
+func geoBlockCounts(ctx context.Context, code string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	err := readQuery(func(q querier) error {
+		clear(counts)
+		rows, err := q.Query(ctx, "SELECT country, blocked FROM geo_blocks WHERE short_code = $1", code)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var country string
+			var n int64
+			if err := rows.Scan(&country, &n); err != nil {
+				return err
+			}
+			counts[country] = n
+		}
+		return rows.Err()
+	})
+	return counts, err
+}
+
+// createClickPartition creates the click_events partition covering [from, to) if it is missing
+func createClickPartition(ctx context.Context, name string, from, to time.Time) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF click_events FOR VALUES FROM ('%s') TO ('%s')",
+			pgx.Identifier{name}.Sanitize(), from.Format(time.RFC3339), to.Format(time.RFC3339),
+		))
+		return err
+	})
+}
+
+// listClickPartitions returns the names of the partitions attached to click_events
+func listClickPartitions(ctx context.Context) ([]string, error) {
+	var names []string
+	err := dbBreaker.call(func() error {
+		names = nil
+		rows, err := db.Query(ctx, `
+			SELECT child.relname FROM pg_inherits
+			JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+			JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+			WHERE parent.relname = 'click_events'`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return rows.Err()
+	})
+	return names, err
+}
+
+// countClickEvents returns the number of events stored in one click_events partition
+func countClickEvents(ctx context.Context, partition string) (int64, error) {
+	var count int64
+	err := dbBreaker.call(func() error {
+		return db.QueryRow(ctx, "SELECT COUNT(*) FROM "+pgx.Identifier{partition}.Sanitize()).Scan(&count)
+	})
+	return count, err
+}
+
+// deleteClickEventsBefore deletes up to limit click events recorded before cutoff
+func deleteClickEventsBefore(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	var removed int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, `
+			DELETE FROM click_events WHERE (id, clicked_at) IN (
+				SELECT id, clicked_at FROM click_events WHERE clicked_at < $1 LIMIT $2
+			)`,
+			cutoff, limit,
+		)
+		removed = result.RowsAffected()
+		return err
+	})
+	return removed, err
+}
+
+// deleteInactiveAnonymousURLs deletes up to limit links without an owner whose last click,
+// or creation if never clicked, is before cutoff, returning their codes
+func deleteInactiveAnonymousURLs(ctx context.Context, cutoff time.Time, limit int) ([]string, error) {
+	var codes []string
+	err := dbBreaker.call(func() error {
+		codes = nil
+		rows, err := db.Query(ctx, `
+			DELETE FROM urls WHERE id IN (
+				SELECT id FROM urls
+				WHERE owner IS NULL AND alias_of IS NULL AND NOT immutable AND COALESCE(last_clicked_at, created_at) < $1
+				LIMIT $2
+			) RETURNING short_code`,
+			cutoff, limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				return err
+			}
+			codes = append(codes, code)
+		}
+		return rows.Err()
+	})
+	return codes, err
+}
+
+// deleteIdempotencyKeysBefore deletes idempotency keys created before cutoff
+func deleteIdempotencyKeysBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	var removed int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1", cutoff)
+		removed = result.RowsAffected()
+		return err
+	})
+	return removed, err
+}
+
+// dropClickPartition drops a click_events partition and all events in it
+func dropClickPartition(ctx context.Context, name string) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{name}.Sanitize())
+		return err
+	})
+}
+
+// urlColumns are the columns read by scanURL, in order
+const urlColumns = "id, short_code, original_url, clicks, created_at, last_clicked_at, COALESCE(owner, ''), tags, COALESCE(alias_of, ''), immutable, expires_at, COALESCE(flagged_reason, ''), COALESCE(moderation, ''), COALESCE(redirect_status, 0), html_redirect, unavailable_at, wayback_fallback, COALESCE(archive_url, ''), COALESCE(visibility, ''), allowed_networks, allowed_countries, blocked_countries, schedule, metadata"
+
+// scanURL reads a row selected with urlColumns
+func scanURL(row pgx.Row, u *URL) error {
+	err := row.Scan(&u.ID, &u.ShortCode, &u.OriginalURL, &u.Clicks, &u.CreatedAt, &u.LastClickedAt, &u.Owner, &u.Tags, &u.AliasOf, &u.Immutable, &u.ExpiresAt, &u.FlaggedReason, &u.Moderation, &u.RedirectStatus, &u.HTMLRedirect, &u.UnavailableSince, &u.WaybackFallback, &u.ArchiveURL, &u.Visibility, &u.AllowedNetworks, &u.AllowedCountries, &u.BlockedCountries, &u.Schedule, &u.Metadata)
+	openURLs(&u.OriginalURL, &u.ArchiveURL)
+	u.CreatedAt = u.CreatedAt.UTC()
+	if u.ExpiresAt != nil {
+		expiresAt := u.ExpiresAt.UTC()
+		u.ExpiresAt = &expiresAt
+	}
+	return err
+}
+
+// confusableCode returns an existing code other than code with the same codeSkeleton,
+// or "" if there is none
+func confusableCode(ctx context.Context, code string) (string, error) {
+	var similar string
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx, "SELECT short_code FROM urls WHERE "+codeSkeletonSQL+" = $1 AND short_code <> $2 LIMIT 1", codeSkeleton(code), code).Scan(&similar)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return similar, err
+}
+
+// listCaseCollisions returns the groups of short codes that are equal ignoring case,
+// oldest first within each group
+func listCaseCollisions(ctx context.Context) ([][]string, error) {
+	var groups [][]string
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, `
+			SELECT array_agg(short_code ORDER BY id) FROM urls
+			GROUP BY lower(short_code) HAVING COUNT(*) > 1
+			ORDER BY lower(short_code)`)
+		if err != nil {
+			return err
+		}
+		groups, err = pgx.CollectRows(rows, pgx.RowTo[[]string])
+		return err
+	})
+	return groups, err
+}
+
+// listShadowedCodes returns the short codes that equal one of segments, ignoring case
+func listShadowedCodes(ctx context.Context, segments []string) ([]string, error) {
+	var codes []string
+	err := readQuery(func(q querier) error {
+		rows, err := q.Query(ctx, "SELECT short_code FROM urls WHERE lower(short_code) = ANY($1) ORDER BY short_code", segments)
+		if err != nil {
+			return err
+		}
+		codes, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	return codes, err
+}
+
+// codeExists reports whether a short code is in use
+func codeExists(ctx context.Context, code string) (bool, error) {
+	if !knownCodes.MightContain(ctx, code) {
+		return false, nil
+	}
+	var exists bool
+	err := dbBreaker.call(func() error {
+		query := "SELECT EXISTS (SELECT 1 FROM urls WHERE short_code = $1)"
+		if cfg().CaseInsensitiveCodes {
+			query = "SELECT EXISTS (SELECT 1 FROM urls WHERE lower(short_code) = lower($1))"
+		}
+		return db.QueryRow(ctx, query, code).Scan(&exists)
+	})
+	return exists, err
+}
+
+// getURL returns the full record for a short code
+func getURL(ctx context.Context, code string) (URL, error) {
+	var u URL
+	err := readQuery(func(q querier) error {
+		return scanURL(q.QueryRow(ctx, "SELECT "+urlColumns+" FROM urls WHERE short_code = $1", code), &u)
+	})
+	return u, notFound(err)
+}
+
+// listRecentURLs returns a page of the links matching filter, most recently created first
+func listRecentURLs(ctx context.Context, filter linkFilter, orderBy string, limit, offset int) ([]URL, error) {
+	where, args := filter.where([]any{limit, offset})
+	var urls []URL
+	err := readQuery(func(q querier) error {
+		urls = []URL{}
+		rows, err := q.Query(ctx,
+			"SELECT "+urlColumns+" FROM urls WHERE "+where+" ORDER BY "+orderBy+" LIMIT $1 OFFSET $2",
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u URL
+			if err := scanURL(rows, &u); err != nil {
+				continue
+			}
+			urls = append(urls, u)
+		}
+		return rows.Err()
+	})
+	return urls, err
+}
+
+// searchLinks returns the links matching filter whose destination contains query or
+// whose destination title and description contain its words, ranked by how well they
+// match. Both conditions use their own index before the link filters apply.
+func searchLinks(ctx context.Context, query string, filter linkFilter, limit int) ([]SearchResult, error) {
+	where, args := filter.where([]any{query, likePattern(query), limit})
+	var results []SearchResult
+	err := readQuery(func(q querier) error {
+		results = []SearchResult{}
+		rows, err := q.Query(ctx, `
+			WITH matches AS (
+				SELECT short_code FROM urls WHERE original_url ILIKE $2
+				UNION
+				SELECT u.short_code FROM destination_metadata m JOIN urls u ON u.normalized_url = m.url
+				WHERE to_tsvector('simple', m.title || ' ' || m.description) @@ websearch_to_tsquery('simple', $1)
+			)
+			SELECT `+urlColumns+`, COALESCE(title, ''), COALESCE(description, ''),
+				COALESCE(ts_rank(to_tsvector('simple', title || ' ' || description), websearch_to_tsquery('simple', $1)), 0)
+					+ word_similarity($1, original_url) AS rank
+			FROM urls JOIN matches USING (short_code) LEFT JOIN destination_metadata ON url = normalized_url
+			WHERE `+where+`
+			ORDER BY rank DESC, id DESC
+			LIMIT $3`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r SearchResult
+			if err := scanURL(extraColumns{rows, []any{&r.Title, &r.Description, &r.Rank}}, &r.URL); err != nil {
+				return err
+			}
+			results = append(results, r)
+		}
+		return rows.Err()
+	})
+	return results, err
+}
+
+// extraColumns scans a row selected with more columns than a scan function reads into
+// dest after the others
+type extraColumns struct {
+	pgx.Row
+	dest []any
+}
+
+func (r extraColumns) Scan(dest ...any) error {
+	return r.Row.Scan(append(dest, r.dest...)...)
+}
+
+// countLinks tallies the links matching filter by state
+func countLinks(ctx context.Context, filter linkFilter) (LinkCounts, error) {
+	where, args := filter.where(nil)
+	var counts LinkCounts
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx,
+			"SELECT COUNT(*), COUNT(*) FILTER (WHERE "+stateSQL(stateActive)+"), COUNT(*) FILTER (WHERE "+stateSQL(stateExpired)+"), COUNT(*) FILTER (WHERE "+stateSQL(stateArchived)+") FROM urls WHERE "+where,
+			args...,
+		).Scan(&counts.Total, &counts.Active, &counts.Expired, &counts.Archived)
+	})
+	return counts, err
+}
+
+// countURLs returns the number of stored short codes
+func countURLs(ctx context.Context) (int64, error) {
+	var count int64
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx, "SELECT COUNT(*) FROM urls").Scan(&count)
+	})
+	return count, err
+}
+
+// eachShortCode calls fn with the id and short code of every link with an id above
+// afterID. It always reads from the primary so replication lag cannot hide freshly
+// created codes.
+func eachShortCode(ctx context.Context, afterID int64, fn func(id int64, code string)) error {
+	return dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, "SELECT id, short_code FROM urls WHERE id > $1", afterID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			var code string
+			if err := rows.Scan(&id, &code); err != nil {
+				return err
+			}
+			fn(id, code)
+		}
+		return rows.Err()
+	})
+}
+
+// restoreGeneration returns how many restores have inserted links, 0 before the first
+// or while the schema predates the count
+func restoreGeneration(ctx context.Context) (int64, error) {
+	var generation int64
+	err := dbBreaker.call(func() error {
+		return db.QueryRow(ctx, "SELECT generation FROM restore_generation").Scan(&generation)
+	})
+	if errors.Is(err, pgx.ErrNoRows) || isUndefinedTable(err) {
+		return 0, nil
+	}
+	return generation, err
+}
+
+// countRestore bumps the restore generation in the restore's transaction, so instances
+// see the restored codes and the new generation together. A snapshot may restore a
+// schema that predates the count, which is then left alone.
+func countRestore(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF to_regclass('restore_generation') IS NOT NULL THEN
+				INSERT INTO restore_generation (id, generation) VALUES (TRUE, 1)
+				ON CONFLICT (id) DO UPDATE SET generation = restore_generation.generation + 1;
+			END IF;
+		END $$`)
+	return err
+}
+
+// listHotCodes returns the n most clicked and n most recently created codes
+func listHotCodes(ctx context.Context, n int) ([]string, error) {
+	var codes []string
+	err := readQuery(func(q querier) error {
+		rows, err := q.Query(ctx, `
+			(SELECT short_code FROM urls ORDER BY created_at DESC LIMIT $1)
+			UNION
+			(SELECT short_code FROM urls ORDER BY clicks DESC LIMIT $1)`,
+			n,
+		)
+		if err != nil {
+			return err
+		}
+		codes, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	return codes, err
+}
+
+// backupColumns are the urls columns read into a backupRecord by scanBackupRecord,
+// followed by the link's hourly clicks as a JSON array
+const backupColumns = `short_code, original_url, COALESCE(owner, ''), tags, clicks, created_at, last_clicked_at,
+	disabled_at, COALESCE(disabled_by, ''), COALESCE(alias_of, ''), immutable, expires_at,
+	COALESCE(flagged_reason, ''), flagged_at, COALESCE(moderation, ''), moderated_at,
+	COALESCE(redirect_status, 0), html_redirect, unavailable_at, wayback_fallback, COALESCE(archive_url, ''),
+	COALESCE(visibility, ''), allowed_networks, allowed_countries, blocked_countries, schedule, metadata,
+	(SELECT json_agg(json_build_object('hour', h.hour, 'clicks', h.clicks) ORDER BY h.hour)
+		FROM click_hourly h WHERE h.short_code = urls.short_code)`
+
+// scanBackupRecord reads a row selected with backupColumns
+func scanBackupRecord(row pgx.Row, r *backupRecord) error {
+	err := row.Scan(&r.ShortCode, &r.OriginalURL, &r.Owner, &r.Tags, &r.Clicks, &r.CreatedAt, &r.LastClickedAt,
+		&r.DisabledAt, &r.DisabledBy, &r.AliasOf, &r.Immutable, &r.ExpiresAt,
+		&r.FlaggedReason, &r.FlaggedAt, &r.Moderation, &r.ModeratedAt,
+		&r.RedirectStatus, &r.HTMLRedirect, &r.UnavailableAt, &r.WaybackFallback, &r.ArchiveURL,
+		&r.Visibility, &r.AllowedNetworks, &r.AllowedCountries, &r.BlockedCountries, &r.Schedule, &r.Metadata,
+		&r.Hourly)
+	openURLs(&r.OriginalURL, &r.ArchiveURL)
+	return err
+}
+
+// eachBackupRecord calls fn with every link. It reads from the primary in a single
+// statement so the export is a consistent snapshot. Links are ordered by id, so the
+// target of an alias always comes before the alias.
+func eachBackupRecord(ctx context.Context, fn func(r backupRecord) error) error {
+	return dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, "SELECT "+backupColumns+" FROM urls ORDER BY id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r backupRecord
+			if err := scanBackupRecord(rows, &r); err != nil {
+				return err
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// restoreBackupRecords inserts links from a backup in one transaction, skipping codes
+// that already exist, and returns how many were inserted. The hourly clicks of a link
+// are only restored along with the link.
+func restoreBackupRecords(ctx context.Context, records []backupRecord) (int64, error) {
+	var inserted int64
+	err := dbBreaker.call(func() error {
+		inserted = 0
+		return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+			for _, r := range records {
+				if r.Tags == nil {
+					r.Tags = []string{}
+				}
+				result, err := tx.Exec(ctx, `
+					INSERT INTO urls (short_code, original_url, normalized_url, owner, tags, clicks, created_at, last_clicked_at,
+						disabled_at, disabled_by, alias_of, immutable, expires_at, flagged_reason, flagged_at,
+						moderation, moderated_at, redirect_status, html_redirect, unavailable_at, wayback_fallback,
+						archive_url, visibility, allowed_networks, allowed_countries, blocked_countries, schedule, metadata)
+					VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8,
+						$9, NULLIF($10, ''), NULLIF($11, ''), $12, $13, NULLIF($14, ''), $15,
+						NULLIF($16, ''), $17, NULLIF($18, 0), $19, $20, $21,
+						NULLIF($22, ''), NULLIF($23, ''), $24, $25, $26, $27, $28)
+					ON CONFLICT (short_code) DO NOTHING`,
+					r.ShortCode, sealURL(r.OriginalURL), destinationIndex(r.OriginalURL), r.Owner, r.Tags, r.Clicks, r.CreatedAt, r.LastClickedAt,
+					r.DisabledAt, r.DisabledBy, r.AliasOf, r.Immutable, r.ExpiresAt, r.FlaggedReason, r.FlaggedAt,
+					r.Moderation, r.ModeratedAt, r.RedirectStatus, r.HTMLRedirect, r.UnavailableAt, r.WaybackFallback,
+					sealURL(r.ArchiveURL), r.Visibility, r.AllowedNetworks, r.AllowedCountries, r.BlockedCountries, r.Schedule, r.Metadata,
+				)
+				if err != nil {
+					return err
+				}
+				if result.RowsAffected() == 0 {
+					continue
+				}
+				inserted++
+
+				if len(r.Hourly) == 0 {
+					continue
+				}
+				hours := make([]time.Time, len(r.Hourly))
+				clicks := make([]int64, len(r.Hourly))
+				for i, h := range r.Hourly {
+					hours[i], clicks[i] = h.Hour, h.Clicks
+				}
+				_, err = tx.Exec(ctx, `
+					INSERT INTO click_hourly (short_code, hour, clicks)
+					SELECT $1::text, * FROM unnest($2::timestamptz[], $3::bigint[])
+					ON CONFLICT (short_code, hour) DO NOTHING`,
+					r.ShortCode, hours, clicks,
+				)
+				if err != nil {
+					return err
+				}
+			}
+			if inserted == 0 {
+				return nil
+			}
+			return countRestore(ctx, tx)
+		})
+	})
+	if err == nil {
+		for _, r := range records {
+			knownCodes.Add(r.ShortCode)
+		}
+	}
+	return inserted, err
+}
+
+// setURLDisabled disables or re-enables a short code
+func setURLDisabled(ctx context.Context, code string, disabled bool) error {
+	defer invalidateWithAliases(ctx, code)
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx,
+			"UPDATE urls SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, disabled_by = CASE WHEN $2 THEN 'admin' END WHERE short_code = $1",
+			code, disabled,
+		)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// urlTakenDown reports whether a link, or the link it is an alias of, was disabled by an
+// administrator, moderation or a blocklist rather than by its owner
+func urlTakenDown(ctx context.Context, code string) (bool, error) {
+	var takenDown bool
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM urls u LEFT JOIN urls t ON t.short_code = u.alias_of
+				WHERE u.short_code = $1
+					AND ((u.disabled_at IS NOT NULL AND u.disabled_by IS DISTINCT FROM 'owner')
+						OR (t.disabled_at IS NOT NULL AND t.disabled_by IS DISTINCT FROM 'owner'))
+			)`,
+			code,
+		).Scan(&takenDown)
+	})
+	return takenDown, err
+}
+
+// moderateURLs approves or rejects pending links, disabling rejected ones. It returns the
+// codes that were pending.
+func moderateURLs(ctx context.Context, codes []string, state string) ([]string, error) {
+	var applied []string
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, `
+			UPDATE urls SET
+				moderation = $2,
+				moderated_at = NOW(),
+				disabled_at = CASE WHEN $2 = 'rejected' THEN COALESCE(disabled_at, NOW()) ELSE disabled_at END,
+				disabled_by = CASE WHEN $2 = 'rejected' THEN 'moderation' ELSE disabled_by END
+			WHERE short_code = ANY($1) AND moderation = 'pending'
+			RETURNING short_code`,
+			codes, state,
+		)
+		if err != nil {
+			return err
+		}
+		applied, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	if len(applied) > 0 {
+		invalidateWithAliases(ctx, applied...)
+	}
+	return applied, err
+}
+
+// setURLPending puts a link back in the moderation queue
+func setURLPending(ctx context.Context, code string) error {
+	defer invalidateWithAliases(ctx, code)
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, "UPDATE urls SET moderation = 'pending', moderated_at = NULL WHERE short_code = $1", code)
+		return err
+	})
+}
+
+// listPendingURLs returns how many links await moderation and the oldest of them
+func listPendingURLs(ctx context.Context, limit int) (int64, []URL, error) {
+	var total int64
+	var links []URL
+	err := readQuery(func(q querier) error {
+		links = []URL{}
+		if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM urls WHERE moderation = 'pending'").Scan(&total); err != nil {
+			return err
+		}
+		rows, err := q.Query(ctx, "SELECT "+urlColumns+" FROM urls WHERE moderation = 'pending' ORDER BY created_at, id LIMIT $1", limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u URL
+			if err := scanURL(rows, &u); err != nil {
+				return err
+			}
+			links = append(links, u)
+		}
+		return rows.Err()
+	})
+	return total, links, err
+}
+
+// reserveQuota counts one link creation by subject in the current day and month and
+// returns the new totals
+func reserveQuota(ctx context.Context, subject string, periods quotaPeriods) (int64, int64, error) {
+	var day, month int64
+	err := dbBreaker.call(func() error {
+		return db.QueryRow(ctx, `
+			WITH counted AS (
+				INSERT INTO creation_quota_usage (subject, period, period_start, links)
+				VALUES ($1, 'day', $2, 1), ($1, 'month', $3, 1)
+				ON CONFLICT (subject, period, period_start) DO UPDATE SET links = creation_quota_usage.links + 1
+				RETURNING period, links
+			)
+			SELECT COALESCE(MAX(links) FILTER (WHERE period = 'day'), 0), COALESCE(MAX(links) FILTER (WHERE period = 'month'), 0)
+			FROM counted`,
+			subject, periods.Day, periods.Month,
+		).Scan(&day, &month)
+	})
+	return day, month, err
+}
+
+// releaseQuota returns a creation counted by reserveQuota that did not create a link
+func releaseQuota(ctx context.Context, subject string, periods quotaPeriods) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			UPDATE creation_quota_usage SET links = GREATEST(links - 1, 0)
+			WHERE subject = $1 AND ((period = 'day' AND period_start = $2) OR (period = 'month' AND period_start = $3))`,
+			subject, periods.Day, periods.Month,
+		)
+		return err
+	})
+}
+
+// quotaUsage returns how many links subject created in the current day and month
+func quotaUsage(ctx context.Context, subject string, periods quotaPeriods) (int64, int64, error) {
+	var day, month int64
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT COALESCE(SUM(links) FILTER (WHERE period = 'day' AND period_start = $2), 0),
+				COALESCE(SUM(links) FILTER (WHERE period = 'month' AND period_start = $3), 0)
+			FROM creation_quota_usage WHERE subject = $1`,
+			subject, periods.Day, periods.Month,
+		).Scan(&day, &month)
+	})
+	return day, month, err
+}
+
+// deleteQuotaUsageBefore removes quota counters of periods that started before cutoff
+func deleteQuotaUsageBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	var deleted int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM creation_quota_usage WHERE period_start < $1", cutoff)
+		deleted = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// setURLFlag flags a short code's destination as suspicious for reason, or clears the
+// flag when reason is empty
+func setURLFlag(ctx context.Context, code, reason string) error {
+	defer invalidateWithAliases(ctx, code)
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx,
+			"UPDATE urls SET flagged_reason = NULLIF($2, ''), flagged_at = CASE WHEN $2 <> '' THEN COALESCE(flagged_at, NOW()) END WHERE short_code = $1",
+			code, reason,
+		)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// claimLinksToCheck returns up to limit active links whose destination was last checked
+// before checkedBefore, oldest first, marking them checked now so that other instances
+// skip them
+func claimLinksToCheck(ctx context.Context, checkedBefore time.Time, limit int) ([]linkCheckTarget, error) {
+	var targets []linkCheckTarget
+	err := dbBreaker.call(func() error {
+		targets = nil
+		rows, err := db.Query(ctx, `
+			WITH claimed AS (
+				INSERT INTO link_checks (short_code, checked_at)
+				SELECT u.short_code, NOW() FROM urls u LEFT JOIN link_checks k ON k.short_code = u.short_code
+				WHERE u.alias_of IS NULL AND u.disabled_at IS NULL AND (u.expires_at IS NULL OR u.expires_at > NOW())
+					AND u.moderation IS DISTINCT FROM 'pending' AND (k.checked_at IS NULL OR k.checked_at < $1)
+				ORDER BY k.checked_at NULLS FIRST, u.id
+				LIMIT $2
+				ON CONFLICT (short_code) DO UPDATE SET checked_at = EXCLUDED.checked_at WHERE link_checks.checked_at < $1
+				RETURNING short_code
+			)
+			SELECT u.short_code, u.original_url, COALESCE(u.owner, ''), u.unavailable_at IS NOT NULL, u.wayback_fallback, COALESCE(u.archive_url, '')
+			FROM urls u JOIN claimed USING (short_code)`,
+			checkedBefore, limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t linkCheckTarget
+			if err := rows.Scan(&t.ShortCode, &t.OriginalURL, &t.Owner, &t.Unavailable, &t.WaybackFallback, &t.ArchiveURL); err != nil {
+				return err
+			}
+			openURLs(&t.OriginalURL, &t.ArchiveURL)
+			targets = append(targets, t)
+		}
+		return rows.Err()
+	})
+	return targets, err
+}
+
+// saveLinkCheck records the result of a destination check and returns how many checks
+// in a row have now failed
+func saveLinkCheck(ctx context.Context, code string, result linkCheckResult) (int, error) {
+	var failures int
+	err := dbBreaker.call(func() error {
+		return db.QueryRow(ctx, `
+			UPDATE link_checks SET checked_at = NOW(), status = NULLIF($2, 0), error = NULLIF($3, ''),
+				failures = CASE WHEN $4 THEN failures + 1 ELSE 0 END
+			WHERE short_code = $1
+			RETURNING failures`,
+			code, result.Status, result.Error, result.dead(),
+		).Scan(&failures)
+	})
+	return failures, notFound(err)
+}
+
+// setURLUnavailable switches a link to or from showing the unavailable page. A recovered
+// link forgets its Wayback snapshot.
+func setURLUnavailable(ctx context.Context, code string, unavailable bool) error {
+	defer invalidateWithAliases(ctx, code)
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			UPDATE urls SET unavailable_at = CASE WHEN $2 THEN COALESCE(unavailable_at, NOW()) END,
+				archive_url = CASE WHEN $2 THEN archive_url END
+			WHERE short_code = $1`,
+			code, unavailable,
+		)
+		return err
+	})
+}
+
+// setURLArchive stores the Wayback snapshot an unavailable link redirects to
+func setURLArchive(ctx context.Context, code, archiveURL string) error {
+	defer invalidateWithAliases(ctx, code)
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, "UPDATE urls SET archive_url = $2 WHERE short_code = $1", code, sealURL(archiveURL))
+		return err
+	})
+}
+
+// resetLinkCheck forgets the check results of a link whose destination changed
+func resetLinkCheck(ctx context.Context, code string) error {
+	defer invalidateWithAliases(ctx, code)
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, "WITH reset AS (DELETE FROM link_checks WHERE short_code = $1) UPDATE urls SET unavailable_at = NULL, archive_url = NULL WHERE short_code = $1", code)
+		return err
+	})
+}
+
+// saveLinkVersion changes a link's destination and tags and records the result as its next
+// version, first recording the link as created if it was never edited. With lock the link
+// also becomes immutable. It returns the new version number.
+func saveLinkVersion(ctx context.Context, code string, change LinkVersion, lock bool) (int, error) {
+	if change.Tags == nil {
+		change.Tags = []string{}
+	}
+	var version int
+	err := dbBreaker.call(func() error {
+		return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+			var aliasOf *string
+			var destination string
+			var immutable bool
+			err := tx.QueryRow(ctx,
+				"SELECT alias_of, original_url, immutable FROM urls WHERE short_code = $1 FOR UPDATE", code,
+			).Scan(&aliasOf, &destination, &immutable)
+			if err != nil {
+				return notFound(err)
+			}
+			if destination, err = openURL(destination); err != nil {
+				return err
+			}
+			if aliasOf != nil {
+				return errEditAlias
+			}
+			if immutable && change.OriginalURL != destination {
+				return errImmutable
+			}
+
+			_, err = tx.Exec(ctx, `
+				INSERT INTO url_versions (short_code, version, original_url, tags, edited_by, created_at)
+				SELECT short_code, 1, original_url, tags, COALESCE(owner, ''), created_at
+				FROM urls WHERE short_code = $1
+				ON CONFLICT (short_code, version) DO NOTHING`,
+				code,
+			)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx,
+				"UPDATE urls SET original_url = $2, normalized_url = $3, tags = $4, immutable = immutable OR $5 WHERE short_code = $1",
+				code, sealURL(change.OriginalURL), destinationIndex(change.OriginalURL), change.Tags, lock,
+			)
+			if err != nil {
+				return err
+			}
+			return tx.QueryRow(ctx, `
+				INSERT INTO url_versions (short_code, version, original_url, tags, edited_by, rollback_of)
+				SELECT $1, MAX(version) + 1, $2, $3, $4, $5 FROM url_versions WHERE short_code = $1
+				RETURNING version`,
+				code, sealURL(change.OriginalURL), change.Tags, change.EditedBy, change.RollbackOf,
+			).Scan(&version)
+		})
+	})
+	if err == nil {
+		invalidateWithAliases(ctx, code)
+	}
+	return version, err
+}
+
+// setURLImmutable makes a link immutable; there is no way back
+func setURLImmutable(ctx context.Context, code string) error {
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "UPDATE urls SET immutable = TRUE WHERE short_code = $1", code)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// setURLMetadata replaces a link's metadata; nil removes it
+func setURLMetadata(ctx context.Context, code string, metadata map[string]any) error {
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "UPDATE urls SET metadata = $2 WHERE short_code = $1", code, metadata)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// linkVersionColumns are the columns read by scanLinkVersion, in order
+const linkVersionColumns = "version, original_url, tags, edited_by, rollback_of, created_at"
+
+func scanLinkVersion(row pgx.Row, v *LinkVersion) error {
+	err := row.Scan(&v.Version, &v.OriginalURL, &v.Tags, &v.EditedBy, &v.RollbackOf, &v.CreatedAt)
+	openURLs(&v.OriginalURL)
+	return err
+}
+
+// listLinkVersions returns a link's recorded versions, newest first
+func listLinkVersions(ctx context.Context, code string) ([]LinkVersion, error) {
+	var versions []LinkVersion
+	err := readQuery(func(q querier) error {
+		versions = []LinkVersion{}
+		rows, err := q.Query(ctx,
+			"SELECT "+linkVersionColumns+" FROM url_versions WHERE short_code = $1 ORDER BY version DESC", code,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var v LinkVersion
+			if err := scanLinkVersion(rows, &v); err != nil {
+				return err
+			}
+			versions = append(versions, v)
+		}
+		return rows.Err()
+	})
+	return versions, err
+}
+
+// getLinkVersion returns one recorded version of a link
+func getLinkVersion(ctx context.Context, code string, version int) (LinkVersion, error) {
+	var v LinkVersion
+	err := readQuery(func(q querier) error {
+		return scanLinkVersion(q.QueryRow(ctx,
+			"SELECT "+linkVersionColumns+" FROM url_versions WHERE short_code = $1 AND version = $2", code, version,
+		), &v)
+	})
+	return v, notFound(err)
+}
+
+// listOwnerCodes returns up to limit codes of an owner's links matching filter, whose
+// own Owner is ignored
+func listOwnerCodes(ctx context.Context, owner string, filter linkFilter, limit int) ([]string, error) {
+	filter.Owner = owner
+	where, args := filter.where([]any{limit})
+	var codes []string
+	err := readQuery(func(q querier) error {
+		rows, err := q.Query(ctx, "SELECT short_code FROM urls WHERE "+where+" ORDER BY id LIMIT $1", args...)
+		if err != nil {
+			return err
+		}
+		codes, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	return codes, err
+}
+
+// bulkLinkStates returns which of codes belong to owner, keyed by code
+func bulkLinkStates(ctx context.Context, owner string, codes []string) (map[string]bulkLinkState, error) {
+	var states map[string]bulkLinkState
+	err := readQuery(func(q querier) error {
+		states = make(map[string]bulkLinkState, len(codes))
+		rows, err := q.Query(ctx,
+			"SELECT short_code, immutable, alias_of IS NOT NULL FROM urls WHERE owner = $1 AND short_code = ANY($2)",
+			owner, codes,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var code string
+			var state bulkLinkState
+			if err := rows.Scan(&code, &state.Immutable, &state.Alias); err != nil {
+				return err
+			}
+			states[code] = state
+		}
+		return rows.Err()
+	})
+	return states, err
+}
+
+// deleteOwnerURLs deletes those of codes that belong to owner and are not immutable,
+// returning the deleted codes
+func deleteOwnerURLs(ctx context.Context, owner string, codes []string) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	invalidateWithAliases(ctx, codes...)
+	var deleted []string
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx,
+			"DELETE FROM urls WHERE owner = $1 AND short_code = ANY($2) AND NOT immutable RETURNING short_code",
+			owner, codes,
+		)
+		if err != nil {
+			return err
+		}
+		deleted, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	for _, code := range deleted {
+		destinationCache.Invalidate(code)
+	}
+	return deleted, err
+}
+
+// setOwnerURLsDisabled disables or re-enables those of codes that belong to owner,
+// returning the codes updated
+func setOwnerURLsDisabled(ctx context.Context, owner string, codes []string, disabled bool) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	var updated []string
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, `
+			UPDATE urls SET disabled_at = CASE WHEN $3 THEN COALESCE(disabled_at, NOW()) END,
+				disabled_by = CASE WHEN $3 AND disabled_at IS NULL THEN 'owner' WHEN $3 THEN disabled_by END
+			WHERE owner = $1 AND short_code = ANY($2)
+			RETURNING short_code`,
+			owner, codes, disabled,
+		)
+		if err != nil {
+			return err
+		}
+		updated, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	if err == nil {
+		invalidateWithAliases(ctx, updated...)
+	}
+	return updated, err
+}
+
+// retagURLs removes and then adds tags on those of codes that belong to owner, recording
+// each changed link as a new version. Links that would end up with more than
+// maxLinkTags tags, and aliases, are left out. It returns the codes whose tags now match
+// the request, changed or not.
+func retagURLs(ctx context.Context, owner string, codes, add, remove []string) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	var applied []string
+	err := dbBreaker.call(func() error {
+		applied = nil
+		return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+			rows, err := tx.Query(ctx, `
+				SELECT short_code, original_url, tags FROM urls
+				WHERE owner = $1 AND short_code = ANY($2) AND alias_of IS NULL
+				FOR UPDATE`,
+				owner, codes,
+			)
+			if err != nil {
+				return err
+			}
+			type link struct {
+				code, destination string
+				tags              []string
+			}
+			links, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (link, error) {
+				var l link
+				err := row.Scan(&l.code, &l.destination, &l.tags)
+				return l, err
+			})
+			if err != nil {
+				return err
+			}
+
+			batch := &pgx.Batch{}
+			for _, l := range links {
+				tags := make([]string, 0, len(l.tags)+len(add))
+				for _, tag := range l.tags {
+					if !containsString(remove, tag) {
+						tags = append(tags, tag)
+					}
+				}
+				for _, tag := range add {
+					if !containsString(tags, tag) {
+						tags = append(tags, tag)
+					}
+				}
+				if len(tags) > maxLinkTags {
+					continue
+				}
+				applied = append(applied, l.code)
+				if slices.Equal(tags, l.tags) {
+					continue
+				}
+
+				batch.Queue(`
+					INSERT INTO url_versions (short_code, version, original_url, tags, edited_by, created_at)
+					SELECT short_code, 1, original_url, tags, COALESCE(owner, ''), created_at
+					FROM urls WHERE short_code = $1
+					ON CONFLICT (short_code, version) DO NOTHING`, l.code)
+				batch.Queue("UPDATE urls SET tags = $2 WHERE short_code = $1", l.code, tags)
+				batch.Queue(`
+					INSERT INTO url_versions (short_code, version, original_url, tags, edited_by)
+					SELECT $1, MAX(version) + 1, $2, $3, $4 FROM url_versions WHERE short_code = $1`,
+					l.code, l.destination, tags, owner)
+			}
+			if batch.Len() == 0 {
+				return nil
+			}
+			return tx.SendBatch(ctx, batch).Close()
+		})
+	})
+	return applied, err
+}
+
+// tagCounts returns every tag with the number of links carrying it, most used first,
+// counting only owner's links unless owner is empty
+func tagCounts(ctx context.Context, owner string) ([]TagCount, error) {
+	var tags []TagCount
+	err := readQuery(func(q querier) error {
+		tags = []TagCount{}
+		rows, err := q.Query(ctx, `
+			SELECT tag, COUNT(*) FROM urls, unnest(tags) AS tag
+			WHERE $1 = '' OR owner = $1
+			GROUP BY tag ORDER BY COUNT(*) DESC, tag`,
+			owner,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t TagCount
+			if err := rows.Scan(&t.Tag, &t.Links); err != nil {
+				return err
+			}
+			tags = append(tags, t)
+		}
+		return rows.Err()
+	})
+	return tags, err
+}
+
+// savedViewColumns are the columns read by scanSavedView, in order
+const savedViewColumns = "id, name, filter, sort, sort_order, created_at"
+
+// scanSavedView reads a row selected with savedViewColumns
+func scanSavedView(row pgx.Row, v *SavedView) error {
+	return row.Scan(&v.ID, &v.Name, &v.Filter, &v.Sort, &v.Order, &v.CreatedAt)
+}
+
+// insertSavedView stores a new view of owner, filling in its ID and creation time
+func insertSavedView(ctx context.Context, owner string, v *SavedView) error {
+	return dbBreaker.call(func() error {
+		return db.QueryRow(ctx,
+			"INSERT INTO saved_views (owner, name, filter, sort, sort_order) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+			owner, v.Name, v.Filter, v.Sort, v.Order,
+		).Scan(&v.ID, &v.CreatedAt)
+	})
+}
+
+// listSavedViews returns owner's views by name
+func listSavedViews(ctx context.Context, owner string) ([]SavedView, error) {
+	views := []SavedView{}
+	err := readQuery(func(q querier) error {
+		rows, err := q.Query(ctx, "SELECT "+savedViewColumns+" FROM saved_views WHERE owner = $1 ORDER BY name", owner)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var v SavedView
+			if err := scanSavedView(rows, &v); err != nil {
+				return err
+			}
+			views = append(views, v)
+		}
+		return rows.Err()
+	})
+	return views, err
+}
+
+// getSavedView returns one of owner's views
+func getSavedView(ctx context.Context, owner string, id int) (SavedView, error) {
+	var v SavedView
+	err := readQuery(func(q querier) error {
+		return scanSavedView(q.QueryRow(ctx, "SELECT "+savedViewColumns+" FROM saved_views WHERE id = $1 AND owner = $2", id, owner), &v)
+	})
+	return v, notFound(err)
+}
+
+// deleteSavedView removes one of owner's views
+func deleteSavedView(ctx context.Context, owner string, id int) error {
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM saved_views WHERE id = $1 AND owner = $2", id, owner)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// alertColumns are the columns read by scanAlert, in order
+const alertColumns = "id, short_code, kind, threshold, window_days, email, webhook_url, fired_at, created_at"
+
+// scanAlert reads a row selected with alertColumns
+func scanAlert(row pgx.Row, a *Alert) error {
+	return row.Scan(&a.ID, &a.ShortCode, &a.Kind, &a.Threshold, &a.WindowDays, &a.Email, &a.WebhookURL, &a.FiredAt, &a.CreatedAt)
+}
+
+// insertAlert stores a new alert, filling in its ID and creation time
+func insertAlert(ctx context.Context, a *Alert) error {
+	return dbBreaker.call(func() error {
+		return db.QueryRow(ctx, `
+			INSERT INTO alerts (short_code, kind, threshold, window_days, email, webhook_url)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at`,
+			a.ShortCode, a.Kind, a.Threshold, a.WindowDays, a.Email, a.WebhookURL,
+		).Scan(&a.ID, &a.CreatedAt)
+	})
+}
+
+// listAlerts returns the alerts defined on a short code
+func listAlerts(ctx context.Context, code string) ([]Alert, error) {
+	alerts := []Alert{}
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, "SELECT "+alertColumns+" FROM alerts WHERE short_code = $1 ORDER BY id", code)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var a Alert
+			if err := scanAlert(rows, &a); err != nil {
+				return err
+			}
+			alerts = append(alerts, a)
+		}
+		return rows.Err()
+	})
+	return alerts, err
+}
+
+// deleteAlert removes one of a short code's alerts
+func deleteAlert(ctx context.Context, code string, id int) error {
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM alerts WHERE id = $1 AND short_code = $2", id, code)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// listDueAlerts returns alerts whose condition currently holds and that have not fired
+// for it yet: clicks alerts fire once, inactivity alerts re-arm after the next click
+func listDueAlerts(ctx context.Context) ([]dueAlert, error) {
+	var due []dueAlert
+	err := dbBreaker.call(func() error {
+		due = nil
+		rows, err := db.Query(ctx, `
+			SELECT a.id, a.short_code, a.kind, a.threshold, a.window_days, a.email, a.webhook_url, a.fired_at, a.created_at,
+				u.original_url, u.clicks, COALESCE(u.last_clicked_at, u.created_at)
+			FROM alerts a JOIN urls u ON u.short_code = a.short_code
+			WHERE (a.kind = 'clicks' AND a.fired_at IS NULL AND u.clicks >= a.threshold)
+				OR (a.kind = 'inactivity'
+					AND COALESCE(u.last_clicked_at, u.created_at) < NOW() - make_interval(days => a.window_days)
+					AND (a.fired_at IS NULL OR a.fired_at < COALESCE(u.last_clicked_at, u.created_at)))
+			ORDER BY a.id
+			LIMIT 1000`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var d dueAlert
+			if err := rows.Scan(&d.ID, &d.ShortCode, &d.Kind, &d.Threshold, &d.WindowDays, &d.Email, &d.WebhookURL, &d.FiredAt, &d.CreatedAt,
+				&d.OriginalURL, &d.Clicks, &d.LastActivity); err != nil {
+				return err
+			}
+			openURLs(&d.OriginalURL)
+			due = append(due, d)
+		}
+		return rows.Err()
+	})
+	return due, err
+}
+
+// claimAlert marks an alert fired if nobody else has since it was read, reporting
+// whether this caller won the claim
+func claimAlert(ctx context.Context, id int, previous *time.Time) (bool, error) {
+	claimed := false
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx,
+			"UPDATE alerts SET fired_at = NOW() WHERE id = $1 AND fired_at IS NOT DISTINCT FROM $2",
+			id, previous,
+		)
+		claimed = result.RowsAffected() == 1
+		return err
+	})
+	return claimed, err
+}
+
+// findDigestSubscription returns an owner's digest subscription
+func findDigestSubscription(ctx context.Context, owner string) (DigestSubscription, error) {
+	var sub DigestSubscription
+	err := dbBreaker.call(func() error {
+		return db.QueryRow(ctx,
+			"SELECT frequency, email, webhook_url, last_period_end FROM digest_subscriptions WHERE owner = $1",
+			owner,
+		).Scan(&sub.Frequency, &sub.Email, &sub.WebhookURL, &sub.LastPeriodEnd)
+	})
+	return sub, notFound(err)
+}
+
+// listDigestSubscriptions returns every digest subscription keyed by owner
+func listDigestSubscriptions(ctx context.Context) (map[string]DigestSubscription, error) {
+	subs := map[string]DigestSubscription{}
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, "SELECT owner, frequency, email, webhook_url, last_period_end FROM digest_subscriptions")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var owner string
+			var sub DigestSubscription
+			if err := rows.Scan(&owner, &sub.Frequency, &sub.Email, &sub.WebhookURL, &sub.LastPeriodEnd); err != nil {
+				return err
+			}
+			subs[owner] = sub
+		}
+		return rows.Err()
+	})
+	return subs, err
+}
+
+// saveDigestSubscription creates or replaces an owner's digest subscription
+func saveDigestSubscription(ctx context.Context, owner string, sub DigestSubscription) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			INSERT INTO digest_subscriptions (owner, frequency, email, webhook_url)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (owner) DO UPDATE
+			SET frequency = EXCLUDED.frequency, email = EXCLUDED.email, webhook_url = EXCLUDED.webhook_url`,
+			owner, sub.Frequency, sub.Email, sub.WebhookURL,
+		)
+		return err
+	})
+}
+
+// removeDigestSubscription deletes an owner's digest subscription
+func removeDigestSubscription(ctx context.Context, owner string) error {
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM digest_subscriptions WHERE owner = $1", owner)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// claimDigestPeriod records that the digest for the period ending at end is being sent,
+// reporting false if it already was
+func claimDigestPeriod(ctx context.Context, owner string, end time.Time) (bool, error) {
+	claimed := false
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx,
+			"UPDATE digest_subscriptions SET last_period_end = $2 WHERE owner = $1 AND (last_period_end IS NULL OR last_period_end < $2)",
+			owner, end,
+		)
+		claimed = result.RowsAffected() == 1
+		return err
+	})
+	return claimed, err
+}
+
+// ownerClickTotals counts an owner's clicks in [start, end) and [previousStart, start),
+// and the links they created in [start, end)
+func ownerClickTotals(ctx context.Context, owner string, previousStart, start, end time.Time) (current, previous, newLinks int64, err error) {
+	err = readQuery(func(q querier) error {
+		err := q.QueryRow(ctx, `
+			SELECT COUNT(*) FILTER (WHERE e.clicked_at >= $3), COUNT(*) FILTER (WHERE e.clicked_at < $3)
+			FROM click_events e JOIN urls u ON u.short_code = e.short_code
+			WHERE u.owner = $1 AND e.clicked_at >= $2 AND e.clicked_at < $4`,
+			owner, previousStart, start, end,
+		).Scan(&current, &previous)
+		if err != nil {
+			return err
+		}
+		return q.QueryRow(ctx,
+			"SELECT COUNT(*) FROM urls WHERE owner = $1 AND created_at >= $2 AND created_at < $3",
+			owner, start, end,
+		).Scan(&newLinks)
+	})
+	return current, previous, newLinks, err
+}
+
+// ownerTopLinks returns an owner's most clicked links in [start, end)
+func ownerTopLinks(ctx context.Context, owner string, start, end time.Time, limit int) ([]TopLink, error) {
+	var links []TopLink
+	err := readQuery(func(q querier) error {
+		links = []TopLink{}
+		rows, err := q.Query(ctx, `
+			SELECT u.short_code, u.original_url, COUNT(*) AS n
+			FROM click_events e JOIN urls u ON u.short_code = e.short_code
+			WHERE u.owner = $1 AND e.clicked_at >= $2 AND e.clicked_at < $3
+			GROUP BY u.short_code, u.original_url
+			ORDER BY n DESC, u.short_code
+			LIMIT $4`,
+			owner, start, end, limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var l TopLink
+			if err := rows.Scan(&l.ShortCode, &l.OriginalURL, &l.Clicks); err != nil {
+				return err
+			}
+			openURLs(&l.OriginalURL)
+			links = append(links, l)
+		}
+		return rows.Err()
+	})
+	return links, err
+}
+
+// invalidateWithAliases drops codes and the codes merged into them from the destination
+// cache, since aliases resolve through their canonical link
+func invalidateWithAliases(ctx context.Context, codes ...string) {
+	for _, code := range codes {
+		destinationCache.Invalidate(code)
+	}
+	dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, "SELECT short_code FROM urls WHERE alias_of = ANY($1)", codes)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var alias string
+			if err := rows.Scan(&alias); err != nil {
+				return err
+			}
+			destinationCache.Invalidate(alias)
+		}
+		return rows.Err()
+	})
+}
+
+// listUnnormalizedURLs returns up to limit links whose normalized_url is not yet set
+func listUnnormalizedURLs(ctx context.Context, limit int) (ids []int, destinations []string, err error) {
+	err = dbBreaker.call(func() error {
+		ids, destinations = nil, nil
+		rows, err := db.Query(ctx, "SELECT id, original_url FROM urls WHERE normalized_url IS NULL LIMIT $1", limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			var destination string
+			if err := rows.Scan(&id, &destination); err != nil {
+				return err
+			}
+			openURLs(&destination)
+			ids = append(ids, id)
+			destinations = append(destinations, destination)
+		}
+		return rows.Err()
+	})
+	return ids, destinations, err
+}
+
+// resealDestinations encrypts up to limit destinations of table (urls or url_versions)
+// that are not yet encrypted with the current key, returning how many it changed. In
+// urls the Wayback snapshot URL is resealed too. Rows are addressed by ctid, which is
+// stable while they are locked.
+func resealDestinations(ctx context.Context, table string, limit int) (int, error) {
+	var n int
+	err := dbBreaker.call(func() error {
+		n = 0
+		return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+			query := "SELECT ctid::text, original_url, '' FROM " + pgx.Identifier{table}.Sanitize() + " WHERE original_url NOT LIKE $1 LIMIT $2 FOR UPDATE"
+			if table == "urls" {
+				query = "SELECT ctid::text, original_url, COALESCE(archive_url, '') FROM urls WHERE original_url NOT LIKE $1 OR archive_url NOT LIKE $1 LIMIT $2 FOR UPDATE"
+			}
+			rows, err := tx.Query(ctx, query, encryptedPrefix+urlKeys.current.id+":%", limit)
+			if err != nil {
+				return err
+			}
+			type row struct{ ctid, stored, archive string }
+			found, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (row, error) {
+				var found row
+				err := r.Scan(&found.ctid, &found.stored, &found.archive)
+				return found, err
+			})
+			if err != nil {
+				return err
+			}
+
+			batch := &pgx.Batch{}
+			for _, r := range found {
+				destination, err := openURL(r.stored)
+				if err != nil {
+					return err
+				}
+				if table == "urls" {
+					archive, err := openURL(r.archive)
+					if err != nil {
+						return err
+					}
+					batch.Queue("UPDATE urls SET original_url = $2, normalized_url = $3, archive_url = NULLIF($4, '') WHERE ctid = $1::tid", r.ctid, sealURL(destination), destinationIndex(destination), sealURL(archive))
+				} else {
+					batch.Queue("UPDATE "+pgx.Identifier{table}.Sanitize()+" SET original_url = $2 WHERE ctid = $1::tid", r.ctid, sealURL(destination))
+				}
+			}
+			n = len(found)
+			if batch.Len() == 0 {
+				return nil
+			}
+			return tx.SendBatch(ctx, batch).Close()
+		})
+	})
+	return n, err
+}
+
+// setNormalizedURLs stores normalized[i] for the link with ids[i]
+func setNormalizedURLs(ctx context.Context, ids []int, normalized []string) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx,
+			"UPDATE urls SET normalized_url = b.n FROM unnest($1::int[], $2::text[]) AS b(id, n) WHERE urls.id = b.id",
+			ids, normalized,
+		)
+		return err
+	})
+}
+
+// listDuplicateGroups returns sets of an owner's links that share a normalized
+// destination, largest first. Codes already merged into another link are left out.
+func listDuplicateGroups(ctx context.Context, limit int) ([]DuplicateGroup, error) {
+	var groups []DuplicateGroup
+	err := readQuery(func(q querier) error {
+		groups = []DuplicateGroup{}
+		rows, err := q.Query(ctx, `
+			SELECT COALESCE(owner, ''), normalized_url, array_agg(short_code ORDER BY created_at, id), SUM(clicks)
+			FROM urls
+			WHERE normalized_url IS NOT NULL AND alias_of IS NULL
+			GROUP BY owner, normalized_url
+			HAVING COUNT(*) > 1
+			ORDER BY COUNT(*) DESC, SUM(clicks) DESC
+			LIMIT $1`,
+			limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var g DuplicateGroup
+			if err := rows.Scan(&g.Owner, &g.NormalizedURL, &g.Codes, &g.TotalClicks); err != nil {
+				return err
+			}
+			groups = append(groups, g)
+		}
+		return rows.Err()
+	})
+	return groups, err
+}
+
+// mergeURLs turns duplicates of canonical with the same owner into aliases of it: their
+// clicks, click events and alerts move to canonical and they redirect wherever canonical
+// does. It returns the codes that were merged.
+func mergeURLs(ctx context.Context, canonical string, duplicates []string) ([]string, error) {
+	var merged []string
+	err := dbBreaker.call(func() error {
+		merged = nil
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		var aliasOf *string
+		err = tx.QueryRow(ctx, "SELECT alias_of FROM urls WHERE short_code = $1 FOR UPDATE", canonical).Scan(&aliasOf)
+		if err != nil {
+			return notFound(err)
+		}
+		if aliasOf != nil {
+			return errMergeIntoAlias
+		}
+
+		rows, err := tx.Query(ctx, `
+			WITH dups AS (
+				SELECT d.short_code, d.clicks FROM urls d, urls c
+				WHERE c.short_code = $1 AND d.short_code = ANY($2) AND d.short_code <> $1
+				AND d.alias_of IS NULL AND NOT d.immutable AND d.owner IS NOT DISTINCT FROM c.owner
+				FOR UPDATE OF d
+			)
+			UPDATE urls SET alias_of = $1, clicks = 0
+			FROM dups WHERE urls.short_code = dups.short_code
+			RETURNING urls.short_code, dups.clicks`,
+			canonical, duplicates,
+		)
+		if err != nil {
+			return err
+		}
+		var clicks int64
+		for rows.Next() {
+			var code string
+			var n int64
+			if err := rows.Scan(&code, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			merged = append(merged, code)
+			clicks += n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(merged) == 0 {
+			return tx.Commit(ctx)
+		}
+
+		if _, err := tx.Exec(ctx, "UPDATE urls SET clicks = clicks + $2 WHERE short_code = $1", canonical, clicks); err != nil {
+			return err
+		}
+		for _, sql := range []string{
+			"UPDATE urls SET alias_of = $1 WHERE alias_of = ANY($2)",
+			"UPDATE click_events SET short_code = $1 WHERE short_code = ANY($2)",
+			"UPDATE alerts SET short_code = $1 WHERE short_code = ANY($2)",
+		} {
+			if _, err := tx.Exec(ctx, sql, canonical, merged); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+	for _, code := range merged {
+		destinationCache.Invalidate(code)
+	}
+	return merged, err
+}
+
+// clickSeries counts click events per link and bucket in r, keyed by short code and the
+// Unix time of the bucket's start
+func clickSeries(ctx context.Context, codes []string, r statsRange) (map[string]map[int64]int64, error) {
+	counts := make(map[string]map[int64]int64, len(codes))
+	err := readQuery(func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT short_code, date_trunc($4, clicked_at AT TIME ZONE $5), COUNT(*)
+			FROM click_events
+			WHERE short_code = ANY($1) AND clicked_at >= $2 AND clicked_at < $3
+			GROUP BY 1, 2`,
+			codes, r.From, r.To, r.Interval, r.Location.String(),
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var code string
+			var bucket time.Time
+			var n int64
+			if err := rows.Scan(&code, &bucket, &n); err != nil {
+				return err
+			}
+			if counts[code] == nil {
+				counts[code] = map[int64]int64{}
+			}
+			counts[code][localWallTime(bucket, r.Location).Unix()] += n
+		}
+		return rows.Err()
+	})
+	return counts, err
+}
+
+// clickHeatmapCounts counts a link's click events in [from, to) by ISO weekday (0 =
+// Monday) and hour of day in loc
+func clickHeatmapCounts(ctx context.Context, code string, from, to time.Time, loc *time.Location) ([7][24]int64, error) {
+	var counts [7][24]int64
+	err := readQuery(func(q querier) error {
+		counts = [7][24]int64{}
+		rows, err := q.Query(ctx, `
+			SELECT EXTRACT(ISODOW FROM clicked_at AT TIME ZONE $4)::int, EXTRACT(HOUR FROM clicked_at AT TIME ZONE $4)::int, COUNT(*)
+			FROM click_events
+			WHERE short_code = $1 AND clicked_at >= $2 AND clicked_at < $3
+			GROUP BY 1, 2`,
+			code, from, to, loc.String(),
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var weekday, hour int
+			var n int64
+			if err := rows.Scan(&weekday, &hour, &n); err != nil {
+				return err
+			}
+			if weekday >= 1 && weekday <= 7 && hour >= 0 && hour < 24 {
+				counts[weekday-1][hour] = n
+			}
+		}
+		return rows.Err()
+	})
+	return counts, err
+}
+
+// eachClickEvent calls fn with up to limit click events of a link with an id above after,
+// in id order, skipping events newer than before. If a replica fails midway the read
+// resumes on the primary after the last event delivered.
+func eachClickEvent(ctx context.Context, code string, after int64, limit int, before time.Time, fn func(e ClickEventRecord) error) error {
+	return readQuery(func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT id, short_code, clicked_at, referrer_host, user_agent, ip_prefix, click_id
+			FROM click_events
+			WHERE short_code = $1 AND id > $2 AND clicked_at < $4
+			ORDER BY id
+			LIMIT $3`,
+			code, after, limit, before,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e ClickEventRecord
+			if err := rows.Scan(&e.ID, &e.ShortCode, &e.ClickedAt, &e.ReferrerHost, &e.UserAgent, &e.IPPrefix, &e.ClickID); err != nil {
+				return err
+			}
+			e.ClickedAt = e.ClickedAt.UTC()
+			if err := fn(e); err != nil {
+				return err
+			}
+			after = e.ID
+			limit--
+		}
+		return rows.Err()
+	})
+}
+
+// saveScreenshot stores a link's screenshot, replacing any earlier one
+func saveScreenshot(ctx context.Context, shot Screenshot) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			INSERT INTO screenshots (short_code, content_type, image, captured_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (short_code) DO UPDATE
+			SET content_type = EXCLUDED.content_type, image = EXCLUDED.image, captured_at = EXCLUDED.captured_at`,
+			shot.ShortCode, shot.ContentType, shot.Image, shot.CapturedAt,
+		)
+		return err
+	})
+}
+
+// loadScreenshot returns a link's stored screenshot
+func loadScreenshot(ctx context.Context, code string) (Screenshot, error) {
+	shot := Screenshot{ShortCode: code}
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx,
+			"SELECT content_type, image, captured_at FROM screenshots WHERE short_code = $1", code,
+		).Scan(&shot.ContentType, &shot.Image, &shot.CapturedAt)
+	})
+	return shot, notFound(err)
+}
+
+// loadPageMetadata returns the stored metadata of a destination URL. Metadata is keyed
+// by the destination's normalized_url, so it is shared by equivalent destinations and
+// holds no plaintext URL when destinations are encrypted.
+func loadPageMetadata(ctx context.Context, destination string) (LinkPreview, error) {
+	meta := LinkPreview{URL: destination}
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT title, description, image, site_name, error, fetched_at
+			FROM destination_metadata WHERE url = $1`,
+			destinationIndex(destination),
+		).Scan(&meta.Title, &meta.Description, &meta.Image, &meta.SiteName, &meta.Error, &meta.FetchedAt)
+	})
+	return meta, notFound(err)
+}
+
+// savePageMetadata stores a destination's metadata, replacing any earlier fetch
+func savePageMetadata(ctx context.Context, meta LinkPreview) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			INSERT INTO destination_metadata (url, title, description, image, site_name, error, fetched_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (url) DO UPDATE
+			SET title = EXCLUDED.title, description = EXCLUDED.description, image = EXCLUDED.image,
+				site_name = EXCLUDED.site_name, error = EXCLUDED.error, fetched_at = EXCLUDED.fetched_at`,
+			destinationIndex(meta.URL), meta.Title, meta.Description, meta.Image, meta.SiteName, meta.Error, meta.FetchedAt,
+		)
+		return err
+	})
+}
+
+// listStaleMetadata returns up to limit destinations of existing links whose metadata was
+// fetched before staleBefore, or failed before failedBefore, oldest first. Each entry is
+// refreshed through one of the links pointing to it.
+func listStaleMetadata(ctx context.Context, staleBefore, failedBefore time.Time, limit int) ([]string, error) {
+	var destinations []string
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, `
+			SELECT destination FROM (
+				SELECT DISTINCT ON (m.url) u.original_url AS destination, m.fetched_at
+				FROM destination_metadata m JOIN urls u ON u.normalized_url = m.url
+				WHERE m.fetched_at < $1 OR (m.error <> '' AND m.fetched_at < $2)
+				ORDER BY m.url, u.id
+			) stale
+			ORDER BY fetched_at
+			LIMIT $3`,
+			staleBefore, failedBefore, limit,
+		)
+		if err != nil {
+			return err
+		}
+		destinations, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	for i := range destinations {
+		openURLs(&destinations[i])
+	}
+	return destinations, err
+}
+
+// deleteUnusedMetadata removes metadata fetched before cutoff that is no longer kept
+// fresh: page metadata no link points to, and favicons nobody asked for since
+func deleteUnusedMetadata(ctx context.Context, cutoff time.Time) (int64, error) {
+	var deleted int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, `
+			DELETE FROM destination_metadata m
+			WHERE m.fetched_at < $1
+			  AND NOT EXISTS (SELECT 1 FROM urls u WHERE u.normalized_url = m.url)`,
+			cutoff,
+		)
+		if err != nil {
+			return err
+		}
+		deleted = result.RowsAffected()
+		result, err = db.Exec(ctx, "DELETE FROM favicons WHERE fetched_at < $1", cutoff)
+		deleted += result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// loadFavicon returns a domain's stored icon
+func loadFavicon(ctx context.Context, domain string) (Favicon, error) {
+	icon := Favicon{Domain: domain}
+	err := readQuery(func(q querier) error {
+		return q.QueryRow(ctx,
+			"SELECT content_type, image, fetched_at FROM favicons WHERE domain = $1", domain,
+		).Scan(&icon.ContentType, &icon.Image, &icon.FetchedAt)
+	})
+	return icon, notFound(err)
+}
+
+// saveFavicon stores a domain's icon, replacing any earlier one
+func saveFavicon(ctx context.Context, icon Favicon) error {
+	return dbBreaker.call(func() error {
+		_, err := db.Exec(ctx, `
+			INSERT INTO favicons (domain, content_type, image, fetched_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (domain) DO UPDATE
+			SET content_type = EXCLUDED.content_type, image = EXCLUDED.image, fetched_at = EXCLUDED.fetched_at`,
+			icon.Domain, icon.ContentType, icon.Image, icon.FetchedAt,
+		)
+		return err
+	})
+}
+
+// insertConversion stores a conversion, reporting false if the same click ID and event
+// were already recorded
+func insertConversion(ctx context.Context, conv Conversion) (bool, error) {
+	created := false
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, `
+			INSERT INTO conversions (short_code, click_id, event, value, currency)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (click_id, event) DO NOTHING`,
+			conv.ShortCode, conv.ClickID, conv.Event, conv.Value, conv.Currency,
+		)
+		created = result.RowsAffected() == 1
+		return err
+	})
+	return created, err
+}
+
+// conversionTotals returns a link's conversion count and value per event
+func conversionTotals(ctx context.Context, code string) ([]ConversionTotals, error) {
+	var totals []ConversionTotals
+	err := readQuery(func(q querier) error {
+		totals = []ConversionTotals{}
+		rows, err := q.Query(ctx, `
+			SELECT event, COUNT(*), COALESCE(SUM(value), 0)::float8
+			FROM conversions WHERE short_code = $1
+			GROUP BY event ORDER BY COUNT(*) DESC, event`,
+			code,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t ConversionTotals
+			if err := rows.Scan(&t.Event, &t.Conversions, &t.Value); err != nil {
+				return err
+			}
+			totals = append(totals, t)
+		}
+		return rows.Err()
+	})
+	return totals, err
+}
+
+// localWallTime reinterprets a timestamp read from a "timestamp without time zone"
+// column as wall clock time in loc
+func localWallTime(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// topLinksSince returns the most clicked links matching filter since a time, from the
+// hourly totals
+func topLinksSince(ctx context.Context, filter linkFilter, since time.Time, limit int) ([]TopLink, error) {
+	var links []TopLink
+	where, args := filter.where([]any{since, limit})
+	err := readQuery(func(q querier) error {
+		links = []TopLink{}
+		rows, err := q.Query(ctx, `
+			SELECT u.short_code, u.original_url, SUM(h.clicks) AS n
+			FROM click_hourly h JOIN urls u ON u.short_code = h.short_code
+			WHERE h.hour >= $1 AND u.short_code IN (SELECT short_code FROM urls WHERE `+where+`)
+			GROUP BY u.short_code, u.original_url
+			ORDER BY n DESC, u.short_code
+			LIMIT $2`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var l TopLink
+			if err := rows.Scan(&l.ShortCode, &l.OriginalURL, &l.Clicks); err != nil {
+				return err
+			}
+			openURLs(&l.OriginalURL)
+			links = append(links, l)
+		}
+		return rows.Err()
+	})
+	return links, err
+}
+
+// deleteClickHourlyBefore deletes up to limit hourly totals older than cutoff
+func deleteClickHourlyBefore(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	var removed int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, `
+			DELETE FROM click_hourly WHERE (short_code, hour) IN (
+				SELECT short_code, hour FROM click_hourly WHERE hour < $1 LIMIT $2
+			)`,
+			cutoff, limit,
+		)
+		removed = result.RowsAffected()
+		return err
+	})
+	return removed, err
+}
+
+// summaryTables are the tables whose size GET /api/stats/summary reports
+var summaryTables = []string{"urls", "click_events", "click_hourly", "alerts", "idempotency_keys"}
+
+// loadStatsSummary computes instance-wide totals. Redirects per minute are averaged over
+// the hourly totals since the start of the previous hour; table row counts are the
+// planner's estimates.
+func loadStatsSummary(ctx context.Context, today, now time.Time) (StatsSummary, error) {
+	summary := StatsSummary{Storage: make(map[string]TableStorage, len(summaryTables))}
+	err := readQuery(func(q querier) error {
+		err := q.QueryRow(ctx,
+			"SELECT COUNT(*), COALESCE(SUM(clicks), 0), COUNT(*) FILTER (WHERE created_at >= $1) FROM urls",
+			today,
+		).Scan(&summary.Links, &summary.Clicks, &summary.LinksCreatedToday)
+		if err != nil {
+			return err
+		}
+
+		since := now.Truncate(time.Hour).Add(-time.Hour)
+		var recent int64
+		err = q.QueryRow(ctx, "SELECT COALESCE(SUM(clicks), 0) FROM click_hourly WHERE hour >= $1", since).Scan(&recent)
+		if err != nil {
+			return err
+		}
+		summary.RedirectsPerMinute = float64(recent) / now.Sub(since).Minutes()
+
+		for _, table := range summaryTables {
+			var storage TableStorage
+			err := q.QueryRow(ctx, `
+				SELECT COALESCE(SUM(GREATEST(c.reltuples, 0)), 0)::bigint, COALESCE(SUM(pg_total_relation_size(c.oid)), 0)::bigint
+				FROM pg_class c
+				WHERE c.oid = $1::regclass OR c.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = $1::regclass)`,
+				table,
+			).Scan(&storage.Rows, &storage.Bytes)
+			if err != nil {
+				return err
+			}
+			summary.Storage[table] = storage
+		}
+		return q.QueryRow(ctx, "SELECT pg_database_size(current_database())").Scan(&summary.DatabaseBytes)
+	})
+	return summary, err
+}
+
+// deleteURL removes a short code
+func deleteURL(ctx context.Context, code string) error {
+	invalidateWithAliases(ctx, code)
+	defer destinationCache.Invalidate(code)
+	return dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM urls WHERE short_code = $1", code)
+		if err == nil && result.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return err
+	})
+}
+
+// upsertBlocklistEntries stores a batch of a feed's entries as seen at seenAt and returns
+// the ones the feed did not list before
+func upsertBlocklistEntries(ctx context.Context, source string, entries []BlocklistEntry, seenAt time.Time) ([]BlocklistEntry, error) {
+	kinds := make([]string, len(entries))
+	values := make([]string, len(entries))
+	for i, e := range entries {
+		kinds[i], values[i] = e.Kind, e.Value
+	}
+	var added []BlocklistEntry
+	err := dbBreaker.call(func() error {
+		added = nil
+		rows, err := db.Query(ctx, `
+			INSERT INTO blocklist (kind, value, source, first_seen, last_seen)
+			SELECT kind, value, $3, $4, $4 FROM unnest($1::text[], $2::text[]) AS e(kind, value)
+			ON CONFLICT (kind, value, source) DO UPDATE SET last_seen = EXCLUDED.last_seen
+			RETURNING kind, value, xmax = 0`,
+			kinds, values, source, seenAt,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e := BlocklistEntry{Source: source}
+			var inserted bool
+			if err := rows.Scan(&e.Kind, &e.Value, &inserted); err != nil {
+				return err
+			}
+			if inserted {
+				added = append(added, e)
+			}
+		}
+		return rows.Err()
+	})
+	return added, err
+}
+
+// deleteStaleBlocklistEntries removes a feed's entries not seen since before, which the
+// feed no longer lists
+func deleteStaleBlocklistEntries(ctx context.Context, source string, before time.Time) (int64, error) {
+	var deleted int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM blocklist WHERE source = $1 AND last_seen < $2", source, before)
+		deleted = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// deleteUnlistedBlocklists removes the entries of feeds that are no longer configured
+func deleteUnlistedBlocklists(ctx context.Context, sources []string) (int64, error) {
+	if sources == nil {
+		sources = []string{}
+	}
+	var deleted int64
+	err := dbBreaker.call(func() error {
+		result, err := db.Exec(ctx, "DELETE FROM blocklist WHERE source <> ALL($1)", sources)
+		deleted = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// findBlocklistEntry returns an entry listing the normalized destination or one of
+// domains
+func findBlocklistEntry(ctx context.Context, normalized string, domains []string) (BlocklistEntry, error) {
+	if domains == nil {
+		domains = []string{}
+	}
+	var e BlocklistEntry
+	err := readQuery(func(q querier) error {
+		return notFound(q.QueryRow(ctx, `
+			SELECT kind, value, source FROM blocklist
+			WHERE (kind = 'url' AND value = $1) OR (kind = 'domain' AND value = ANY($2))
+			LIMIT 1`,
+			normalized, domains,
+		).Scan(&e.Kind, &e.Value, &e.Source))
+	})
+	return e, err
+}
+
+// blockMatchingLinks disables or, with the warn action, flags active links whose
+// normalized destination, destination domain or a domain above it is one of entries,
+// recording which entry matched each. It returns the codes that were blocked.
+func blockMatchingLinks(ctx context.Context, entries []BlocklistEntry, action string) ([]string, error) {
+	kinds := make([]string, len(entries))
+	values := make([]string, len(entries))
+	sources := make([]string, len(entries))
+	for i, e := range entries {
+		kinds[i], values[i], sources[i] = e.Kind, e.Value, e.Source
+	}
+	var codes []string
+	err := dbBreaker.call(func() error {
+		rows, err := db.Query(ctx, `
+			WITH entries AS (
+				SELECT * FROM unnest($1::text[], $2::text[], $3::text[]) AS e(kind, value, source)
+			), matched AS (
+				SELECT DISTINCT ON (short_code) short_code, kind, value, source FROM (
+					SELECT u.short_code, e.kind, e.value, e.source
+					FROM urls u JOIN entries e ON e.kind = 'url' AND e.value = u.normalized_url
+					WHERE u.disabled_at IS NULL
+					UNION ALL
+					SELECT u.short_code, e.kind, e.value, e.source
+					FROM urls u
+					CROSS JOIN LATERAL (
+						SELECT array_to_string(p.parts[i:], '.') AS domain
+						FROM (SELECT string_to_array(`+linkDomainSQL+`, '.') AS parts) p, generate_subscripts(p.parts, 1) AS i
+					) d
+					JOIN entries e ON e.kind = 'domain' AND e.value = d.domain
+					WHERE u.disabled_at IS NULL
+				) m
+				ORDER BY short_code, kind DESC
+			), blocked AS (
+				UPDATE urls SET
+					disabled_at = CASE WHEN $4 = 'warn' THEN disabled_at ELSE NOW() END,
+					disabled_by = CASE WHEN $4 = 'warn' THEN disabled_by ELSE 'blocklist' END,
+					flagged_reason = CASE WHEN $4 = 'warn' THEN $5 ELSE flagged_reason END,
+					flagged_at = CASE WHEN $4 = 'warn' THEN NOW() ELSE flagged_at END
+				FROM matched
+				WHERE urls.short_code = matched.short_code AND urls.disabled_at IS NULL
+					AND ($4 <> 'warn' OR urls.flagged_reason IS NULL)
+				RETURNING urls.short_code
+			)
+			INSERT INTO blocklist_hits (short_code, kind, value, source, action, disabled_at)
+			SELECT m.short_code, m.kind, m.value, m.source, $4, NOW()
+			FROM matched m JOIN blocked b USING (short_code)
+			ON CONFLICT (short_code) DO UPDATE SET
+				kind = EXCLUDED.kind, value = EXCLUDED.value, source = EXCLUDED.source,
+				action = EXCLUDED.action, disabled_at = EXCLUDED.disabled_at
+			RETURNING short_code`,
+			kinds, values, sources, action, blocklistWarning,
+		)
+		if err != nil {
+			return err
+		}
+		codes, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	if len(codes) > 0 {
+		invalidateWithAliases(ctx, codes...)
+	}
+	return codes, err
+}
+
+// blocklistSourceStats returns the number of stored entries and the latest sync time of
+// each feed
+func blocklistSourceStats(ctx context.Context) (map[string]BlocklistFeedStatus, error) {
+	var stats map[string]BlocklistFeedStatus
+	err := readQuery(func(q querier) error {
+		stats = map[string]BlocklistFeedStatus{}
+		rows, err := q.Query(ctx, "SELECT source, COUNT(*), MAX(last_seen) FROM blocklist GROUP BY source")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var s BlocklistFeedStatus
+			if err := rows.Scan(&s.URL, &s.Entries, &s.LastSeen); err != nil {
+				return err
+			}
+			stats[s.URL] = s
+		}
+		return rows.Err()
+	})
+	return stats, err
+}
+
+// listBlockedLinks returns links disabled because of blocklist entries, most recently
+// disabled first
+func listBlockedLinks(ctx context.Context, limit int) ([]BlockedLink, error) {
+	var links []BlockedLink
+	err := readQuery(func(q querier) error {
+		links = []BlockedLink{}
+		rows, err := q.Query(ctx, `
+			SELECT h.short_code, u.original_url, COALESCE(u.owner, ''), h.kind, h.value, h.source, h.action, h.disabled_at
+			FROM blocklist_hits h JOIN urls u USING (short_code)
+			ORDER BY h.disabled_at DESC
+			LIMIT $1`,
+			limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var l BlockedLink
+			if err := rows.Scan(&l.ShortCode, &l.OriginalURL, &l.Owner, &l.Kind, &l.Value, &l.Source, &l.Action, &l.BlockedAt); err != nil {
+				return err
+			}
+			openURLs(&l.OriginalURL)
+			links = append(links, l)
+		}
+		return rows.Err()
+	})
+	return links, err
+}
+
+// notFound maps pgx's missing-row error to errNotFound
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNotFound
+	}
+	return err
+}
+
+// respondStoreError answers a failed store call with 404, 410, 503 or 500 as appropriate
+func respondStoreError(c *gin.Context, err error, notFoundMessage, failureMessage string) {
+	switch {
+	case errors.Is(err, errNotFound):
+		respondError(c, http.StatusNotFound, notFoundMessage)
+	case errors.Is(err, errDisabled):
+		respondError(c, http.StatusGone, "Short URL has been disabled")
+	case errors.Is(err, errExpired):
+		respondError(c, http.StatusGone, "Short URL has expired")
+	case errors.Is(err, errPending):
+		respondError(c, http.StatusForbidden, "Short URL is awaiting review")
+	case errors.Is(err, errUnavailable):
+		respondError(c, http.StatusServiceUnavailable, "Destination is temporarily unavailable")
+	case errors.Is(err, errCircuitOpen):
+		c.Header("Retry-After", "10")
+		respondError(c, http.StatusServiceUnavailable, "Database temporarily unavailable")
+	default:
+		respondError(c, http.StatusInternalServerError, failureMessage)
+	}
+}
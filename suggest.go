@@ -0,0 +1,192 @@
+package shorty
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Alias suggestion limits
+const (
+	defaultSuggestions = 5
+	maxSuggestions     = 10
+	maxSuggestionWords = 3
+)
+
+var (
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	wordPattern  = regexp.MustCompile(`[a-z0-9]+`)
+
+	// suggestionStopWords are skipped when building aliases from titles and paths
+	suggestionStopWords = map[string]bool{
+		"a": true, "an": true, "and": true, "at": true, "for": true, "from": true, "home": true,
+		"in": true, "index": true, "is": true, "of": true, "on": true, "or": true, "page": true,
+		"the": true, "to": true, "with": true, "www": true, "html": true, "php": true, "htm": true,
+	}
+
+	// suggestionAdjectives and suggestionNouns make readable fallback aliases
+	suggestionAdjectives = []string{
+		"bold", "brave", "bright", "calm", "clever", "cosmic", "crisp", "eager", "fancy", "gentle",
+		"golden", "happy", "jolly", "lucky", "mellow", "nimble", "proud", "quick", "quiet", "rapid",
+		"shiny", "silver", "sunny", "swift", "tidy", "vivid", "warm", "witty", "zesty", "zippy",
+	}
+	suggestionNouns = []string{
+		"anchor", "arrow", "badger", "beacon", "breeze", "canyon", "comet", "falcon", "forest", "harbor",
+		"island", "kite", "lagoon", "maple", "meadow", "otter", "panda", "pebble", "pepper", "planet",
+		"river", "rocket", "summit", "tiger", "tulip", "valley", "walrus", "willow", "yeti", "zebra",
+	}
+)
+
+// SuggestResponse is the response of GET /api/suggest
+type SuggestResponse struct {
+	URL         string   `json:"url"`
+	Title       string   `json:"title,omitempty"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// suggestAliases handles GET /api/suggest?url=...&n=5. It proposes available aliases
+// built from the page title, the URL's path and domain, and finally from word lists.
+func suggestAliases(c *gin.Context) {
+	target := strings.TrimSpace(c.Query("url"))
+	if !strings.Contains(target, "://") && target != "" {
+		target = "https://" + target
+	}
+	if fe := validateDestination("url", target); fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+	n := defaultSuggestions
+	if v, err := strconv.Atoi(c.Query("n")); err == nil && v > 0 {
+		n = min(v, maxSuggestions)
+	}
+
+	title := fetchPageTitle(c.Request.Context(), target)
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	suggestions := make([]string, 0, n)
+	for _, candidate := range aliasCandidates(target, title) {
+		result, err := checkAlias(ctx, candidate)
+		if err != nil {
+			respondStoreError(c, err, "", "Failed to check aliases")
+			return
+		}
+		if result.Available {
+			suggestions = append(suggestions, candidate)
+			if len(suggestions) == n {
+				break
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, SuggestResponse{URL: target, Title: title, Suggestions: suggestions})
+}
+
+// fetchPageTitle returns the <title> of an HTML destination, or "" if it cannot be read
+// within a few seconds
+func fetchPageTitle(ctx context.Context, target string) string {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	meta, err := pageMetadata(ctx, target)
+	if err != nil {
+		return ""
+	}
+	return meta.Title
+}
+
+// aliasCandidates lists aliases for a destination in order of preference, without
+// checking whether they are taken
+func aliasCandidates(target, title string) []string {
+	u, _ := url.Parse(target)
+	domain := domainLabel(u.Hostname())
+	titleWords := significantWords(title)
+	pathWords := significantWords(u.Path)
+
+	var candidates []string
+	add := func(words ...string) {
+		if containsString(words, "") {
+			return
+		}
+		alias := strings.Join(words, "-")
+		if len(alias) > 32 {
+			alias = strings.TrimRight(alias[:32], "-")
+		}
+		if len(alias) >= 3 && !containsString(candidates, alias) {
+			candidates = append(candidates, alias)
+		}
+	}
+
+	for _, words := range [][]string{titleWords, pathWords} {
+		if len(words) > 0 {
+			add(words[:min(len(words), maxSuggestionWords)]...)
+			add(words[:min(len(words), 2)]...)
+			add(domain, words[0])
+		}
+	}
+	add(domain)
+
+	// Numbered variants of the best readable candidates, then random word pairs
+	base := append([]string(nil), candidates...)
+	for _, alias := range base[:min(len(base), 2)] {
+		for i := 2; i <= 4; i++ {
+			add(fmt.Sprintf("%s-%d", alias, i))
+		}
+	}
+	for i := 0; i < 2*maxSuggestions; i++ {
+		adjective := suggestionAdjectives[rand.Intn(len(suggestionAdjectives))]
+		noun := suggestionNouns[rand.Intn(len(suggestionNouns))]
+		if i%2 == 0 {
+			add(domain, noun)
+		} else {
+			add(adjective, noun)
+		}
+	}
+	return candidates
+}
+
+// significantWords lowercases text and returns its words, minus stop words
+func significantWords(text string) []string {
+	var words []string
+	for _, w := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		if !suggestionStopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// domainLabel returns the most distinctive label of a host name, such as "example"
+// for www.example.co.uk
+func domainLabel(host string) string {
+	labels := strings.Split(strings.TrimPrefix(strings.ToLower(host), "www."), ".")
+	if len(labels) >= 3 && len(labels[len(labels)-2]) <= 3 {
+		return significantLabel(labels[len(labels)-3])
+	}
+	if len(labels) >= 2 {
+		return significantLabel(labels[len(labels)-2])
+	}
+	return significantLabel(labels[0])
+}
+
+func significantLabel(label string) string {
+	return strings.Join(wordPattern.FindAllString(label, -1), "-")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package shorty
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TagCount is a tag and how many links carry it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Links int64  `json:"links"`
+}
+
+// RenameTagRequest is the body of POST /api/tags/:tag/rename
+type RenameTagRequest struct {
+	To string `json:"to" binding:"required,tag"`
+}
+
+// TagChangeReport is the result of renaming or removing a tag across links
+type TagChangeReport struct {
+	Tag       string   `json:"tag"`
+	RenamedTo string   `json:"renamed_to,omitempty"`
+	Links     int      `json:"links"`
+	Skipped   []string `json:"skipped"`
+}
+
+// listTags handles GET /api/tags. With an API key only the caller's links are counted.
+func listTags(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	tags, err := tagCounts(ctx, owner)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch tags")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// renameTag handles POST /api/tags/:tag/rename, renaming a tag on all of the caller's
+// links. Renaming onto a tag a link already has merges the two.
+func renameTag(c *gin.Context) {
+	tag, ok := tagParam(c)
+	if !ok {
+		return
+	}
+	var req RenameTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	to := strings.ToLower(req.To)
+	if to == tag {
+		respondValidationError(c, FieldError{Field: "to", Rule: "nefield", Param: "tag", Message: "to must differ from the tag being renamed"})
+		return
+	}
+
+	report, err := changeTag(c, tag, []string{to})
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to rename tag")
+		return
+	}
+	report.RenamedTo = to
+	c.JSON(http.StatusOK, report)
+}
+
+// deleteTag handles DELETE /api/tags/:tag, removing a tag from all of the caller's links
+func deleteTag(c *gin.Context) {
+	tag, ok := tagParam(c)
+	if !ok {
+		return
+	}
+	report, err := changeTag(c, tag, nil)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to remove tag")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// tagParam returns the validated, lowercased :tag path parameter
+func tagParam(c *gin.Context) (string, bool) {
+	param := struct {
+		Tag string `binding:"required,tag"`
+	}{Tag: strings.ToLower(c.Param("tag"))}
+	if details := validateRequest(&param); len(details) > 0 {
+		respondValidationError(c, details...)
+		return "", false
+	}
+	return param.Tag, true
+}
+
+// changeTag replaces tag with add on every one of the caller's links carrying it, in
+// batches of bulkBatchSize. Aliases are skipped.
+func changeTag(c *gin.Context, tag string, add []string) (TagChangeReport, error) {
+	report := TagChangeReport{Tag: tag, Skipped: []string{}}
+	owner, _ := apiKeyName(c)
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	// Each batch drops the tag, so the next listing starts with links not handled yet
+	for {
+		codes, err := listOwnerCodes(ctx, owner, linkFilter{Tag: tag}, bulkBatchSize+len(report.Skipped))
+		if err != nil {
+			return report, err
+		}
+		pending := make([]string, 0, len(codes))
+		for _, code := range codes {
+			if !containsString(report.Skipped, code) {
+				pending = append(pending, code)
+			}
+		}
+		if len(pending) == 0 {
+			return report, nil
+		}
+
+		applied, err := retagURLs(ctx, owner, pending, add, []string{tag})
+		if err != nil {
+			return report, err
+		}
+		report.Links += len(applied)
+		for _, code := range pending {
+			if !containsString(applied, code) {
+				report.Skipped = append(report.Skipped, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package shorty
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UTMRequest is the body of POST /api/utm
+type UTMRequest struct {
+	URL      string   `json:"url" binding:"required"`
+	Source   string   `json:"source" binding:"required,max=100"`
+	Medium   string   `json:"medium" binding:"required,max=100"`
+	Campaign string   `json:"campaign" binding:"required,max=100"`
+	Term     string   `json:"term" binding:"omitempty,max=100"`
+	Content  string   `json:"content" binding:"omitempty,max=100"`
+	Shorten  bool     `json:"shorten"`
+	Alias    string   `json:"alias" binding:"omitempty,min=3,max=32,alias"`
+	Tags     []string `json:"tags" binding:"omitempty,max=10,dive,tag"`
+}
+
+// UTMResponse is the tagged URL, plus the short link when one was requested
+type UTMResponse struct {
+	TaggedURL string `json:"tagged_url"`
+	ShortURL  string `json:"short_url,omitempty"`
+	ShortCode string `json:"short_code,omitempty"`
+}
+
+// buildUTMURL handles POST /api/utm. Campaign values are lowercased and their spaces
+// replaced with '-', so "Spring Sale" and "spring sale" land in the same analytics bucket.
+func buildUTMURL(c *gin.Context) {
+	var req UTMRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	base := strings.TrimSpace(req.URL)
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	if fe := validateDestination("url", base); fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+
+	tagged := tagUTM(base, map[string]string{
+		"utm_source":   req.Source,
+		"utm_medium":   req.Medium,
+		"utm_campaign": req.Campaign,
+		"utm_term":     req.Term,
+		"utm_content":  req.Content,
+	})
+	if fe := validateDestination("url", tagged); fe != nil {
+		respondValidationError(c, *fe)
+		return
+	}
+	if !req.Shorten {
+		c.JSON(http.StatusOK, UTMResponse{TaggedURL: tagged})
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	link, created, err := createLink(ctx, ShortenRequest{URL: tagged, Alias: req.Alias, Tags: req.Tags}, owner)
+	if err != nil {
+		respondCreateError(c, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, UTMResponse{
+		TaggedURL: tagged,
+		ShortURL:  buildShortURL(c, link.ShortCode),
+		ShortCode: link.ShortCode,
+	})
+}
+
+// tagUTM sets the given utm_ parameters on target, replacing any it already has and
+// dropping those left empty
+func tagUTM(target string, params map[string]string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	query := u.Query()
+	for name, value := range params {
+		query.Del(name)
+		if value = normalizeUTMValue(value); value != "" {
+			query.Set(name, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// normalizeUTMValue lowercases a campaign value and joins its words with '-'
+func normalizeUTMValue(value string) string {
+	return strings.Join(strings.Fields(strings.ToLower(value)), "-")
+}
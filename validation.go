@@ -0,0 +1,256 @@
+package shorty
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// maxRequestBody caps API request bodies, far above any valid request
+const maxRequestBody = 1 << 20
+
+// maxTagLength is the longest tag accepted on a link
+const maxTagLength = 32
+
+// maxLinkTags is the most tags a link can have
+const maxLinkTags = 10
+
+var (
+	// aliasPattern restricts custom aliases to characters that need no escaping in a URL path
+	aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	// tagPattern restricts tags to words joined by '-', '_' or ':'
+	tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_:-]*$`)
+)
+
+// FieldError describes a single field that failed validation
+type FieldError struct {
+	Field   string   `json:"field"`
+	Rule    string   `json:"rule"`
+	Param   string   `json:"param,omitempty"`
+	Allowed []string `json:"allowed,omitempty"`
+	Message string   `json:"message"`
+}
+
+func (fe *FieldError) Error() string {
+	return fe.Message
+}
+
+// ValidationErrorResponse is returned when a request body fails validation
+type ValidationErrorResponse struct {
+	Error     string       `json:"error"`
+	Details   []FieldError `json:"details"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// setupValidator makes validation errors report JSON field names instead of Go struct names
+func setupValidator() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("alias", func(fl validator.FieldLevel) bool {
+		return aliasPattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("tag", func(fl validator.FieldLevel) bool {
+		tag := fl.Field().String()
+		return len(tag) <= maxTagLength && tagPattern.MatchString(tag)
+	})
+}
+
+// respondValidationError writes a 400 response listing the given field errors
+func respondValidationError(c *gin.Context, details ...FieldError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, ValidationErrorResponse{
+		Error:     "Validation failed",
+		Details:   details,
+		RequestID: requestID(c),
+	})
+}
+
+// validateRequest runs the binding rules on a request that was not decoded by gin,
+// such as one assembled from query parameters or an integration payload
+func validateRequest(req any) []FieldError {
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return bindingErrorDetails(err)
+	}
+	return nil
+}
+
+// bindingErrorDetails converts an error returned by ShouldBindJSON into field errors
+func bindingErrorDetails(err error) []FieldError {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		details := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			details = append(details, fieldErrorFor(fe))
+		}
+		return details
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type.String()),
+		}}
+	}
+
+	var sizeErr *http.MaxBytesError
+	if errors.As(err, &sizeErr) {
+		return []FieldError{{
+			Field:   "body",
+			Rule:    "max",
+			Param:   fmt.Sprint(sizeErr.Limit),
+			Message: fmt.Sprintf("Request body must be at most %d bytes", sizeErr.Limit),
+		}}
+	}
+
+	return []FieldError{{
+		Field:   "body",
+		Rule:    "json",
+		Message: "Request body must be a valid JSON object",
+	}}
+}
+
+// limitRequestBody stops reading API request bodies after maxRequestBody bytes
+func limitRequestBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBody)
+		c.Next()
+	}
+}
+
+// fieldErrorFor builds a human readable FieldError from a validator error
+func fieldErrorFor(fe validator.FieldError) FieldError {
+	field := fe.Field()
+	detail := FieldError{Field: field, Rule: fe.Tag(), Param: fe.Param()}
+
+	switch fe.Tag() {
+	case "required":
+		detail.Message = field + " is required"
+	case "max":
+		detail.Message = fmt.Sprintf("%s must be at most %s%s", field, fe.Param(), sizeUnit(fe.Kind()))
+	case "min":
+		detail.Message = fmt.Sprintf("%s must be at least %s%s", field, fe.Param(), sizeUnit(fe.Kind()))
+	case "email":
+		detail.Message = field + " must be a valid email address"
+	case "alias":
+		detail.Message = field + " may only contain letters, digits, '-' and '_'"
+	case "tag":
+		detail.Message = fmt.Sprintf("%s must be at most %d letters, digits, '-', '_' or ':' and start with a letter or digit", field, maxTagLength)
+	case "oneof":
+		detail.Allowed = strings.Fields(fe.Param())
+		detail.Message = fmt.Sprintf("%s must be one of: %s", field, strings.Join(detail.Allowed, ", "))
+	default:
+		detail.Message = fmt.Sprintf("%s failed the %s check", field, fe.Tag())
+	}
+	return detail
+}
+
+// sizeUnit names what min and max count for a field of the given kind
+func sizeUnit(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	default:
+		return ""
+	}
+}
+
+// normalizeTags lowercases tags and removes duplicates, keeping their order
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// validateDestination checks that a normalized destination is an absolute http(s) URL
+// of at most MAX_URL_LENGTH characters once encoded for a redirect
+func validateDestination(field, raw string) *FieldError {
+	// The encoded form is measured so a link cannot be shortened past what a Location
+	// header or browser will carry
+	if limit := cfg().MaxURLLength; len(raw) > limit || len(asciiURL(raw)) > limit {
+		return &FieldError{
+			Field:   field,
+			Rule:    "max",
+			Param:   fmt.Sprint(limit),
+			Message: fmt.Sprintf("%s must be at most %d characters", field, limit),
+		}
+	}
+
+	if !utf8.ValidString(raw) {
+		return &FieldError{Field: field, Rule: "utf8", Message: field + " must be valid UTF-8"}
+	}
+	for _, r := range raw {
+		if unicode.IsControl(r) || unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) {
+			return &FieldError{
+				Field:   field,
+				Rule:    "printable",
+				Param:   fmt.Sprintf("%U", r),
+				Message: fmt.Sprintf("%s must not contain whitespace or control characters (found %U)", field, r),
+			}
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error repeats the whole URL; only the reason is useful
+		var urlErr *url.Error
+		reason := err.Error()
+		if errors.As(err, &urlErr) {
+			reason = urlErr.Err.Error()
+		}
+		return &FieldError{Field: field, Rule: "url", Message: field + " must be a valid URL: " + reason}
+	}
+	if !u.IsAbs() {
+		return &FieldError{Field: field, Rule: "url", Message: field + " must be an absolute URL with a scheme"}
+	}
+	if u.Host == "" || u.Hostname() == "" {
+		return &FieldError{Field: field, Rule: "url", Message: field + " must include a host"}
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &FieldError{
+			Field:   field,
+			Rule:    "scheme",
+			Allowed: []string{"http", "https"},
+			Message: field + " must use http or https",
+		}
+	}
+
+	if !validHostName(u.Hostname()) {
+		return &FieldError{Field: field, Rule: "hostname", Message: field + " must have a valid host name"}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package shorty
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, injected at build time with:
+//
+//	go build -ldflags "-X shorty.version=1.2.0 -X shorty.commit=$(git rev-parse --short HEAD) -X shorty.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/shorty
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// VersionResponse describes the running build
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildInfo returns the running build, falling back to VCS data stamped by the Go toolchain
+func buildInfo() VersionResponse {
+	info := VersionResponse{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "unknown":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// versionHandler handles GET /api/version
+func versionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, buildInfo())
+}
@@ -0,0 +1,153 @@
+package shorty
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SavedView is a named link filter and sort order kept for one API key
+type SavedView struct {
+	ID        int              `json:"id"`
+	Name      string           `json:"name"`
+	Filter    LinkFilterParams `json:"filter"`
+	Sort      string           `json:"sort,omitempty"`
+	Order     string           `json:"order,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// SavedViewRequest is the body of POST /api/views
+type SavedViewRequest struct {
+	Name   string           `json:"name" binding:"required,max=64"`
+	Filter LinkFilterParams `json:"filter"`
+	Sort   string           `json:"sort" binding:"omitempty,oneof=clicks created_at last_clicked"`
+	Order  string           `json:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+// createView handles POST /api/views
+func createView(c *gin.Context) {
+	var req SavedViewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	if _, details := parseLinkFilter(req.Filter); len(details) > 0 {
+		for i := range details {
+			details[i].Field = "filter." + details[i].Field
+		}
+		respondValidationError(c, details...)
+		return
+	}
+
+	owner, _ := apiKeyName(c)
+	view := SavedView{Name: strings.TrimSpace(req.Name), Filter: req.Filter, Sort: req.Sort, Order: req.Order}
+	if view.Name == "" {
+		respondValidationError(c, FieldError{Field: "name", Rule: "required", Message: "name is required"})
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := insertSavedView(ctx, owner, &view); err != nil {
+		if isUniqueViolation(err) {
+			respondError(c, http.StatusConflict, "A view named "+view.Name+" already exists")
+			return
+		}
+		respondStoreError(c, err, "", "Failed to save view")
+		return
+	}
+	c.JSON(http.StatusCreated, view)
+}
+
+// listViews handles GET /api/views
+func listViews(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	views, err := listSavedViews(ctx, owner)
+	if err != nil {
+		respondStoreError(c, err, "", "Failed to fetch views")
+		return
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// getView handles GET /api/views/:id
+func getView(c *gin.Context) {
+	view, ok := loadOwnedView(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+// deleteView handles DELETE /api/views/:id
+func deleteView(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "View not found")
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	if err := deleteSavedView(ctx, owner, id); err != nil {
+		respondStoreError(c, err, "View not found", "Failed to delete view")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listViewURLs handles GET /api/views/:id/urls, listing links through a saved view.
+// Only limit, page and after are taken from the query; the view decides the rest.
+func listViewURLs(c *gin.Context) {
+	view, ok := loadOwnedView(c)
+	if !ok {
+		return
+	}
+
+	var page struct {
+		Limit int    `form:"limit" binding:"omitempty,min=1,max=1000"`
+		Page  int    `form:"page" binding:"omitempty,min=1,max=100000"`
+		After string `form:"after" binding:"omitempty,max=64"`
+	}
+	if err := c.ShouldBindQuery(&page); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+	respondLinkList(c, ListParams{
+		LinkFilterParams: view.Filter,
+		Sort:             view.Sort,
+		Order:            view.Order,
+		Limit:            page.Limit,
+		Page:             page.Page,
+		After:            page.After,
+	})
+}
+
+// loadOwnedView fetches the :id view of the caller, responding 404 for other keys' views
+func loadOwnedView(c *gin.Context) (SavedView, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "View not found")
+		return SavedView{}, false
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	owner, _ := apiKeyName(c)
+	view, err := getSavedView(ctx, owner, id)
+	if err != nil {
+		respondStoreError(c, err, "View not found", "Failed to fetch view")
+		return SavedView{}, false
+	}
+	return view, true
+}
@@ -0,0 +1,148 @@
+package shorty
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Link visibility settings
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+	visibilityMembers = "members"
+)
+
+// visitor is whoever a request is authenticated as: the API key it presents, or the user
+// and groups an authenticating proxy passes in AUTH_USER_HEADER and AUTH_GROUPS_HEADER
+type visitor struct {
+	User   string
+	Groups []string
+}
+
+// requestVisitor returns the visitor a request is authenticated as, if any. An API key
+// counts as a member of the group named like it, so owners can always reach their links.
+func requestVisitor(c *gin.Context) (visitor, bool) {
+	if name, ok := apiKeyName(c); ok {
+		return visitor{User: name, Groups: []string{name}}, true
+	}
+	header := cfg().AuthUserHeader
+	if header == "" || strings.TrimSpace(c.GetHeader(header)) == "" {
+		return visitor{}, false
+	}
+	v := visitor{User: strings.TrimSpace(c.GetHeader(header))}
+	if groupsHeader := cfg().AuthGroupsHeader; groupsHeader != "" {
+		for _, group := range strings.Split(c.GetHeader(groupsHeader), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				v.Groups = append(v.Groups, group)
+			}
+		}
+	}
+	return v, true
+}
+
+// canView reports whether v may resolve a link with the given owner and visibility.
+// Members links are open to the owning API key and to users in a group of that name.
+func (v visitor) canView(owner, visibility string, authenticated bool) bool {
+	switch visibility {
+	case visibilityPrivate:
+		return authenticated
+	case visibilityMembers:
+		return authenticated && owner != "" && (v.User == owner || containsString(v.Groups, owner))
+	}
+	return true
+}
+
+// visibleTo reports whether the request may see a link with the given owner and
+// visibility
+func visibleTo(c *gin.Context, owner, visibility string) bool {
+	v, ok := requestVisitor(c)
+	return v.canView(owner, visibility, ok)
+}
+
+// reachableBy reports whether the request could follow a link with the given owner,
+// allowed networks and countries, so the API reveals no more of it than a redirect
+// would. The owner always can, to manage links restricted to elsewhere.
+func reachableBy(c *gin.Context, owner string, networks []netip.Prefix, allowedCountries, blockedCountries []string) bool {
+	if v, ok := requestVisitor(c); ok && owner != "" && v.User == owner {
+		return true
+	}
+	if !networkPermitted(c, networks) {
+		return false
+	}
+	return len(allowedCountries) == 0 && len(blockedCountries) == 0 ||
+		countryPermitted(allowedCountries, blockedCountries, visitorCountry(c))
+}
+
+// visibilityScope returns the link filter restricting listings to links the request may
+// see and, like reachableBy, could follow from where it comes from
+func visibilityScope(c *gin.Context) *linkVisibility {
+	scope := &linkVisibility{Country: visitorCountry(c)}
+	if addr, ok := networkClientIP(c); ok {
+		ip := addr.String()
+		scope.Addr = &ip
+	}
+	if v, ok := requestVisitor(c); ok {
+		scope.Authenticated, scope.User = true, v.User
+		scope.Groups = append([]string{v.User}, v.Groups...)
+	}
+	return scope
+}
+
+// authorizeVisitor answers requests for a link the visitor may not resolve, with a login
+// prompt for anonymous visitors and 403 for the others. It reports whether the request
+// may continue.
+func authorizeVisitor(c *gin.Context, link resolvedLink) bool {
+	v, ok := requestVisitor(c)
+	if v.canView(link.Owner, link.Visibility, ok) {
+		return true
+	}
+
+	data := map[string]any{"Anonymous": !ok}
+	status := http.StatusForbidden
+	if !ok {
+		status = http.StatusUnauthorized
+		if login := cfg().AuthLoginURL; login != "" {
+			data["LoginURL"] = strings.ReplaceAll(login, "{url}", url.QueryEscape(requestBaseURL(c)+c.Request.URL.RequestURI()))
+		}
+	}
+	var page bytes.Buffer
+	if err := accessPage.Execute(&page, data); err != nil {
+		respondError(c, status, "Sign-in required")
+		return false
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(status, "text/html; charset=utf-8", page.Bytes())
+	c.Abort()
+	return false
+}
+
+var accessPage = template.Must(template.New("access").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>Shorty - Private link</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 560px; width: 100%; box-sizing: border-box; text-align: center; }
+        h1 { color: #333; margin: 0 0 12px; }
+        p { color: #555; line-height: 1.5; }
+        .login { display: inline-block; background: #667eea; color: white; text-decoration: none; padding: 12px 20px; border-radius: 8px; margin-top: 12px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🔒 This link is private</h1>
+        {{if .Anonymous}}<p>Sign in to follow it.</p>
+        {{with .LoginURL}}<a class="login" href="{{.}}">Sign in</a>{{end}}{{else}}<p>You do not have access to it. If you think you should, ask the person who shared it.</p>{{end}}
+    </div>
+</body>
+</html>`))
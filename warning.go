@@ -0,0 +1,132 @@
+package shorty
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FlagRequest is the body of PUT /admin/urls/:code/flag
+type FlagRequest struct {
+	Reason string `json:"reason" binding:"required,max=200"`
+}
+
+// adminFlagURL handles PUT /admin/urls/:code/flag, marking a link's destination as
+// suspicious so visitors see a warning page before being sent on. Scanners and
+// moderators use it for links that are not yet removed.
+func adminFlagURL(c *gin.Context) {
+	var req FlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingErrorDetails(err)...)
+		return
+	}
+
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := setURLFlag(ctx, c.Param("code"), strings.TrimSpace(req.Reason)); err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to update URL")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// adminUnflagURL handles DELETE /admin/urls/:code/flag, redirecting visitors directly
+// again
+func adminUnflagURL(c *gin.Context) {
+	ctx, cancel := dbContext(c)
+	defer cancel()
+
+	if err := setURLFlag(ctx, c.Param("code"), ""); err != nil {
+		respondStoreError(c, err, "URL not found", "Failed to update URL")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// proceedToURL handles POST /:code, submitted from the warning page by visitors who
+// choose to continue to a flagged destination. Requiring a POST means a link to the
+// short URL alone can never skip the warning.
+func proceedToURL(c *gin.Context) {
+	link, ok := resolveCode(c)
+	if !ok {
+		return
+	}
+	// Links with a 307 or 308 status forward the POST itself, unless they are flagged
+	if link.Warning == "" && preservesMethod(link.Status) {
+		redirectVisitor(c, link, link.Status)
+		return
+	}
+	redirectVisitor(c, link, http.StatusSeeOther)
+}
+
+// respondWarningPage shows the warning page for a flagged link instead of redirecting
+func respondWarningPage(c *gin.Context, link resolvedLink) {
+	host := link.Destination
+	if u, err := url.Parse(link.Destination); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+
+	// Proceeding from a share URL must carry its signature along
+	action := "/" + link.Code
+	if link.Shared {
+		action += "?" + shareQuery(c)
+	}
+
+	var page bytes.Buffer
+	err := warningPage.Execute(&page, map[string]string{
+		"Action":      action,
+		"Destination": link.Destination,
+		"Host":        host,
+		"Reason":      link.Warning,
+		"CSRFToken":   issueCSRFToken(c),
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to render warning page")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+var warningPage = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>Shorty - Suspicious link</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: linear-gradient(135deg, #e53e3e 0%, #9b2c2c 100%); min-height: 100vh; display: flex; align-items: center; justify-content: center; margin: 0; padding: 20px; }
+        .container { background: white; padding: 40px; border-radius: 16px; box-shadow: 0 20px 60px rgba(0,0,0,0.3); max-width: 560px; width: 100%; box-sizing: border-box; }
+        h1 { color: #9b2c2c; margin: 0 0 12px; }
+        p { color: #555; line-height: 1.5; }
+        .destination { background: #f7f7f7; border: 1px solid #ddd; border-radius: 8px; padding: 12px; word-break: break-all; font-family: monospace; color: #333; }
+        .host { font-weight: bold; color: #9b2c2c; }
+        .actions { display: flex; gap: 12px; margin-top: 24px; flex-wrap: wrap; }
+        .back { background: #667eea; color: white; text-decoration: none; padding: 12px 20px; border-radius: 8px; }
+        button { background: none; border: 1px solid #aaa; color: #555; padding: 12px 20px; border-radius: 8px; cursor: pointer; font-size: 1em; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>⚠️ This link may be unsafe</h1>
+        <p>The short link you followed leads to <span class="host">{{.Host}}</span>, which has been flagged as suspicious: {{.Reason}}</p>
+        <p class="destination">{{.Destination}}</p>
+        <p>It may try to steal passwords or payment details, or install harmful software. Only continue if you trust this site.</p>
+        <div class="actions">
+            <a class="back" href="/">Take me to safety</a>
+            <form method="post" action="{{.Action}}">
+                <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+                <button type="submit">Proceed anyway</button>
+            </form>
+        </div>
+    </div>
+</body>
+</html>`))
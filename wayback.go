@@ -0,0 +1,74 @@
+package shorty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// waybackClient queries the Internet Archive's availability API
+var waybackClient = &http.Client{Timeout: 10 * time.Second}
+
+// waybackAvailability is the part of an availability API response that is used
+type waybackAvailability struct {
+	ArchivedSnapshots struct {
+		Closest struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Status    string `json:"status"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// findWaybackSnapshot returns the URL of the latest good Internet Archive snapshot of a
+// destination, or "" when it was never archived
+func findWaybackSnapshot(ctx context.Context, destination string) (string, error) {
+	query := url.Values{"url": {asciiURL(destination)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg().WaybackAPIURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Shorty/"+version+" (+link check)")
+	resp, err := waybackClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("availability API answered " + resp.Status)
+	}
+
+	var availability waybackAvailability
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&availability); err != nil {
+		return "", err
+	}
+	closest := availability.ArchivedSnapshots.Closest
+	if !closest.Available || closest.Status != "200" || !strings.HasPrefix(closest.URL, "http") {
+		return "", nil
+	}
+	// Snapshots are served over https even when the API reports http
+	return "https://" + strings.TrimPrefix(strings.TrimPrefix(closest.URL, "http://"), "https://"), nil
+}
+
+// useWaybackSnapshot makes an unavailable link with wayback_fallback redirect to the
+// latest snapshot of its destination. The snapshot is stored with the link until the
+// destination recovers.
+func useWaybackSnapshot(ctx context.Context, target linkCheckTarget) {
+	snapshot, err := findWaybackSnapshot(ctx, target.OriginalURL)
+	if err != nil {
+		log.Printf("Wayback lookup for %s failed: %v", target.ShortCode, err)
+		return
+	}
+	if snapshot == "" {
+		return
+	}
+	if err := setURLArchive(ctx, target.ShortCode, snapshot); err != nil {
+		log.Printf("Failed to store the Wayback snapshot of %s: %v", target.ShortCode, err)
+	}
+}
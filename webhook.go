@@ -0,0 +1,79 @@
+package shorty
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhookClient delivers to webhook URLs set by the operator, which may be internal
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// ownerWebhookClient delivers to webhook URLs supplied by link owners. Like
+// destinationClient it refuses to connect to non-public addresses, and it does not
+// follow redirects, so owners cannot make the instance call its own admin listener or
+// other internal services.
+var ownerWebhookClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: destinationClient.Transport,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookEvent is the envelope POSTed to webhook URLs
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// postWebhook delivers an event to a webhook URL supplied by a link owner
+func postWebhook(ctx context.Context, target, event string, data any) error {
+	return deliverWebhook(ctx, ownerWebhookClient, target, webhookEvent{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+}
+
+// deliverWebhook POSTs event as JSON with client. When WEBHOOK_SECRET is set the request
+// carries X-Shorty-Signature: t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">, so
+// receivers can verify the sender and reject replays. Redirects count as failures.
+func deliverWebhook(ctx context.Context, client *http.Client, target string, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Shorty-Webhook/"+version)
+	req.Header.Set("X-Shorty-Event", event.Event)
+	if secret := cfg().WebhookSecret; secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-Shorty-Signature", "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s answered %s", strings.SplitN(target, "?", 2)[0], resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package shorty
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+)
+
+// task is a unit of background work queued on the worker pool
+type task struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+// workerPool runs background work on a fixed number of goroutines fed by a bounded
+// queue, so traffic spikes drop work instead of spawning unbounded goroutines
+type workerPool struct {
+	size  int
+	tasks chan task
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	processed *expvar.Int
+	dropped   *expvar.Int
+}
+
+// workers drops all work until start replaces it with a pool sized from WORKER_COUNT and
+// WORKER_QUEUE_SIZE
+var workers = newWorkerPool(0, 0)
+
+var (
+	workerTasksProcessed = expvar.NewInt("shorty_worker_tasks_processed_total")
+	workerTasksDropped   = expvar.NewInt("shorty_worker_tasks_dropped_total")
+)
+
+func init() {
+	expvar.Publish("shorty_worker_queue_depth", expvar.Func(func() any { return len(workers.tasks) }))
+
+	registerHealthCheck("worker_queue", false, func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{
+			"depth":    len(workers.tasks),
+			"capacity": cap(workers.tasks),
+			"workers":  workers.size,
+			"dropped":  workers.dropped.Value(),
+		}, nil
+	})
+}
+
+func newWorkerPool(size, queueSize int) *workerPool {
+	return &workerPool{
+		size:      size,
+		tasks:     make(chan task, queueSize),
+		processed: workerTasksProcessed,
+		dropped:   workerTasksDropped,
+	}
+}
+
+// Start launches the worker goroutines
+func (p *workerPool) Start() {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Submit queues fn without blocking. It reports false, and counts a drop, when the
+// queue is full or the pool is shutting down.
+func (p *workerPool) Submit(name string, fn func(ctx context.Context)) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.closed {
+		select {
+		case p.tasks <- task{name: name, fn: fn}:
+			return true
+		default:
+		}
+	}
+	p.dropped.Add(1)
+	return false
+}
+
+// Stop stops accepting work and waits for queued tasks to finish
+func (p *workerPool) Stop() {
+	p.mu.Lock()
+	p.closed = true
+	close(p.tasks)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for t := range p.tasks {
+		p.run(t)
+	}
+}
+
+// run executes a task, keeping the worker alive if it panics
+func (p *workerPool) run(t task) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Background task %s panicked: %v", t.name, r)
+		}
+		p.processed.Add(1)
+	}()
+
+	ctx, cancel := backgroundDBContext()
+	defer cancel()
+	t.fn(ctx)
+}